@@ -58,9 +58,10 @@ func NewAlipayClient(appId, privateKey string, opts ...OptionFunc) (client *Clie
 appID:支付宝分配给开发者的应用ID
 privateKey: 开发者生成的私钥
 aliPublicKey: 支付宝公钥
+opts: 可选 如WithApiDomain、WithHttpClient
 */
-func GetAliPayClient(appID, privateKey, aliPublicKey string) (*Client, error) {
-	var client, err = NewAlipayClient(appID, privateKey)
+func GetAliPayClient(appID, privateKey, aliPublicKey string, opts ...OptionFunc) (*Client, error) {
+	var client, err = NewAlipayClient(appID, privateKey, opts...)
 	if err != nil {
 		fmt.Printf("GetAliPayClient-> New alipay's client failed, error(%v)", err)
 		return nil, err
@@ -0,0 +1,204 @@
+package alipay
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+)
+
+//账单下载地址查询API详情请查阅:https://opendocs.alipay.com/open/028woc
+//下载的账单为zip压缩包,其中"业务明细"文件为GBK编码的csv,解析前需解压并转为UTF-8(如simplifiedchinese.GBK.NewDecoder())
+
+/*
+业务明细示例:
+#支付宝业务明细查询
+#账号：[20881234567890120156]
+#起始日期：[2023年06月01日 00:00:00]   终止日期：[2023年06月02日 00:00:00]
+#-----------------------------------------业务明细列表----------------------------------------
+支付宝交易号,商户订单号,业务类型,商品名称,创建时间,完成时间,...,订单金额（元）,商家实收（元）,...,退款批次号/请求号,服务费（元）,分润（元）,备注
+2023060122001411111111111111,6823789339978248,交易,iPhone,2023-06-01 10:00:00,2023-06-01 10:00:05,...,88.88,88.88,...,,-0.53,0.00,
+#-----------------------------------------业务明细列表结束------------------------------------
+#交易合计：1笔，商家实收：88.88元，商家优惠：0.00元
+#退款合计：0笔，商家实收：0.00元，商家优惠：0.00元
+#导出时间：[2023年06月02日 10:00:00]
+*/
+
+// 业务类型
+const (
+	BillBizTypeTrade  = "交易"
+	BillBizTypeRefund = "退款"
+)
+
+// 业务明细账单
+type TradeBill struct {
+	Rows    []*TradeBillRow
+	Summary *TradeBillSummary
+}
+
+// 业务明细 金额字段单位为分
+type TradeBillRow struct {
+	TradeNo        string       //支付宝交易号
+	OutTradeNo     string       //商户订单号
+	BizType        string       //业务类型 交易/退款
+	Subject        string       //商品名称
+	CreateTime     string       //创建时间
+	FinishTime     string       //完成时间
+	StoreId        string       //门店编号
+	StoreName      string       //门店名称
+	Operator       string       //操作员
+	TerminalNo     string       //终端号
+	BuyerAccount   string       //对方账户
+	TotalAmount    money.Amount //订单金额 退款时为本次退款金额(含退回的优惠)
+	ReceiptAmount  money.Amount //商家实收 退款时为负数
+	RedPacket      money.Amount //支付宝红包
+	Point          money.Amount //集分宝
+	AlipayDiscount money.Amount //支付宝优惠
+	MerchantCoupon money.Amount //商家优惠
+	VoucherAmount  money.Amount //券核销金额
+	VoucherName    string       //券名称
+	MerchantRedPkt money.Amount //商家红包消费金额
+	CardAmount     money.Amount //卡消费金额
+	OutRequestNo   string       //退款批次号/请求号
+	ServiceFee     money.Amount //服务费 收取时为负数,退款退回时为正数
+	Royalty        money.Amount //分润
+	Remark         string       //备注
+}
+
+// 业务明细汇总
+type TradeBillSummary struct {
+	TradeCount   int          //交易笔数
+	TradeAmount  money.Amount //交易商家实收
+	RefundCount  int          //退款笔数
+	RefundAmount money.Amount //退款商家实收 负数
+}
+
+var billSummaryRe = regexp.MustCompile(`^#(交易|退款)合计[:：]\s*(\d+)笔[,，]\s*商家实收[:：]\s*(-?[\d.]+)元`)
+
+// [ParseTradeBill] 解析业务明细账单(UTF-8),按表头字段名取值,#开头的行为说明及汇总
+func ParseTradeBill(r io.Reader) (*TradeBill, error) {
+	var lines []string
+	tradeBill := &TradeBill{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimPrefix(scanner.Text(), "\ufeff")
+		if !strings.HasPrefix(line, "#") {
+			if strings.TrimSpace(line) != "" {
+				lines = append(lines, line)
+			}
+			continue
+		}
+		match := billSummaryRe.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		count, _ := strconv.Atoi(match[2])
+		amount, err := money.ParseYuan(match[3])
+		if err != nil {
+			return nil, fmt.Errorf("bill summary %v: %w", match[1], err)
+		}
+		if tradeBill.Summary == nil {
+			tradeBill.Summary = &TradeBillSummary{}
+		}
+		if match[1] == BillBizTypeTrade {
+			tradeBill.Summary.TradeCount, tradeBill.Summary.TradeAmount = count, amount
+		} else {
+			tradeBill.Summary.RefundCount, tradeBill.Summary.RefundAmount = count, amount
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Printf("ParseTradeBill-> read bill error(%v)", err)
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, errors.New("ParseTradeBill-> empty bill")
+	}
+	csvReader := csv.NewReader(strings.NewReader(strings.Join(lines, "\n")))
+	csvReader.FieldsPerRecord = -1
+	csvReader.LazyQuotes = true
+	records, err := csvReader.ReadAll()
+	if err != nil {
+		fmt.Printf("ParseTradeBill-> read csv error(%v)", err)
+		return nil, err
+	}
+	header := billHeader(records[0])
+	for _, record := range records[1:] {
+		row := billRecord{header: header, values: record}
+		tradeBillRow := &TradeBillRow{
+			TradeNo:      row.get("支付宝交易号"),
+			OutTradeNo:   row.get("商户订单号"),
+			BizType:      row.get("业务类型"),
+			Subject:      row.get("商品名称"),
+			CreateTime:   row.get("创建时间"),
+			FinishTime:   row.get("完成时间"),
+			StoreId:      row.get("门店编号"),
+			StoreName:    row.get("门店名称"),
+			Operator:     row.get("操作员"),
+			TerminalNo:   row.get("终端号"),
+			BuyerAccount: row.get("对方账户"),
+			VoucherName:  row.get("券名称"),
+			OutRequestNo: row.get("退款批次号/请求号"),
+			Remark:       row.get("备注"),
+		}
+		tradeBillRow.TotalAmount = row.fen("订单金额(元)", &err)
+		tradeBillRow.ReceiptAmount = row.fen("商家实收(元)", &err)
+		tradeBillRow.RedPacket = row.fen("支付宝红包(元)", &err)
+		tradeBillRow.Point = row.fen("集分宝(元)", &err)
+		tradeBillRow.AlipayDiscount = row.fen("支付宝优惠(元)", &err)
+		tradeBillRow.MerchantCoupon = row.fen("商家优惠(元)", &err)
+		tradeBillRow.VoucherAmount = row.fen("券核销金额(元)", &err)
+		tradeBillRow.MerchantRedPkt = row.fen("商家红包消费金额(元)", &err)
+		tradeBillRow.CardAmount = row.fen("卡消费金额(元)", &err)
+		tradeBillRow.ServiceFee = row.fen("服务费(元)", &err)
+		tradeBillRow.Royalty = row.fen("分润(元)", &err)
+		if err != nil {
+			return nil, err
+		}
+		tradeBill.Rows = append(tradeBill.Rows, tradeBillRow)
+	}
+	return tradeBill, nil
+}
+
+// 表头字段名 -> 列下标 全角括号转为半角,兼容"订单金额（元）"与"订单金额(元)"
+func billHeader(record []string) map[string]int {
+	header := make(map[string]int, len(record))
+	for i, name := range record {
+		name = strings.NewReplacer("（", "(", "）", ")").Replace(strings.TrimSpace(name))
+		header[name] = i
+	}
+	return header
+}
+
+type billRecord struct {
+	header map[string]int
+	values []string
+}
+
+// 支付宝账单字段带有空格及制表符填充
+func (b billRecord) get(name string) string {
+	i, ok := b.header[name]
+	if !ok || i >= len(b.values) {
+		return ""
+	}
+	return strings.TrimSpace(b.values[i])
+}
+
+// 金额字段 元 -> 分,出错时记录第一个错误
+func (b billRecord) fen(name string, errp *error) money.Amount {
+	value := b.get(name)
+	if value == "" || *errp != nil {
+		return money.Amount{}
+	}
+	fen, err := money.ParseYuan(value)
+	if err != nil {
+		*errp = fmt.Errorf("bill field %v: %w", name, err)
+	}
+	return fen
+}
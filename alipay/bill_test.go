@@ -0,0 +1,44 @@
+package alipay
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseTradeBill(t *testing.T) {
+	f, err := os.Open("testdata/tradebill.csv")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer f.Close()
+	tradeBill, err := ParseTradeBill(f)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(tradeBill.Rows) != 3 {
+		t.Errorf("unexpected rows(%v)", len(tradeBill.Rows))
+		return
+	}
+	row := tradeBill.Rows[0]
+	if row.TradeNo != "2023060122001411111111111111" || row.OutTradeNo != "6823789339978248" || row.BizType != BillBizTypeTrade ||
+		row.TotalAmount.Minor() != 8888 || row.ServiceFee.Minor() != -53 {
+		t.Errorf("unexpected row(%+v)", row)
+	}
+	refundRow := tradeBill.Rows[2]
+	if refundRow.BizType != BillBizTypeRefund || refundRow.OutRequestNo != "refund-1" || refundRow.TotalAmount.Minor() != 1000 || refundRow.ReceiptAmount.Minor() != -1000 || refundRow.ServiceFee.Minor() != 6 {
+		t.Errorf("unexpected refund row(%+v)", refundRow)
+	}
+	summary := tradeBill.Summary
+	if summary == nil || summary.TradeCount != 2 || summary.TradeAmount.Minor() != 10122 || summary.RefundCount != 1 || summary.RefundAmount.Minor() != -1000 {
+		t.Errorf("unexpected summary(%+v)", summary)
+	}
+	if _, err = ParseTradeBill(strings.NewReader("#支付宝业务明细查询\n")); err == nil {
+		t.Error("empty bill but no return err")
+	}
+	if _, err = ParseTradeBill(strings.NewReader("商户订单号,订单金额（元）\n123,1.001\n")); err == nil {
+		t.Error("sub fen amount but no return err")
+	}
+}
@@ -2,10 +2,20 @@ package alipay
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+	"github.com/tanjl855/Sms_Pay_SDK/notifyguard"
 )
 
+// 异步通知参数详情请查阅:https://opendocs.alipay.com/open/270/105902
+// 商户处理成功后须原样返回字符串success,返回其他内容支付宝会在25小时内重新通知(共8次)
+
 const (
 	TradeStatusWaitBuyerPay = "WAIT_BUYER_PAY" //（交易创建，等待买家付款）
 	TradeStatusClosed       = "TRADE_CLOSED"   //（未付款交易超时关闭，或支付完成后全额退款）
@@ -13,61 +23,250 @@ const (
 	TradeStatusFinished     = "TRADE_FINISHED" //（交易结束，不可退款）
 )
 
+// notify_time格式 北京时间
+const notifyTimeLayout = "2006-01-02 15:04:05"
+
+var beijing = time.FixedZone("CST", 8*3600)
+
+// 应答支付宝异步通知
+const (
+	notifySuccess = "success"
+	notifyFail    = "fail"
+)
+
+var (
+	ErrNotifySign     = errors.New("alipay notify sign verify failed")
+	ErrNotifyMismatch = errors.New("alipay notify mismatch with order")
+)
+
 type Option struct {
-	OnCallBack func(context.Context) error
+	OnCallBack func(context.Context, *Notification) error //异步通知回调,每次通知都是新的*Notification
+
+	// 以下用于校验通知与原始订单是否一致,不一致时应答fail且不调用OnCallBack
+	AppId       string                                                           //[非必填]本应用app_id,不为空时校验通知的app_id
+	SellerId    string                                                           //[非必填]本商户支付宝用户号(2088开头),不为空时校验通知的seller_id
+	LookupOrder func(ctx context.Context, outTradeNo string) (*AliPayReq, error) //[非必填]按商户订单号查询下单时的*AliPayReq,不为nil时校验total_amount
+
+	Store  notifyguard.NotificationStore //[非必填]按notify_id去重,已处理的通知直接应答success且不调用OnCallBack
+	Replay *notifyguard.ReplayGuard      //[非必填]防重放,校验notify_time在时间窗口内且notify_id+notify_time未出现过
+}
+
+// 支付宝异步通知
+type Notification struct {
+	NotifyTime        string      `json:"notify_time"`                   //通知时间 格式yyyy-MM-dd HH:mm:ss
+	NotifyType        string      `json:"notify_type"`                   //通知类型 trade_status_sync
+	NotifyId          string      `json:"notify_id"`                     //通知校验ID
+	Charset           string      `json:"charset,omitempty"`             //编码格式
+	Version           string      `json:"version,omitempty"`             //接口版本
+	SignType          string      `json:"sign_type"`                     //签名类型 RSA2/RSA
+	AppId             string      `json:"app_id"`                        //支付宝分配给开发者的应用ID
+	AuthAppId         string      `json:"auth_app_id,omitempty"`         //授权方的app_id
+	TradeNo           string      `json:"trade_no"`                      //支付宝交易号
+	OutTradeNo        string      `json:"out_trade_no"`                  //商户订单号
+	OutBizNo          string      `json:"out_biz_no,omitempty"`          //商户业务号 退款通知中返回退款请求号
+	BuyerId           string      `json:"buyer_id,omitempty"`            //买家支付宝用户号
+	BuyerLogonId      string      `json:"buyer_logon_id,omitempty"`      //买家支付宝账号
+	SellerId          string      `json:"seller_id,omitempty"`           //卖家支付宝用户号
+	SellerEmail       string      `json:"seller_email,omitempty"`        //卖家支付宝账号
+	TradeStatus       string      `json:"trade_status,omitempty"`        //交易状态
+	TotalAmount       string      `json:"total_amount,omitempty"`        //订单金额 单位为元
+	ReceiptAmount     string      `json:"receipt_amount,omitempty"`      //实收金额
+	InvoiceAmount     string      `json:"invoice_amount,omitempty"`      //开票金额
+	BuyerPayAmount    string      `json:"buyer_pay_amount,omitempty"`    //付款金额
+	PointAmount       string      `json:"point_amount,omitempty"`        //集分宝金额
+	RefundFee         string      `json:"refund_fee,omitempty"`          //总退款金额
+	Subject           string      `json:"subject,omitempty"`             //订单标题
+	Body              string      `json:"body,omitempty"`                //商品描述
+	GmtCreate         string      `json:"gmt_create,omitempty"`          //交易创建时间
+	GmtPayment        string      `json:"gmt_payment,omitempty"`         //交易付款时间
+	GmtRefund         string      `json:"gmt_refund,omitempty"`          //交易退款时间
+	GmtClose          string      `json:"gmt_close,omitempty"`           //交易结束时间
+	FundBillList      []*FundBill `json:"fund_bill_list,omitempty"`      //支付金额信息
+	PassbackParams    string      `json:"passback_params,omitempty"`     //回传参数
+	VoucherDetailList string      `json:"voucher_detail_list,omitempty"` //优惠券信息 JSON原文
+}
+
+// 支付金额信息
+type FundBill struct {
+	FundChannel string `json:"fundChannel"`          //支付渠道 如ALIPAYACCOUNT/PCREDIT
+	Amount      string `json:"amount"`               //使用指定支付渠道支付的金额
+	RealAmount  string `json:"realAmount,omitempty"` //渠道实际付款金额
 }
 
 /*
+[ParseNotification]-> 验签并解析支付宝异步通知
+form: 通知参数,通常为r.PostForm
+aliPublicKey: 支付宝公钥,支持带或不带PEM头尾的格式
+*/
+func ParseNotification(form url.Values, aliPublicKey string) (*Notification, error) {
+	params := url.Values{}
+	for k, v := range form {
+		params[k] = v
+	}
+	sign := params.Get("sign")
+	signType := params.Get("sign_type")
+	params.Del("sign")
+	params.Del("sign_type")
+	if !verifySign(params, sign, signType, aliPublicKey) {
+		return nil, ErrNotifySign
+	}
+
+	notification := &Notification{
+		NotifyTime:        form.Get("notify_time"),
+		NotifyType:        form.Get("notify_type"),
+		NotifyId:          form.Get("notify_id"),
+		Charset:           form.Get("charset"),
+		Version:           form.Get("version"),
+		SignType:          signType,
+		AppId:             form.Get("app_id"),
+		AuthAppId:         form.Get("auth_app_id"),
+		TradeNo:           form.Get("trade_no"),
+		OutTradeNo:        form.Get("out_trade_no"),
+		OutBizNo:          form.Get("out_biz_no"),
+		BuyerId:           form.Get("buyer_id"),
+		BuyerLogonId:      form.Get("buyer_logon_id"),
+		SellerId:          form.Get("seller_id"),
+		SellerEmail:       form.Get("seller_email"),
+		TradeStatus:       form.Get("trade_status"),
+		TotalAmount:       form.Get("total_amount"),
+		ReceiptAmount:     form.Get("receipt_amount"),
+		InvoiceAmount:     form.Get("invoice_amount"),
+		BuyerPayAmount:    form.Get("buyer_pay_amount"),
+		PointAmount:       form.Get("point_amount"),
+		RefundFee:         form.Get("refund_fee"),
+		Subject:           form.Get("subject"),
+		Body:              form.Get("body"),
+		GmtCreate:         form.Get("gmt_create"),
+		GmtPayment:        form.Get("gmt_payment"),
+		GmtRefund:         form.Get("gmt_refund"),
+		GmtClose:          form.Get("gmt_close"),
+		PassbackParams:    form.Get("passback_params"),
+		VoucherDetailList: form.Get("voucher_detail_list"),
+	}
+	if fundBillList := form.Get("fund_bill_list"); fundBillList != "" {
+		if err := json.Unmarshal([]byte(fundBillList), &notification.FundBillList); err != nil {
+			fmt.Printf("ParseNotification-> Unmarshal fund_bill_list(%v) error(%v)", fundBillList, err)
+			return nil, err
+		}
+	}
+	return notification, nil
+}
+
+/*
+[NotifyHandle]
+处理支付宝异步通知,每次通知都解析到新的*Notification并传给options.OnCallBack
+handler不保存订单状态,可同时服务多个订单,设置options.Store时按notify_id去重
 aliPublicKey: 支付宝公钥
-a: 支付宝支付请求struct(支付状态更新在此)
+options: 提供钩子函数,可为nil
 */
-func NotifyHandle(aliPublicKey string, ctx context.Context, options *Option, a *AliPayReq) http.HandlerFunc {
+func NotifyHandle(aliPublicKey string, ctx context.Context, options *Option) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
-		if a.PayStatus == TradeStatusSuccess || a.PayStatus == TradeStatusFinished || a.PayStatus == TradeStatusClosed {
-			fmt.Printf("NotifyHandle-> AliPay notify is handled.")
-			return
-		}
 		if err := r.ParseForm(); err != nil {
 			fmt.Printf("NotifyHandle->parseForm error:%v", err)
-			w.WriteHeader(http.StatusBadRequest)
+			writeNotifyRes(w, http.StatusBadRequest, notifyFail)
 			return
 		}
 
-		// 获取请求参数
-		formData := r.PostForm
-		sign := formData.Get("sign")
-		signType := formData.Get("sign_type")
-		formData.Del("sign")
-		formData.Del("sign_type")
-
-		// 验证签名
-		if !verifySign(formData, sign, signType, aliPublicKey) {
-			fmt.Printf("NotifyHandle->验证签名失败！")
-			w.WriteHeader(http.StatusBadRequest)
+		// 验证签名并解析通知
+		notification, err := ParseNotification(r.PostForm, aliPublicKey)
+		if err != nil {
+			fmt.Printf("NotifyHandle->ParseNotification error:%v", err)
+			writeNotifyRes(w, http.StatusBadRequest, notifyFail)
+			return
+		}
+
+		//防重放 支付宝重新通知时notify_id不变而notify_time不同
+		if options != nil && options.Replay != nil {
+			nonce := notification.NotifyId + "|" + notification.NotifyTime
+			if err = options.Replay.CheckLayout(notifyTimeLayout, notification.NotifyTime, beijing, nonce); err != nil {
+				fmt.Printf("NotifyHandle->replay check error:%v", err)
+				status := http.StatusInternalServerError
+				if errors.Is(err, notifyguard.ErrReplay) {
+					status = http.StatusBadRequest
+				}
+				writeNotifyRes(w, status, notifyFail)
+				return
+			}
+		}
+
+		//校验通知与原始订单
+		if err = checkNotification(ctx, options, notification); err != nil {
+			fmt.Printf("NotifyHandle->checkNotification error:%v", err)
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrNotifyMismatch) {
+				status = http.StatusBadRequest
+			}
+			writeNotifyRes(w, status, notifyFail)
 			return
 		}
 
 		//logic
 		if options != nil && options.OnCallBack != nil {
-			err := options.OnCallBack(ctx)
+			_, err = notifyguard.Process(options.Store, notification.NotifyId, func() error {
+				return options.OnCallBack(ctx, notification)
+			})
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				fmt.Printf("NotifyHandle->OnCallBack error:%v", err)
+				writeNotifyRes(w, http.StatusInternalServerError, notifyFail)
 				return
 			}
 		}
-		var out_trade_no, trade_no, trade_status string
-		out_trade_no = formData.Get("out_trade_no") //商户订单号
-		trade_no = formData.Get("trade_no")         //支付宝交易号
-		trade_status = formData.Get("trade_status") //状态
-
-		//更新状态
-		a.OutTradeNo = out_trade_no
-		a.PayStatus = trade_status
-		a.TradeNo = trade_no
 
 		//通知支付宝
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("success"))
+		writeNotifyRes(w, http.StatusOK, notifySuccess)
+	}
+}
+
+/*
+[checkNotification] 按支付宝文档要求校验通知:app_id为本应用、seller_id为本商户、total_amount与订单金额一致
+不一致时返回ErrNotifyMismatch,LookupOrder失败时返回其error
+*/
+func checkNotification(ctx context.Context, options *Option, notification *Notification) error {
+	if options == nil {
+		return nil
 	}
+	if options.AppId != "" && notification.AppId != options.AppId {
+		return fmt.Errorf("%w: app_id(%v) expect(%v)", ErrNotifyMismatch, notification.AppId, options.AppId)
+	}
+	if options.SellerId != "" && notification.SellerId != options.SellerId {
+		return fmt.Errorf("%w: seller_id(%v) expect(%v)", ErrNotifyMismatch, notification.SellerId, options.SellerId)
+	}
+	if options.LookupOrder == nil {
+		return nil
+	}
+	order, err := options.LookupOrder(ctx, notification.OutTradeNo)
+	if err != nil {
+		return err
+	}
+	if order == nil {
+		return fmt.Errorf("%w: order(%v) not found", ErrNotifyMismatch, notification.OutTradeNo)
+	}
+	if order.OutTradeNo != "" && order.OutTradeNo != notification.OutTradeNo {
+		return fmt.Errorf("%w: out_trade_no(%v) expect(%v)", ErrNotifyMismatch, notification.OutTradeNo, order.OutTradeNo)
+	}
+	if !amountEqual(notification.TotalAmount, order.TotalAmount.String()) {
+		return fmt.Errorf("%w: total_amount(%v) expect(%v)", ErrNotifyMismatch, notification.TotalAmount, order.TotalAmount)
+	}
+	return nil
+}
+
+// 比较两个以元为单位的金额 "20"与"20.00"相等,无法解析或精度超过分时不相等
+func amountEqual(a, b string) bool {
+	amountA, err := money.ParseYuan(a)
+	if err != nil {
+		return false
+	}
+	amountB, err := money.ParseYuan(b)
+	if err != nil {
+		return false
+	}
+	return amountA.Equal(amountB)
+}
+
+// 应答支付宝异步通知 只有success表示接收成功
+func writeNotifyRes(w http.ResponseWriter, status int, res string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write([]byte(res))
 }
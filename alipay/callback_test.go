@@ -2,19 +2,308 @@ package alipay
 
 import (
 	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+	"github.com/tanjl855/Sms_Pay_SDK/notifyguard"
 )
 
-func TestNotifyHandle(t *testing.T) {
-	alipublicKey := `-----BEGIN PUBLIC KEY-----
-	xxxxxx
-	-----END PUBLIC KEY-----`
-	a := NewAliPayReq("", "", "xxx", "123.1", "https://www.baidu.com")
-	handler := NotifyHandle(alipublicKey, context.Background(), nil, a)
-	err := http.ListenAndServe(":1234", handler)
+// 本地生成的密钥模拟支付宝签名,公钥使用支付宝开放平台的无PEM头尾格式
+func newTestAliKey(t *testing.T) (*rsa.PrivateKey, string) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return privateKey, base64.StdEncoding.EncodeToString(der)
+}
+
+func signTestNotify(t *testing.T, privateKey *rsa.PrivateKey, form url.Values, signType string) url.Values {
+	keys := make([]string, 0, len(form))
+	for k := range form {
+		if k == "sign" || k == "sign_type" || form.Get(k) == "" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+form.Get(k))
+	}
+	data := strings.Join(pairs, "&")
+	var sign string
+	var err error
+	if signType == "RSA" {
+		hashed := sha1.Sum([]byte(data))
+		var bys []byte
+		bys, err = rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA1, hashed[:])
+		sign = base64.StdEncoding.EncodeToString(bys)
+	} else {
+		sign, err = ShaSign(data, privateKey)
+	}
 	if err != nil {
-		t.Error(err)
-		return
+		t.Fatal(err)
+	}
+	form.Set("sign_type", signType)
+	form.Set("sign", sign)
+	return form
+}
+
+func testNotifyForm(outTradeNo, tradeStatus string) url.Values {
+	return url.Values{
+		"notify_time":      {"2015-14-27 15:45:58"},
+		"notify_type":      {"trade_status_sync"},
+		"notify_id":        {"ac05099524730693a8b330c5ecf72da9786"},
+		"charset":          {"utf-8"},
+		"version":          {"1.0"},
+		"app_id":           {"2014072300007148"},
+		"trade_no":         {"2013112011001004330000121536"},
+		"out_trade_no":     {outTradeNo},
+		"buyer_id":         {"2088102122524333"},
+		"seller_id":        {"2088101106499364"},
+		"trade_status":     {tradeStatus},
+		"total_amount":     {"20.00"},
+		"receipt_amount":   {"15.00"},
+		"buyer_pay_amount": {"13.88"},
+		"refund_fee":       {""},
+		"subject":          {"当面付交易"},
+		"gmt_payment":      {"2015-04-27 15:45:57"},
+		"fund_bill_list":   {`[{"amount":"15.00","fundChannel":"ALIPAYACCOUNT"}]`},
+	}
+}
+
+func postNotify(handler http.HandlerFunc, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/notify", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestNotifyHandle(t *testing.T) {
+	privateKey, aliPublicKey := newTestAliKey(t)
+	var notifications []*Notification
+	options := &Option{OnCallBack: func(ctx context.Context, notification *Notification) error {
+		notifications = append(notifications, notification)
+		return nil
+	}}
+	handler := NotifyHandle(aliPublicKey, context.Background(), options)
+
+	//同一个handler处理多个订单,前一个订单已是终态也不影响后续订单
+	w := postNotify(handler, signTestNotify(t, privateKey, testNotifyForm("6823789339978248", TradeStatusSuccess), "RSA2"))
+	if w.Code != http.StatusOK || w.Body.String() != "success" {
+		t.Fatalf("unexpected status(%v) body(%v)", w.Code, w.Body.String())
+	}
+	w = postNotify(handler, signTestNotify(t, privateKey, testNotifyForm("6823789339978249", TradeStatusClosed), "RSA"))
+	if w.Code != http.StatusOK || w.Body.String() != "success" {
+		t.Fatalf("unexpected status(%v) body(%v)", w.Code, w.Body.String())
+	}
+	if len(notifications) != 2 {
+		t.Fatalf("unexpected notifications(%v)", len(notifications))
+	}
+	notification := notifications[0]
+	if notification.OutTradeNo != "6823789339978248" || notification.TradeStatus != TradeStatusSuccess ||
+		notification.TotalAmount != "20.00" || notification.BuyerId != "2088102122524333" ||
+		notification.GmtPayment != "2015-04-27 15:45:57" || notification.SignType != "RSA2" {
+		t.Errorf("unexpected notification(%+v)", notification)
+	}
+	if len(notification.FundBillList) != 1 || notification.FundBillList[0].FundChannel != "ALIPAYACCOUNT" || notification.FundBillList[0].Amount != "15.00" {
+		t.Errorf("unexpected fund_bill_list(%+v)", notification.FundBillList)
+	}
+	if notifications[1].OutTradeNo != "6823789339978249" || notifications[1].TradeStatus != TradeStatusClosed {
+		t.Errorf("unexpected notification(%+v)", notifications[1])
+	}
+}
+
+func TestNotifyHandleFail(t *testing.T) {
+	privateKey, aliPublicKey := newTestAliKey(t)
+	called := false
+	options := &Option{OnCallBack: func(ctx context.Context, notification *Notification) error {
+		called = true
+		return errors.New("order not found")
+	}}
+	handler := NotifyHandle(aliPublicKey, context.Background(), options)
+
+	//签名后篡改金额
+	form := signTestNotify(t, privateKey, testNotifyForm("6823789339978248", TradeStatusSuccess), "RSA2")
+	form.Set("total_amount", "0.01")
+	if w := postNotify(handler, form); w.Code != http.StatusBadRequest || w.Body.String() != "fail" || called {
+		t.Errorf("tampered notify: status(%v) body(%v) called(%v)", w.Code, w.Body.String(), called)
+	}
+
+	//其他密钥签名
+	otherKey, _ := newTestAliKey(t)
+	if w := postNotify(handler, signTestNotify(t, otherKey, testNotifyForm("6823789339978248", TradeStatusSuccess), "RSA2")); w.Body.String() != "fail" || called {
+		t.Errorf("unknown key: body(%v) called(%v)", w.Body.String(), called)
+	}
+
+	//不支持的签名类型
+	form = signTestNotify(t, privateKey, testNotifyForm("6823789339978248", TradeStatusSuccess), "RSA2")
+	form.Set("sign_type", "MD5")
+	if w := postNotify(handler, form); w.Body.String() != "fail" || called {
+		t.Errorf("unsupported sign_type: body(%v) called(%v)", w.Body.String(), called)
+	}
+
+	//回调失败
+	w := postNotify(handler, signTestNotify(t, privateKey, testNotifyForm("6823789339978248", TradeStatusSuccess), "RSA2"))
+	if w.Code != http.StatusInternalServerError || w.Body.String() != "fail" || !called {
+		t.Errorf("callback error: status(%v) body(%v) called(%v)", w.Code, w.Body.String(), called)
+	}
+
+	//options为nil
+	w = postNotify(NotifyHandle(aliPublicKey, context.Background(), nil), signTestNotify(t, privateKey, testNotifyForm("6823789339978248", TradeStatusSuccess), "RSA2"))
+	if w.Body.String() != "success" {
+		t.Errorf("nil options: body(%v)", w.Body.String())
+	}
+}
+
+func TestNotifyHandleCheckOrder(t *testing.T) {
+	privateKey, aliPublicKey := newTestAliKey(t)
+	order := NewAliPayReq("", "当面付交易", "6823789339978248", money.Fen(2000), "")
+	called := 0
+	options := &Option{
+		AppId:    "2014072300007148",
+		SellerId: "2088101106499364",
+		LookupOrder: func(ctx context.Context, outTradeNo string) (*AliPayReq, error) {
+			if outTradeNo != order.OutTradeNo {
+				return nil, errors.New("order not found")
+			}
+			return order, nil
+		},
+		OnCallBack: func(ctx context.Context, notification *Notification) error {
+			called++
+			return nil
+		},
+	}
+	handler := NotifyHandle(aliPublicKey, context.Background(), options)
+	cases := []struct {
+		name   string
+		key    string
+		value  string
+		status int
+	}{
+		{"match", "total_amount", "20.00", http.StatusOK},
+		{"app_id", "app_id", "2014072300007149", http.StatusBadRequest},
+		{"seller_id", "seller_id", "2088101106499365", http.StatusBadRequest},
+		{"total_amount", "total_amount", "0.01", http.StatusBadRequest},
+		{"not found", "out_trade_no", "6823789339978249", http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		form := testNotifyForm(order.OutTradeNo, TradeStatusSuccess)
+		form.Set(c.key, c.value)
+		w := postNotify(handler, signTestNotify(t, privateKey, form, "RSA2"))
+		if w.Code != c.status {
+			t.Errorf("%v: unexpected status(%v) body(%v)", c.name, w.Code, w.Body.String())
+		}
+	}
+	if called != 1 {
+		t.Errorf("OnCallBack called(%v) times, expect 1", called)
+	}
+}
+
+func TestAmountEqual(t *testing.T) {
+	cases := []struct {
+		a, b  string
+		equal bool
+	}{
+		{"20", "20.00", true},
+		{"20.1", "20.10", true},
+		{"0.01", "0.01", true},
+		{"20.00", "20.01", false},
+		{"20.001", "20.00", false},
+		{"", "0", false},
+		{"abc", "abc", false},
+	}
+	for _, c := range cases {
+		if amountEqual(c.a, c.b) != c.equal {
+			t.Errorf("amountEqual(%v, %v) expect %v", c.a, c.b, c.equal)
+		}
+	}
+}
+
+func TestNotifyHandleStore(t *testing.T) {
+	privateKey, aliPublicKey := newTestAliKey(t)
+	called := map[string]int{}
+	options := &Option{
+		Store: notifyguard.NewMemoryStore(),
+		OnCallBack: func(ctx context.Context, notification *Notification) error {
+			called[notification.NotifyId]++
+			return nil
+		},
+	}
+	handler := NotifyHandle(aliPublicKey, context.Background(), options)
+	for _, notifyId := range []string{"notify-1", "notify-1", "notify-2", "notify-1"} {
+		form := testNotifyForm("6823789339978248", TradeStatusSuccess)
+		form.Set("notify_id", notifyId)
+		w := postNotify(handler, signTestNotify(t, privateKey, form, "RSA2"))
+		if w.Code != http.StatusOK || w.Body.String() != "success" {
+			t.Errorf("%v: unexpected status(%v) body(%v)", notifyId, w.Code, w.Body.String())
+		}
+	}
+	if called["notify-1"] != 1 || called["notify-2"] != 1 {
+		t.Errorf("unexpected OnCallBack calls(%v)", called)
+	}
+}
+
+func TestNotifyHandleReplay(t *testing.T) {
+	privateKey, aliPublicKey := newTestAliKey(t)
+	called := 0
+	var rejects []string
+	replay := notifyguard.NewReplayGuard(5 * time.Minute)
+	replay.OnReject = func(err *notifyguard.RejectError) {
+		rejects = append(rejects, err.Reason)
+	}
+	options := &Option{
+		Replay: replay,
+		OnCallBack: func(ctx context.Context, notification *Notification) error {
+			called++
+			return nil
+		},
+	}
+	handler := NotifyHandle(aliPublicKey, context.Background(), options)
+	notifyForm := func(notifyTime time.Time) url.Values {
+		form := testNotifyForm("6823789339978248", TradeStatusSuccess)
+		form.Set("notify_time", notifyTime.In(beijing).Format(notifyTimeLayout))
+		return signTestNotify(t, privateKey, form, "RSA2")
+	}
+	captured := notifyForm(time.Now())
+	cases := []struct {
+		name   string
+		form   url.Values
+		status int
+	}{
+		{"first", captured, http.StatusOK},
+		{"replay", captured, http.StatusBadRequest},
+		{"retry", notifyForm(time.Now().Add(time.Second)), http.StatusOK},
+		{"expired", notifyForm(time.Now().Add(-10 * time.Minute)), http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		w := postNotify(handler, c.form)
+		if w.Code != c.status {
+			t.Errorf("%v: unexpected status(%v) body(%v)", c.name, w.Code, w.Body.String())
+		}
+	}
+	if called != 2 {
+		t.Errorf("OnCallBack called(%v) times, expect 2", called)
+	}
+	if len(rejects) != 2 || rejects[0] != notifyguard.RejectNonceReused || rejects[1] != notifyguard.RejectExpired {
+		t.Errorf("unexpected rejects(%v)", rejects)
 	}
 }
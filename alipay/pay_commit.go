@@ -2,15 +2,15 @@ package alipay
 
 import (
 	"fmt"
-	"net/url"
-	"time"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
 )
 
 // 支付宝支付文档详情:https://opendocs.alipay.com/open/270/01didh?pathHash=a6ccbe9a&ref=api#%E6%8E%A5%E5%8F%A3%E8%B0%83%E7%94%A8%E9%85%8D%E7%BD%AE
 // 支付宝网关: https://openapi.alipay.com/gateway.do
 
 type AliPay interface {
-	PayCommit(appID, privateKey, aliPublicKey string) (string, error)
+	PayCommit(appID, privateKey, aliPublicKey string, opts ...OptionFunc) (string, error)
 }
 
 var _ AliPay = &AliPayReq{}
@@ -20,11 +20,11 @@ type AliTrade struct {
 	ReturnURL    string // 支付成功后跳转界面url
 	AppAuthToken string // 可选
 
-	OutTradeNo  string `json:"out_trade_no"` //商户订单号 64个字符以内，仅支持字母、数字、下划线且需保证在商户端不重复。
-	TradeNo     string `json:"trade_no"`     //支付宝交易号
-	TotalAmount string `json:"total_amount"` //订单总金额 单位为元，精确到小数点后两位，取值范围[0.01,100000000]
-	Subject     string `json:"subject"`      //订单标题
-	ProductCode string `json:"product_code"` //销售产品码 目前电脑支付场景下仅支持FAST_INSTANT_TRADE_PAY
+	OutTradeNo  string        `json:"out_trade_no"` //商户订单号 64个字符以内，仅支持字母、数字、下划线且需保证在商户端不重复。
+	TradeNo     string        `json:"trade_no"`     //支付宝交易号
+	TotalAmount money.Decimal `json:"total_amount"` //订单总金额 单位为元，精确到小数点后两位，取值范围[0.01,100000000]
+	Subject     string        `json:"subject"`      //订单标题
+	ProductCode string        `json:"product_code"` //销售产品码 目前电脑支付场景下仅支持FAST_INSTANT_TRADE_PAY
 
 	GoodsDetail     []*GoodsDetail `json:"goods_detail,omitempty"`      // 可选 订单包含的商品列表信息，Json格式，详见商品明细说明
 	TimeExpire      string         `json:"time_expire,omitempty"`       // 可选 订单绝对超时时间 格式为yyyy-MM-dd HH:mm:ss。超时时间范围：1m~15d。
@@ -49,15 +49,15 @@ type ExtUserInfo struct {
 
 // 可选 订单包含的商品列表信息
 type GoodsDetail struct {
-	GoodsId        string  `json:"goods_id"`
-	AliPayGoodsId  string  `json:"alipay_goods_id,omitempty"`
-	GoodsName      string  `json:"goods_name"`
-	Quantity       int     `json:"quantity"`
-	Price          float64 `json:"price"`
-	GoodsCategory  string  `json:"goods_category,omitempty"`
-	CategoriesTree string  `json:"categories_tree,omitempty"`
-	Body           string  `json:"body,omitempty"`
-	ShowURL        string  `json:"show_url,omitempty"`
+	GoodsId        string        `json:"goods_id"`
+	AliPayGoodsId  string        `json:"alipay_goods_id,omitempty"`
+	GoodsName      string        `json:"goods_name"`
+	Quantity       int           `json:"quantity"`
+	Price          money.Decimal `json:"price"`
+	GoodsCategory  string        `json:"goods_category,omitempty"`
+	CategoriesTree string        `json:"categories_tree,omitempty"`
+	Body           string        `json:"body,omitempty"`
+	ShowURL        string        `json:"show_url,omitempty"`
 }
 
 // AliPay请求结构
@@ -88,12 +88,12 @@ const (
 	ProductCode = "FAST_INSTANT_TRADE_PAY" // 目前电脑支付场景下仅支持FAST_INSTANT_TRADE_PAY
 )
 
-func NewAliPayReq(notifyUrl, subject, outTradeNo, totalAmount, returnURL string) *AliPayReq {
+func NewAliPayReq(notifyUrl, subject, outTradeNo string, totalAmount money.Amount, returnURL string) *AliPayReq {
 	return &AliPayReq{
 		AliTrade: AliTrade{
 			Subject:     subject,
 			OutTradeNo:  outTradeNo,
-			TotalAmount: totalAmount,
+			TotalAmount: money.Decimal(totalAmount),
 			NotifyURL:   notifyUrl,
 			ReturnURL:   returnURL,
 		},
@@ -101,43 +101,25 @@ func NewAliPayReq(notifyUrl, subject, outTradeNo, totalAmount, returnURL string)
 }
 
 /*
+委托Client.TradePagePay生成url:业务参数放在biz_content中,ProductCode为空时使用FAST_INSTANT_TRADE_PAY,NotifyURL为空时不传notify_url
 appID: 支付宝分配给开发者的应用ID
 privateKey: 开发者生成的私钥
 aliPublicKey: 支付宝公钥
 return示例：https://openapi.alipay.com/gateway.do?timestamp=2013-01-01 08:08:08&method=alipay.trade.page.pay&app_id=24610&sign_type=RSA2&sign=ERITJKEIJKJHKKKKKKKHJEREEEEEEEEEEE&version=1.0&charset=GBK&biz_content=AlipayTradePageCreateandpayModel
 */
-func (a *AliPayReq) PayCommit(appID, privateKey, aliPublicKey string) (string, error) {
-	a.PayType = aliPay
-	client, err := GetAliPayClient(appID, privateKey, aliPublicKey)
+func (a *AliPayReq) PayCommit(appID, privateKey, aliPublicKey string, opts ...OptionFunc) (string, error) {
+	client, err := GetAliPayClient(appID, privateKey, aliPublicKey, opts...)
 	if err != nil {
 		fmt.Printf("PayCommit-> Get alipay error(%v)", err)
 		return "", err
 	}
 	Debug(a.Debug, "PayCommit-> Get alipay client(%v) success", client)
 
-	var vals = &url.Values{}
-	vals.Add("method", "alipay.trade.page.pay")
-	vals.Add("app_id", appID)
-	vals.Add("timestamp", time.Now().Format("2006-01-02 15:04:05"))
-	vals.Add("charset", "utf-8")
-	vals.Add("version", "1.0")
-	vals.Add("notify_url", a.NotifyURL)
-	if len(a.ReturnURL) > 0 {
-		vals.Add("return_url", a.ReturnURL)
+	uri, err := client.TradePagePay(a)
+	if err != nil {
+		fmt.Printf("PayCommit-> TradePagePay(%v) error(%v)", a.OutTradeNo, err)
+		return "", err
 	}
-	vals.Add("out_trade_no", a.OutTradeNo)
-	vals.Add("total_amount", a.TotalAmount)
-	vals.Add("subject", a.Subject)
-	vals.Add("product_code", a.ProductCode)
-
-	var data = vals.Encode()
-	data, _ = url.QueryUnescape(data)
-	sign, _ := ShaSign(data, client.appPrivateKey)
-	vals.Add("sign_type", "RSA2")
-	vals.Add("sign", sign)
-	Debug(a.Debug, "PayCommit-> add vals(%v) done", vals)
-
-	uri := fmt.Sprintf("%v?%v", client.apiDomain, vals.Encode())
 	Debug(a.Debug, "PayCommit-> create uri(%v) success", uri)
 	return uri, nil
 }
@@ -149,8 +131,9 @@ privateKey: 开发者生成的私钥
 aliPublicKey: 支付宝公钥
 isProduction: false表示支付宝沙箱环境，true表示生产环境
 a: 支付宝支付请求struct
+opts: 可选 如WithApiDomain指定网关
 返回支付界面url
 */
-func AliPayCommit(appID, privateKey, aliPublicKey string, a *AliPayReq) (string, error) {
-	return a.PayCommit(appID, privateKey, aliPublicKey)
+func AliPayCommit(appID, privateKey, aliPublicKey string, a *AliPayReq, opts ...OptionFunc) (string, error) {
+	return a.PayCommit(appID, privateKey, aliPublicKey, opts...)
 }
@@ -4,12 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/url"
 	"time"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
 )
 
 type AlipayRefund interface {
-	AliPayRefund(appID, privateKey, aliPublicKey string) (*AliPayRefundRsp, error)
+	AliPayRefund(appID, privateKey, aliPublicKey string, opts ...OptionFunc) (*AliPayRefundRsp, error)
 }
 
 var _ AlipayRefund = &AliPayRefundReq{}
@@ -20,9 +21,9 @@ type AliPayRefundReq struct {
 	OutTradeNo string `json:"out_trade_no"` //商户订单号 与TradeNo二选一
 	TradeNo    string `json:"trade_no"`     //支付宝交易号 与OutTradeNo二选一
 
-	RefundAmount string `json:"refund_amount"`  //退款金额
-	RefundReason string `json:"refund_reason"`  // 可选 退款的原因说明
-	OutRequestNo string `json:"out_request_no"` // 必须 标识一次退款请求，同一笔交易多次退款需要保证唯一，如需部分退款，则此参数必传。
+	RefundAmount money.Decimal `json:"refund_amount"`  //退款金额
+	RefundReason string        `json:"refund_reason"`  // 可选 退款的原因说明
+	OutRequestNo string        `json:"out_request_no"` // 必须 标识一次退款请求，同一笔交易多次退款需要保证唯一，如需部分退款，则此参数必传。
 	Debug        bool
 }
 
@@ -54,6 +55,14 @@ type RefundDetailItem struct {
 	FundType    string `json:"fund_type"`    // 渠道所使用的资金类型
 }
 
+// 电脑网站支付默认交易成功后3个月内可退款
+const RefundWindowMonths = 3
+
+// 可退款的截止时间 交易付款时间起RefundWindowMonths个月
+func RefundDeadline(gmtPayment time.Time) time.Time {
+	return gmtPayment.AddDate(0, RefundWindowMonths, 0)
+}
+
 /*
 outTradeNo和tradeNo二选一
 outTradeNo:商户订单号
@@ -62,53 +71,50 @@ refundAmount:退款金额
 refundReason:退款原因
 outRequestNo:非必选，标识一次退款请求
 */
-func NewAliPayRefundReq(outTradeNo, tradeNo, refundAmount, refundReason, outRequestNo string) *AliPayRefundReq {
+func NewAliPayRefundReq(outTradeNo, tradeNo string, refundAmount money.Amount, refundReason, outRequestNo string) *AliPayRefundReq {
 	return &AliPayRefundReq{
 		OutTradeNo:   outTradeNo,
 		TradeNo:      tradeNo,
-		RefundAmount: refundAmount,
+		RefundAmount: money.Decimal(refundAmount),
 		RefundReason: refundReason,
 		OutRequestNo: outRequestNo,
 	}
 }
 
 /*
+业务参数放在biz_content中,RefundReason为空时不传refund_reason;应答从alipay_trade_refund_response节点解析
 appID: 支付宝分配给开发者的应用ID
 privateKey: 开发者生成的私钥
 aliPublicKey: 支付宝公钥
 a: 支付宝支付请求struct
 uri示例: https://openapi.alipay.com/gateway.do?timestamp=2013-01-01 08:08:08&method=alipay.trade.refund&app_id=19761&sign_type=RSA2&sign=ERITJKEIJKJHKKKKKKKHJEREEEEEEEEEEE&version=1.0&charset=GBK&biz_content=AlipayTradeRefundModel
 */
-func (a *AliPayRefundReq) AliPayRefund(appID, privateKey, aliPublicKey string) (*AliPayRefundRsp, error) {
-	client, err := GetAliPayClient(appID, privateKey, aliPublicKey)
+func (a *AliPayRefundReq) AliPayRefund(appID, privateKey, aliPublicKey string, opts ...OptionFunc) (*AliPayRefundRsp, error) {
+	client, err := GetAliPayClient(appID, privateKey, aliPublicKey, opts...)
 	if err != nil {
 		fmt.Printf("AliPayRefund-> get alipay client error(%v)", err)
 		return nil, err
 	}
 	Debug(a.Debug, "AliPayRefund-> Get alipay client(%v) success", client)
 
-	vals := url.Values{}
-	vals.Add("charset", "utf-8")
-	if a.OutTradeNo != "" {
-		vals.Add("out_trade_no", a.OutTradeNo)
-	} else {
-		vals.Add("trade_no", a.TradeNo)
+	bizContent := tradeNoBizContent(a.OutTradeNo, a.TradeNo)
+	bizContent["refund_amount"] = a.RefundAmount.String()
+	if a.RefundReason != "" {
+		bizContent["refund_reason"] = a.RefundReason
 	}
-	vals.Add("refund_amount", a.RefundAmount)
-	vals.Add("refund_reason", a.RefundReason)
 	if a.OutRequestNo != "" {
-		vals.Add("out_request_no", a.OutRequestNo)
+		bizContent["out_request_no"] = a.OutRequestNo
+	}
+	vals, err := client.publicParams("alipay.trade.refund", bizContent)
+	if err != nil {
+		return nil, err
+	}
+	if a.AppAuthToken != "" {
+		vals.Set("app_auth_token", a.AppAuthToken)
+	}
+	if err = client.sign(vals); err != nil {
+		return nil, err
 	}
-	vals.Add("method", "alipay.trade.refund")
-	vals.Add("app_id", appID)
-	vals.Add("timestamp", time.Now().Format("2006-01-02 15:04:05"))
-	vals.Add("charset", "utf-8")
-	vals.Add("version", "1.0")
-	var data = vals.Encode()
-	data, _ = url.QueryUnescape(data)
-	sign, _ := ShaSign(data, client.appPrivateKey)
-	vals.Add("sign_type", "RSA2")
-	vals.Add("sign", sign)
 	Debug(a.Debug, "AliPayRefund-> add vals(%v) done", vals)
 
 	uri := fmt.Sprintf("%v?%v", client.apiDomain, vals.Encode())
@@ -119,15 +125,24 @@ func (a *AliPayRefundReq) AliPayRefund(appID, privateKey, aliPublicKey string) (
 		fmt.Printf("AliPayRefund-> alipay postForm error(%v)", err)
 		return nil, err
 	}
+	defer resp.Body.Close()
 	byteData, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		fmt.Printf("AliPayRefund-> read resp body error(%v)", err)
 		return nil, err
 	}
-	defer resp.Body.Close()
+	//业务字段在alipay_trade_refund_response节点内
+	var body struct {
+		Response json.RawMessage `json:"alipay_trade_refund_response"`
+	}
 	refundRsp := &AliPayRefundRsp{}
-	fmt.Println(string(byteData))
-	if err = json.Unmarshal(byteData, refundRsp); err != nil {
+	if err = json.Unmarshal(byteData, &body); err == nil {
+		err = json.Unmarshal(byteData, refundRsp)
+	}
+	if err == nil && len(body.Response) > 0 {
+		err = json.Unmarshal(body.Response, refundRsp)
+	}
+	if err != nil {
 		fmt.Printf("AliPayRefund-> Unmarshal byteData(%v) to refundRsp error(%v)", string(byteData), err)
 		return nil, err
 	}
@@ -141,7 +156,8 @@ appID: 支付宝分配给开发者的应用ID
 privateKey: 开发者生成的私钥
 aliPublicKey: 支付宝公钥
 a: 支付宝支付请求struct
+opts: 可选 如WithApiDomain指定网关
 */
-func RefundByAliPay(appID, privateKey, aliPublicKey string, a *AliPayRefundReq) (*AliPayRefundRsp, error) {
-	return a.AliPayRefund(appID, privateKey, aliPublicKey)
+func RefundByAliPay(appID, privateKey, aliPublicKey string, a *AliPayRefundReq, opts ...OptionFunc) (*AliPayRefundRsp, error) {
+	return a.AliPayRefund(appID, privateKey, aliPublicKey, opts...)
 }
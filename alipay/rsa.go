@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto"
 	"crypto/rsa"
+	_ "crypto/sha1"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
@@ -161,7 +162,7 @@ func ShaSign(data string, privateKey *rsa.PrivateKey) (string, error) {
 	}
 }
 
-// 验证签名
+// 验证签名 sign及sign_type不参与验签,空值参数不参与验签
 func verifySign(formData url.Values, sign string, signType, alipayPublicKey string) bool {
 	if formData == nil {
 		return false
@@ -177,24 +178,24 @@ func verifySign(formData url.Values, sign string, signType, alipayPublicKey stri
 	sort.Strings(keys)
 
 	// 拼接待验签字符串
-	var signData string
+	var signData []string
 	for _, k := range keys {
 		vs := formData[k]
 		// 将参数值按升序排列
 		sort.Strings(vs)
 		for _, v := range vs {
-			signData += k + "=" + v + "&"
+			if v == "" {
+				continue
+			}
+			signData = append(signData, k+"="+v)
 		}
 	}
-	// 去掉最后一个&
-	signData = signData[:len(signData)-1]
-
-	// 读取支付宝公钥
-	block, _ := pem.Decode([]byte(alipayPublicKey))
-	if block == nil {
+	if len(signData) == 0 {
 		return false
 	}
-	pubKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+
+	// 读取支付宝公钥
+	pubKey, err := ParsePublicKey(FormatPublicKey(alipayPublicKey))
 	if err != nil {
 		return false
 	}
@@ -207,15 +208,20 @@ func verifySign(formData url.Values, sign string, signType, alipayPublicKey stri
 
 	// 根据签名类型选择哈希算法
 	var hash crypto.Hash
-	if signType == "RSA2" {
+	switch signType {
+	case "RSA2":
 		hash = crypto.SHA256
+	case "RSA":
+		hash = crypto.SHA1
+	default:
+		return false
 	}
 
 	// 计算待验签字符串的哈希值
 	h := hash.New()
-	h.Write([]byte(signData))
+	h.Write([]byte(strings.Join(signData, "&")))
 	hashed := h.Sum(nil)
 
 	// 验证签名
-	return rsa.VerifyPKCS1v15(pubKey.(*rsa.PublicKey), hash, hashed, signBytes) == nil
+	return rsa.VerifyPKCS1v15(pubKey, hash, hashed, signBytes) == nil
 }
@@ -0,0 +1,362 @@
+package alipay
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+//统一收单交易接口 业务参数放在biz_content,公共参数及签名见:https://opendocs.alipay.com/open/00dn7o
+//应答示例 {"alipay_trade_query_response":{"code":"10000","msg":"Success",...},"sign":"..."}
+//使用支付宝公钥对xxx_response的原文验签
+
+// 网关返回码 10000表示接口调用成功
+const aliCodeSuccess = "10000"
+
+// 退款查询 退款成功时refund_status为REFUND_SUCCESS,为空表示退款未成功(处理中或失败)
+const RefundStatusSuccess = "REFUND_SUCCESS"
+
+var ErrResponseSign = errors.New("alipay response sign verify failed")
+
+// 支付宝网关错误 code不为10000
+type Error struct {
+	Code    string //网关返回码 如40004业务处理失败
+	Msg     string //网关返回码描述
+	SubCode string //业务返回码 如ACQ.TRADE_NOT_EXIST
+	SubMsg  string //业务返回码描述
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("alipay code(%v) msg(%v) sub_code(%v) sub_msg(%v)", e.Code, e.Msg, e.SubCode, e.SubMsg)
+}
+
+// 交易查询返回 alipay.trade.query
+type TradeQueryRsp struct {
+	AlipayResponse
+	TradeNo        string `json:"trade_no"`         //支付宝交易号
+	OutTradeNo     string `json:"out_trade_no"`     //商户订单号
+	BuyerLogonId   string `json:"buyer_logon_id"`   //买家支付宝账号
+	TradeStatus    string `json:"trade_status"`     //交易状态 TradeStatusXxx
+	TotalAmount    string `json:"total_amount"`     //交易的订单金额 单位为元
+	BuyerPayAmount string `json:"buyer_pay_amount"` //买家实付金额
+	ReceiptAmount  string `json:"receipt_amount"`   //实收金额
+	SendPayDate    string `json:"send_pay_date"`    //本次交易打款给卖家的时间
+	BuyerUserId    string `json:"buyer_user_id"`    //买家在支付宝的用户id
+}
+
+// 交易关闭返回 alipay.trade.close
+type TradeCloseRsp struct {
+	AlipayResponse
+	TradeNo    string `json:"trade_no"`     //支付宝交易号
+	OutTradeNo string `json:"out_trade_no"` //商户订单号
+}
+
+// 交易退款返回 alipay.trade.refund
+type TradeRefundRsp struct {
+	AlipayResponse
+	TradeNo      string `json:"trade_no"`       //支付宝交易号
+	OutTradeNo   string `json:"out_trade_no"`   //商户订单号
+	BuyerLogonId string `json:"buyer_logon_id"` //用户的登录id
+	FundChange   string `json:"fund_change"`    //本次退款是否发生了资金变化 Y/N
+	RefundFee    string `json:"refund_fee"`     //退款总金额
+	GmtRefundPay string `json:"gmt_refund_pay"` //退款支付时间
+	BuyerUserId  string `json:"buyer_user_id"`  //买家在支付宝的用户id
+	SendBackFee  string `json:"send_back_fee"`  //本次商户实际退回金额
+}
+
+// 退款查询返回 alipay.trade.fastpay.refund.query
+type TradeRefundQueryRsp struct {
+	AlipayResponse
+	TradeNo      string `json:"trade_no"`       //支付宝交易号
+	OutTradeNo   string `json:"out_trade_no"`   //商户订单号
+	OutRequestNo string `json:"out_request_no"` //退款请求号
+	TotalAmount  string `json:"total_amount"`   //该笔退款所对应的交易的订单金额
+	RefundAmount string `json:"refund_amount"`  //本次退款请求对应的退款金额
+	RefundStatus string `json:"refund_status"`  //退款状态 REFUND_SUCCESS
+	GmtRefundPay string `json:"gmt_refund_pay"` //退款时间
+}
+
+/*
+[WithApiDomain]-> 替换支付宝网关,如沙箱环境 https://openapi-sandbox.dl.alipaydev.com/gateway.do
+*/
+func WithApiDomain(apiDomain string) OptionFunc {
+	return func(c *Client) {
+		c.apiDomain = apiDomain
+	}
+}
+
+/*
+[TradePagePay]-> 电脑网站支付 alipay.trade.page.pay
+返回支付页面url,由浏览器跳转
+*/
+func (c *Client) TradePagePay(a *AliPayReq) (string, error) {
+	productCode := a.ProductCode
+	if productCode == "" {
+		productCode = ProductCode
+	}
+	bizContent := map[string]interface{}{
+		"out_trade_no": a.OutTradeNo,
+		"total_amount": a.TotalAmount.String(),
+		"subject":      a.Subject,
+		"product_code": productCode,
+	}
+	if a.TimeExpire != "" {
+		bizContent["time_expire"] = a.TimeExpire
+	}
+	if a.QrPayMode != "" {
+		bizContent["qr_pay_mode"] = a.QrPayMode
+	}
+	vals, err := c.publicParams("alipay.trade.page.pay", bizContent)
+	if err != nil {
+		return "", err
+	}
+	if a.NotifyURL != "" {
+		vals.Set("notify_url", a.NotifyURL)
+	}
+	if a.ReturnURL != "" {
+		vals.Set("return_url", a.ReturnURL)
+	}
+	if a.AppAuthToken != "" {
+		vals.Set("app_auth_token", a.AppAuthToken)
+	}
+	if err = c.sign(vals); err != nil {
+		return "", err
+	}
+	a.PayType = aliPay
+	return fmt.Sprintf("%v?%v", c.apiDomain, vals.Encode()), nil
+}
+
+/*
+[TradeQuery]-> 交易查询 alipay.trade.query
+outTradeNo和tradeNo二选一
+*/
+func (c *Client) TradeQuery(ctx context.Context, outTradeNo, tradeNo string) (*TradeQueryRsp, error) {
+	res := &TradeQueryRsp{}
+	if err := c.Execute(ctx, "alipay.trade.query", tradeNoBizContent(outTradeNo, tradeNo), res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+/*
+[TradeClose]-> 关闭未付款的交易 alipay.trade.close
+outTradeNo和tradeNo二选一
+*/
+func (c *Client) TradeClose(ctx context.Context, outTradeNo, tradeNo string) (*TradeCloseRsp, error) {
+	res := &TradeCloseRsp{}
+	if err := c.Execute(ctx, "alipay.trade.close", tradeNoBizContent(outTradeNo, tradeNo), res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+/*
+[TradeRefund]-> 交易退款 alipay.trade.refund
+部分退款及同一笔交易多次退款时OutRequestNo必填
+*/
+func (c *Client) TradeRefund(ctx context.Context, a *AliPayRefundReq) (*TradeRefundRsp, error) {
+	bizContent := tradeNoBizContent(a.OutTradeNo, a.TradeNo)
+	bizContent["refund_amount"] = a.RefundAmount.String()
+	if a.RefundReason != "" {
+		bizContent["refund_reason"] = a.RefundReason
+	}
+	if a.OutRequestNo != "" {
+		bizContent["out_request_no"] = a.OutRequestNo
+	}
+	res := &TradeRefundRsp{}
+	if err := c.Execute(ctx, "alipay.trade.refund", bizContent, res); err != nil {
+		return nil, err
+	}
+	Debug(a.Debug, "TradeRefund-> refund(%v) response(%v)", a.OutRequestNo, res)
+	return res, nil
+}
+
+/*
+[TradeRefundQuery]-> 退款查询 alipay.trade.fastpay.refund.query
+outTradeNo和tradeNo二选一
+outRequestNo: 退款请求号,全额退款且未传退款请求号时为商户订单号
+*/
+func (c *Client) TradeRefundQuery(ctx context.Context, outTradeNo, tradeNo, outRequestNo string) (*TradeRefundQueryRsp, error) {
+	if outRequestNo == "" {
+		outRequestNo = outTradeNo
+	}
+	bizContent := tradeNoBizContent(outTradeNo, tradeNo)
+	bizContent["out_request_no"] = outRequestNo
+	bizContent["query_options"] = []string{"gmt_refund_pay"}
+	res := &TradeRefundQueryRsp{}
+	if err := c.Execute(ctx, "alipay.trade.fastpay.refund.query", bizContent, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+/*
+[Execute]-> 调用支付宝开放平台接口
+method: 接口名称 示例 "alipay.trade.query"
+bizContent: 业务参数,序列化为biz_content
+res: 应答中xxx_response节点的结构
+已加载支付宝公钥时校验应答签名;code不为10000时返回*Error
+*/
+func (c *Client) Execute(ctx context.Context, method string, bizContent interface{}, res interface{}) error {
+	vals, err := c.publicParams(method, bizContent)
+	if err != nil {
+		return err
+	}
+	if err = c.sign(vals); err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.apiDomain, strings.NewReader(vals.Encode()))
+	if err != nil {
+		fmt.Printf("Execute-> NewRequest(%v) error(%v)", method, err)
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded;charset=utf-8")
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	httpRes, err := client.Do(httpReq)
+	if err != nil {
+		fmt.Printf("Execute-> Do(%v) error(%v)", method, err)
+		return err
+	}
+	defer httpRes.Body.Close()
+	body, err := ioutil.ReadAll(httpRes.Body)
+	if err != nil {
+		fmt.Printf("Execute-> read response(%v) body error(%v)", method, err)
+		return err
+	}
+	if httpRes.StatusCode != http.StatusOK {
+		return fmt.Errorf("Execute-> %v http status(%v) body(%v)", method, httpRes.StatusCode, string(body))
+	}
+
+	node, err := c.responseNode(method, body)
+	if err != nil {
+		return err
+	}
+	common := &AlipayResponse{}
+	if err = json.Unmarshal(node, common); err != nil {
+		fmt.Printf("Execute-> Unmarshal body(%v) error(%v)", string(body), err)
+		return err
+	}
+	if common.Code != aliCodeSuccess {
+		return &Error{Code: common.Code, Msg: common.Msg, SubCode: common.SubCode, SubMsg: common.SubMsg}
+	}
+	if err = json.Unmarshal(node, res); err != nil {
+		fmt.Printf("Execute-> Unmarshal body(%v) error(%v)", string(body), err)
+		return err
+	}
+	return nil
+}
+
+// 取出xxx_response节点原文并验签 错误应答的节点为error_response
+func (c *Client) responseNode(method string, body []byte) (json.RawMessage, error) {
+	var nodes map[string]json.RawMessage
+	if err := json.Unmarshal(body, &nodes); err != nil {
+		fmt.Printf("Execute-> Unmarshal body(%v) error(%v)", string(body), err)
+		return nil, err
+	}
+	node, ok := nodes[strings.ReplaceAll(method, ".", "_")+"_response"]
+	if !ok {
+		if node, ok = nodes["error_response"]; !ok {
+			return nil, fmt.Errorf("Execute-> %v response not found in body(%v)", method, string(body))
+		}
+	}
+	c.mux.Lock()
+	publicKey := c.aliPublicKeyList[c.aliPublicCertSN]
+	c.mux.Unlock()
+	if publicKey == nil {
+		return node, nil
+	}
+	var sign string
+	if raw, ok := nodes["sign"]; ok {
+		if err := json.Unmarshal(raw, &sign); err != nil {
+			return nil, err
+		}
+	}
+	if sign == "" {
+		//网关层错误(如app_id无效)可能不带签名,此时只返回错误
+		common := &AlipayResponse{}
+		if json.Unmarshal(node, common) == nil && common.Code != "" && common.Code != aliCodeSuccess {
+			return node, nil
+		}
+		return nil, ErrResponseSign
+	}
+	if !verifyResponseSign(node, sign, publicKey) {
+		return nil, ErrResponseSign
+	}
+	return node, nil
+}
+
+// 公共请求参数
+func (c *Client) publicParams(method string, bizContent interface{}) (url.Values, error) {
+	biz, err := json.Marshal(bizContent)
+	if err != nil {
+		fmt.Printf("publicParams-> Marshal bizContent(%v) error(%v)", bizContent, err)
+		return nil, err
+	}
+	vals := url.Values{}
+	vals.Set("app_id", c.appId)
+	vals.Set("method", method)
+	vals.Set("format", "JSON")
+	vals.Set("charset", "utf-8")
+	vals.Set("sign_type", "RSA2")
+	vals.Set("timestamp", time.Now().In(beijing).Format(notifyTimeLayout))
+	vals.Set("version", "1.0")
+	vals.Set("biz_content", string(biz))
+	return vals, nil
+}
+
+// 请求签名 除sign外的非空参数按参数名排序,以k=v&k=v拼接后SHA256WithRSA
+func (c *Client) sign(vals url.Values) error {
+	keys := make([]string, 0, len(vals))
+	for k := range vals {
+		if k == "sign" || vals.Get(k) == "" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	signData := make([]string, 0, len(keys))
+	for _, k := range keys {
+		signData = append(signData, k+"="+vals.Get(k))
+	}
+	sign, err := ShaSign(strings.Join(signData, "&"), c.appPrivateKey)
+	if err != nil {
+		fmt.Printf("sign-> ShaSign error(%v)", err)
+		return err
+	}
+	vals.Set("sign", sign)
+	return nil
+}
+
+func verifyResponseSign(node []byte, sign string, publicKey *rsa.PublicKey) bool {
+	signBytes, err := base64.StdEncoding.DecodeString(sign)
+	if err != nil {
+		return false
+	}
+	hashed := sha256.Sum256(node)
+	return rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hashed[:], signBytes) == nil
+}
+
+func tradeNoBizContent(outTradeNo, tradeNo string) map[string]interface{} {
+	bizContent := map[string]interface{}{}
+	if outTradeNo != "" {
+		bizContent["out_trade_no"] = outTradeNo
+	}
+	if tradeNo != "" {
+		bizContent["trade_no"] = tradeNo
+	}
+	return bizContent
+}
@@ -0,0 +1,100 @@
+package alipay
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+	"github.com/tanjl855/Sms_Pay_SDK/paytest"
+)
+
+// 接入模拟支付宝网关的Client aliPublicKey为空时使用模拟网关的支付宝公钥
+func newTestTradeClient(t *testing.T, fake *paytest.Alipay, aliPublicKey string) *Client {
+	client, err := NewAlipayClient(fake.AppId, fake.AppPrivateKey, WithApiDomain(fake.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aliPublicKey == "" {
+		aliPublicKey = fake.AlipayPublicKey
+	}
+	if err = client.LoadAliPayPublicKey(aliPublicKey); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestTrade(t *testing.T) {
+	fake := paytest.NewAlipay()
+	defer fake.Close()
+	client := newTestTradeClient(t, fake, "")
+	ctx := context.Background()
+
+	uri, err := client.TradePagePay(NewAliPayReq("", "iPhone", "6823789339978248", money.Fen(8888), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Query().Get("method") != "alipay.trade.page.pay" || !strings.Contains(u.Query().Get("biz_content"), `"product_code":"FAST_INSTANT_TRADE_PAY"`) || !strings.Contains(u.Query().Get("biz_content"), `"total_amount":"88.88"`) || u.Query().Get("sign") == "" {
+		t.Errorf("unexpected page pay url(%v)", uri)
+	}
+	openPayPage(t, uri)
+
+	queryRsp, err := client.TradeQuery(ctx, "6823789339978248", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queryRsp.TradeStatus != TradeStatusWaitBuyerPay || queryRsp.TotalAmount != "88.88" || queryRsp.OutTradeNo != "6823789339978248" {
+		t.Errorf("unexpected query response(%+v)", queryRsp)
+	}
+	//通知地址为空 不发送通知
+	if err = fake.Pay("6823789339978248"); err != nil {
+		t.Fatal(err)
+	}
+
+	//未支付的交易可关闭 已支付的交易关闭失败
+	if uri, err = client.TradePagePay(NewAliPayReq("", "iPhone", "6823789339978249", money.Fen(100), "")); err != nil {
+		t.Fatal(err)
+	}
+	openPayPage(t, uri)
+	if _, err = client.TradeClose(ctx, "6823789339978249", ""); err != nil {
+		t.Error(err)
+	}
+	_, err = client.TradeClose(ctx, "6823789339978248", "")
+	var aliErr *Error
+	if !errors.As(err, &aliErr) || aliErr.SubCode != "ACQ.TRADE_STATUS_ERROR" {
+		t.Errorf("unexpected close error(%v)", err)
+	}
+
+	refundRsp, err := client.TradeRefund(ctx, NewAliPayRefundReq("6823789339978248", "", money.Fen(1000), "正常退款", "refund-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refundRsp.RefundFee != "10.00" || refundRsp.FundChange != "Y" {
+		t.Errorf("unexpected refund response(%+v)", refundRsp)
+	}
+
+	refundQueryRsp, err := client.TradeRefundQuery(ctx, "6823789339978248", "", "refund-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refundQueryRsp.RefundStatus != RefundStatusSuccess || refundQueryRsp.OutRequestNo != "refund-1" || refundQueryRsp.RefundAmount != "10.00" {
+		t.Errorf("unexpected refund query response(%+v)", refundQueryRsp)
+	}
+}
+
+func TestTradeResponseSign(t *testing.T) {
+	fake := paytest.NewAlipay()
+	defer fake.Close()
+	//使用其他支付宝公钥验签 模拟网关的应答验签失败
+	_, otherPublicKey := newTestAliKey(t)
+	client := newTestTradeClient(t, fake, otherPublicKey)
+	if _, err := client.TradeQuery(context.Background(), "6823789339978248", ""); !errors.Is(err, ErrResponseSign) {
+		t.Errorf("expect ErrResponseSign, got(%v)", err)
+	}
+}
@@ -0,0 +1,269 @@
+package httprecord
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"unicode/utf8"
+)
+
+//录制/回放渠道的HTTP交互,用于无网络的应答解析回归测试
+//录制模式下请求真实服务,脱敏(密钥、签名、手机号)后写入golden文件;回放模式下按请求匹配录制的交互并返回录制的应答
+//回放时请求按同样规则脱敏后,与录制的请求比较方法、路径、query参数及body,时间戳等每次请求都不同的参数(IgnoreParams)不参与比较
+//Recorder实现http.RoundTripper,通过Client()接入alipay.WithHttpClient、wechatpay.Config.HttpClient、
+//AliyunAdaptor.Client(dysmsapi.Client.SetTransport)及TianYiyunAdaptor.HttpClient
+
+type Mode int
+
+const (
+	ModeReplay Mode = iota //只回放 没有匹配的录制时返回ErrNoInteraction
+	ModeRecord             //请求真实服务并录制 覆盖原golden文件
+)
+
+// 环境变量HTTPRECORD_MODE=record时为录制模式 用于重新录制golden文件
+const ModeEnv = "HTTPRECORD_MODE"
+
+var ErrNoInteraction = errors.New("httprecord: no recorded interaction matches request")
+
+// 每次请求都不同、回放时不比较的参数 支付宝、天翼云: timestamp;阿里云短信: Timestamp(SignatureNonce及签名已脱敏)
+var DefaultIgnoreParams = []string{"timestamp", "Timestamp"}
+
+// [ModeFromEnv] 按环境变量HTTPRECORD_MODE选择模式 默认回放
+func ModeFromEnv() Mode {
+	if os.Getenv(ModeEnv) == "record" {
+		return ModeRecord
+	}
+	return ModeReplay
+}
+
+// golden文件内容
+type Cassette struct {
+	Synthetic    bool           `json:"synthetic,omitempty"` //非真实服务录制(如应答按文档示例编写) 录制模式写入的文件为false
+	Comment      string         `json:"comment,omitempty"`   //[非必填]来源说明
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// 一次请求及应答
+type Interaction struct {
+	Request  *Request  `json:"request"`
+	Response *Response `json:"response"`
+}
+
+type Request struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	BodyBase64 string      `json:"body_base64,omitempty"` //非UTF-8内容(如gzip账单)
+}
+
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	BodyBase64 string      `json:"body_base64,omitempty"` //非UTF-8内容(如gzip账单)
+}
+
+var _ http.RoundTripper = &Recorder{}
+
+// 录制/回放Transport
+type Recorder struct {
+	Path         string                                 //golden文件位置
+	Mode         Mode                                   //录制或回放
+	Transport    http.RoundTripper                      //[非必填]录制时请求真实服务使用 默认http.DefaultTransport
+	Redactor     *Redactor                              //[非必填]录制及回放匹配时的脱敏规则 默认DefaultRedactor
+	IgnoreParams []string                               //回放时不比较的query参数、表单参数及JSON字段 默认DefaultIgnoreParams
+	Match        func(r *http.Request, i *Request) bool //[非必填]自定义匹配 不为nil时忽略默认匹配规则
+	mu           sync.Mutex
+	cassette     *Cassette
+	used         []bool
+}
+
+/*
+[New]-> 创建Recorder
+path: golden文件位置,回放模式下读取,录制模式下每次请求后写入
+*/
+func New(path string, mode Mode) (*Recorder, error) {
+	r := &Recorder{Path: path, Mode: mode, IgnoreParams: DefaultIgnoreParams, cassette: &Cassette{}}
+	if mode == ModeRecord {
+		return r, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Printf("httprecord.New-> ReadFile(%v) error(%v)", path, err)
+		return nil, err
+	}
+	if err = json.Unmarshal(data, r.cassette); err != nil {
+		fmt.Printf("httprecord.New-> Unmarshal(%v) error(%v)", path, err)
+		return nil, err
+	}
+	r.used = make([]bool, len(r.cassette.Interactions))
+	return r, nil
+}
+
+// [Client] 使用Recorder的http.Client
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if r.Mode == ModeRecord {
+		return r.record(req)
+	}
+	return r.replay(req)
+}
+
+// 按录制顺序返回第一个未使用且匹配的交互
+func (r *Recorder) replay(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		if reqBody, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, interaction := range r.cassette.Interactions {
+		if r.used[i] || !r.match(req, reqBody, interaction.Request) {
+			continue
+		}
+		r.used[i] = true
+		body, err := decodeBody(interaction.Response.Body, interaction.Response.BodyBase64)
+		if err != nil {
+			return nil, err
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", interaction.Response.StatusCode, http.StatusText(interaction.Response.StatusCode)),
+			StatusCode:    interaction.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        interaction.Response.Header.Clone(),
+			Body:          ioutil.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: %v %v", ErrNoInteraction, req.Method, req.URL)
+}
+
+// 请求按录制时的规则脱敏后比较 忽略host及IgnoreParams
+func (r *Recorder) match(req *http.Request, reqBody []byte, recorded *Request) bool {
+	if r.Match != nil {
+		return r.Match(req, recorded)
+	}
+	if req.Method != recorded.Method {
+		return false
+	}
+	recordedURL, err := url.Parse(recorded.URL)
+	if err != nil || recordedURL.Path != req.URL.Path {
+		return false
+	}
+	redactor, ignore := r.redactor(), &Redactor{Params: r.IgnoreParams}
+	reqURL, err := url.Parse(redactor.URL(req.URL.String()))
+	if err != nil || ignore.values(reqURL.Query()).Encode() != ignore.values(recordedURL.Query()).Encode() {
+		return false
+	}
+	recordedBody, err := decodeBody(recorded.Body, recorded.BodyBase64)
+	if err != nil {
+		return false
+	}
+	body := ignore.Body(req.Header.Get("Content-Type"), redactor.Body(req.Header.Get("Content-Type"), reqBody))
+	return bytes.Equal(body, ignore.Body(recorded.Header.Get("Content-Type"), recordedBody))
+}
+
+func (r *Recorder) redactor() *Redactor {
+	if r.Redactor == nil {
+		return DefaultRedactor
+	}
+	return r.Redactor
+}
+
+// 请求真实服务 脱敏后追加到golden文件
+func (r *Recorder) record(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		if reqBody, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resBody, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(resBody))
+
+	redactor := r.redactor()
+	interaction := &Interaction{
+		Request: &Request{
+			Method: req.Method,
+			URL:    redactor.URL(req.URL.String()),
+			Header: redactor.Header(req.Header),
+		},
+		Response: &Response{
+			StatusCode: res.StatusCode,
+			Header:     redactor.Header(res.Header),
+		},
+	}
+	interaction.Request.Body, interaction.Request.BodyBase64 = encodeBody(redactor.Body(req.Header.Get("Content-Type"), reqBody))
+	interaction.Response.Body, interaction.Response.BodyBase64 = encodeBody(redactor.Body(res.Header.Get("Content-Type"), resBody))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	if err = r.save(); err != nil {
+		fmt.Printf("Recorder.record-> save(%v) error(%v)", r.Path, err)
+		return nil, err
+	}
+	return res, nil
+}
+
+func (r *Recorder) save() error {
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(r.cassette); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.Path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.Path, buf.Bytes(), 0644)
+}
+
+func encodeBody(body []byte) (text, base64Text string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return "", base64.StdEncoding.EncodeToString(body)
+}
+
+func decodeBody(text, base64Text string) ([]byte, error) {
+	if base64Text != "" {
+		return base64.StdEncoding.DecodeString(base64Text)
+	}
+	return []byte(text), nil
+}
@@ -0,0 +1,148 @@
+package httprecord
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func get(t *testing.T, client *http.Client, uri string) (int, string) {
+	res, err := client.Get(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, string(body)
+}
+
+func TestRecordReplay(t *testing.T) {
+	var seq int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seq++
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Wechatpay-Signature", "c2lnbmF0dXJl")
+		if r.URL.Query().Get("method") == "alipay.trade.query" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		fmt.Fprintf(w, `{"seq":%d,"phones":"18200771880,13800138000","detail":{"sign":"c2lnbg=="},"url":"a&b"}`, seq)
+	}))
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	recorder, err := New(path, ModeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := url.Values{"method": {"alipay.trade.refund"}, "sign": {"c2lnbg=="}, "phone": {"18200771880"}}
+	client := recorder.Client()
+	for i := 1; i <= 2; i++ {
+		if status, body := get(t, client, server.URL+"/gateway.do?"+query.Encode()); status != http.StatusOK || !strings.Contains(body, "18200771880") {
+			t.Fatalf("record should return the real response, got(%v, %v)", status, body)
+		}
+	}
+	query.Set("method", "alipay.trade.query")
+	get(t, client, server.URL+"/gateway.do?"+query.Encode())
+	server.Close()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"c2lnbg==", "c2lnbmF0dXJl", "18200771880", "13800138000"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("cassette contains %v: %s", secret, data)
+		}
+	}
+	if !strings.Contains(string(data), `a&b`) {
+		t.Errorf("cassette should keep body readable: %s", data)
+	}
+
+	recorder, err = New(path, ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = recorder.Client()
+	//按录制顺序回放 忽略host,sign按脱敏后比较
+	query.Set("method", "alipay.trade.refund")
+	query.Set("sign", "other")
+	for i := 1; i <= 2; i++ {
+		status, body := get(t, client, "https://openapi.alipay.com/gateway.do?"+query.Encode())
+		expect := fmt.Sprintf(`{"detail":{"sign":"%v"},"phones":"%v,%v","seq":%d,"url":"a&b"}`, Redacted, RedactedPhone, RedactedPhone, i)
+		if status != http.StatusOK || body != expect {
+			t.Errorf("unexpected replay(%v, %v), expect %v", status, body, expect)
+		}
+	}
+	if _, err = client.Get("https://openapi.alipay.com/gateway.do?" + query.Encode()); !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("expect ErrNoInteraction after interactions used, got(%v)", err)
+	}
+	query.Set("method", "alipay.trade.query")
+	if status, _ := get(t, client, "https://openapi.alipay.com/gateway.do?"+query.Encode()); status != http.StatusNotFound {
+		t.Errorf("unexpected replay status(%v)", status)
+	}
+	if _, err = client.Get("https://openapi.alipay.com/other?" + query.Encode()); !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("expect ErrNoInteraction for other path, got(%v)", err)
+	}
+}
+
+// 回放时比较脱敏后的query参数及body 忽略时间戳
+func TestReplayMatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	cassette := `{"synthetic":true,"comment":"合成数据","interactions":[{"request":{"method":"POST",` +
+		`"url":"https://dysmsapi.aliyuncs.com/?Action=SendSms&PhoneNumbers=1**********&Signature=REDACTED&Timestamp=2023-06-01T08%3A00%3A00Z&Version=2017-05-25",` +
+		`"header":{"Content-Type":["application/json"]},"body":"{\"phones\":\"1**********\",\"sign\":\"REDACTED\",\"timestamp\":\"20230601160000000\"}"},` +
+		`"response":{"status_code":200,"body":"{\"Code\":\"OK\"}"}}]}`
+	if err := ioutil.WriteFile(path, []byte(cassette), 0644); err != nil {
+		t.Fatal(err)
+	}
+	post := func(version, body string) error {
+		recorder, err := New(path, ModeReplay)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !recorder.cassette.Synthetic || recorder.cassette.Comment != "合成数据" {
+			t.Errorf("unexpected cassette(%+v)", recorder.cassette)
+		}
+		query := url.Values{"Action": {"SendSms"}, "PhoneNumbers": {"18200771880"}, "Signature": {"abc"}, "Timestamp": {"2026-10-19T08:00:00Z"}, "Version": {version}}
+		res, err := recorder.Client().Post("https://other.aliyuncs.com/?"+query.Encode(), "application/json", strings.NewReader(body))
+		if err == nil {
+			res.Body.Close()
+		}
+		return err
+	}
+	if err := post("2017-05-25", `{"timestamp":"20261019160000000","sign":"xyz","phones":"18200771880"}`); err != nil {
+		t.Errorf("timestamp and redacted params should be ignored, got(%v)", err)
+	}
+	if err := post("2024-05-26", `{"timestamp":"20261019160000000","sign":"xyz","phones":"18200771880"}`); !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("expect ErrNoInteraction for other Version, got(%v)", err)
+	}
+	if err := post("2017-05-25", `{"timestamp":"20261019160000000","sign":"xyz","phones":"18200771880","content":"x"}`); !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("expect ErrNoInteraction for other body, got(%v)", err)
+	}
+}
+
+func TestRedactor(t *testing.T) {
+	redactor := &Redactor{Params: []string{"Signature"}, Phone: true}
+	if res := redactor.phone("18200771880,13800138000;tel:15900000000 订单号20230601182007718801"); res != "1**********,1**********;tel:1********** 订单号20230601182007718801" {
+		t.Errorf("unexpected phone redaction(%v)", res)
+	}
+	if res := redactor.URL("https://dysmsapi.aliyuncs.com/?Signature=abc&PhoneNumbers=18200771880"); res != "https://dysmsapi.aliyuncs.com/?PhoneNumbers=1**********&Signature=REDACTED" {
+		t.Errorf("unexpected url redaction(%v)", res)
+	}
+	if res := string(redactor.Body("application/x-www-form-urlencoded", []byte("Signature=abc&amount=100"))); res != "Signature=REDACTED&amount=100" {
+		t.Errorf("unexpected form redaction(%v)", res)
+	}
+	if res := string(redactor.Body("application/json", []byte(`{"list":[{"Signature":"abc","total":100000000000000001}]}`))); res != `{"list":[{"Signature":"REDACTED","total":100000000000000001}]}` {
+		t.Errorf("unexpected json redaction(%v)", res)
+	}
+	if header := redactor.Header(http.Header{"Content-Length": {"10"}}); header != nil {
+		t.Errorf("unexpected header(%v)", header)
+	}
+}
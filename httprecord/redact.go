@@ -0,0 +1,144 @@
+package httprecord
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// 脱敏后的值
+const (
+	Redacted      = "REDACTED"
+	RedactedPhone = "1**********"
+)
+
+// 脱敏规则 录制时作用于请求及应答的头、URL query、表单及JSON字段
+type Redactor struct {
+	Headers []string //头名称 不区分大小写
+	Params  []string //query、表单参数及JSON字段名(任意层级) 区分大小写
+	Phone   bool     //替换11位手机号
+}
+
+/*
+默认脱敏规则
+支付宝: sign、app_auth_token;微信支付: Authorization、Wechatpay-Signature;阿里云短信: AccessKeyId、Signature;天翼云: sign
+*/
+var DefaultRedactor = &Redactor{
+	Headers: []string{"Authorization", "Wechatpay-Signature", "Cookie", "Set-Cookie"},
+	Params:  []string{"sign", "app_auth_token", "AccessKeyId", "Signature", "SignatureNonce"},
+	Phone:   true,
+}
+
+// 前后不是数字的11位手机号
+var phoneRegexp = regexp.MustCompile(`(^|\D)(1[3-9]\d{9})(\D|$)`)
+
+// 脱敏可能改变body长度,不保存Content-Length
+func (d *Redactor) Header(header http.Header) http.Header {
+	res := header.Clone()
+	res.Del("Content-Length")
+	if len(res) == 0 {
+		return nil
+	}
+	for _, name := range d.Headers {
+		if res.Get(name) != "" {
+			res.Set(name, Redacted)
+		}
+	}
+	for k, values := range res {
+		for i := range values {
+			values[i] = d.phone(values[i])
+		}
+		res[k] = values
+	}
+	return res
+}
+
+func (d *Redactor) URL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return d.phone(rawURL)
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = d.values(u.Query()).Encode()
+	}
+	return d.phone(u.String())
+}
+
+// 按Content-Type脱敏JSON及表单 其他内容只替换手机号
+func (d *Redactor) Body(contentType string, body []byte) []byte {
+	if len(body) == 0 {
+		return body
+	}
+	switch {
+	case strings.Contains(contentType, "json") || json.Valid(body):
+		var v interface{}
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if decoder.Decode(&v) == nil {
+			buf := &bytes.Buffer{}
+			encoder := json.NewEncoder(buf)
+			encoder.SetEscapeHTML(false)
+			if encoder.Encode(d.json(v)) == nil {
+				body = bytes.TrimRight(buf.Bytes(), "\n")
+			}
+		}
+	case strings.Contains(contentType, "application/x-www-form-urlencoded"):
+		if form, err := url.ParseQuery(string(body)); err == nil {
+			body = []byte(d.values(form).Encode())
+		}
+	}
+	return []byte(d.phone(string(body)))
+}
+
+func (d *Redactor) values(vals url.Values) url.Values {
+	for _, name := range d.Params {
+		if _, ok := vals[name]; ok {
+			vals.Set(name, Redacted)
+		}
+	}
+	return vals
+}
+
+func (d *Redactor) json(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, value := range v {
+			if d.isParam(k) {
+				v[k] = Redacted
+			} else {
+				v[k] = d.json(value)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = d.json(v[i])
+		}
+	}
+	return v
+}
+
+func (d *Redactor) isParam(name string) bool {
+	for _, param := range d.Params {
+		if param == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Redactor) phone(s string) string {
+	if !d.Phone {
+		return s
+	}
+	//相邻的手机号共用分隔符,重复替换直到没有手机号
+	for {
+		res := phoneRegexp.ReplaceAllString(s, "${1}"+RedactedPhone+"${3}")
+		if res == s {
+			return res
+		}
+		s = res
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 
+	sdkerrors "github.com/aliyun/alibaba-cloud-sdk-go/sdk/errors"
 	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
 	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/responses"
 	dysmsapi "github.com/aliyun/alibaba-cloud-sdk-go/services/dysmsapi"
@@ -56,9 +57,12 @@ const (
 )
 
 // aliyun's request
+// 接入点可通过RpcRequest的Scheme/Domain/Port替换(如测试环境),RegionId为空时使用AliyunSmsRegionId
 type AliyunAdaptor struct {
 	*requests.RpcRequest                  //请求参数*Method/Scheme...*
-	Client               *dysmsapi.Client //Aliyun sdk's client
+	Client               *dysmsapi.Client //Aliyun sdk's client 为nil时每次发送按RegionId及AccessKey创建
+	AccessKeyId          string           //[非必填]默认AliyunSmsAccessKeyId
+	AccessKeySecret      string           //[非必填]默认AliyunSmsAccessKeySecret
 	Mock                 bool             //mock error
 	CodeType             string           //需要mock的错误or成功返回Code值
 	SmsUpExtendCode      string           `position:"Query" name:"SmsUpExtendCode"` //上行短信扩展码
@@ -101,13 +105,16 @@ func NewAliyunAdaptor(signName, templateCode, templateParam string) *AliyunAdapt
 // Aliyun-> Send logic
 func (adaptor *AliyunAdaptor) Send(phone string) (*SmsResponse, error) {
 	Debug(adaptor.Debug, "Send message by Aliyun(%v)", adaptor)
-	var err error
-	adaptor.Client, err = dysmsapi.NewClientWithAccessKey(AliyunSmsRegionId,
-		AliyunSmsAccessKeyId, AliyunSmsAccessKeySecret)
-	if err != nil {
-		return nil, err
+	//未指定Client时每次按当前的RegionId及AccessKey创建 不缓存,修改AccessKey后立即生效
+	client := adaptor.Client
+	if client == nil {
+		var err error
+		client, err = dysmsapi.NewClientWithAccessKey(adaptor.regionId(), adaptor.accessKeyId(), adaptor.accessKeySecret())
+		if err != nil {
+			return nil, err
+		}
+		Debug(adaptor.Debug, "Init client done!")
 	}
-	Debug(adaptor.Debug, "Init client done!")
 	//send api
 	adaptor.PhoneNumbers = phone
 	response := &AliyunSmsResponse{}
@@ -124,7 +131,17 @@ func (adaptor *AliyunAdaptor) Send(phone string) (*SmsResponse, error) {
 		return smsRes, nil
 	}
 
-	err = adaptor.Client.DoAction(adaptor, response)
+	err := client.DoAction(adaptor, response)
+	var serverErr *sdkerrors.ServerError
+	if errors.As(err, &serverErr) && serverErr.ErrorCode() == SignatureNonceUsed {
+		//SignatureNonceUsed以非2xx返回,转换为Code交由SendSms重发;鉴权等其他网关错误仍作为error返回
+		Debug(adaptor.Debug, "Send message server error(%v)", serverErr)
+		smsRes.Code = serverErr.ErrorCode()
+		smsRes.Message = serverErr.Message()
+		smsRes.RequestId = serverErr.RequestId()
+		smsRes.SmsType = ALIYUN
+		return smsRes, nil
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -136,3 +153,24 @@ func (adaptor *AliyunAdaptor) Send(phone string) (*SmsResponse, error) {
 	Debug(adaptor.Debug, "Send message success,smsRes: %v", smsRes)
 	return smsRes, err
 }
+
+func (adaptor *AliyunAdaptor) regionId() string {
+	if adaptor.RegionId == "" {
+		return AliyunSmsRegionId
+	}
+	return adaptor.RegionId
+}
+
+func (adaptor *AliyunAdaptor) accessKeyId() string {
+	if adaptor.AccessKeyId == "" {
+		return AliyunSmsAccessKeyId
+	}
+	return adaptor.AccessKeyId
+}
+
+func (adaptor *AliyunAdaptor) accessKeySecret() string {
+	if adaptor.AccessKeySecret == "" {
+		return AliyunSmsAccessKeySecret
+	}
+	return adaptor.AccessKeySecret
+}
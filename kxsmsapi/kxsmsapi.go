@@ -13,6 +13,12 @@ type Adaptor interface {
 var _ Adaptor = &AliyunAdaptor{}
 var _ Adaptor = &TianYiyunAdaptor{}
 
+// [阿里云] 签名随机数重复的返回码
+const SignatureNonceUsed = "SignatureNonceUsed"
+
+// 同一平台SignatureNonceUsed时的最大重发次数
+const maxNonceRetries = 3
+
 type Config struct {
 	OnInit    func() error       // 发送前调用
 	OnRepeat  func() error       // [Aliyun]SignatureNoceUsed重复错误时调用
@@ -65,18 +71,20 @@ func SendSms(phone string, config *Config, adaptor ...Adaptor) (*SmsResponse, er
 		smsRes *SmsResponse
 	)
 	for i := 0; i < len(adaptor); i++ {
-		smsRes, err = adaptor[i].Send(phone)
-		if err != nil {
-			return nil, err
-		}
-		if smsRes.Code == "SignatureNonceUsed" {
-			//[阿里云] SignatureNonceUsed 重复了，重新发送
+		for retry := 0; ; retry++ {
+			smsRes, err = adaptor[i].Send(phone)
+			if err != nil {
+				return nil, err
+			}
+			//[阿里云] SignatureNonceUsed 重复了，重新发送 超过maxNonceRetries次后按其他错误处理
+			if smsRes.Code != SignatureNonceUsed || retry >= maxNonceRetries {
+				break
+			}
 			if config != nil && config.OnRepeat != nil {
 				if err := config.OnRepeat(); err != nil {
 					return nil, err
 				}
 			}
-			return SendSms(phone, config, adaptor...)
 		}
 		if smsRes.Code == "OK" {
 			fmt.Println("SendSms-> send success!")
@@ -2,18 +2,48 @@ package kxsmsapi
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
+
+	sdkerrors "github.com/aliyun/alibaba-cloud-sdk-go/sdk/errors"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/dysmsapi"
+	"github.com/tanjl855/Sms_Pay_SDK/httprecord"
+	"github.com/tanjl855/Sms_Pay_SDK/smstest"
 )
 
+// 接入模拟阿里云短信服务
+func newTestAliyunAdaptor(fake *smstest.Aliyun, templateParam string) *AliyunAdaptor {
+	aliyunAdaptor := NewAliyunAdaptor("xxx", "SMS_Send", templateParam)
+	aliyunAdaptor.Scheme = "http"
+	aliyunAdaptor.Domain = fake.Host()
+	aliyunAdaptor.AccessKeyId = fake.AccessKeyId
+	aliyunAdaptor.AccessKeySecret = fake.AccessKeySecret
+	return aliyunAdaptor
+}
+
 func TestSendSms(t *testing.T) {
+	fake := smstest.NewAliyun()
+	defer fake.Close()
 	code := "1234"
-	aliyunAdaptor := NewAliyunAdaptor("xxx", "SMS_Send", fmt.Sprintf(`{"code":"%v"}`, code))
+	aliyunAdaptor := newTestAliyunAdaptor(fake, fmt.Sprintf(`{"code":"%v"}`, code))
+	var repeats int
+	var errCodes []string
 	config := &Config{
 		OnInit: func() error {
 			fmt.Println("Init")
 			return nil
 		},
+		OnRepeat: func() error {
+			repeats++
+			return nil
+		},
+		OnError: func(code string) error {
+			errCodes = append(errCodes, code)
+			return nil
+		},
 		OnSuccess: func(la string) error {
 			fmt.Println(la)
 			return nil
@@ -21,13 +51,60 @@ func TestSendSms(t *testing.T) {
 	}
 	aliyunAdaptor.Debug = true
 
-	var err error
-	//
-	_, err = SendSms("18200771880", config, aliyunAdaptor)
+	res, err := SendSms("18200771880", config, aliyunAdaptor)
 	if err != nil {
 		t.Error(err)
 		return
 	}
+	messages := fake.Messages()
+	if res.Code != "OK" || res.BizId == "" || res.SmsType != ALIYUN || len(messages) != 1 {
+		t.Fatalf("unexpected res(%+v) messages(%+v)", res, messages)
+	}
+	if message := messages[0]; message.PhoneNumbers != "18200771880" || message.SignName != "xxx" || message.TemplateCode != "SMS_Send" ||
+		message.TemplateParam != `{"code":"1234"}` || message.BizId != res.BizId {
+		t.Errorf("unexpected message(%+v)", message)
+	}
+	//签名随机数重复时重新发送
+	fake.Script(smstest.AliyunSignatureNonceUsed)
+	if res, err = SendSms("18200771880", config, aliyunAdaptor); err != nil || res.Code != "OK" || repeats != 1 {
+		t.Errorf("unexpected res(%+v, %v) repeats(%v)", res, err, repeats)
+	}
+	//流控
+	fake.Script(smstest.AliyunBusinessLimitControl)
+	if _, err = SendSms("18200771880", config, aliyunAdaptor); err == nil || err.Error() != smstest.AliyunBusinessLimitControl {
+		t.Errorf("expect %v error, got(%v)", smstest.AliyunBusinessLimitControl, err)
+	}
+	if _, err = SendSms("1820077188", config, aliyunAdaptor); err == nil || err.Error() != smstest.AliyunMobileNumberIllegal {
+		t.Errorf("expect %v error, got(%v)", smstest.AliyunMobileNumberIllegal, err)
+	}
+	if len(errCodes) != 2 || errCodes[0] != smstest.AliyunBusinessLimitControl {
+		t.Errorf("unexpected error codes(%v)", errCodes)
+	}
+	//密钥错误
+	wrongKeyAdaptor := newTestAliyunAdaptor(fake, `{"code":"1234"}`)
+	wrongKeyAdaptor.AccessKeySecret = "xxx"
+	var serverErr *sdkerrors.ServerError
+	if _, err = SendSms("18200771880", nil, wrongKeyAdaptor); !errors.As(err, &serverErr) || serverErr.ErrorCode() != smstest.AliyunSignatureDoesNotMatch {
+		t.Errorf("expect %v error, got(%v)", smstest.AliyunSignatureDoesNotMatch, err)
+	}
+	if len(fake.Messages()) != 5 {
+		t.Errorf("unexpected messages(%+v)", fake.Messages())
+	}
+	//修改密钥后立即生效 不沿用之前创建的Client
+	aliyunAdaptor.AccessKeySecret = "xxx"
+	if _, err = SendSms("18200771880", nil, aliyunAdaptor); !errors.As(err, &serverErr) || serverErr.ErrorCode() != smstest.AliyunSignatureDoesNotMatch {
+		t.Errorf("expect %v error after key changed, got(%v)", smstest.AliyunSignatureDoesNotMatch, err)
+	}
+	aliyunAdaptor.AccessKeySecret = fake.AccessKeySecret
+	//签名随机数一直重复时 重发maxNonceRetries次后返回错误
+	repeats, errCodes = 0, nil
+	fake.Script(smstest.AliyunSignatureNonceUsed, smstest.AliyunSignatureNonceUsed, smstest.AliyunSignatureNonceUsed, smstest.AliyunSignatureNonceUsed)
+	if _, err = SendSms("18200771880", config, aliyunAdaptor); err == nil || err.Error() != SignatureNonceUsed || repeats != maxNonceRetries {
+		t.Errorf("expect %v error, got(%v) repeats(%v)", SignatureNonceUsed, err, repeats)
+	}
+	if len(errCodes) != 1 || errCodes[0] != SignatureNonceUsed {
+		t.Errorf("unexpected error codes(%v)", errCodes)
+	}
 	//mock error
 	//
 	aliyunAdaptor.Mock = true
@@ -70,8 +147,11 @@ func TestSendSms(t *testing.T) {
 
 func TestTianYiyun(t *testing.T) {
 	// 天翼云
+	fake := smstest.NewTianYiyun()
+	defer fake.Close()
 	code := "6666"
-	tianYiyunAdaptor := NewTianYiyunAdaptor("111.11.121.151:1234", "xxx", "xxxx", "xxxx", "【tanjl】您的短信验证码：%v，该验证码5分钟内有效，请勿泄露于他人！", code)
+	content := "【tanjl】您的短信验证码：%v，该验证码5分钟内有效，请勿泄露于他人！"
+	tianYiyunAdaptor := NewTianYiyunAdaptor(fake.Host(), fake.EnterpriseNo, fake.Account, fake.HttpSignKey, content, code)
 	tianYiyunAdaptor.Debug = true
 	res, err := SendSms("18200771880", nil, tianYiyunAdaptor)
 	if err != nil {
@@ -84,4 +164,102 @@ func TestTianYiyun(t *testing.T) {
 		return
 	}
 	fmt.Printf("response:%v", string(byteRes))
+	messages := fake.Messages()
+	if res.Code != "OK" || res.SmsType != TIANYIYUN || len(messages) != 1 || res.RequestId != messages[0].MsgId {
+		t.Fatalf("unexpected res(%+v) messages(%+v)", res, messages)
+	}
+	if messages[0].Phones != "18200771880" || !strings.Contains(messages[0].Content, "验证码：6666") {
+		t.Errorf("unexpected message(%+v)", messages[0])
+	}
+	fake.Script("发送频率过快")
+	if _, err = SendSms("18200771880", nil, tianYiyunAdaptor); err == nil || err.Error() != "发送频率过快" {
+		t.Errorf("expect 发送频率过快 error, got(%v)", err)
+	}
+	wrongKeyAdaptor := NewTianYiyunAdaptor(fake.Host(), fake.EnterpriseNo, fake.Account, "xxxx", content, code)
+	if _, err = SendSms("18200771880", nil, wrongKeyAdaptor); err == nil || err.Error() != smstest.TianYiyunSignError {
+		t.Errorf("expect %v error, got(%v)", smstest.TianYiyunSignError, err)
+	}
+}
+
+func TestGetTimeStamp(t *testing.T) {
+	timestamp := time.Date(2023, 6, 1, 9, 5, 7, 0, time.Local).Unix()
+	if res := GetTimeStamp(timestamp); res != "20230601090507000" {
+		t.Errorf("unexpected timestamp(%v)", res)
+	}
+}
+
+// 第一个平台失败时使用下一个平台发送
+func TestSendSmsFallback(t *testing.T) {
+	aliyun, tianYiyun := smstest.NewAliyun(), smstest.NewTianYiyun()
+	defer aliyun.Close()
+	defer tianYiyun.Close()
+	aliyun.Script(smstest.AliyunBusinessLimitControl)
+	aliyunAdaptor := newTestAliyunAdaptor(aliyun, `{"code":"1234"}`)
+	tianYiyunAdaptor := NewTianYiyunAdaptor(tianYiyun.Host(), tianYiyun.EnterpriseNo, tianYiyun.Account, tianYiyun.HttpSignKey, "您的短信验证码：%v", "1234")
+	res, err := SendSms("18200771880", nil, aliyunAdaptor, tianYiyunAdaptor)
+	if err != nil || res.SmsType != TIANYIYUN {
+		t.Errorf("unexpected res(%+v, %v)", res, err)
+	}
+	if len(aliyun.Messages()) != 1 || len(tianYiyun.Messages()) != 1 {
+		t.Errorf("unexpected aliyun messages(%+v) tianyiyun messages(%+v)", aliyun.Messages(), tianYiyun.Messages())
+	}
+	//鉴权失败直接返回错误 不切换平台
+	aliyunAdaptor = newTestAliyunAdaptor(aliyun, `{"code":"1234"}`)
+	aliyunAdaptor.AccessKeyId = "xxx"
+	var serverErr *sdkerrors.ServerError
+	if _, err = SendSms("18200771880", nil, aliyunAdaptor, tianYiyunAdaptor); !errors.As(err, &serverErr) || serverErr.ErrorCode() != smstest.AliyunInvalidAccessKeyId {
+		t.Errorf("expect %v error, got(%v)", smstest.AliyunInvalidAccessKeyId, err)
+	}
+	if len(tianYiyun.Messages()) != 1 {
+		t.Errorf("auth error should not fall back, tianyiyun messages(%+v)", tianYiyun.Messages())
+	}
+}
+
+// 回放golden文件中的天翼云应答 解析TianYiyunRes
+// golden文件为手工维护的合成数据,不能重新录制,请求需与TianYiyunAdaptor生成的一致
+func TestTianYiyunReplay(t *testing.T) {
+	rec, err := httprecord.New("testdata/httprecord/tianyiyun.json", httprecord.ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := "【tanjl】您的短信验证码：%v，该验证码5分钟内有效，请勿泄露于他人！"
+	tianYiyunAdaptor := NewTianYiyunAdaptor("111.11.121.151:1234", "10001", "tanjl", "xxxx", content, "6666")
+	tianYiyunAdaptor.HttpClient = rec.Client()
+	res, err := SendSms("18200771880", nil, tianYiyunAdaptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != "OK" || res.Message != "成功" || res.RequestId != "23060116000012001" || res.TimeStamp != "20230601160000120" ||
+		res.Sign != httprecord.Redacted || res.SmsType != TIANYIYUN {
+		t.Errorf("unexpected res(%+v)", res)
+	}
+	if _, err = SendSms("18200771880", nil, tianYiyunAdaptor); err == nil || err.Error() != "账号余额不足" {
+		t.Errorf("expect 账号余额不足 error, got(%v)", err)
+	}
+}
+
+// 回放golden文件中的阿里云短信应答
+// golden文件为手工维护的合成数据,不能重新录制,请求需与AliyunAdaptor生成的一致
+func TestAliyunReplay(t *testing.T) {
+	rec, err := httprecord.New("testdata/httprecord/aliyun.json", httprecord.ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := dysmsapi.NewClientWithAccessKey(AliyunSmsRegionId, "xxx", "xxx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetTransport(rec)
+	aliyunAdaptor := NewAliyunAdaptor("阿里云短信测试", "SMS_154950909", `{"code":"1234"}`)
+	aliyunAdaptor.Client = client
+	res, err := SendSms("18200771880", nil, aliyunAdaptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != "OK" || res.BizId != "900619746936498440^0" || res.RequestId != "F655A8D5-B967-440B-8683-DAD6FF8DE990" || res.SmsType != ALIYUN {
+		t.Errorf("unexpected res(%+v)", res)
+	}
+	if _, err = SendSms("18200771880", nil, aliyunAdaptor); err == nil || err.Error() != smstest.AliyunBusinessLimitControl {
+		t.Errorf("expect %v error, got(%v)", smstest.AliyunBusinessLimitControl, err)
+	}
 }
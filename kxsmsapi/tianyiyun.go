@@ -30,13 +30,14 @@ MD5(enterprise_no+account+timestamp+http_sign_Key)
 const TIANYIYUN = "TianYiyun"
 
 type TianYiyunAdaptor struct {
-	Sign         string `json:"sign"`          //签名
-	EnterpriseNo string `json:"enterprise_no"` //企业编号
-	Account      string `json:"account"`       //http接入账号
-	Phones       string `json:"phones"`        //手机号码
-	Content      string `json:"content"`       //短信内容
-	TimeStamp    string `json:"timestamp"`     //时间戳,格式：yyyyMMddHHmmssSSS 用于生成Sign 20230417163200000
-	Ip           string //http://ip/json/submit
+	Sign         string       `json:"sign"`          //签名
+	EnterpriseNo string       `json:"enterprise_no"` //企业编号
+	Account      string       `json:"account"`       //http接入账号
+	Phones       string       `json:"phones"`        //手机号码
+	Content      string       `json:"content"`       //短信内容
+	TimeStamp    string       `json:"timestamp"`     //时间戳,格式：yyyyMMddHHmmssSSS 用于生成Sign 20230417163200000
+	Ip           string       //http://ip/json/submit ip可带端口
+	HttpClient   *http.Client `json:"-"` //[非必填]默认http.DefaultClient
 	Debug        bool
 }
 
@@ -90,7 +91,11 @@ func (adaptor *TianYiyunAdaptor) Send(phone string) (*SmsResponse, error) {
 
 	Debug(adaptor.Debug, "Init done")
 
-	response, err := http.Post(url, contentType, buffer)
+	client := adaptor.HttpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Post(url, contentType, buffer)
 	if err != nil {
 		fmt.Printf("Send-> post http://%v/json/submit error(%v)", adaptor.Ip, err)
 		return nil, err
@@ -149,6 +154,6 @@ func GetCodeByDesc(desc string) string {
 // 将int64的时间戳转换成string的yyyyMMddHHmmssSSS
 func GetTimeStamp(timestamp int64) string {
 	t := time.Unix(timestamp, 0)
-	formatTime := t.Format("2006010215040512")
-	return formatTime
+	formatTime := t.Format("20060102150405.000")
+	return strings.Replace(formatTime, ".", "", 1)
 }
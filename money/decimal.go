@@ -0,0 +1,45 @@
+package money
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// 以主单位小数字符串序列化的金额 支付宝格式 示例: {"total_amount":"88.88"}
+// 与Amount可直接转换: Decimal(amount)、Amount(decimal)
+type Decimal Amount
+
+// 元字符串 -> 人民币Decimal
+func ParseDecimal(yuan string) (Decimal, error) {
+	amount, err := ParseYuan(yuan)
+	return Decimal(amount), err
+}
+
+func (d Decimal) Amount() Amount {
+	return Amount(d)
+}
+
+func (d Decimal) String() string {
+	return Amount(d).Format()
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return json.Marshal(Amount(d).Format())
+}
+
+// 接受字符串"88.88"及数字88.88,币种为DefaultCurrency
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidAmount, data)
+		}
+	}
+	amount, err := Parse(s, DefaultCurrency)
+	if err != nil {
+		return err
+	}
+	*d = Decimal(amount)
+	return nil
+}
@@ -0,0 +1,266 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//金额统一以币种最小单位(人民币为分)的整数保存,避免浮点误差
+//元字符串与最小单位之间精确转换,精度超过最小单位时返回错误,不做四舍五入
+//加减乘运算检查溢出及币种,JSON序列化为最小单位整数(微信支付格式);支付宝使用Decimal序列化为元字符串
+
+// 币种 ISO 4217
+type Currency string
+
+const (
+	CNY Currency = "CNY" //人民币
+	HKD Currency = "HKD" //港币
+	USD Currency = "USD" //美元
+	EUR Currency = "EUR" //欧元
+	GBP Currency = "GBP" //英镑
+	JPY Currency = "JPY" //日元
+	KRW Currency = "KRW" //韩元
+)
+
+// 默认币种 未指定币种时使用
+const DefaultCurrency = CNY
+
+// 币种最小单位的小数位数 未列出的币种按两位处理
+var exponents = map[Currency]int{
+	CNY: 2,
+	HKD: 2,
+	USD: 2,
+	EUR: 2,
+	GBP: 2,
+	JPY: 0,
+	KRW: 0,
+}
+
+var (
+	ErrInvalidAmount    = errors.New("money: invalid amount")                         //金额格式错误
+	ErrPrecision        = errors.New("money: amount is more precise than minor unit") //金额精度超过最小单位(如分)
+	ErrOverflow         = errors.New("money: amount out of range")                    //金额超出范围
+	ErrCurrencyMismatch = errors.New("money: currency mismatch")                      //不同币种的金额运算
+)
+
+// 空币种视为DefaultCurrency
+func (c Currency) normalize() Currency {
+	if c == "" {
+		return DefaultCurrency
+	}
+	return Currency(strings.ToUpper(string(c)))
+}
+
+// 最小单位的小数位数 人民币为2(分)
+func (c Currency) Exponent() int {
+	if exponent, ok := exponents[c.normalize()]; ok {
+		return exponent
+	}
+	return 2
+}
+
+// 金额 零值为0元人民币
+type Amount struct {
+	minor    int64
+	currency Currency
+}
+
+// 以最小单位创建金额 currency为空时使用DefaultCurrency
+func New(minor int64, currency Currency) Amount {
+	return Amount{minor: minor, currency: currency.normalize()}
+}
+
+// 人民币金额 单位:分
+func Fen(fen int64) Amount {
+	return New(fen, CNY)
+}
+
+/*
+[Parse] 主单位金额字符串 -> Amount
+示例: Parse("12.34", CNY) -> 1234分, Parse("1200", JPY) -> 1200日元
+小数位超过币种最小单位(如"12.345"元)时返回ErrPrecision,不做四舍五入
+*/
+func Parse(s string, currency Currency) (Amount, error) {
+	currency = currency.normalize()
+	exponent := currency.Exponent()
+	value := strings.TrimSpace(s)
+	neg := false
+	if strings.HasPrefix(value, "-") {
+		neg = true
+		value = value[1:]
+	}
+	intPart, fracPart := value, ""
+	if i := strings.IndexByte(value, '.'); i >= 0 {
+		intPart, fracPart = value[:i], value[i+1:]
+		if fracPart == "" {
+			return Amount{}, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+		}
+	}
+	if intPart == "" || !isDigits(intPart) || !isDigits(fracPart) {
+		return Amount{}, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	fracPart = strings.TrimRight(fracPart, "0")
+	if len(fracPart) > exponent {
+		return Amount{}, fmt.Errorf("%w: %q %v", ErrPrecision, s, currency)
+	}
+	fracPart += strings.Repeat("0", exponent-len(fracPart))
+	minor, err := strconv.ParseInt(intPart+fracPart, 10, 64)
+	if err != nil {
+		return Amount{}, fmt.Errorf("%w: %q", ErrOverflow, s)
+	}
+	if neg {
+		minor = -minor
+	}
+	return Amount{minor: minor, currency: currency}, nil
+}
+
+// 元字符串 -> 人民币金额 示例: "12.34" -> 1234分
+func ParseYuan(yuan string) (Amount, error) {
+	return Parse(yuan, CNY)
+}
+
+// 最小单位的整数值 人民币为分
+func (a Amount) Minor() int64 {
+	return a.minor
+}
+
+func (a Amount) Currency() Currency {
+	return a.currency.normalize()
+}
+
+func (a Amount) IsZero() bool {
+	return a.minor == 0
+}
+
+func (a Amount) IsPositive() bool {
+	return a.minor > 0
+}
+
+func (a Amount) IsNegative() bool {
+	return a.minor < 0
+}
+
+// 金额及币种均相同
+func (a Amount) Equal(b Amount) bool {
+	return a.minor == b.minor && a.Currency() == b.Currency()
+}
+
+// 比较大小 a<b返回-1,a==b返回0,a>b返回1,币种不同时返回ErrCurrencyMismatch
+func (a Amount) Cmp(b Amount) (int, error) {
+	if err := a.checkCurrency(b); err != nil {
+		return 0, err
+	}
+	switch {
+	case a.minor < b.minor:
+		return -1, nil
+	case a.minor > b.minor:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (a Amount) Add(b Amount) (Amount, error) {
+	if err := a.checkCurrency(b); err != nil {
+		return Amount{}, err
+	}
+	if (b.minor > 0 && a.minor > math.MaxInt64-b.minor) || (b.minor < 0 && a.minor < math.MinInt64-b.minor) {
+		return Amount{}, fmt.Errorf("%w: %v + %v", ErrOverflow, a, b)
+	}
+	return Amount{minor: a.minor + b.minor, currency: a.Currency()}, nil
+}
+
+func (a Amount) Sub(b Amount) (Amount, error) {
+	if err := a.checkCurrency(b); err != nil {
+		return Amount{}, err
+	}
+	if (b.minor < 0 && a.minor > math.MaxInt64+b.minor) || (b.minor > 0 && a.minor < math.MinInt64+b.minor) {
+		return Amount{}, fmt.Errorf("%w: %v - %v", ErrOverflow, a, b)
+	}
+	return Amount{minor: a.minor - b.minor, currency: a.Currency()}, nil
+}
+
+// 乘以整数 如单价*数量
+func (a Amount) Mul(n int64) (Amount, error) {
+	if a.minor == 0 || n == 0 {
+		return Amount{currency: a.Currency()}, nil
+	}
+	result := a.minor * n
+	if result/n != a.minor || (a.minor == -1 && n == math.MinInt64) || (n == -1 && a.minor == math.MinInt64) {
+		return Amount{}, fmt.Errorf("%w: %v * %v", ErrOverflow, a, n)
+	}
+	return Amount{minor: result, currency: a.Currency()}, nil
+}
+
+// 求和 amounts为空时返回0元人民币
+func Sum(amounts ...Amount) (Amount, error) {
+	if len(amounts) == 0 {
+		return Amount{currency: DefaultCurrency}, nil
+	}
+	total := New(0, amounts[0].Currency())
+	for _, amount := range amounts {
+		var err error
+		if total, err = total.Add(amount); err != nil {
+			return Amount{}, err
+		}
+	}
+	return total, nil
+}
+
+// 主单位字符串 按币种小数位补零 示例: 1234分 -> "12.34", 1200日元 -> "1200"
+func (a Amount) Format() string {
+	exponent := a.Currency().Exponent()
+	sign := ""
+	v := uint64(a.minor)
+	if a.minor < 0 {
+		sign = "-"
+		v = uint64(-(a.minor + 1)) + 1
+	}
+	if exponent == 0 {
+		return sign + strconv.FormatUint(v, 10)
+	}
+	scale := uint64(math.Pow10(exponent))
+	return fmt.Sprintf("%s%d.%0*d", sign, v/scale, exponent, v%scale)
+}
+
+// 元字符串 同Format
+func (a Amount) Yuan() string {
+	return a.Format()
+}
+
+func (a Amount) String() string {
+	return a.Format()
+}
+
+// 序列化为最小单位整数 示例: {"total":100}
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.FormatInt(a.minor, 10)), nil
+}
+
+// 仅接受整数,币种为DefaultCurrency
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	minor, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidAmount, data)
+	}
+	*a = Amount{minor: minor, currency: DefaultCurrency}
+	return nil
+}
+
+func (a Amount) checkCurrency(b Amount) error {
+	if a.Currency() != b.Currency() {
+		return fmt.Errorf("%w: %v and %v", ErrCurrencyMismatch, a.Currency(), b.Currency())
+	}
+	return nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
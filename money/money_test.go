@@ -0,0 +1,141 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		s        string
+		currency Currency
+		minor    int64
+		err      error
+	}{
+		{"12.34", CNY, 1234, nil},
+		{"12", CNY, 1200, nil},
+		{"0.1", CNY, 10, nil},
+		{"0.01", CNY, 1, nil},
+		{"1231.11", CNY, 123111, nil},
+		{"12.340", CNY, 1234, nil},
+		{" 12.34 ", "", 1234, nil},
+		{"-5.5", CNY, -550, nil},
+		{"1200", JPY, 1200, nil},
+		{"1200.00", JPY, 1200, nil},
+		{"1200.5", JPY, 0, ErrPrecision},
+		{"12.345", CNY, 0, ErrPrecision},
+		{"0.001", CNY, 0, ErrPrecision},
+		{"", CNY, 0, ErrInvalidAmount},
+		{"12.", CNY, 0, ErrInvalidAmount},
+		{".5", CNY, 0, ErrInvalidAmount},
+		{"1e3", CNY, 0, ErrInvalidAmount},
+		{"1,000", CNY, 0, ErrInvalidAmount},
+		{"92233720368547758.07", CNY, math.MaxInt64, nil},
+		{"92233720368547758.08", CNY, 0, ErrOverflow},
+		{"99999999999999999999", CNY, 0, ErrOverflow},
+	}
+	for _, c := range cases {
+		amount, err := Parse(c.s, c.currency)
+		if !errors.Is(err, c.err) {
+			t.Errorf("Parse(%q, %v) error(%v), want(%v)", c.s, c.currency, err, c.err)
+			continue
+		}
+		if err == nil && (amount.Minor() != c.minor || amount.Currency() != c.currency.normalize()) {
+			t.Errorf("Parse(%q, %v) = %v %v, want %v", c.s, c.currency, amount.Minor(), amount.Currency(), c.minor)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	cases := []struct {
+		amount Amount
+		s      string
+	}{
+		{Fen(0), "0.00"},
+		{Fen(1), "0.01"},
+		{Fen(1234), "12.34"},
+		{Fen(123111), "1231.11"},
+		{Fen(-550), "-5.50"},
+		{Fen(math.MinInt64), "-92233720368547758.08"},
+		{New(1200, JPY), "1200"},
+		{New(199, USD), "1.99"},
+		{Amount{}, "0.00"},
+	}
+	for _, c := range cases {
+		if c.amount.Format() != c.s || c.amount.String() != c.s || c.amount.Yuan() != c.s {
+			t.Errorf("Format(%d %v) = %v, want %v", c.amount.Minor(), c.amount.Currency(), c.amount.Format(), c.s)
+		}
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	sum, err := Fen(1234).Add(Fen(66))
+	if err != nil || !sum.Equal(Fen(1300)) {
+		t.Errorf("unexpected sum(%v, %v)", sum, err)
+	}
+	diff, err := Fen(1234).Sub(Fen(2000))
+	if err != nil || !diff.Equal(Fen(-766)) || !diff.IsNegative() {
+		t.Errorf("unexpected diff(%v, %v)", diff, err)
+	}
+	product, err := Fen(199).Mul(3)
+	if err != nil || product.Minor() != 597 {
+		t.Errorf("unexpected product(%v, %v)", product, err)
+	}
+	if _, err = Fen(math.MaxInt64).Add(Fen(1)); !errors.Is(err, ErrOverflow) {
+		t.Errorf("expect ErrOverflow, got(%v)", err)
+	}
+	if _, err = Fen(math.MinInt64).Sub(Fen(1)); !errors.Is(err, ErrOverflow) {
+		t.Errorf("expect ErrOverflow, got(%v)", err)
+	}
+	if _, err = Fen(math.MaxInt64 / 2).Mul(3); !errors.Is(err, ErrOverflow) {
+		t.Errorf("expect ErrOverflow, got(%v)", err)
+	}
+	if _, err = Fen(math.MinInt64).Mul(-1); !errors.Is(err, ErrOverflow) {
+		t.Errorf("expect ErrOverflow, got(%v)", err)
+	}
+	if _, err = Fen(100).Add(New(100, USD)); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("expect ErrCurrencyMismatch, got(%v)", err)
+	}
+	if cmp, err := Fen(100).Cmp(Fen(99)); err != nil || cmp != 1 {
+		t.Errorf("unexpected cmp(%v, %v)", cmp, err)
+	}
+	if !(Amount{}).Equal(Fen(0)) || Fen(100).Equal(New(100, HKD)) {
+		t.Error("unexpected Equal")
+	}
+	total, err := Sum(Fen(1), Fen(2), Fen(3))
+	if err != nil || total.Minor() != 6 {
+		t.Errorf("unexpected total(%v, %v)", total, err)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	type request struct {
+		Total  Amount  `json:"total"`
+		Amount Decimal `json:"total_amount"`
+	}
+	byteReq, err := json.Marshal(request{Total: Fen(8888), Amount: Decimal(Fen(8888))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(byteReq) != `{"total":8888,"total_amount":"88.88"}` {
+		t.Errorf("unexpected json(%v)", string(byteReq))
+	}
+	req := request{}
+	if err = json.Unmarshal(byteReq, &req); err != nil {
+		t.Fatal(err)
+	}
+	if !req.Total.Equal(Fen(8888)) || !req.Amount.Amount().Equal(Fen(8888)) {
+		t.Errorf("unexpected request(%+v)", req)
+	}
+	if err = json.Unmarshal([]byte(`{"total_amount":88.8}`), &req); err != nil || req.Amount.Amount().Minor() != 8880 {
+		t.Errorf("decimal number should unmarshal, got(%v, %v)", req.Amount, err)
+	}
+	if err = json.Unmarshal([]byte(`{"total":100.5}`), &req); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("float total should fail, got(%v)", err)
+	}
+	if err = json.Unmarshal([]byte(`{"total_amount":"88.888"}`), &req); !errors.Is(err, ErrPrecision) {
+		t.Errorf("sub fen decimal should fail, got(%v)", err)
+	}
+}
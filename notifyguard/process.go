@@ -0,0 +1,73 @@
+package notifyguard
+
+import (
+	"fmt"
+	"sync"
+)
+
+// 同一进程内按通知ID加锁,避免并发到达的重复通知同时执行回调
+var locks = &keyedMutex{locks: make(map[string]*refMutex)}
+
+/*
+[Process]-> 通知未处理过时执行fn,fn成功后标记为已处理
+store为nil或id为空时不去重,直接执行fn
+duplicate: 通知已处理过,fn未执行,调用方应直接应答成功
+fn返回error时不标记,支付平台重新通知后会再次执行
+*/
+func Process(store NotificationStore, id string, fn func() error) (duplicate bool, err error) {
+	if store == nil || id == "" {
+		return false, fn()
+	}
+	locks.lock(id)
+	defer locks.unlock(id)
+
+	seen, err := store.Seen(id)
+	if err != nil {
+		fmt.Printf("Process-> Seen(%v) error(%v)", id, err)
+		return false, err
+	}
+	if seen {
+		return true, nil
+	}
+	if err = fn(); err != nil {
+		return false, err
+	}
+	//回调已成功,标记失败时仍应答成功,避免重新通知导致回调再次执行
+	if err = store.MarkProcessed(id); err != nil {
+		fmt.Printf("Process-> MarkProcessed(%v) error(%v)", id, err)
+	}
+	return false, nil
+}
+
+type refMutex struct {
+	sync.Mutex
+	ref int
+}
+
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*refMutex
+}
+
+func (k *keyedMutex) lock(key string) {
+	k.mu.Lock()
+	m, ok := k.locks[key]
+	if !ok {
+		m = &refMutex{}
+		k.locks[key] = m
+	}
+	m.ref++
+	k.mu.Unlock()
+	m.Lock()
+}
+
+func (k *keyedMutex) unlock(key string) {
+	k.mu.Lock()
+	m := k.locks[key]
+	m.ref--
+	if m.ref == 0 {
+		delete(k.locks, key)
+	}
+	k.mu.Unlock()
+	m.Unlock()
+}
@@ -0,0 +1,184 @@
+package notifyguard
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//防重放:已验签的通知还需要校验时间戳在允许的时间窗口内,且nonce在窗口内未出现过
+//微信支付使用Wechatpay-Timestamp/Wechatpay-Nonce,支付宝使用notify_time,nonce取notify_id+notify_time(支付宝重新通知时notify_time不同)
+
+// 默认时间窗口 与微信支付SDK一致
+const DefaultReplayWindow = 5 * time.Minute
+
+// 拒绝原因
+const (
+	RejectInvalidTimestamp = "invalid_timestamp" //时间戳缺失或格式错误
+	RejectExpired          = "expired"           //时间戳早于窗口
+	RejectFuture           = "future"            //时间戳晚于窗口
+	RejectEmptyNonce       = "empty_nonce"       //nonce缺失
+	RejectNonceReused      = "nonce_reused"      //nonce已出现过,疑似重放
+)
+
+var ErrReplay = errors.New("notifyguard: notification replay rejected")
+
+// 防重放拒绝详情 errors.Is(err, ErrReplay)为true
+type RejectError struct {
+	Reason    string    //拒绝原因 RejectXxx
+	Timestamp time.Time //通知时间戳
+	Nonce     string    //通知nonce
+	Err       error     //时间戳解析错误
+}
+
+func (e *RejectError) Error() string {
+	msg := fmt.Sprintf("notifyguard: notification rejected reason(%v) timestamp(%v) nonce(%v)", e.Reason, e.Timestamp.Format(time.RFC3339), e.Nonce)
+	if e.Err != nil {
+		msg += fmt.Sprintf(" error(%v)", e.Err)
+	}
+	return msg
+}
+
+func (e *RejectError) Is(target error) bool {
+	return target == ErrReplay
+}
+
+func (e *RejectError) Unwrap() error {
+	return e.Err
+}
+
+type NonceCache interface {
+	// nonce在expireAt之前未出现过时记录并返回true,已出现过返回false
+	Add(nonce string, expireAt time.Time) (bool, error)
+}
+
+var _ NonceCache = &MemoryNonceCache{}
+
+// 内存nonce缓存 过期的nonce定期清理;零值可直接使用
+type MemoryNonceCache struct {
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	lastPurge time.Time
+	now       func() time.Time
+}
+
+func NewMemoryNonceCache() *MemoryNonceCache {
+	return &MemoryNonceCache{nonces: make(map[string]time.Time), now: time.Now}
+}
+
+func (c *MemoryNonceCache) Add(nonce string, expireAt time.Time) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.now != nil {
+		now = c.now()
+	}
+	if c.nonces == nil {
+		c.nonces = make(map[string]time.Time)
+	}
+	if now.Sub(c.lastPurge) > time.Minute {
+		for k, v := range c.nonces {
+			if now.After(v) {
+				delete(c.nonces, k)
+			}
+		}
+		c.lastPurge = now
+	}
+	if v, ok := c.nonces[nonce]; ok && !now.After(v) {
+		return false, nil
+	}
+	c.nonces[nonce] = expireAt
+	return true, nil
+}
+
+// 防重放校验器
+type ReplayGuard struct {
+	Window   time.Duration          //允许的时间偏差(前后),默认5分钟
+	Nonces   NonceCache             //[非必填]nonce缓存,为nil时只校验时间窗口
+	OnReject func(err *RejectError) //[非必填]拒绝时回调,可用于告警或统计
+
+	now func() time.Time
+}
+
+/*
+[NewReplayGuard]-> 使用内存nonce缓存
+window: 允许的时间偏差,<=0时使用DefaultReplayWindow
+*/
+func NewReplayGuard(window time.Duration) *ReplayGuard {
+	return &ReplayGuard{Window: window, Nonces: NewMemoryNonceCache()}
+}
+
+/*
+[Check]-> 校验时间戳及nonce,拒绝时返回*RejectError并调用OnReject
+应在验签之后调用,避免伪造的通知占用nonce
+*/
+func (g *ReplayGuard) Check(timestamp time.Time, nonce string) error {
+	window := g.Window
+	if window <= 0 {
+		window = DefaultReplayWindow
+	}
+	now := time.Now
+	if g.now != nil {
+		now = g.now
+	}
+	var rejectErr *RejectError
+	switch diff := now().Sub(timestamp); {
+	case timestamp.IsZero():
+		rejectErr = &RejectError{Reason: RejectInvalidTimestamp}
+	case diff > window:
+		rejectErr = &RejectError{Reason: RejectExpired}
+	case diff < -window:
+		rejectErr = &RejectError{Reason: RejectFuture}
+	case g.Nonces == nil:
+	case nonce == "":
+		rejectErr = &RejectError{Reason: RejectEmptyNonce}
+	default:
+		//超过timestamp+window后时间戳校验即可拒绝,nonce无需保留
+		ok, err := g.Nonces.Add(nonce, timestamp.Add(window))
+		if err != nil {
+			//缓存不可用不属于重放,返回普通error,调用方应答失败后支付平台会重新通知
+			fmt.Printf("ReplayGuard.Check-> NonceCache.Add(%v) error(%v)", nonce, err)
+			return err
+		}
+		if !ok {
+			rejectErr = &RejectError{Reason: RejectNonceReused}
+		}
+	}
+	if rejectErr == nil {
+		return nil
+	}
+	rejectErr.Timestamp = timestamp
+	rejectErr.Nonce = nonce
+	return g.reject(rejectErr)
+}
+
+/*
+[CheckUnix]-> 时间戳为Unix秒字符串,如Wechatpay-Timestamp
+*/
+func (g *ReplayGuard) CheckUnix(timestamp, nonce string) error {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || sec <= 0 {
+		return g.reject(&RejectError{Reason: RejectInvalidTimestamp, Nonce: nonce, Err: err})
+	}
+	return g.Check(time.Unix(sec, 0), nonce)
+}
+
+/*
+[CheckLayout]-> 按layout及时区解析时间戳,如支付宝notify_time "2006-01-02 15:04:05" 北京时间
+*/
+func (g *ReplayGuard) CheckLayout(layout, timestamp string, loc *time.Location, nonce string) error {
+	t, err := time.ParseInLocation(layout, timestamp, loc)
+	if err != nil {
+		return g.reject(&RejectError{Reason: RejectInvalidTimestamp, Nonce: nonce, Err: err})
+	}
+	return g.Check(t, nonce)
+}
+
+func (g *ReplayGuard) reject(err *RejectError) error {
+	if g.OnReject != nil {
+		g.OnReject(err)
+	}
+	return err
+}
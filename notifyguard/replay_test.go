@@ -0,0 +1,114 @@
+package notifyguard
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type failNonceCache struct{}
+
+func (failNonceCache) Add(nonce string, expireAt time.Time) (bool, error) {
+	return false, errors.New("redis unavailable")
+}
+
+func TestReplayGuard(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	var rejects []string
+	guard := NewReplayGuard(time.Minute)
+	guard.now = func() time.Time { return now }
+	guard.Nonces.(*MemoryNonceCache).now = guard.now
+	guard.OnReject = func(err *RejectError) {
+		rejects = append(rejects, err.Reason)
+	}
+	cases := []struct {
+		name      string
+		timestamp time.Time
+		nonce     string
+		reason    string
+	}{
+		{"ok", now.Add(-30 * time.Second), "nonce-1", ""},
+		{"reused", now.Add(-30 * time.Second), "nonce-1", RejectNonceReused},
+		{"clock skew", now.Add(30 * time.Second), "nonce-2", ""},
+		{"expired", now.Add(-2 * time.Minute), "nonce-3", RejectExpired},
+		{"future", now.Add(2 * time.Minute), "nonce-4", RejectFuture},
+		{"empty nonce", now, "", RejectEmptyNonce},
+		{"zero timestamp", time.Time{}, "nonce-5", RejectInvalidTimestamp},
+	}
+	for _, c := range cases {
+		err := guard.Check(c.timestamp, c.nonce)
+		if c.reason == "" {
+			if err != nil {
+				t.Errorf("%v: unexpected error(%v)", c.name, err)
+			}
+			continue
+		}
+		var rejectErr *RejectError
+		if !errors.As(err, &rejectErr) || rejectErr.Reason != c.reason || !errors.Is(err, ErrReplay) {
+			t.Errorf("%v: unexpected error(%v) expect reason(%v)", c.name, err, c.reason)
+		}
+	}
+	if len(rejects) != 5 {
+		t.Errorf("OnReject called(%v) expect 5 times", rejects)
+	}
+
+	if err := guard.CheckUnix(strconv.FormatInt(now.Unix(), 10), "nonce-6"); err != nil {
+		t.Errorf("CheckUnix error(%v)", err)
+	}
+	if err := guard.CheckUnix("abc", "nonce-7"); !errors.Is(err, ErrReplay) {
+		t.Errorf("CheckUnix invalid timestamp error(%v)", err)
+	}
+	beijing := time.FixedZone("CST", 8*3600)
+	if err := guard.CheckLayout("2006-01-02 15:04:05", now.In(beijing).Format("2006-01-02 15:04:05"), beijing, "nonce-8"); err != nil {
+		t.Errorf("CheckLayout error(%v)", err)
+	}
+	if err := guard.CheckLayout("2006-01-02 15:04:05", "2015-14-27 15:45:58", beijing, "nonce-9"); !errors.Is(err, ErrReplay) {
+		t.Errorf("CheckLayout invalid timestamp error(%v)", err)
+	}
+
+	//只校验时间窗口
+	guard.Nonces = nil
+	for i := 0; i < 2; i++ {
+		if err := guard.Check(now, ""); err != nil {
+			t.Errorf("Check without nonce cache error(%v)", err)
+		}
+	}
+
+	//缓存不可用不属于重放
+	guard.Nonces = failNonceCache{}
+	if err := guard.Check(now, "nonce-10"); err == nil || errors.Is(err, ErrReplay) {
+		t.Errorf("unexpected error(%v)", err)
+	}
+}
+
+func TestMemoryNonceCache(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	cache := NewMemoryNonceCache()
+	cache.now = func() time.Time { return now }
+	if ok, _ := cache.Add("nonce-1", now.Add(time.Minute)); !ok {
+		t.Fatal("first add should succeed")
+	}
+	if ok, _ := cache.Add("nonce-1", now.Add(time.Minute)); ok {
+		t.Fatal("second add should fail")
+	}
+	now = now.Add(2 * time.Minute)
+	if ok, _ := cache.Add("nonce-2", now.Add(time.Minute)); !ok {
+		t.Fatal("add nonce-2 should succeed")
+	}
+	if _, ok := cache.nonces["nonce-1"]; ok {
+		t.Error("expired nonce should be purged")
+	}
+	if ok, _ := cache.Add("nonce-1", now.Add(time.Minute)); !ok {
+		t.Error("expired nonce can be added again")
+	}
+
+	//零值可直接使用
+	var zero MemoryNonceCache
+	if ok, err := zero.Add("nonce-1", time.Now().Add(time.Minute)); !ok || err != nil {
+		t.Errorf("zero value add should succeed, got(%v, %v)", ok, err)
+	}
+	if ok, _ := zero.Add("nonce-1", time.Now().Add(time.Minute)); ok {
+		t.Error("zero value second add should fail")
+	}
+}
@@ -0,0 +1,145 @@
+package notifyguard
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//支付平台会重复发送异步通知(应答失败、网络超时等),业务回调需要幂等
+//NotificationStore记录已处理的通知ID:支付宝使用notify_id,微信支付使用通知ID
+//内存实现适用于单实例,重启后丢失,ID保留TTL(默认48小时)后清理;文件实现重启后仍然有效,多实例部署时请基于共享存储(如数据库、Redis)实现该接口
+
+var ErrInvalidId = errors.New("notifyguard: invalid notification id")
+
+// 内存去重默认保留时长 支付宝重试通知约25小时内结束,微信支付约24小时内结束
+const DefaultStoreTTL = 48 * time.Hour
+
+type NotificationStore interface {
+	Seen(id string) (bool, error)  //通知是否已处理
+	MarkProcessed(id string) error //标记通知已处理
+}
+
+var (
+	_ NotificationStore = &MemoryStore{}
+	_ NotificationStore = &FileStore{}
+)
+
+// 内存去重 重启后丢失,超过TTL的ID定期清理;零值可直接使用
+type MemoryStore struct {
+	TTL time.Duration //ID保留时长 默认DefaultStoreTTL,应大于渠道重试通知的时长
+
+	mu        sync.RWMutex
+	ids       map[string]time.Time //ID->过期时间
+	lastPurge time.Time
+	now       func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{TTL: DefaultStoreTTL, ids: make(map[string]time.Time), now: time.Now}
+}
+
+func (s *MemoryStore) Seen(id string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	expireAt, ok := s.ids[id]
+	return ok && !s.clock().After(expireAt), nil
+}
+
+func (s *MemoryStore) MarkProcessed(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock()
+	if s.ids == nil {
+		s.ids = make(map[string]time.Time)
+	}
+	if now.Sub(s.lastPurge) > time.Minute {
+		for k, v := range s.ids {
+			if now.After(v) {
+				delete(s.ids, k)
+			}
+		}
+		s.lastPurge = now
+	}
+	ttl := s.TTL
+	if ttl <= 0 {
+		ttl = DefaultStoreTTL
+	}
+	s.ids[id] = now.Add(ttl)
+	return nil
+}
+
+func (s *MemoryStore) clock() time.Time {
+	if s.now == nil {
+		return time.Now()
+	}
+	return s.now()
+}
+
+// 文件去重 每个已处理的ID追加一行并落盘,启动时加载
+type FileStore struct {
+	mu   sync.RWMutex
+	file *os.File
+	ids  map[string]struct{}
+}
+
+/*
+[NewFileStore]-> 打开或创建记录文件并加载已处理的通知ID
+path: 示例 "/path/to/notify_processed.log"
+*/
+func NewFileStore(path string) (*FileStore, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		fmt.Printf("NewFileStore-> open(%v) error(%v)", path, err)
+		return nil, err
+	}
+	ids := make(map[string]struct{})
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if id := scanner.Text(); id != "" {
+			ids[id] = struct{}{}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		file.Close()
+		fmt.Printf("NewFileStore-> read(%v) error(%v)", path, err)
+		return nil, err
+	}
+	return &FileStore{file: file, ids: ids}, nil
+}
+
+func (s *FileStore) Seen(id string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.ids[id]
+	return ok, nil
+}
+
+func (s *FileStore) MarkProcessed(id string) error {
+	if id == "" || strings.ContainsAny(id, "\r\n") {
+		return ErrInvalidId
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.ids[id]; ok {
+		return nil
+	}
+	if _, err := s.file.WriteString(id + "\n"); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	s.ids[id] = struct{}{}
+	return nil
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,128 @@
+package notifyguard
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testStore(t *testing.T, store NotificationStore) {
+	seen, err := store.Seen("notify-1")
+	if err != nil || seen {
+		t.Fatalf("Seen before MarkProcessed: seen(%v) err(%v)", seen, err)
+	}
+	if err = store.MarkProcessed("notify-1"); err != nil {
+		t.Fatalf("MarkProcessed error(%v)", err)
+	}
+	if err = store.MarkProcessed("notify-1"); err != nil {
+		t.Fatalf("MarkProcessed again error(%v)", err)
+	}
+	seen, err = store.Seen("notify-1")
+	if err != nil || !seen {
+		t.Fatalf("Seen after MarkProcessed: seen(%v) err(%v)", seen, err)
+	}
+	if seen, _ = store.Seen("notify-2"); seen {
+		t.Fatal("notify-2 should not be seen")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+	//零值可直接使用
+	testStore(t, &MemoryStore{})
+}
+
+// 超过TTL的ID不再视为已处理,并在之后的MarkProcessed时清理
+func TestMemoryStoreTTL(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryStore()
+	store.TTL = time.Hour
+	store.now = func() time.Time { return now }
+	store.MarkProcessed("notify-1")
+	now = now.Add(30 * time.Minute)
+	store.MarkProcessed("notify-2")
+	if seen, _ := store.Seen("notify-1"); !seen {
+		t.Error("notify-1 should be seen within ttl")
+	}
+	now = now.Add(45 * time.Minute)
+	if seen, _ := store.Seen("notify-1"); seen {
+		t.Error("notify-1 should expire after ttl")
+	}
+	store.MarkProcessed("notify-3")
+	if _, ok := store.ids["notify-1"]; ok || len(store.ids) != 2 {
+		t.Errorf("expired ids should be purged, got(%v)", store.ids)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "processed.log")
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+	if err = store.MarkProcessed("bad\nid"); !errors.Is(err, ErrInvalidId) {
+		t.Errorf("expect ErrInvalidId, got(%v)", err)
+	}
+	store.Close()
+
+	//重启后仍然有效
+	store, err = NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if seen, _ := store.Seen("notify-1"); !seen {
+		t.Error("notify-1 should be seen after reopen")
+	}
+	if seen, _ := store.Seen("notify-2"); seen {
+		t.Error("notify-2 should not be seen after reopen")
+	}
+}
+
+func TestProcess(t *testing.T) {
+	store := NewMemoryStore()
+	var called int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := Process(store, "notify-1", func() error {
+				atomic.AddInt32(&called, 1)
+				return nil
+			}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if called != 1 {
+		t.Errorf("fn called(%v) times, expect 1", called)
+	}
+
+	//失败不标记,重试时再次执行
+	fail := errors.New("callback failed")
+	if duplicate, err := Process(store, "notify-2", func() error { return fail }); duplicate || err != fail {
+		t.Errorf("unexpected duplicate(%v) err(%v)", duplicate, err)
+	}
+	if duplicate, err := Process(store, "notify-2", func() error { return nil }); duplicate || err != nil {
+		t.Errorf("unexpected duplicate(%v) err(%v)", duplicate, err)
+	}
+	if duplicate, _ := Process(store, "notify-2", func() error { return nil }); !duplicate {
+		t.Error("notify-2 should be duplicate")
+	}
+
+	//不去重
+	called = 0
+	for i := 0; i < 2; i++ {
+		Process(nil, "notify-1", func() error { called++; return nil })
+		Process(store, "", func() error { called++; return nil })
+	}
+	if called != 4 {
+		t.Errorf("fn called(%v) times, expect 4", called)
+	}
+}
@@ -0,0 +1,260 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tanjl855/Sms_Pay_SDK/alipay"
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+)
+
+// 支付宝时间格式 北京时间
+const alipayTimeLayout = "2006-01-02 15:04:05"
+
+var beijing = time.FixedZone("CST", 8*3600)
+
+// 支付宝配置
+type AlipayConfig struct {
+	AppId        string       //支付宝分配给开发者的应用ID
+	PrivateKey   string       //应用私钥
+	AliPublicKey string       //支付宝公钥 用于应答及通知验签
+	SellerId     string       //[非必填]收款支付宝用户号(2088开头),不为空时校验通知的seller_id
+	Domain       string       //[非必填]支付宝网关 默认https://openapi.alipay.com/gateway.do
+	HttpClient   *http.Client //[非必填]
+}
+
+type alipayGateway struct {
+	config *AlipayConfig
+	client *alipay.Client
+}
+
+var _ Gateway = &alipayGateway{}
+
+/*
+[NewAlipayGateway]-> 支付宝网关 下单使用电脑网站支付(alipay.trade.page.pay)
+*/
+func NewAlipayGateway(config *AlipayConfig) (Gateway, error) {
+	var opts []alipay.OptionFunc
+	if config.Domain != "" {
+		opts = append(opts, alipay.WithApiDomain(config.Domain))
+	}
+	if config.HttpClient != nil {
+		opts = append(opts, alipay.WithHttpClient(config.HttpClient))
+	}
+	client, err := alipay.NewAlipayClient(config.AppId, config.PrivateKey, opts...)
+	if err != nil {
+		fmt.Printf("NewAlipayGateway-> NewAlipayClient error(%v)", err)
+		return nil, err
+	}
+	if err = client.LoadAliPayPublicKey(config.AliPublicKey); err != nil {
+		fmt.Printf("NewAlipayGateway-> LoadAliPayPublicKey error(%v)", err)
+		return nil, err
+	}
+	return &alipayGateway{config: config, client: client}, nil
+}
+
+func (g *alipayGateway) Channel() Channel {
+	return ChannelAlipay
+}
+
+func (g *alipayGateway) Create(ctx context.Context, order *Order) (*CreateResult, error) {
+	if err := order.check(); err != nil {
+		return nil, err
+	}
+	if err := checkAlipayCurrency(order.Amount); err != nil {
+		return nil, err
+	}
+	aliPayReq := alipay.NewAliPayReq(order.NotifyURL, order.Subject, order.OutTradeNo, order.Amount, order.ReturnURL)
+	if !order.ExpireAt.IsZero() {
+		aliPayReq.TimeExpire = order.ExpireAt.In(beijing).Format(alipayTimeLayout)
+	}
+	payURL, err := g.client.TradePagePay(aliPayReq)
+	if err != nil {
+		return nil, err
+	}
+	return &CreateResult{Channel: ChannelAlipay, OutTradeNo: order.OutTradeNo, PayURL: payURL}, nil
+}
+
+func (g *alipayGateway) Query(ctx context.Context, outTradeNo string) (*Transaction, error) {
+	res, err := g.client.TradeQuery(ctx, outTradeNo, "")
+	if err != nil {
+		return nil, alipayError(err)
+	}
+	return alipayTransaction(res.OutTradeNo, res.TradeNo, res.TradeStatus, res.TotalAmount, res.BuyerPayAmount, res.BuyerUserId, res.SendPayDate)
+}
+
+func (g *alipayGateway) Close(ctx context.Context, outTradeNo string) error {
+	if _, err := g.client.TradeClose(ctx, outTradeNo, ""); err != nil {
+		return alipayError(err)
+	}
+	return nil
+}
+
+// 支付宝退款同步返回结果,code为10000即退款成功
+func (g *alipayGateway) Refund(ctx context.Context, req *RefundRequest) (*Refund, error) {
+	if err := req.check(); err != nil {
+		return nil, err
+	}
+	if err := checkAlipayCurrency(req.Amount); err != nil {
+		return nil, err
+	}
+	refundReq := alipay.NewAliPayRefundReq(req.OutTradeNo, "", req.Amount, req.Reason, req.OutRefundNo)
+	res, err := g.client.TradeRefund(ctx, refundReq)
+	if err != nil {
+		return nil, alipayError(err)
+	}
+	refund := &Refund{
+		Channel:       ChannelAlipay,
+		OutTradeNo:    req.OutTradeNo,
+		OutRefundNo:   req.OutRefundNo,
+		Status:        RefundSuccess,
+		ChannelStatus: alipay.RefundStatusSuccess,
+		Amount:        req.Amount,
+		SucceededAt:   parseAlipayTime(res.GmtRefundPay),
+	}
+	if res.RefundFee != "" {
+		refundFee, err := money.ParseYuan(res.RefundFee)
+		if err != nil {
+			return nil, err
+		}
+		refund.TotalRefunded = refundFee
+	}
+	return refund, nil
+}
+
+func (g *alipayGateway) QueryRefund(ctx context.Context, outTradeNo, outRefundNo string) (*Refund, error) {
+	res, err := g.client.TradeRefundQuery(ctx, outTradeNo, "", outRefundNo)
+	if err != nil {
+		return nil, alipayError(err)
+	}
+	refund := &Refund{
+		Channel:       ChannelAlipay,
+		OutTradeNo:    outTradeNo,
+		OutRefundNo:   outRefundNo,
+		Status:        AlipayRefundStatus(res.RefundStatus),
+		ChannelStatus: res.RefundStatus,
+		SucceededAt:   parseAlipayTime(res.GmtRefundPay),
+	}
+	if res.RefundAmount != "" {
+		amount, err := money.ParseYuan(res.RefundAmount)
+		if err != nil {
+			return nil, err
+		}
+		refund.Amount = amount
+	}
+	return refund, nil
+}
+
+/*
+[ParseNotification]-> 验签并解析支付宝异步通知
+带out_biz_no及refund_fee的通知为退款通知,其余为支付结果通知
+验签失败、app_id与配置不一致、配置了SellerId且seller_id不一致时返回ErrInvalidNotification
+*/
+func (g *alipayGateway) ParseNotification(ctx context.Context, r *http.Request) (*Notification, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	notification, err := alipay.ParseNotification(r.PostForm, g.config.AliPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidNotification, err)
+	}
+	if notification.AppId != g.config.AppId {
+		return nil, fmt.Errorf("%w: app_id(%v) expect(%v)", ErrInvalidNotification, notification.AppId, g.config.AppId)
+	}
+	if g.config.SellerId != "" && notification.SellerId != g.config.SellerId {
+		return nil, fmt.Errorf("%w: seller_id(%v) expect(%v)", ErrInvalidNotification, notification.SellerId, g.config.SellerId)
+	}
+	if notification.OutBizNo != "" && notification.RefundFee != "" {
+		refundFee, err := money.ParseYuan(notification.RefundFee)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidNotification, err)
+		}
+		return &Notification{
+			Channel: ChannelAlipay,
+			Id:      notification.NotifyId,
+			Type:    NotifyRefund,
+			Refund: &Refund{
+				Channel:       ChannelAlipay,
+				OutTradeNo:    notification.OutTradeNo,
+				OutRefundNo:   notification.OutBizNo,
+				Status:        RefundSuccess,
+				ChannelStatus: notification.TradeStatus,
+				TotalRefunded: refundFee, //refund_fee为该交易累计退款金额
+				SucceededAt:   parseAlipayTime(notification.GmtRefund),
+			},
+		}, nil
+	}
+	transaction, err := alipayTransaction(notification.OutTradeNo, notification.TradeNo, notification.TradeStatus, notification.TotalAmount, notification.BuyerPayAmount, notification.BuyerId, notification.GmtPayment)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidNotification, err)
+	}
+	return &Notification{Channel: ChannelAlipay, Id: notification.NotifyId, Type: NotifyPayment, Transaction: transaction}, nil
+}
+
+// 支付宝要求处理成功后返回success,其余内容会重新通知
+func (g *alipayGateway) WriteNotifyResponse(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err != nil {
+		w.WriteHeader(notifyErrorStatus(err))
+		w.Write([]byte("fail"))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("success"))
+}
+
+func alipayTransaction(outTradeNo, tradeNo, tradeStatus, totalAmount, buyerPayAmount, buyer, paidAt string) (*Transaction, error) {
+	transaction := &Transaction{
+		Channel:       ChannelAlipay,
+		OutTradeNo:    outTradeNo,
+		TradeNo:       tradeNo,
+		Status:        AlipayStatus(tradeStatus),
+		ChannelStatus: tradeStatus,
+		Payer:         buyer,
+		PaidAt:        parseAlipayTime(paidAt),
+	}
+	if totalAmount != "" {
+		amount, err := money.ParseYuan(totalAmount)
+		if err != nil {
+			return nil, err
+		}
+		transaction.Amount = amount
+	}
+	if buyerPayAmount != "" {
+		amount, err := money.ParseYuan(buyerPayAmount)
+		if err != nil {
+			return nil, err
+		}
+		transaction.PaidAmount = amount
+	}
+	return transaction, nil
+}
+
+// 电脑网站支付的total_amount及refund_amount均以人民币元计
+func checkAlipayCurrency(amount money.Amount) error {
+	if amount.Currency() != money.CNY {
+		return fmt.Errorf("%w: alipay amount currency(%v) must be CNY", ErrInvalidOrder, amount.Currency())
+	}
+	return nil
+}
+
+// 交易不存在(如用户未扫码)转换为ErrOrderNotFound
+func alipayError(err error) error {
+	var aliErr *alipay.Error
+	if errors.As(err, &aliErr) && aliErr.SubCode == "ACQ.TRADE_NOT_EXIST" {
+		return fmt.Errorf("%w: %v", ErrOrderNotFound, err)
+	}
+	return err
+}
+
+// 退款通知的gmt_refund带毫秒(如2015-04-28 15:45:57.320),秒后的小数部分由time.Parse直接解析,无需单独的layout
+func parseAlipayTime(s string) time.Time {
+	t, err := time.ParseInLocation(alipayTimeLayout, s, beijing)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,168 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+	"github.com/tanjl855/Sms_Pay_SDK/notifyguard"
+	"github.com/tanjl855/Sms_Pay_SDK/paytest"
+)
+
+// 浏览器打开支付页面 模拟支付宝创建待支付交易
+func openPayURL(t *testing.T, payURL string) {
+	resp, err := http.Get(payURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected pay page status(%v)", resp.StatusCode)
+	}
+}
+
+func TestAlipayGateway(t *testing.T) {
+	fake := paytest.NewAlipay()
+	defer fake.Close()
+	notify := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("success"))
+	}))
+	defer notify.Close()
+	gateway, err := New(&Config{Channel: ChannelAlipay, Alipay: newPaytestAlipayConfig(fake)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gateway.Channel() != ChannelAlipay {
+		t.Errorf("unexpected channel(%v)", gateway.Channel())
+	}
+	ctx := context.Background()
+
+	if _, err = gateway.Create(ctx, &Order{OutTradeNo: "6823789339978248", Subject: "iPhone", Amount: money.New(8888, money.USD)}); !errors.Is(err, ErrInvalidOrder) {
+		t.Errorf("expect ErrInvalidOrder for USD order, got(%v)", err)
+	}
+	createResult, err := gateway.Create(ctx, &Order{OutTradeNo: "6823789339978248", Subject: "iPhone", Amount: money.Fen(8888), NotifyURL: notify.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payURL, err := url.Parse(createResult.PayURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(payURL.Query().Get("biz_content"), `"total_amount":"88.88"`) {
+		t.Errorf("unexpected pay url(%v)", createResult.PayURL)
+	}
+	if _, err = gateway.Create(ctx, &Order{OutTradeNo: "6823789339978248"}); !errors.Is(err, ErrInvalidOrder) {
+		t.Errorf("expect ErrInvalidOrder, got(%v)", err)
+	}
+	openPayURL(t, createResult.PayURL)
+	if err = fake.Pay("6823789339978248"); err != nil {
+		t.Fatal(err)
+	}
+
+	transaction, err := gateway.Query(ctx, "6823789339978248")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trade, _ := fake.Trade("6823789339978248")
+	if transaction.Status != StatusPaid || transaction.Amount.Minor() != 8888 || transaction.PaidAmount.Minor() != 8888 || transaction.TradeNo != trade.TradeNo ||
+		transaction.Payer != trade.BuyerId || transaction.PaidAt.IsZero() {
+		t.Errorf("unexpected transaction(%+v)", transaction)
+	}
+	if _, err = gateway.Query(ctx, "unknown"); !errors.Is(err, ErrOrderNotFound) {
+		t.Errorf("expect ErrOrderNotFound, got(%v)", err)
+	}
+	//未支付的交易可关闭
+	closeResult, err := gateway.Create(ctx, &Order{OutTradeNo: "6823789339978249", Subject: "iPhone", Amount: money.Fen(100), NotifyURL: notify.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	openPayURL(t, closeResult.PayURL)
+	if err = gateway.Close(ctx, "6823789339978249"); err != nil {
+		t.Error(err)
+	}
+	if trade, _ = fake.Trade("6823789339978249"); trade.TradeStatus != paytest.AlipayTradeClosed {
+		t.Errorf("unexpected trade status(%v)", trade.TradeStatus)
+	}
+
+	refund, err := gateway.Refund(ctx, &RefundRequest{OutTradeNo: "6823789339978248", OutRefundNo: "refund-1", Amount: money.Fen(1000), Total: money.Fen(8888)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.Status != RefundSuccess || refund.Amount.Minor() != 1000 || refund.TotalRefunded.Minor() != 1000 || refund.SucceededAt.IsZero() {
+		t.Errorf("unexpected refund(%+v)", refund)
+	}
+	refund, err = gateway.QueryRefund(ctx, "6823789339978248", "refund-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.Status != RefundSuccess || refund.Amount.Minor() != 1000 || refund.OutRefundNo != "refund-1" {
+		t.Errorf("unexpected refund(%+v)", refund)
+	}
+}
+
+func TestAlipayGatewayNotify(t *testing.T) {
+	fake := paytest.NewAlipay()
+	defer fake.Close()
+	gateway, err := NewAlipayGateway(newPaytestAlipayConfig(fake))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received []*Notification
+	notify := httptest.NewServer(NotifyHandler(gateway, notifyguard.NewMemoryStore(), func(ctx context.Context, n *Notification) error {
+		received = append(received, n)
+		return nil
+	}))
+	defer notify.Close()
+	ctx := context.Background()
+	createResult, err := gateway.Create(ctx, &Order{OutTradeNo: "6823789339978248", Subject: "iPhone", Amount: money.Fen(8888), NotifyURL: notify.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	openPayURL(t, createResult.PayURL)
+	if err = fake.Pay("6823789339978248"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = gateway.Refund(ctx, &RefundRequest{OutTradeNo: "6823789339978248", OutRefundNo: "refund-1", Amount: money.Fen(1000)}); err != nil {
+		t.Fatal(err)
+	}
+	if err = fake.NotifyRefund("6823789339978248", "refund-1"); err != nil {
+		t.Error(err)
+	}
+	if len(received) != 2 {
+		t.Fatalf("unexpected notifications(%v)", len(received))
+	}
+	if received[0].Type != NotifyPayment || received[0].Transaction.Status != StatusPaid || received[0].Transaction.Amount.Minor() != 8888 {
+		t.Errorf("unexpected payment notification(%+v)", received[0].Transaction)
+	}
+	//gmt_refund带毫秒
+	if refund := received[1].Refund; received[1].Type != NotifyRefund || refund.OutRefundNo != "refund-1" || refund.TotalRefunded.Minor() != 1000 ||
+		refund.SucceededAt.IsZero() || time.Since(refund.SucceededAt) > time.Minute {
+		t.Errorf("unexpected refund notification(%+v)", refund)
+	}
+	if succeededAt := parseAlipayTime("2015-04-28 15:45:57.320"); !succeededAt.Equal(time.Date(2015, 4, 28, 15, 45, 57, 320*int(time.Millisecond), beijing)) {
+		t.Errorf("unexpected gmt_refund(%v)", succeededAt)
+	}
+
+	//其他应用的通知 应答fail
+	config := newPaytestAlipayConfig(fake)
+	config.AppId = "2014072300000000"
+	other, err := NewAlipayGateway(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherNotify := httptest.NewServer(NotifyHandler(other, notifyguard.NewMemoryStore(), func(ctx context.Context, n *Notification) error {
+		received = append(received, n)
+		return nil
+	}))
+	defer otherNotify.Close()
+	fake.NotifyURL = otherNotify.URL
+	if err = fake.Notify("6823789339978248"); err == nil || len(received) != 2 {
+		t.Errorf("app_id mismatch should fail, got(%v) received(%v)", err, len(received))
+	}
+}
@@ -0,0 +1,166 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+)
+
+//统一支付网关:屏蔽支付宝与微信支付在接口签名、金额格式及状态取值上的差异
+//金额统一使用money.Amount(最小单位整数,带币种),状态统一使用Status/RefundStatus,原始状态保留在ChannelStatus
+//业务方按配置选择Channel,通过New创建Gateway,切换渠道无需修改下单、查询、退款及通知处理代码
+
+// 支付渠道
+type Channel string
+
+const (
+	ChannelAlipay Channel = "alipay"
+	ChannelWechat Channel = "wechat"
+)
+
+// 通知类型
+type NotifyType string
+
+const (
+	NotifyPayment NotifyType = "PAYMENT" //支付结果通知
+	NotifyRefund  NotifyType = "REFUND"  //退款结果通知
+)
+
+var (
+	ErrUnsupportedChannel = errors.New("payment: unsupported channel")
+	ErrOrderNotFound      = errors.New("payment: order not found")
+	ErrInvalidOrder       = errors.New("payment: invalid order")
+	// 通知验签失败、解析失败或与商户配置不一致 应答400,渠道会按策略重新通知
+	ErrInvalidNotification = errors.New("payment: invalid notification")
+)
+
+// 下单
+type Order struct {
+	OutTradeNo string       //商户订单号
+	Subject    string       //订单标题/商品描述
+	Amount     money.Amount //订单金额 币种为Amount的币种
+	NotifyURL  string       //异步通知地址
+	ReturnURL  string       //[非必填]支付成功后跳转地址(支付宝电脑网站支付)
+	ExpireAt   time.Time    //[非必填]订单失效时间
+}
+
+// 下单结果 支付宝返回PayURL(浏览器跳转),微信支付返回CodeURL(生成二维码)
+type CreateResult struct {
+	Channel    Channel
+	OutTradeNo string
+	PayURL     string //支付页面地址
+	CodeURL    string //二维码内容
+}
+
+// 交易
+type Transaction struct {
+	Channel       Channel
+	OutTradeNo    string       //商户订单号
+	TradeNo       string       //渠道交易号 支付宝trade_no/微信transaction_id
+	Status        Status       //统一交易状态
+	ChannelStatus string       //渠道原始状态
+	Amount        money.Amount //订单金额
+	PaidAmount    money.Amount //用户实付金额
+	Payer         string       //付款用户 支付宝buyer_id/微信openid
+	PaidAt        time.Time    //支付完成时间
+}
+
+// 退款请求
+type RefundRequest struct {
+	OutTradeNo  string       //商户订单号
+	OutRefundNo string       //商户退款单号 同一笔退款重试时保持不变
+	Amount      money.Amount //退款金额
+	Total       money.Amount //原订单金额(微信支付必填)
+	Reason      string       //[非必填]退款原因
+	NotifyURL   string       //[非必填]退款结果通知地址(微信支付)
+}
+
+// 退款
+type Refund struct {
+	Channel       Channel
+	OutTradeNo    string       //商户订单号
+	OutRefundNo   string       //商户退款单号
+	RefundNo      string       //渠道退款单号 微信refund_id,支付宝无
+	Status        RefundStatus //统一退款状态
+	ChannelStatus string       //渠道原始状态
+	Amount        money.Amount //本次退款金额 支付宝退款通知不返回单笔金额时为0
+	TotalRefunded money.Amount //订单累计退款金额 渠道未返回时为0
+	SucceededAt   time.Time    //退款成功时间
+}
+
+// 异步通知
+type Notification struct {
+	Channel     Channel
+	Id          string       //通知ID 支付宝notify_id/微信通知id,可用于notifyguard去重
+	Type        NotifyType   //通知类型
+	Transaction *Transaction //支付结果 Type为NotifyPayment时不为nil
+	Refund      *Refund      //退款结果 Type为NotifyRefund时不为nil
+}
+
+type Gateway interface {
+	Channel() Channel
+	Create(ctx context.Context, order *Order) (*CreateResult, error)
+	Query(ctx context.Context, outTradeNo string) (*Transaction, error)
+	Close(ctx context.Context, outTradeNo string) error
+	Refund(ctx context.Context, req *RefundRequest) (*Refund, error)
+	QueryRefund(ctx context.Context, outTradeNo, outRefundNo string) (*Refund, error)
+	ParseNotification(ctx context.Context, r *http.Request) (*Notification, error)
+	// 按渠道要求应答通知 err为nil表示处理成功
+	WriteNotifyResponse(w http.ResponseWriter, err error)
+}
+
+// 网关配置 可从配置文件加载,Channel决定使用的渠道
+type Config struct {
+	Channel Channel
+	Alipay  *AlipayConfig
+	Wechat  *WechatConfig
+}
+
+/*
+[New]-> 按配置的渠道创建Gateway
+*/
+func New(config *Config) (Gateway, error) {
+	if config == nil {
+		return nil, fmt.Errorf("%w: config can not be nil", ErrUnsupportedChannel)
+	}
+	switch config.Channel {
+	case ChannelAlipay:
+		if config.Alipay == nil {
+			return nil, errors.New("payment: alipay config can not be nil")
+		}
+		return NewAlipayGateway(config.Alipay)
+	case ChannelWechat:
+		if config.Wechat == nil {
+			return nil, errors.New("payment: wechat config can not be nil")
+		}
+		return NewWechatGateway(config.Wechat)
+	}
+	return nil, fmt.Errorf("%w: %v", ErrUnsupportedChannel, config.Channel)
+}
+
+func (o *Order) check() error {
+	if o == nil || o.OutTradeNo == "" || !o.Amount.IsPositive() {
+		return fmt.Errorf("%w: OutTradeNo and Amount can not be empty", ErrInvalidOrder)
+	}
+	return nil
+}
+
+func (r *RefundRequest) check() error {
+	if r == nil || r.OutTradeNo == "" || r.OutRefundNo == "" || !r.Amount.IsPositive() {
+		return fmt.Errorf("%w: OutTradeNo, OutRefundNo and Amount can not be empty", ErrInvalidOrder)
+	}
+	if r.Total.IsPositive() {
+		cmp, err := r.Amount.Cmp(r.Total)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidOrder, err)
+		}
+		if cmp > 0 {
+			return fmt.Errorf("%w: refund amount(%v) more than total(%v)", ErrInvalidOrder, r.Amount, r.Total)
+		}
+	}
+	return nil
+}
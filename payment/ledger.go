@@ -0,0 +1,89 @@
+package payment
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+)
+
+var _ RefundLedger = &MemoryRefundLedger{}
+
+// 内存退款账本 适用于单实例及测试
+type MemoryRefundLedger struct {
+	mu      sync.RWMutex
+	refunds map[string]*RefundRecord //OutRefundNo->退款记录
+	orders  map[string][]string      //OutTradeNo->OutRefundNo 按创建顺序
+}
+
+func NewMemoryRefundLedger() *MemoryRefundLedger {
+	return &MemoryRefundLedger{refunds: make(map[string]*RefundRecord), orders: make(map[string][]string)}
+}
+
+func (l *MemoryRefundLedger) Reserve(ctx context.Context, record *RefundRecord, total money.Amount, maxCount int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.refunds[record.OutRefundNo]; ok {
+		return fmt.Errorf("%w: duplicate refund(%v)", ErrInvalidOrder, record.OutRefundNo)
+	}
+	outRefundNos := l.orders[record.OutTradeNo]
+	refunded := money.New(0, total.Currency())
+	count := 0
+	for _, outRefundNo := range outRefundNos {
+		if refund := l.refunds[outRefundNo]; refund.counted() {
+			var err error
+			if refunded, err = refunded.Add(refund.Amount); err != nil {
+				return err
+			}
+			count++
+		}
+	}
+	if count >= maxCount {
+		return fmt.Errorf("%w: order(%v) refunds(%v) max(%v)", ErrRefundLimit, record.OutTradeNo, count, maxCount)
+	}
+	after, err := refunded.Add(record.Amount)
+	if err != nil {
+		return fmt.Errorf("%w: order(%v) %v", ErrOverRefund, record.OutTradeNo, err)
+	}
+	if cmp, err := after.Cmp(total); err != nil || cmp > 0 {
+		return fmt.Errorf("%w: order(%v) refunded(%v) amount(%v) total(%v)", ErrOverRefund, record.OutTradeNo, refunded, record.Amount, total)
+	}
+	copied := *record
+	l.refunds[record.OutRefundNo] = &copied
+	l.orders[record.OutTradeNo] = append(outRefundNos, record.OutRefundNo)
+	return nil
+}
+
+func (l *MemoryRefundLedger) Update(ctx context.Context, record *RefundRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.refunds[record.OutRefundNo]; !ok {
+		return fmt.Errorf("%w: %v", ErrRefundNotFound, record.OutRefundNo)
+	}
+	copied := *record
+	l.refunds[record.OutRefundNo] = &copied
+	return nil
+}
+
+func (l *MemoryRefundLedger) Get(ctx context.Context, outRefundNo string) (*RefundRecord, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	refund, ok := l.refunds[outRefundNo]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrRefundNotFound, outRefundNo)
+	}
+	copied := *refund
+	return &copied, nil
+}
+
+func (l *MemoryRefundLedger) List(ctx context.Context, outTradeNo string) ([]*RefundRecord, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	records := make([]*RefundRecord, 0, len(l.orders[outTradeNo]))
+	for _, outRefundNo := range l.orders[outTradeNo] {
+		copied := *l.refunds[outRefundNo]
+		records = append(records, &copied)
+	}
+	return records, nil
+}
@@ -0,0 +1,44 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/tanjl855/Sms_Pay_SDK/notifyguard"
+)
+
+/*
+[NotifyHandler]-> 解析渠道通知并调用callback,按渠道要求应答
+store: [非必填]按Notification.Id去重,同一通知处理成功后重复送达不再调用callback
+callback返回error时应答失败,渠道会按策略重新通知
+*/
+func NotifyHandler(gateway Gateway, store notifyguard.NotificationStore, callback func(ctx context.Context, n *Notification) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		notification, err := gateway.ParseNotification(r.Context(), r)
+		if err != nil {
+			fmt.Printf("NotifyHandler-> %v ParseNotification error(%v)", gateway.Channel(), err)
+			gateway.WriteNotifyResponse(w, err)
+			return
+		}
+		_, err = notifyguard.Process(store, notification.Id, func() error {
+			if callback == nil {
+				return nil
+			}
+			return callback(r.Context(), notification)
+		})
+		if err != nil {
+			fmt.Printf("NotifyHandler-> %v notification(%v) callback error(%v)", gateway.Channel(), notification.Id, err)
+		}
+		gateway.WriteNotifyResponse(w, err)
+	}
+}
+
+// 通知无效应答400,其余错误(业务处理失败等)应答500
+func notifyErrorStatus(err error) int {
+	if errors.Is(err, ErrInvalidNotification) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,313 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+)
+
+//订单支付状态机:所有状态变更都经过transitions校验,非法变更(如关闭已支付订单)返回ErrIllegalTransition
+//状态通过OrderRepository持久化,变更成功后调用OnTransition通知业务(发货、记账等)
+//支付/退款通知(HandleNotification)及主动查询结果(ApplyTransaction/ApplyRefund)驱动状态变化,重复或过期的结果不会产生变更
+
+// 订单状态
+type OrderState string
+
+const (
+	OrderCreated           OrderState = "CREATED"            //已创建 待支付
+	OrderPaying            OrderState = "PAYING"             //用户支付中
+	OrderPaid              OrderState = "PAID"               //已支付
+	OrderPartiallyRefunded OrderState = "PARTIALLY_REFUNDED" //部分退款
+	OrderRefunded          OrderState = "REFUNDED"           //全额退款
+	OrderClosed            OrderState = "CLOSED"             //已关闭 未支付
+)
+
+// 合法的状态变更 未列出的均为非法变更
+var transitions = map[OrderState][]OrderState{
+	OrderCreated:           {OrderPaying, OrderPaid, OrderClosed},
+	OrderPaying:            {OrderPaid, OrderClosed},
+	OrderPaid:              {OrderPartiallyRefunded, OrderRefunded},
+	OrderPartiallyRefunded: {OrderPartiallyRefunded, OrderRefunded},
+}
+
+var (
+	ErrIllegalTransition = errors.New("payment: illegal order state transition")
+	ErrAmountMismatch    = errors.New("payment: amount mismatch with order")
+)
+
+// 非法状态变更
+type TransitionError struct {
+	OutTradeNo string
+	From       OrderState
+	To         OrderState
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("payment: order(%v) can not transit from %v to %v", e.OutTradeNo, e.From, e.To)
+}
+
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrIllegalTransition
+}
+
+// 是否允许从s变更到to
+func (s OrderState) CanTransit(to OrderState) bool {
+	for _, state := range transitions[s] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+// 终态 不再发生变更
+func (s OrderState) IsFinal() bool {
+	return len(transitions[s]) == 0
+}
+
+// 订单记录
+type OrderRecord struct {
+	OutTradeNo     string                  //商户订单号
+	Channel        Channel                 //支付渠道
+	Amount         money.Amount            //订单金额
+	RefundedAmount money.Amount            //累计退款金额
+	Refunds        map[string]money.Amount //已成功的退款 商户退款单号->退款金额
+	RefundedAt     map[string]time.Time    //退款成功时间 商户退款单号->渠道退款成功时间,渠道未返回时为登记时间
+	State          OrderState              //订单状态
+	TradeNo        string                  //渠道交易号
+	PaidAt         time.Time               //支付完成时间
+	Version        int64                   //版本号 每次更新加1,用于并发更新检测
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+func (o *OrderRecord) clone() *OrderRecord {
+	order := *o
+	order.Refunds = make(map[string]money.Amount, len(o.Refunds))
+	for k, v := range o.Refunds {
+		order.Refunds[k] = v
+	}
+	order.RefundedAt = make(map[string]time.Time, len(o.RefundedAt))
+	for k, v := range o.RefundedAt {
+		order.RefundedAt[k] = v
+	}
+	return &order
+}
+
+// 状态变更事件
+type OrderEvent struct {
+	OutTradeNo string
+	From       OrderState
+	To         OrderState
+	Order      *OrderRecord //变更后的订单
+	At         time.Time
+}
+
+// 订单状态机
+type StateMachine struct {
+	Repo         OrderRepository
+	OnTransition func(ctx context.Context, event *OrderEvent) //[非必填]状态变更成功后调用
+	now          func() time.Time
+}
+
+func NewStateMachine(repo OrderRepository) *StateMachine {
+	return &StateMachine{Repo: repo, now: time.Now}
+}
+
+// 并发更新冲突时的重试次数
+const maxUpdateRetries = 3
+
+/*
+[Create]-> 创建待支付订单
+*/
+func (m *StateMachine) Create(ctx context.Context, channel Channel, order *Order) (*OrderRecord, error) {
+	if err := order.check(); err != nil {
+		return nil, err
+	}
+	now := m.now()
+	record := &OrderRecord{
+		OutTradeNo:     order.OutTradeNo,
+		Channel:        channel,
+		Amount:         order.Amount,
+		RefundedAmount: money.New(0, order.Amount.Currency()),
+		Refunds:        make(map[string]money.Amount),
+		RefundedAt:     make(map[string]time.Time),
+		State:          OrderCreated,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if err := m.Repo.Create(ctx, record); err != nil {
+		return nil, err
+	}
+	return record.clone(), nil
+}
+
+// 关闭未支付订单 已关闭时不变更,已支付的订单返回ErrIllegalTransition
+func (m *StateMachine) Close(ctx context.Context, outTradeNo string) (*OrderRecord, error) {
+	return m.update(ctx, outTradeNo, func(order *OrderRecord) (OrderState, error) {
+		return OrderClosed, nil
+	})
+}
+
+/*
+[ApplyTransaction]-> 按支付通知或查询结果更新订单
+待支付及无法识别的状态不变更;已支付后再次收到支付成功不变更
+已关闭的订单收到支付成功时返回ErrIllegalTransition,调用方需退款或人工处理这笔款项
+支付宝全额退款后交易状态为TRADE_CLOSED,已支付订单收到StatusClosed时不变更,退款以ApplyRefund为准
+*/
+func (m *StateMachine) ApplyTransaction(ctx context.Context, transaction *Transaction) (*OrderRecord, error) {
+	return m.update(ctx, transaction.OutTradeNo, func(order *OrderRecord) (OrderState, error) {
+		if transaction.Amount.IsPositive() && !transaction.Amount.Equal(order.Amount) {
+			return order.State, fmt.Errorf("%w: order(%v) amount(%v) expect(%v)", ErrAmountMismatch, order.OutTradeNo, transaction.Amount, order.Amount)
+		}
+		switch transaction.Status {
+		case StatusPaying:
+			if order.State == OrderCreated {
+				return OrderPaying, nil
+			}
+		case StatusPaid, StatusFinished, StatusRefunded:
+			if order.State == OrderCreated || order.State == OrderPaying {
+				order.TradeNo = transaction.TradeNo
+				order.PaidAt = transaction.PaidAt
+				return OrderPaid, nil
+			}
+			if order.State == OrderClosed {
+				return order.State, &TransitionError{OutTradeNo: order.OutTradeNo, From: OrderClosed, To: OrderPaid}
+			}
+		case StatusClosed, StatusFailed:
+			if order.State == OrderCreated || order.State == OrderPaying {
+				return OrderClosed, nil
+			}
+		}
+		return order.State, nil
+	})
+}
+
+/*
+[ApplyRefund]-> 按退款结果更新订单 仅处理退款成功
+同一商户退款单号重复送达不会重复累计;渠道返回累计退款金额(TotalRefunded)时以其为准,累计退款金额不会减少
+*/
+func (m *StateMachine) ApplyRefund(ctx context.Context, refund *Refund) (*OrderRecord, error) {
+	return m.update(ctx, refund.OutTradeNo, func(order *OrderRecord) (OrderState, error) {
+		if refund.Status != RefundSuccess {
+			return order.State, nil
+		}
+		refunded, err := order.addRefund(refund, m.now())
+		if err != nil {
+			return order.State, err
+		}
+		if refunded.Equal(order.RefundedAmount) {
+			return order.State, nil
+		}
+		order.RefundedAmount = refunded
+		if refunded.Equal(order.Amount) {
+			return OrderRefunded, nil
+		}
+		return OrderPartiallyRefunded, nil
+	})
+}
+
+// 登记成功的退款并返回累计退款金额 取单笔退款之和、渠道累计金额及已记录累计金额中的最大值
+// 超过订单金额或币种不一致时返回ErrAmountMismatch
+func (o *OrderRecord) addRefund(refund *Refund, now time.Time) (money.Amount, error) {
+	if _, ok := o.Refunds[refund.OutRefundNo]; !ok {
+		if !refund.SucceededAt.IsZero() {
+			now = refund.SucceededAt
+		}
+		o.RefundedAt[refund.OutRefundNo] = now
+	}
+	if refund.Amount.IsPositive() {
+		o.Refunds[refund.OutRefundNo] = refund.Amount
+	} else if _, ok := o.Refunds[refund.OutRefundNo]; !ok {
+		o.Refunds[refund.OutRefundNo] = money.New(0, o.Amount.Currency())
+	}
+	refunded := money.New(0, o.Amount.Currency())
+	for _, amount := range o.Refunds {
+		var err error
+		if refunded, err = refunded.Add(amount); err != nil {
+			return refunded, fmt.Errorf("%w: order(%v) %v", ErrAmountMismatch, o.OutTradeNo, err)
+		}
+	}
+	//通知与查询结果交替送达时(如退款通知只带累计金额,查询结果只带单笔金额),累计退款金额只增不减
+	for _, total := range []money.Amount{refund.TotalRefunded, o.RefundedAmount} {
+		if !total.IsPositive() {
+			continue
+		}
+		cmp, err := total.Cmp(refunded)
+		if err != nil {
+			return refunded, fmt.Errorf("%w: order(%v) %v", ErrAmountMismatch, o.OutTradeNo, err)
+		}
+		if cmp > 0 {
+			refunded = total
+		}
+	}
+	if cmp, _ := refunded.Cmp(o.Amount); cmp > 0 {
+		return refunded, fmt.Errorf("%w: order(%v) refunded(%v) more than amount(%v)", ErrAmountMismatch, o.OutTradeNo, refunded, o.Amount)
+	}
+	return refunded, nil
+}
+
+/*
+[HandleNotification]-> 按通知更新订单 可作为NotifyHandler的callback
+*/
+func (m *StateMachine) HandleNotification(ctx context.Context, n *Notification) error {
+	var err error
+	switch n.Type {
+	case NotifyPayment:
+		_, err = m.ApplyTransaction(ctx, n.Transaction)
+	case NotifyRefund:
+		_, err = m.ApplyRefund(ctx, n.Refund)
+	}
+	return err
+}
+
+// 读取订单并按mutate的结果变更状态 版本冲突时重新读取后重试
+func (m *StateMachine) update(ctx context.Context, outTradeNo string, mutate func(order *OrderRecord) (OrderState, error)) (*OrderRecord, error) {
+	for i := 0; ; i++ {
+		current, err := m.Repo.Get(ctx, outTradeNo)
+		if err != nil {
+			return nil, err
+		}
+		order := current.clone()
+		to, err := mutate(order)
+		if err != nil {
+			return nil, err
+		}
+		//只登记了新的退款单号而累计金额未变时 保存订单但不产生状态变更事件
+		transited := to != current.State || !order.RefundedAmount.Equal(current.RefundedAmount)
+		if !transited && sameRefunds(order.Refunds, current.Refunds) {
+			return current, nil
+		}
+		if transited && !current.State.CanTransit(to) {
+			return nil, &TransitionError{OutTradeNo: outTradeNo, From: current.State, To: to}
+		}
+		order.State = to
+		order.UpdatedAt = m.now()
+		err = m.Repo.Update(ctx, order, current.Version)
+		if errors.Is(err, ErrVersionConflict) && i < maxUpdateRetries {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if transited && m.OnTransition != nil {
+			m.OnTransition(ctx, &OrderEvent{OutTradeNo: outTradeNo, From: current.State, To: to, Order: order.clone(), At: order.UpdatedAt})
+		}
+		return order, nil
+	}
+}
+
+func sameRefunds(a, b map[string]money.Amount) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if other, ok := b[k]; !ok || !other.Equal(v) {
+			return false
+		}
+	}
+	return true
+}
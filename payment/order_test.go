@@ -0,0 +1,207 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+)
+
+func newTestStateMachine(t *testing.T, amount int64) (*StateMachine, *[]*OrderEvent) {
+	machine := NewStateMachine(NewMemoryOrderRepository())
+	var events []*OrderEvent
+	machine.OnTransition = func(ctx context.Context, event *OrderEvent) {
+		events = append(events, event)
+	}
+	if _, err := machine.Create(context.Background(), ChannelWechat, &Order{OutTradeNo: "1217752501201407033233368018", Amount: money.Fen(amount)}); err != nil {
+		t.Fatal(err)
+	}
+	return machine, &events
+}
+
+func TestStateMachine(t *testing.T) {
+	machine, events := newTestStateMachine(t, 8888)
+	ctx := context.Background()
+	outTradeNo := "1217752501201407033233368018"
+
+	if _, err := machine.Create(ctx, ChannelWechat, &Order{OutTradeNo: outTradeNo, Amount: money.Fen(8888)}); !errors.Is(err, ErrOrderExists) {
+		t.Errorf("expect ErrOrderExists, got(%v)", err)
+	}
+	order, err := machine.ApplyTransaction(ctx, &Transaction{OutTradeNo: outTradeNo, Status: StatusPending})
+	if err != nil || order.State != OrderCreated {
+		t.Fatalf("pending should not change state, got(%+v, %v)", order, err)
+	}
+	if order, err = machine.ApplyTransaction(ctx, &Transaction{OutTradeNo: outTradeNo, Status: StatusPaying}); err != nil || order.State != OrderPaying {
+		t.Fatalf("unexpected order(%+v, %v)", order, err)
+	}
+	if _, err = machine.ApplyTransaction(ctx, &Transaction{OutTradeNo: outTradeNo, Status: StatusPaid, Amount: money.Fen(1)}); !errors.Is(err, ErrAmountMismatch) {
+		t.Errorf("expect ErrAmountMismatch, got(%v)", err)
+	}
+	if order, err = machine.ApplyTransaction(ctx, &Transaction{OutTradeNo: outTradeNo, TradeNo: "4200000001", Status: StatusPaid, Amount: money.Fen(8888)}); err != nil || order.State != OrderPaid || order.TradeNo != "4200000001" {
+		t.Fatalf("unexpected order(%+v, %v)", order, err)
+	}
+	//重复的支付通知及已支付后的过期查询结果
+	if order, err = machine.ApplyTransaction(ctx, &Transaction{OutTradeNo: outTradeNo, Status: StatusPaid, Amount: money.Fen(8888)}); err != nil || order.State != OrderPaid {
+		t.Fatalf("unexpected order(%+v, %v)", order, err)
+	}
+	if order, err = machine.ApplyTransaction(ctx, &Transaction{OutTradeNo: outTradeNo, Status: StatusPending}); err != nil || order.State != OrderPaid {
+		t.Fatalf("unexpected order(%+v, %v)", order, err)
+	}
+	if _, err = machine.Close(ctx, outTradeNo); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("close paid order should fail, got(%v)", err)
+	}
+
+	if order, err = machine.ApplyRefund(ctx, &Refund{OutTradeNo: outTradeNo, OutRefundNo: "refund-1", Status: RefundProcessing, Amount: money.Fen(1000)}); err != nil || order.State != OrderPaid {
+		t.Fatalf("processing refund should not change state, got(%+v, %v)", order, err)
+	}
+	for i := 0; i < 2; i++ {
+		if order, err = machine.ApplyRefund(ctx, &Refund{OutTradeNo: outTradeNo, OutRefundNo: "refund-1", Status: RefundSuccess, Amount: money.Fen(1000)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if order.State != OrderPartiallyRefunded || order.RefundedAmount.Minor() != 1000 {
+		t.Errorf("unexpected order(%+v)", order)
+	}
+	if _, err = machine.ApplyRefund(ctx, &Refund{OutTradeNo: outTradeNo, OutRefundNo: "refund-2", Status: RefundSuccess, Amount: money.Fen(8888)}); !errors.Is(err, ErrAmountMismatch) {
+		t.Errorf("refund more than amount should fail, got(%v)", err)
+	}
+	if order, err = machine.ApplyRefund(ctx, &Refund{OutTradeNo: outTradeNo, OutRefundNo: "refund-2", Status: RefundSuccess, Amount: money.Fen(7888)}); err != nil || order.State != OrderRefunded || order.RefundedAmount.Minor() != 8888 {
+		t.Fatalf("unexpected order(%+v, %v)", order, err)
+	}
+
+	expect := []OrderState{OrderPaying, OrderPaid, OrderPartiallyRefunded, OrderRefunded}
+	if len(*events) != len(expect) {
+		t.Fatalf("unexpected events(%v)", len(*events))
+	}
+	for i, event := range *events {
+		if event.To != expect[i] || event.Order.State != expect[i] {
+			t.Errorf("unexpected event(%+v)", event)
+		}
+	}
+	if order.Version != int64(len(expect)) {
+		t.Errorf("unexpected version(%v)", order.Version)
+	}
+}
+
+func TestStateMachineClose(t *testing.T) {
+	machine, events := newTestStateMachine(t, 100)
+	ctx := context.Background()
+	outTradeNo := "1217752501201407033233368018"
+	for i := 0; i < 2; i++ {
+		if order, err := machine.Close(ctx, outTradeNo); err != nil || order.State != OrderClosed {
+			t.Fatalf("unexpected order(%+v, %v)", order, err)
+		}
+	}
+	if len(*events) != 1 {
+		t.Errorf("close twice should emit one event, got(%v)", len(*events))
+	}
+	//关单后才送达的支付通知 不能静默丢弃
+	err := machine.HandleNotification(ctx, &Notification{Type: NotifyPayment, Transaction: &Transaction{OutTradeNo: outTradeNo, TradeNo: "4200000001", Status: StatusPaid, Amount: money.Fen(100)}})
+	var transitionErr *TransitionError
+	if !errors.As(err, &transitionErr) || transitionErr.From != OrderClosed || transitionErr.To != OrderPaid {
+		t.Errorf("paid after closed should fail, got(%v)", err)
+	}
+	if order, err := machine.Repo.Get(ctx, outTradeNo); err != nil || order.State != OrderClosed || order.TradeNo != "" {
+		t.Errorf("unexpected order(%+v, %v)", order, err)
+	}
+	if _, err := machine.ApplyRefund(ctx, &Refund{OutTradeNo: outTradeNo, OutRefundNo: "refund-1", Status: RefundSuccess, Amount: money.Fen(100)}); !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("refund closed order should fail, got(%v)", err)
+	}
+	if _, err := machine.Close(ctx, "unknown"); !errors.Is(err, ErrOrderNotFound) {
+		t.Errorf("expect ErrOrderNotFound, got(%v)", err)
+	}
+}
+
+// 支付宝退款通知只返回累计退款金额
+func TestStateMachineTotalRefunded(t *testing.T) {
+	machine, _ := newTestStateMachine(t, 8888)
+	ctx := context.Background()
+	outTradeNo := "1217752501201407033233368018"
+	if err := machine.HandleNotification(ctx, &Notification{Type: NotifyPayment, Transaction: &Transaction{OutTradeNo: outTradeNo, Status: StatusPaid, Amount: money.Fen(8888)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := machine.HandleNotification(ctx, &Notification{Type: NotifyRefund, Refund: &Refund{OutTradeNo: outTradeNo, OutRefundNo: "refund-1", Status: RefundSuccess, TotalRefunded: money.Fen(1000)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := machine.HandleNotification(ctx, &Notification{Type: NotifyRefund, Refund: &Refund{OutTradeNo: outTradeNo, OutRefundNo: "refund-2", Status: RefundSuccess, TotalRefunded: money.Fen(8888)}}); err != nil {
+		t.Fatal(err)
+	}
+	order, err := machine.Repo.Get(ctx, outTradeNo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.State != OrderRefunded || order.RefundedAmount.Minor() != 8888 {
+		t.Errorf("unexpected order(%+v)", order)
+	}
+}
+
+// 退款通知只带累计金额、查询结果只带单笔金额时 累计退款金额不减少
+func TestStateMachineMixedRefundSources(t *testing.T) {
+	machine, _ := newTestStateMachine(t, 8888)
+	ctx := context.Background()
+	outTradeNo := "1217752501201407033233368018"
+	if _, err := machine.ApplyTransaction(ctx, &Transaction{OutTradeNo: outTradeNo, Status: StatusPaid, Amount: money.Fen(8888)}); err != nil {
+		t.Fatal(err)
+	}
+	//商户平台手动退款的通知
+	if err := machine.HandleNotification(ctx, &Notification{Type: NotifyRefund, Refund: &Refund{OutTradeNo: outTradeNo, OutRefundNo: "manual-1", Status: RefundSuccess, TotalRefunded: money.Fen(1000)}}); err != nil {
+		t.Fatal(err)
+	}
+	//另一笔退款的查询结果
+	order, err := machine.ApplyRefund(ctx, &Refund{OutTradeNo: outTradeNo, OutRefundNo: "refund-2", Status: RefundSuccess, Amount: money.Fen(500)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.State != OrderPartiallyRefunded || order.RefundedAmount.Minor() != 1000 {
+		t.Errorf("refunded amount should not decrease, got(%+v)", order)
+	}
+	if order, err = machine.ApplyRefund(ctx, &Refund{OutTradeNo: outTradeNo, OutRefundNo: "refund-3", Status: RefundSuccess, Amount: money.Fen(1000)}); err != nil {
+		t.Fatal(err)
+	}
+	if order.RefundedAmount.Minor() != 1500 {
+		t.Errorf("unexpected refunded amount(%v)", order.RefundedAmount)
+	}
+	//渠道返回退款成功时间时记录该时间,否则为登记时间
+	succeededAt := time.Date(2023, 6, 1, 15, 2, 45, 0, beijing)
+	if order, err = machine.ApplyRefund(ctx, &Refund{OutTradeNo: outTradeNo, OutRefundNo: "refund-4", Status: RefundSuccess, Amount: money.Fen(100), SucceededAt: succeededAt}); err != nil {
+		t.Fatal(err)
+	}
+	if !order.RefundedAt["refund-4"].Equal(succeededAt) || order.RefundedAt["refund-2"].IsZero() || len(order.RefundedAt) != len(order.Refunds) {
+		t.Errorf("unexpected refunded at(%v)", order.RefundedAt)
+	}
+	if err = machine.HandleNotification(ctx, &Notification{Type: NotifyRefund, Refund: &Refund{OutTradeNo: outTradeNo, OutRefundNo: "refund-3", Status: RefundSuccess, TotalRefunded: money.Fen(2600)}}); err != nil {
+		t.Fatal(err)
+	}
+	if order, err = machine.Repo.Get(ctx, outTradeNo); err != nil || order.RefundedAmount.Minor() != 2600 {
+		t.Errorf("unexpected order(%+v, %v)", order, err)
+	}
+}
+
+// 支付通知与主动查询并发更新 只产生一次状态变更
+func TestStateMachineConcurrent(t *testing.T) {
+	machine, _ := newTestStateMachine(t, 100)
+	var mu sync.Mutex
+	count := 0
+	machine.OnTransition = func(ctx context.Context, event *OrderEvent) {
+		mu.Lock()
+		count++
+		mu.Unlock()
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := machine.ApplyTransaction(context.Background(), &Transaction{OutTradeNo: "1217752501201407033233368018", Status: StatusPaid, Amount: money.Fen(100)}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if count != 1 {
+		t.Errorf("expect one transition, got(%v)", count)
+	}
+}
@@ -0,0 +1,171 @@
+package payment
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+	"github.com/tanjl855/Sms_Pay_SDK/notifyguard"
+	"github.com/tanjl855/Sms_Pay_SDK/paytest"
+	wechatpay "github.com/tanjl855/Sms_Pay_SDK/wechat_pay"
+)
+
+// 下单->付款通知->退款->退款通知 全流程使用模拟渠道,通知由NotifyHandler交给StateMachine处理
+type testFlow struct {
+	gateway Gateway
+	machine *StateMachine
+	notify  *httptest.Server
+}
+
+func newTestFlow(t *testing.T, gateway Gateway) *testFlow {
+	machine := NewStateMachine(NewMemoryOrderRepository())
+	notify := httptest.NewServer(NotifyHandler(gateway, notifyguard.NewMemoryStore(), machine.HandleNotification))
+	t.Cleanup(notify.Close)
+	return &testFlow{gateway: gateway, machine: machine, notify: notify}
+}
+
+func (f *testFlow) create(t *testing.T, outTradeNo string, amount money.Amount) *CreateResult {
+	ctx := context.Background()
+	order := &Order{OutTradeNo: outTradeNo, Subject: "Iphone6 16G", Amount: amount, NotifyURL: f.notify.URL}
+	if _, err := f.machine.Create(ctx, f.gateway.Channel(), order); err != nil {
+		t.Fatal(err)
+	}
+	res, err := f.gateway.Create(ctx, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func (f *testFlow) expectState(t *testing.T, outTradeNo string, state OrderState) *OrderRecord {
+	order, err := f.machine.Repo.Get(context.Background(), outTradeNo)
+	if err != nil || order.State != state {
+		t.Fatalf("expect order state(%v), got(%+v, %v)", state, order, err)
+	}
+	return order
+}
+
+func newPaytestAlipayConfig(fake *paytest.Alipay) *AlipayConfig {
+	return &AlipayConfig{
+		AppId:        fake.AppId,
+		PrivateKey:   fake.AppPrivateKey,
+		AliPublicKey: fake.AlipayPublicKey,
+		SellerId:     fake.SellerId,
+		Domain:       fake.URL,
+	}
+}
+
+func newPaytestWechatConfig(fake *paytest.Wechat) *WechatConfig {
+	config := wechatpay.NewConfig(fake.MchId, fake.MchCertificateSerialNumber, fake.APIv3Key, "")
+	config.PrivateKey = fake.MchPrivateKey
+	config.PlatformCertificates = []*x509.Certificate{fake.PlatformCertificate}
+	config.Domain = fake.URL
+	return &WechatConfig{AppId: "wxd678efh567hg6787", Config: config}
+}
+
+func newPaytestWechatGateway(t *testing.T, fake *paytest.Wechat) Gateway {
+	gateway, err := NewWechatGateway(newPaytestWechatConfig(fake))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gateway
+}
+
+func TestAlipayFlow(t *testing.T) {
+	fake := paytest.NewAlipay()
+	defer fake.Close()
+	gateway, err := NewAlipayGateway(newPaytestAlipayConfig(fake))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flow, ctx := newTestFlow(t, gateway), context.Background()
+	outTradeNo := "20150320010101001"
+	//打开支付页面前交易不存在
+	if _, err = gateway.Query(ctx, outTradeNo); err == nil {
+		t.Error("trade not created but no return err")
+	}
+	res := flow.create(t, outTradeNo, money.Fen(8888))
+	resp, err := http.Get(res.PayURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if err = fake.Pay(outTradeNo); err != nil {
+		t.Fatal(err)
+	}
+	order := flow.expectState(t, outTradeNo, OrderPaid)
+	trade, _ := fake.Trade(outTradeNo)
+	if order.TradeNo != trade.TradeNo {
+		t.Errorf("unexpected order trade no(%v) expect(%v)", order.TradeNo, trade.TradeNo)
+	}
+	//重复通知
+	if err = fake.Notify(outTradeNo); err != nil {
+		t.Error(err)
+	}
+
+	refund, err := gateway.Refund(ctx, &RefundRequest{OutTradeNo: outTradeNo, OutRefundNo: "refund-1", Amount: money.Fen(1000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = flow.machine.ApplyRefund(ctx, refund); err != nil {
+		t.Fatal(err)
+	}
+	if err = fake.NotifyRefund(outTradeNo, "refund-1"); err != nil {
+		t.Error(err)
+	}
+	flow.expectState(t, outTradeNo, OrderPartiallyRefunded)
+	if refund, err = gateway.QueryRefund(ctx, outTradeNo, "refund-1"); err != nil || refund.Status != RefundSuccess || !refund.Amount.Equal(money.Fen(1000)) {
+		t.Errorf("unexpected refund(%+v, %v)", refund, err)
+	}
+}
+
+func TestWechatFlow(t *testing.T) {
+	fake := paytest.NewWechat()
+	defer fake.Close()
+	gateway := newPaytestWechatGateway(t, fake)
+	flow, ctx := newTestFlow(t, gateway), context.Background()
+	outTradeNo := "1217752501201407033233368018"
+	if res := flow.create(t, outTradeNo, money.Fen(100)); res.CodeURL == "" {
+		t.Errorf("unexpected create result(%+v)", res)
+	}
+	if err := fake.Pay(outTradeNo); err != nil {
+		t.Fatal(err)
+	}
+	flow.expectState(t, outTradeNo, OrderPaid)
+
+	refund, err := gateway.Refund(ctx, &RefundRequest{OutTradeNo: outTradeNo, OutRefundNo: "1217752501201407033233368019", Amount: money.Fen(100), Total: money.Fen(100), NotifyURL: flow.notify.URL})
+	if err != nil || refund.Status != RefundProcessing {
+		t.Fatalf("unexpected refund(%+v, %v)", refund, err)
+	}
+	if err = fake.CompleteRefund(refund.OutRefundNo); err != nil {
+		t.Fatal(err)
+	}
+	flow.expectState(t, outTradeNo, OrderRefunded)
+}
+
+// 支付通知丢失 由Poller查询到支付结果
+func TestPollerFlow(t *testing.T) {
+	fake := paytest.NewWechat()
+	defer fake.Close()
+	lost := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer lost.Close()
+	fake.NotifyURL = lost.URL
+	gateway := newPaytestWechatGateway(t, fake)
+	flow, ctx := newTestFlow(t, gateway), context.Background()
+	outTradeNo := "1217752501201407033233368018"
+	flow.create(t, outTradeNo, money.Fen(100))
+	poller := newTestPoller(gateway, flow.machine.HandleNotification)
+	poller.Schedule = []time.Duration{10 * time.Millisecond}
+	poller.Watch(ctx, outTradeNo, time.Now().Add(5*time.Second))
+	if err := fake.Pay(outTradeNo); err == nil {
+		t.Error("notify lost but no return err")
+	}
+	poller.Wait()
+	flow.expectState(t, outTradeNo, OrderPaid)
+}
@@ -0,0 +1,224 @@
+package payment
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tanjl855/Sms_Pay_SDK/notifyguard"
+)
+
+//主动查询:异步通知可能丢失(网络故障、通知地址不可用等),下单后按退避间隔查询交易状态,直到终态或订单过期
+//查询到终态后以Notification(Type为NotifyPayment)调用与NotifyHandler相同的callback,如StateMachine.HandleNotification
+//多实例部署时通过Lease保证同一订单只有一个实例在查询;callback需幂等,通知与查询可能先后送达同一结果
+
+// 默认查询间隔 用完后按最后一个间隔继续查询
+var DefaultPollSchedule = []time.Duration{15 * time.Second, 30 * time.Second, time.Minute, 5 * time.Minute, 15 * time.Minute, 30 * time.Minute}
+
+// 未指定过期时间时的最长查询时间
+const DefaultPollTimeout = 2 * time.Hour
+
+var (
+	ErrPollExpired = errors.New("payment: poll expired before final status")
+	ErrLeaseHeld   = errors.New("payment: poll lease held by another owner")
+)
+
+//Lease 查询租约,多实例部署时基于Redis(SET key owner NX PX ttl)或数据库实现
+
+type Lease interface {
+	// 获取租约 owner已持有时续期,其他owner持有且未过期时返回false
+	Acquire(ctx context.Context, key, owner string, ttl time.Duration) (bool, error)
+	Release(ctx context.Context, key, owner string) error //仅释放owner持有的租约
+}
+
+// 交易状态查询调度
+type Poller struct {
+	Gateway   Gateway
+	Callback  func(ctx context.Context, n *Notification) error //查询到终态后调用 返回error时按下一个间隔重新查询
+	Store     notifyguard.NotificationStore                    //[非必填]按Notification.Id去重 多实例查询到同一结果时只回调一次
+	Lease     Lease                                            //[非必填]多实例部署时防止重复查询
+	Owner     string                                           //租约持有者 默认随机生成
+	Schedule  []time.Duration                                  //查询间隔 默认DefaultPollSchedule
+	Timeout   time.Duration                                    //未指定过期时间时的最长查询时间 默认DefaultPollTimeout
+	OnExpired func(ctx context.Context, outTradeNo string)     //[非必填]过期仍未到终态时调用,如关闭订单
+	wg        sync.WaitGroup
+}
+
+func NewPoller(gateway Gateway, callback func(ctx context.Context, n *Notification) error) *Poller {
+	return &Poller{Gateway: gateway, Callback: callback, Owner: newPollOwner(), Schedule: DefaultPollSchedule, Timeout: DefaultPollTimeout}
+}
+
+/*
+[Watch]-> 下单后在后台查询交易状态,不阻塞调用方
+expireAt: [非必填]订单失效时间,零值时查询Timeout时长;ctx取消后停止查询
+*/
+func (p *Poller) Watch(ctx context.Context, outTradeNo string, expireAt time.Time) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		if _, err := p.Poll(ctx, outTradeNo, expireAt); err != nil {
+			fmt.Printf("Poller.Watch-> %v order(%v) poll error(%v)", p.Gateway.Channel(), outTradeNo, err)
+		}
+	}()
+}
+
+// [Wait] 等待Watch启动的查询全部结束
+func (p *Poller) Wait() {
+	p.wg.Wait()
+}
+
+/*
+[Poll]-> 按Schedule查询交易状态,直到终态并回调成功、订单过期或ctx取消
+返回终态交易;过期返回ErrPollExpired,其他实例正在查询返回ErrLeaseHeld
+*/
+func (p *Poller) Poll(ctx context.Context, outTradeNo string, expireAt time.Time) (*Transaction, error) {
+	if outTradeNo == "" {
+		return nil, fmt.Errorf("%w: OutTradeNo can not be empty", ErrInvalidOrder)
+	}
+	if expireAt.IsZero() {
+		expireAt = time.Now().Add(p.timeout())
+	}
+	key := "payment:poll:" + string(p.Gateway.Channel()) + ":" + outTradeNo
+	if p.Lease != nil {
+		defer p.Lease.Release(context.Background(), key, p.Owner)
+	}
+	for attempt := 0; ; attempt++ {
+		delay := p.delay(attempt)
+		//订单过期后再查询一次,支付可能在过期前完成
+		last := !time.Now().Add(delay).Before(expireAt)
+		if last {
+			delay = expireAt.Sub(time.Now())
+		}
+		if err := p.acquire(ctx, key, delay); err != nil {
+			return nil, err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		transaction, err := p.query(ctx, outTradeNo)
+		if err != nil {
+			fmt.Printf("Poller.Poll-> %v order(%v) attempt(%v) error(%v)", p.Gateway.Channel(), outTradeNo, attempt, err)
+		} else if transaction.Status.IsFinal() {
+			return transaction, nil
+		}
+		if last {
+			if p.OnExpired != nil {
+				p.OnExpired(ctx, outTradeNo)
+			}
+			return nil, fmt.Errorf("%w: order(%v)", ErrPollExpired, outTradeNo)
+		}
+	}
+}
+
+// 查询交易 终态时回调,回调失败返回error以便重新查询
+func (p *Poller) query(ctx context.Context, outTradeNo string) (*Transaction, error) {
+	transaction, err := p.Gateway.Query(ctx, outTradeNo)
+	if errors.Is(err, ErrOrderNotFound) {
+		//支付宝用户未扫码前交易不存在
+		return &Transaction{Channel: p.Gateway.Channel(), OutTradeNo: outTradeNo, Status: StatusPending}, nil
+	}
+	if err != nil || !transaction.Status.IsFinal() {
+		return transaction, err
+	}
+	notification := &Notification{
+		Channel:     p.Gateway.Channel(),
+		Id:          "poll:" + string(p.Gateway.Channel()) + ":" + outTradeNo + ":" + string(transaction.Status),
+		Type:        NotifyPayment,
+		Transaction: transaction,
+	}
+	_, err = notifyguard.Process(p.Store, notification.Id, func() error {
+		if p.Callback == nil {
+			return nil
+		}
+		return p.Callback(ctx, notification)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("callback error: %w", err)
+	}
+	return transaction, nil
+}
+
+// 获取或续期租约 有效期覆盖本次等待及查询
+func (p *Poller) acquire(ctx context.Context, key string, delay time.Duration) error {
+	if p.Lease == nil {
+		return nil
+	}
+	ok, err := p.Lease.Acquire(ctx, key, p.Owner, delay+time.Minute)
+	if err != nil {
+		fmt.Printf("Poller.acquire-> Acquire(%v) error(%v)", key, err)
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: %v", ErrLeaseHeld, key)
+	}
+	return nil
+}
+
+func (p *Poller) delay(attempt int) time.Duration {
+	schedule := p.Schedule
+	if len(schedule) == 0 {
+		schedule = DefaultPollSchedule
+	}
+	if attempt >= len(schedule) {
+		return schedule[len(schedule)-1]
+	}
+	return schedule[attempt]
+}
+
+func (p *Poller) timeout() time.Duration {
+	if p.Timeout <= 0 {
+		return DefaultPollTimeout
+	}
+	return p.Timeout
+}
+
+func newPollOwner() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+var _ Lease = &MemoryLease{}
+
+// 内存租约 适用于单实例及测试
+type MemoryLease struct {
+	mu     sync.Mutex
+	leases map[string]memoryLease
+}
+
+type memoryLease struct {
+	owner    string
+	expireAt time.Time
+}
+
+func NewMemoryLease() *MemoryLease {
+	return &MemoryLease{leases: make(map[string]memoryLease)}
+}
+
+func (l *MemoryLease) Acquire(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if lease, ok := l.leases[key]; ok && lease.owner != owner && now.Before(lease.expireAt) {
+		return false, nil
+	}
+	l.leases[key] = memoryLease{owner: owner, expireAt: now.Add(ttl)}
+	return true, nil
+}
+
+func (l *MemoryLease) Release(ctx context.Context, key, owner string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if lease, ok := l.leases[key]; ok && lease.owner == owner {
+		delete(l.leases, key)
+	}
+	return nil
+}
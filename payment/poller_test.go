@@ -0,0 +1,154 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+	"github.com/tanjl855/Sms_Pay_SDK/notifyguard"
+)
+
+// 只实现查询的渠道 按调用次数返回交易状态
+type testQueryGateway struct {
+	Gateway
+	mu      sync.Mutex
+	queries int
+	query   func(n int) (*Transaction, error)
+}
+
+func (g *testQueryGateway) Channel() Channel {
+	return ChannelWechat
+}
+
+func (g *testQueryGateway) Query(ctx context.Context, outTradeNo string) (*Transaction, error) {
+	g.mu.Lock()
+	g.queries++
+	n := g.queries
+	g.mu.Unlock()
+	return g.query(n)
+}
+
+func newTestPoller(gateway Gateway, callback func(ctx context.Context, n *Notification) error) *Poller {
+	poller := NewPoller(gateway, callback)
+	poller.Schedule = []time.Duration{time.Millisecond, 2 * time.Millisecond}
+	return poller
+}
+
+func TestPoller(t *testing.T) {
+	outTradeNo := "1217752501201407033233368018"
+	gateway := &testQueryGateway{query: func(n int) (*Transaction, error) {
+		switch n {
+		case 1:
+			return nil, ErrOrderNotFound
+		case 2:
+			return nil, errors.New("timeout")
+		case 3:
+			return &Transaction{OutTradeNo: outTradeNo, Status: StatusPaying}, nil
+		}
+		return &Transaction{OutTradeNo: outTradeNo, TradeNo: "4200000001", Status: StatusPaid, Amount: money.Fen(100)}, nil
+	}}
+	machine := NewStateMachine(NewMemoryOrderRepository())
+	ctx := context.Background()
+	if _, err := machine.Create(ctx, ChannelWechat, &Order{OutTradeNo: outTradeNo, Amount: money.Fen(100)}); err != nil {
+		t.Fatal(err)
+	}
+	//第一次回调失败,下一次查询时重新回调
+	callbacks := 0
+	poller := newTestPoller(gateway, func(ctx context.Context, n *Notification) error {
+		callbacks++
+		if callbacks == 1 {
+			return errors.New("db error")
+		}
+		if n.Type != NotifyPayment || n.Channel != ChannelWechat || n.Id == "" {
+			t.Errorf("unexpected notification(%+v)", n)
+		}
+		return machine.HandleNotification(ctx, n)
+	})
+	transaction, err := poller.Poll(ctx, outTradeNo, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transaction.Status != StatusPaid || gateway.queries != 5 || callbacks != 2 {
+		t.Errorf("unexpected transaction(%+v) queries(%v) callbacks(%v)", transaction, gateway.queries, callbacks)
+	}
+	order, err := machine.Repo.Get(ctx, outTradeNo)
+	if err != nil || order.State != OrderPaid || order.TradeNo != "4200000001" {
+		t.Errorf("unexpected order(%+v, %v)", order, err)
+	}
+	if _, err = poller.Poll(ctx, "", time.Time{}); !errors.Is(err, ErrInvalidOrder) {
+		t.Errorf("expect ErrInvalidOrder, got(%v)", err)
+	}
+}
+
+func TestPollerExpired(t *testing.T) {
+	gateway := &testQueryGateway{query: func(n int) (*Transaction, error) {
+		return &Transaction{Status: StatusPending}, nil
+	}}
+	var expired []string
+	poller := newTestPoller(gateway, nil)
+	poller.OnExpired = func(ctx context.Context, outTradeNo string) {
+		expired = append(expired, outTradeNo)
+	}
+	if _, err := poller.Poll(context.Background(), "1217752501201407033233368018", time.Now().Add(20*time.Millisecond)); !errors.Is(err, ErrPollExpired) {
+		t.Fatalf("expect ErrPollExpired, got(%v)", err)
+	}
+	if len(expired) != 1 || gateway.queries < 2 {
+		t.Errorf("unexpected expired(%v) queries(%v)", expired, gateway.queries)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	poller.Schedule = []time.Duration{time.Hour}
+	poller.Watch(ctx, "1217752501201407033233368019", time.Time{})
+	cancel()
+	poller.Wait()
+}
+
+// 多实例查询同一订单 只有持有租约的实例查询,回调只执行一次
+func TestPollerLease(t *testing.T) {
+	gateway := &testQueryGateway{query: func(n int) (*Transaction, error) {
+		return &Transaction{Status: StatusClosed}, nil
+	}}
+	lease, store := NewMemoryLease(), notifyguard.NewMemoryStore()
+	var mu sync.Mutex
+	callbacks := 0
+	callback := func(ctx context.Context, n *Notification) error {
+		mu.Lock()
+		callbacks++
+		mu.Unlock()
+		return nil
+	}
+	pollers := make([]*Poller, 3)
+	for i := range pollers {
+		pollers[i] = newTestPoller(gateway, callback)
+		pollers[i].Schedule = []time.Duration{10 * time.Millisecond}
+		pollers[i].Lease, pollers[i].Store = lease, store
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, len(pollers))
+	for i, poller := range pollers {
+		wg.Add(1)
+		go func(i int, poller *Poller) {
+			defer wg.Done()
+			_, errs[i] = poller.Poll(context.Background(), "1217752501201407033233368018", time.Time{})
+		}(i, poller)
+	}
+	wg.Wait()
+	held := 0
+	for _, err := range errs {
+		if errors.Is(err, ErrLeaseHeld) {
+			held++
+		} else if err != nil {
+			t.Error(err)
+		}
+	}
+	if held != 2 || gateway.queries != 1 || callbacks != 1 {
+		t.Errorf("unexpected held(%v) queries(%v) callbacks(%v)", held, gateway.queries, callbacks)
+	}
+	//查询结束后释放租约
+	if ok, err := lease.Acquire(context.Background(), "payment:poll:wechat:1217752501201407033233368018", "other", time.Minute); !ok || err != nil {
+		t.Errorf("lease should be released, got(%v, %v)", ok, err)
+	}
+}
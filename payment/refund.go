@@ -0,0 +1,324 @@
+package payment
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tanjl855/Sms_Pay_SDK/alipay"
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+	wechatpay "github.com/tanjl855/Sms_Pay_SDK/wechat_pay"
+	"github.com/wechatpay-apiv3/wechatpay-go/core"
+)
+
+//退款编排:统一两个渠道的退款金额(money.Amount)及退款单号生成,通过RefundLedger记录每笔订单的退款
+//申请退款前在账本中原子地预留额度,处理中及成功的退款都计入已退款金额,防止并发请求超额退款
+//渠道明确拒绝(业务错误)的退款释放额度;网络超时等结果未知的退款保持处理中,通过Sync查询后确认,重试时使用同一退款单号
+
+// 微信支付每笔订单最多支持50次部分退款
+const DefaultMaxRefunds = 50
+
+var (
+	ErrOverRefund     = errors.New("payment: refund amount exceeds refundable amount")
+	ErrRefundLimit    = errors.New("payment: too many refunds for order")
+	ErrRefundExpired  = errors.New("payment: order exceeds refund window")
+	ErrNotRefundable  = errors.New("payment: order is not refundable")
+	ErrRefundNotFound = errors.New("payment: refund not found")
+)
+
+// 退款记录
+type RefundRecord struct {
+	OutTradeNo  string       //商户订单号
+	OutRefundNo string       //商户退款单号 支付宝out_request_no/微信out_refund_no
+	RefundNo    string       //渠道退款单号
+	Amount      money.Amount //退款金额
+	Reason      string       //退款原因
+	Status      RefundStatus //退款状态
+	Err         string       //最近一次申请或查询的错误
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// 计入已退款金额 处理中、成功及结果未知的退款
+func (r *RefundRecord) counted() bool {
+	return r.Status != RefundClosed
+}
+
+//RefundLedger 退款账本,Reserve需在同一事务中校验额度并写入(如对订单行加锁后汇总退款)
+
+type RefundLedger interface {
+	// 原子地校验并登记退款:未关闭的退款金额加本次金额不超过total,未关闭的退款次数小于maxCount
+	Reserve(ctx context.Context, record *RefundRecord, total money.Amount, maxCount int) error
+	Update(ctx context.Context, record *RefundRecord) error               //按OutRefundNo更新状态
+	Get(ctx context.Context, outRefundNo string) (*RefundRecord, error)   //不存在时返回ErrRefundNotFound
+	List(ctx context.Context, outTradeNo string) ([]*RefundRecord, error) //订单的全部退款 按创建顺序
+}
+
+// 申请退款
+type RefundApply struct {
+	OutTradeNo string       //商户订单号
+	Amount     money.Amount //退款金额
+	Reason     string       //[非必填]退款原因
+	NotifyURL  string       //[非必填]退款结果通知地址(微信支付)
+}
+
+// 退款服务
+type RefundService struct {
+	Gateway     Gateway
+	Orders      *StateMachine //订单状态机 提供订单金额、支付时间,退款成功后更新订单
+	Ledger      RefundLedger
+	MaxRefunds  int                                               //每笔订单最多退款次数 默认DefaultMaxRefunds
+	Deadline    func(channel Channel, paidAt time.Time) time.Time //[非必填]可退款截止时间 默认微信支付一年、支付宝3个月
+	NewRefundNo func(outTradeNo string) string                    //[非必填]生成商户退款单号 默认R+时间+随机数
+	now         func() time.Time
+}
+
+func NewRefundService(gateway Gateway, orders *StateMachine, ledger RefundLedger) *RefundService {
+	return &RefundService{Gateway: gateway, Orders: orders, Ledger: ledger, MaxRefunds: DefaultMaxRefunds, now: time.Now}
+}
+
+/*
+[Refund]-> 申请退款
+校验订单状态及退款期限后生成退款单号并预留额度,再向渠道申请退款
+返回error时若RefundRecord不为nil,说明退款结果未知,需通过Sync确认
+*/
+func (s *RefundService) Refund(ctx context.Context, apply *RefundApply) (*RefundRecord, error) {
+	if apply == nil || apply.OutTradeNo == "" || !apply.Amount.IsPositive() {
+		return nil, fmt.Errorf("%w: OutTradeNo and Amount can not be empty", ErrInvalidOrder)
+	}
+	order, err := s.Orders.Repo.Get(ctx, apply.OutTradeNo)
+	if err != nil {
+		return nil, err
+	}
+	if order.State != OrderPaid && order.State != OrderPartiallyRefunded {
+		return nil, fmt.Errorf("%w: order(%v) state(%v)", ErrNotRefundable, order.OutTradeNo, order.State)
+	}
+	now := s.now()
+	if !order.PaidAt.IsZero() && now.After(s.deadline(order.Channel, order.PaidAt)) {
+		return nil, fmt.Errorf("%w: order(%v) paid at %v", ErrRefundExpired, order.OutTradeNo, order.PaidAt)
+	}
+	record := &RefundRecord{
+		OutTradeNo:  apply.OutTradeNo,
+		OutRefundNo: s.newRefundNo(apply.OutTradeNo),
+		Amount:      apply.Amount,
+		Reason:      apply.Reason,
+		Status:      RefundProcessing,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	limit, err := s.limit(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.Ledger.Reserve(ctx, record, limit, s.maxRefunds()); err != nil {
+		return nil, err
+	}
+	return s.submit(ctx, record, order.Amount, apply.NotifyURL)
+}
+
+// 向渠道申请退款 渠道明确拒绝时释放额度
+func (s *RefundService) submit(ctx context.Context, record *RefundRecord, total money.Amount, notifyURL string) (*RefundRecord, error) {
+	refund, err := s.Gateway.Refund(ctx, &RefundRequest{
+		OutTradeNo:  record.OutTradeNo,
+		OutRefundNo: record.OutRefundNo,
+		Amount:      record.Amount,
+		Total:       total,
+		Reason:      record.Reason,
+		NotifyURL:   notifyURL,
+	})
+	if err != nil {
+		fmt.Printf("RefundService.submit-> refund(%v) error(%v)", record.OutRefundNo, err)
+		record.Err = err.Error()
+		record.UpdatedAt = s.now()
+		if refundRejected(err) {
+			record.Status = RefundClosed
+		}
+		if updateErr := s.Ledger.Update(ctx, record); updateErr != nil {
+			fmt.Printf("RefundService.submit-> update refund(%v) error(%v)", record.OutRefundNo, updateErr)
+		}
+		if record.Status == RefundClosed {
+			return nil, err
+		}
+		return record, err
+	}
+	return record, s.apply(ctx, record, refund)
+}
+
+/*
+[Sync]-> 查询渠道退款结果并更新账本及订单 用于结果未知或处理中的退款
+微信支付查询不到该退款(ErrOrderNotFound)、支付宝查询不到退款数据时说明申请未送达或未退款成功,使用同一退款单号重新申请
+重新申请被渠道明确拒绝时才释放额度,查询失败(如订单号有误返回的404)不会释放额度
+*/
+func (s *RefundService) Sync(ctx context.Context, outRefundNo string) (*RefundRecord, error) {
+	record, err := s.Ledger.Get(ctx, outRefundNo)
+	if err != nil {
+		return nil, err
+	}
+	if record.Status.IsFinal() {
+		return record, nil
+	}
+	refund, err := s.Gateway.QueryRefund(ctx, record.OutTradeNo, record.OutRefundNo)
+	notFound := errors.Is(err, ErrOrderNotFound)
+	if err != nil && !notFound {
+		return nil, err
+	}
+	if notFound || (s.Gateway.Channel() == ChannelAlipay && refund.Status == RefundProcessing && refund.Amount.IsZero()) {
+		order, err := s.Orders.Repo.Get(ctx, record.OutTradeNo)
+		if err != nil {
+			return nil, err
+		}
+		return s.submit(ctx, record, order.Amount, "")
+	}
+	return record, s.apply(ctx, record, refund)
+}
+
+/*
+[HandleNotification]-> 按退款通知更新账本及订单 可作为NotifyHandler的callback
+支付通知交给订单状态机处理
+*/
+func (s *RefundService) HandleNotification(ctx context.Context, n *Notification) error {
+	if n.Type != NotifyRefund {
+		return s.Orders.HandleNotification(ctx, n)
+	}
+	record, err := s.Ledger.Get(ctx, n.Refund.OutRefundNo)
+	if errors.Is(err, ErrRefundNotFound) {
+		//非本服务发起的退款(如商户平台手工退款)只更新订单
+		_, err = s.Orders.ApplyRefund(ctx, n.Refund)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	return s.apply(ctx, record, n.Refund)
+}
+
+// 订单剩余可退金额
+func (s *RefundService) Refundable(ctx context.Context, outTradeNo string) (money.Amount, error) {
+	order, err := s.Orders.Repo.Get(ctx, outTradeNo)
+	if err != nil {
+		return money.Amount{}, err
+	}
+	refundable, err := s.limit(ctx, order)
+	if err != nil {
+		return money.Amount{}, err
+	}
+	records, err := s.Ledger.List(ctx, outTradeNo)
+	if err != nil {
+		return money.Amount{}, err
+	}
+	for _, record := range records {
+		if !record.counted() {
+			continue
+		}
+		if refundable, err = refundable.Sub(record.Amount); err != nil {
+			return money.Amount{}, err
+		}
+	}
+	return refundable, nil
+}
+
+// 账本可预留的额度: 订单金额减去未经账本的退款(如商户平台手工退款)
+// 订单累计退款中超出账本成功退款的部分即为账本外退款
+func (s *RefundService) limit(ctx context.Context, order *OrderRecord) (money.Amount, error) {
+	records, err := s.Ledger.List(ctx, order.OutTradeNo)
+	if err != nil {
+		return money.Amount{}, err
+	}
+	external := order.RefundedAmount
+	for _, record := range records {
+		if record.Status != RefundSuccess {
+			continue
+		}
+		if external, err = external.Sub(record.Amount); err != nil {
+			return money.Amount{}, err
+		}
+	}
+	if !external.IsPositive() {
+		return order.Amount, nil
+	}
+	return order.Amount.Sub(external)
+}
+
+// 按渠道结果更新退款记录 退款成功时更新订单
+func (s *RefundService) apply(ctx context.Context, record *RefundRecord, refund *Refund) error {
+	//已是终态的记录不被过期的查询结果覆盖
+	if !record.Status.IsFinal() && refund.Status != RefundUnknown && (refund.Status != record.Status || refund.RefundNo != record.RefundNo) {
+		record.Status = refund.Status
+		if refund.RefundNo != "" {
+			record.RefundNo = refund.RefundNo
+		}
+		record.Err = ""
+		record.UpdatedAt = s.now()
+		if err := s.Ledger.Update(ctx, record); err != nil {
+			return err
+		}
+	}
+	//订单更新是幂等的,重复的成功结果再次应用以补偿上次失败
+	if record.Status != RefundSuccess {
+		return nil
+	}
+	_, err := s.Orders.ApplyRefund(ctx, &Refund{
+		Channel:       refund.Channel,
+		OutTradeNo:    record.OutTradeNo,
+		OutRefundNo:   record.OutRefundNo,
+		RefundNo:      record.RefundNo,
+		Status:        RefundSuccess,
+		ChannelStatus: refund.ChannelStatus,
+		Amount:        record.Amount,
+		TotalRefunded: refund.TotalRefunded,
+		SucceededAt:   refund.SucceededAt,
+	})
+	return err
+}
+
+func (s *RefundService) maxRefunds() int {
+	if s.MaxRefunds <= 0 {
+		return DefaultMaxRefunds
+	}
+	return s.MaxRefunds
+}
+
+func (s *RefundService) deadline(channel Channel, paidAt time.Time) time.Time {
+	if s.Deadline != nil {
+		return s.Deadline(channel, paidAt)
+	}
+	if channel == ChannelAlipay {
+		return alipay.RefundDeadline(paidAt)
+	}
+	return wechatpay.RefundDeadline(paidAt)
+}
+
+func (s *RefundService) newRefundNo(outTradeNo string) string {
+	if s.NewRefundNo != nil {
+		return s.NewRefundNo(outTradeNo)
+	}
+	return newRefundNo(s.now())
+}
+
+// R+北京时间(14位)+随机数(16位) 共31位,满足两个渠道64位以内的限制
+func newRefundNo(now time.Time) string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "R" + now.In(beijing).Format("20060102150405") + fmt.Sprintf("%016d", now.UnixNano()%1e16)
+	}
+	return "R" + now.In(beijing).Format("20060102150405") + hex.EncodeToString(b)
+}
+
+// 渠道明确拒绝的退款(参数错误、余额不足、交易状态不允许等),未产生退款
+func refundRejected(err error) bool {
+	var aliErr *alipay.Error
+	if errors.As(err, &aliErr) {
+		//20000服务不可用、ACQ.SYSTEM_ERROR系统错误 结果未知,需使用同一退款单号重试
+		return aliErr.Code != "20000" && aliErr.SubCode != "ACQ.SYSTEM_ERROR"
+	}
+	var apiErr *core.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= http.StatusBadRequest && apiErr.StatusCode < http.StatusInternalServerError && apiErr.StatusCode != http.StatusTooManyRequests
+	}
+	//微信支付申请退款返回404(订单不存在)时已转换为ErrOrderNotFound
+	return errors.Is(err, ErrInvalidOrder) || errors.Is(err, ErrOrderNotFound)
+}
@@ -0,0 +1,301 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tanjl855/Sms_Pay_SDK/alipay"
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+	"github.com/wechatpay-apiv3/wechatpay-go/core"
+)
+
+// 只实现退款的渠道
+type testRefundGateway struct {
+	Gateway
+	channel Channel
+	mu      sync.Mutex
+	refunds []*RefundRequest
+	refund  func(req *RefundRequest) (*Refund, error)
+	query   func(outRefundNo string) (*Refund, error)
+}
+
+func (g *testRefundGateway) Channel() Channel {
+	return g.channel
+}
+
+func (g *testRefundGateway) Refund(ctx context.Context, req *RefundRequest) (*Refund, error) {
+	g.mu.Lock()
+	g.refunds = append(g.refunds, req)
+	g.mu.Unlock()
+	return g.refund(req)
+}
+
+func (g *testRefundGateway) QueryRefund(ctx context.Context, outTradeNo, outRefundNo string) (*Refund, error) {
+	return g.query(outRefundNo)
+}
+
+func newTestRefundService(t *testing.T, gateway *testRefundGateway, paidAt time.Time) *RefundService {
+	machine := NewStateMachine(NewMemoryOrderRepository())
+	ctx := context.Background()
+	if _, err := machine.Create(ctx, gateway.channel, &Order{OutTradeNo: "1217752501201407033233368018", Amount: money.Fen(10000)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := machine.ApplyTransaction(ctx, &Transaction{OutTradeNo: "1217752501201407033233368018", Status: StatusPaid, Amount: money.Fen(10000), PaidAt: paidAt}); err != nil {
+		t.Fatal(err)
+	}
+	return NewRefundService(gateway, machine, NewMemoryRefundLedger())
+}
+
+func TestRefundService(t *testing.T) {
+	gateway := &testRefundGateway{channel: ChannelWechat, refund: func(req *RefundRequest) (*Refund, error) {
+		return &Refund{OutTradeNo: req.OutTradeNo, OutRefundNo: req.OutRefundNo, RefundNo: "5000000038" + req.OutRefundNo, Status: RefundSuccess, Amount: req.Amount}, nil
+	}}
+	service := newTestRefundService(t, gateway, time.Now().Add(-time.Hour))
+	ctx := context.Background()
+	outTradeNo := "1217752501201407033233368018"
+
+	record, err := service.Refund(ctx, &RefundApply{OutTradeNo: outTradeNo, Amount: money.Fen(3000), Reason: "商品已售完"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Status != RefundSuccess || !strings.HasPrefix(record.OutRefundNo, "R") || len(record.OutRefundNo) != 31 || record.RefundNo == "" {
+		t.Errorf("unexpected refund(%+v)", record)
+	}
+	if gateway.refunds[0].Total.Minor() != 10000 || gateway.refunds[0].OutRefundNo != record.OutRefundNo {
+		t.Errorf("unexpected refund request(%+v)", gateway.refunds[0])
+	}
+	if _, err = service.Refund(ctx, &RefundApply{OutTradeNo: outTradeNo, Amount: money.Fen(7001)}); !errors.Is(err, ErrOverRefund) {
+		t.Errorf("expect ErrOverRefund, got(%v)", err)
+	}
+	refundable, err := service.Refundable(ctx, outTradeNo)
+	if err != nil || refundable.Minor() != 7000 {
+		t.Errorf("unexpected refundable(%v, %v)", refundable, err)
+	}
+	second, err := service.Refund(ctx, &RefundApply{OutTradeNo: outTradeNo, Amount: money.Fen(7000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.OutRefundNo == record.OutRefundNo {
+		t.Error("out_refund_no should be unique")
+	}
+	order, err := service.Orders.Repo.Get(ctx, outTradeNo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.State != OrderRefunded || order.RefundedAmount.Minor() != 10000 {
+		t.Errorf("unexpected order(%+v)", order)
+	}
+	if _, err = service.Refund(ctx, &RefundApply{OutTradeNo: outTradeNo, Amount: money.Fen(1)}); !errors.Is(err, ErrNotRefundable) {
+		t.Errorf("expect ErrNotRefundable, got(%v)", err)
+	}
+	//重复的退款通知
+	if err = service.HandleNotification(ctx, &Notification{Type: NotifyRefund, Refund: &Refund{OutTradeNo: outTradeNo, OutRefundNo: record.OutRefundNo, Status: RefundSuccess, Amount: money.Fen(3000)}}); err != nil {
+		t.Error(err)
+	}
+}
+
+// 商户平台手工退款不经过账本 申请退款时仍需扣除
+func TestRefundServiceExternalRefund(t *testing.T) {
+	gateway := &testRefundGateway{channel: ChannelWechat, refund: func(req *RefundRequest) (*Refund, error) {
+		return &Refund{OutTradeNo: req.OutTradeNo, OutRefundNo: req.OutRefundNo, Status: RefundSuccess, Amount: req.Amount}, nil
+	}}
+	service := newTestRefundService(t, gateway, time.Now().Add(-time.Hour))
+	ctx := context.Background()
+	outTradeNo := "1217752501201407033233368018"
+
+	if err := service.HandleNotification(ctx, &Notification{Type: NotifyRefund, Refund: &Refund{OutTradeNo: outTradeNo, OutRefundNo: "manual-1", Status: RefundSuccess, Amount: money.Fen(4000)}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Refund(ctx, &RefundApply{OutTradeNo: outTradeNo, Amount: money.Fen(6001)}); !errors.Is(err, ErrOverRefund) {
+		t.Errorf("expect ErrOverRefund, got(%v)", err)
+	}
+	if len(gateway.refunds) != 0 {
+		t.Errorf("over refund should not reach channel(%+v)", gateway.refunds)
+	}
+	if _, err := service.Refund(ctx, &RefundApply{OutTradeNo: outTradeNo, Amount: money.Fen(3000)}); err != nil {
+		t.Fatal(err)
+	}
+	//账本内的成功退款已计入订单累计退款 不重复扣除
+	refundable, err := service.Refundable(ctx, outTradeNo)
+	if err != nil || refundable.Minor() != 3000 {
+		t.Errorf("unexpected refundable(%v, %v)", refundable, err)
+	}
+	if _, err = service.Refund(ctx, &RefundApply{OutTradeNo: outTradeNo, Amount: money.Fen(3001)}); !errors.Is(err, ErrOverRefund) {
+		t.Errorf("expect ErrOverRefund, got(%v)", err)
+	}
+}
+
+func TestRefundServiceConcurrent(t *testing.T) {
+	gateway := &testRefundGateway{channel: ChannelWechat, refund: func(req *RefundRequest) (*Refund, error) {
+		return &Refund{OutRefundNo: req.OutRefundNo, Status: RefundProcessing, Amount: req.Amount}, nil
+	}}
+	service := newTestRefundService(t, gateway, time.Now())
+	service.MaxRefunds = 3
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	errs := map[error]int{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := service.Refund(context.Background(), &RefundApply{OutTradeNo: "1217752501201407033233368018", Amount: money.Fen(2000)})
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				errs[nil]++
+			case errors.Is(err, ErrRefundLimit):
+				errs[ErrRefundLimit]++
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if errs[nil] != 3 || errs[ErrRefundLimit] != 7 {
+		t.Errorf("unexpected results(%v)", errs)
+	}
+}
+
+func TestRefundServiceWindow(t *testing.T) {
+	wechat := &testRefundGateway{channel: ChannelWechat}
+	service := newTestRefundService(t, wechat, time.Now().AddDate(-1, 0, -1))
+	if _, err := service.Refund(context.Background(), &RefundApply{OutTradeNo: "1217752501201407033233368018", Amount: money.Fen(100)}); !errors.Is(err, ErrRefundExpired) {
+		t.Errorf("wechat order paid more than a year ago should expire, got(%v)", err)
+	}
+	if len(wechat.refunds) != 0 {
+		t.Error("expired refund should not be sent")
+	}
+
+	ali := &testRefundGateway{channel: ChannelAlipay, refund: func(req *RefundRequest) (*Refund, error) {
+		return &Refund{Status: RefundSuccess}, nil
+	}}
+	service = newTestRefundService(t, ali, time.Now().AddDate(0, -4, 0))
+	if _, err := service.Refund(context.Background(), &RefundApply{OutTradeNo: "1217752501201407033233368018", Amount: money.Fen(100)}); !errors.Is(err, ErrRefundExpired) {
+		t.Errorf("alipay order paid 4 months ago should expire, got(%v)", err)
+	}
+	service = newTestRefundService(t, ali, time.Now().AddDate(0, -2, 0))
+	if _, err := service.Refund(context.Background(), &RefundApply{OutTradeNo: "1217752501201407033233368018", Amount: money.Fen(100)}); err != nil {
+		t.Errorf("alipay order paid 2 months ago should refund, got(%v)", err)
+	}
+}
+
+func TestRefundServiceFailure(t *testing.T) {
+	var refundErr error
+	gateway := &testRefundGateway{channel: ChannelAlipay, refund: func(req *RefundRequest) (*Refund, error) {
+		if refundErr != nil {
+			return nil, refundErr
+		}
+		return &Refund{Status: RefundSuccess, Amount: req.Amount}, nil
+	}}
+	gateway.query = func(outRefundNo string) (*Refund, error) {
+		return &Refund{OutRefundNo: outRefundNo, Status: RefundProcessing}, nil
+	}
+	service := newTestRefundService(t, gateway, time.Now())
+	ctx := context.Background()
+	outTradeNo := "1217752501201407033233368018"
+
+	//业务错误 释放额度
+	refundErr = &alipay.Error{Code: "40004", Msg: "Business Failed", SubCode: "ACQ.SELLER_BALANCE_NOT_ENOUGH"}
+	if record, err := service.Refund(ctx, &RefundApply{OutTradeNo: outTradeNo, Amount: money.Fen(10000)}); err == nil || record != nil {
+		t.Errorf("rejected refund should return error only, got(%+v, %v)", record, err)
+	}
+	if refundable, _ := service.Refundable(ctx, outTradeNo); refundable.Minor() != 10000 {
+		t.Errorf("rejected refund should release amount, refundable(%v)", refundable)
+	}
+
+	//结果未知 保留额度
+	refundErr = &http.ProtocolError{ErrorString: "connection reset"}
+	record, err := service.Refund(ctx, &RefundApply{OutTradeNo: outTradeNo, Amount: money.Fen(10000)})
+	if err == nil || record == nil || record.Status != RefundProcessing {
+		t.Fatalf("unknown refund should keep processing, got(%+v, %v)", record, err)
+	}
+	if _, err = service.Refund(ctx, &RefundApply{OutTradeNo: outTradeNo, Amount: money.Fen(1)}); !errors.Is(err, ErrOverRefund) {
+		t.Errorf("unknown refund should keep amount reserved, got(%v)", err)
+	}
+
+	//查询不到退款数据 使用同一退款单号重新申请
+	refundErr = nil
+	synced, err := service.Sync(ctx, record.OutRefundNo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if synced.Status != RefundSuccess || synced.OutRefundNo != record.OutRefundNo {
+		t.Errorf("unexpected synced refund(%+v)", synced)
+	}
+	last := gateway.refunds[len(gateway.refunds)-1]
+	if last.OutRefundNo != record.OutRefundNo {
+		t.Errorf("retry should reuse out_request_no, got(%v)", last.OutRefundNo)
+	}
+	order, _ := service.Orders.Repo.Get(ctx, outTradeNo)
+	if order.State != OrderRefunded {
+		t.Errorf("unexpected order state(%v)", order.State)
+	}
+}
+
+// 微信支付查询不到退款时使用同一退款单号重新申请 被明确拒绝时才释放额度
+func TestRefundServiceSyncNotFound(t *testing.T) {
+	var refundErr error
+	gateway := &testRefundGateway{channel: ChannelWechat, refund: func(req *RefundRequest) (*Refund, error) {
+		if refundErr != nil {
+			return nil, refundErr
+		}
+		return &Refund{OutRefundNo: req.OutRefundNo, Status: RefundProcessing, Amount: req.Amount}, nil
+	}}
+	gateway.query = func(outRefundNo string) (*Refund, error) {
+		return nil, fmt.Errorf("%w: RESOURCE_NOT_EXISTS", ErrOrderNotFound)
+	}
+	service := newTestRefundService(t, gateway, time.Now())
+	ctx := context.Background()
+	outTradeNo := "1217752501201407033233368018"
+
+	refundErr = &http.ProtocolError{ErrorString: "connection reset"}
+	record, err := service.Refund(ctx, &RefundApply{OutTradeNo: outTradeNo, Amount: money.Fen(4000)})
+	if err == nil || record == nil {
+		t.Fatalf("unknown refund should keep processing, got(%+v, %v)", record, err)
+	}
+	//重新申请仍结果未知 保留额度
+	if _, err = service.Sync(ctx, record.OutRefundNo); err == nil {
+		t.Error("expect resubmit error")
+	}
+	if refundable, _ := service.Refundable(ctx, outTradeNo); refundable.Minor() != 6000 {
+		t.Errorf("not found refund should keep amount reserved, refundable(%v)", refundable)
+	}
+	refundErr = nil
+	synced, err := service.Sync(ctx, record.OutRefundNo)
+	if err != nil || synced.Status != RefundProcessing {
+		t.Fatalf("unexpected synced refund(%+v, %v)", synced, err)
+	}
+	for _, req := range gateway.refunds {
+		if req.OutRefundNo != record.OutRefundNo {
+			t.Errorf("retry should reuse out_refund_no, got(%v)", req.OutRefundNo)
+		}
+	}
+	if len(gateway.refunds) != 3 {
+		t.Errorf("unexpected refund requests(%v)", len(gateway.refunds))
+	}
+
+	//重新申请被拒绝 释放额度
+	refundErr = nil
+	second, err := service.Refund(ctx, &RefundApply{OutTradeNo: outTradeNo, Amount: money.Fen(1000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refundErr = &core.APIError{StatusCode: http.StatusBadRequest, Code: "INVALID_REQUEST", Message: "订单已全额退款"}
+	if _, err = service.Sync(ctx, second.OutRefundNo); err == nil {
+		t.Error("expect rejected error")
+	}
+	closed, err := service.Ledger.Get(ctx, second.OutRefundNo)
+	if err != nil || closed.Status != RefundClosed {
+		t.Fatalf("rejected refund should be closed, got(%+v, %v)", closed, err)
+	}
+	if refundable, _ := service.Refundable(ctx, outTradeNo); refundable.Minor() != 6000 {
+		t.Errorf("rejected refund should release amount, refundable(%v)", refundable)
+	}
+}
@@ -0,0 +1,68 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrOrderExists     = errors.New("payment: order already exists")
+	ErrVersionConflict = errors.New("payment: order version conflict")
+)
+
+//OrderRepository 订单持久化,Update需按版本号做乐观锁(如 UPDATE ... WHERE version = ?)
+//内存实现适用于单实例及测试,多实例部署时请基于数据库实现该接口
+
+type OrderRepository interface {
+	Create(ctx context.Context, order *OrderRecord) error                      //订单已存在时返回ErrOrderExists
+	Get(ctx context.Context, outTradeNo string) (*OrderRecord, error)          //订单不存在时返回ErrOrderNotFound
+	Update(ctx context.Context, order *OrderRecord, expectVersion int64) error //版本号不一致时返回ErrVersionConflict,成功后order.Version加1
+}
+
+var _ OrderRepository = &MemoryOrderRepository{}
+
+type MemoryOrderRepository struct {
+	mu     sync.RWMutex
+	orders map[string]*OrderRecord
+}
+
+func NewMemoryOrderRepository() *MemoryOrderRepository {
+	return &MemoryOrderRepository{orders: make(map[string]*OrderRecord)}
+}
+
+func (r *MemoryOrderRepository) Create(ctx context.Context, order *OrderRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.orders[order.OutTradeNo]; ok {
+		return fmt.Errorf("%w: %v", ErrOrderExists, order.OutTradeNo)
+	}
+	r.orders[order.OutTradeNo] = order.clone()
+	return nil
+}
+
+func (r *MemoryOrderRepository) Get(ctx context.Context, outTradeNo string) (*OrderRecord, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	order, ok := r.orders[outTradeNo]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrOrderNotFound, outTradeNo)
+	}
+	return order.clone(), nil
+}
+
+func (r *MemoryOrderRepository) Update(ctx context.Context, order *OrderRecord, expectVersion int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current, ok := r.orders[order.OutTradeNo]
+	if !ok {
+		return fmt.Errorf("%w: %v", ErrOrderNotFound, order.OutTradeNo)
+	}
+	if current.Version != expectVersion {
+		return fmt.Errorf("%w: order(%v) version(%v) expect(%v)", ErrVersionConflict, order.OutTradeNo, current.Version, expectVersion)
+	}
+	order.Version = expectVersion + 1
+	r.orders[order.OutTradeNo] = order.clone()
+	return nil
+}
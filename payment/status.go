@@ -0,0 +1,124 @@
+package payment
+
+import (
+	"github.com/tanjl855/Sms_Pay_SDK/alipay"
+	wechatpay "github.com/tanjl855/Sms_Pay_SDK/wechat_pay"
+)
+
+//渠道状态到统一状态的映射表,业务代码只使用Status/RefundStatus判断,不直接比较渠道原始字符串
+//渠道新增的未知状态映射为StatusUnknown/RefundUnknown,按非终态处理(继续查询)
+
+// 统一交易状态
+type Status string
+
+const (
+	StatusPending  Status = "PENDING"  //待支付 支付宝WAIT_BUYER_PAY/微信NOTPAY
+	StatusPaying   Status = "PAYING"   //用户支付中 微信USERPAYING
+	StatusPaid     Status = "PAID"     //支付成功 支付宝TRADE_SUCCESS/微信SUCCESS
+	StatusFinished Status = "FINISHED" //交易结束不可退款 支付宝TRADE_FINISHED
+	StatusRefunded Status = "REFUNDED" //转入退款 微信REFUND
+	StatusClosed   Status = "CLOSED"   //已关闭 支付宝TRADE_CLOSED/微信CLOSED、REVOKED
+	StatusFailed   Status = "FAILED"   //支付失败 微信PAYERROR
+	StatusUnknown  Status = "UNKNOWN"  //无法识别的渠道状态
+)
+
+// 统一退款状态
+type RefundStatus string
+
+const (
+	RefundProcessing RefundStatus = "PROCESSING" //退款处理中
+	RefundSuccess    RefundStatus = "SUCCESS"    //退款成功
+	RefundClosed     RefundStatus = "CLOSED"     //退款关闭
+	RefundAbnormal   RefundStatus = "ABNORMAL"   //退款异常 需人工处理后重新发起
+	RefundUnknown    RefundStatus = "UNKNOWN"    //无法识别的渠道状态
+)
+
+var alipayStatuses = map[string]Status{
+	alipay.TradeStatusWaitBuyerPay: StatusPending,
+	alipay.TradeStatusSuccess:      StatusPaid,
+	alipay.TradeStatusFinished:     StatusFinished,
+	alipay.TradeStatusClosed:       StatusClosed, //未付款超时关闭或支付完成后全额退款
+}
+
+var wechatStatuses = map[string]Status{
+	wechatpay.TradeStateNotPay:     StatusPending,
+	wechatpay.TradeStateUserPaying: StatusPaying,
+	wechatpay.TradeStateSuccess:    StatusPaid,
+	wechatpay.TradeStateRefund:     StatusRefunded,
+	wechatpay.TradeStateClosed:     StatusClosed,
+	wechatpay.TradeStateRevoked:    StatusClosed,
+	wechatpay.TradeStatePayError:   StatusFailed,
+}
+
+// 支付宝退款查询仅在退款成功时返回REFUND_SUCCESS,未返回时为处理中
+var alipayRefundStatuses = map[string]RefundStatus{
+	"":                         RefundProcessing,
+	alipay.RefundStatusSuccess: RefundSuccess,
+}
+
+var wechatRefundStatuses = map[string]RefundStatus{
+	wechatpay.RefundStatusProcessing: RefundProcessing,
+	wechatpay.RefundStatusSuccess:    RefundSuccess,
+	wechatpay.RefundStatusClosed:     RefundClosed,
+	wechatpay.RefundStatusAbnormal:   RefundAbnormal,
+}
+
+// 支付宝trade_status -> Status
+func AlipayStatus(tradeStatus string) Status {
+	if status, ok := alipayStatuses[tradeStatus]; ok {
+		return status
+	}
+	return StatusUnknown
+}
+
+// 微信支付trade_state -> Status
+func WechatStatus(tradeState string) Status {
+	if status, ok := wechatStatuses[tradeState]; ok {
+		return status
+	}
+	return StatusUnknown
+}
+
+// 支付宝refund_status -> RefundStatus
+func AlipayRefundStatus(refundStatus string) RefundStatus {
+	if status, ok := alipayRefundStatuses[refundStatus]; ok {
+		return status
+	}
+	return RefundUnknown
+}
+
+// 微信支付退款status/refund_status -> RefundStatus
+func WechatRefundStatus(refundStatus string) RefundStatus {
+	if status, ok := wechatRefundStatuses[refundStatus]; ok {
+		return status
+	}
+	return RefundUnknown
+}
+
+// 终态 交易状态不会再因用户支付而变化,可停止查询
+func (s Status) IsFinal() bool {
+	switch s {
+	case StatusPaid, StatusFinished, StatusRefunded, StatusClosed, StatusFailed:
+		return true
+	}
+	return false
+}
+
+// 用户已付款(含已结束及转入退款的交易)
+func (s Status) IsPaid() bool {
+	switch s {
+	case StatusPaid, StatusFinished, StatusRefunded:
+		return true
+	}
+	return false
+}
+
+// 可发起退款 微信转入退款的交易可继续部分退款,支付宝TRADE_FINISHED不可退款
+func (s Status) IsRefundable() bool {
+	return s == StatusPaid || s == StatusRefunded
+}
+
+// 终态 退款成功或关闭,异常退款需人工处理
+func (s RefundStatus) IsFinal() bool {
+	return s == RefundSuccess || s == RefundClosed
+}
@@ -0,0 +1,81 @@
+package payment
+
+import "testing"
+
+func TestStatusMapping(t *testing.T) {
+	cases := []struct {
+		name    string
+		mapping func(string) Status
+		raw     string
+		expect  Status
+	}{
+		{"alipay", AlipayStatus, "WAIT_BUYER_PAY", StatusPending},
+		{"alipay", AlipayStatus, "TRADE_SUCCESS", StatusPaid},
+		{"alipay", AlipayStatus, "TRADE_FINISHED", StatusFinished},
+		{"alipay", AlipayStatus, "TRADE_CLOSED", StatusClosed},
+		{"alipay", AlipayStatus, "SUCCESS", StatusUnknown},
+		{"alipay", AlipayStatus, "", StatusUnknown},
+		{"wechat", WechatStatus, "NOTPAY", StatusPending},
+		{"wechat", WechatStatus, "USERPAYING", StatusPaying},
+		{"wechat", WechatStatus, "SUCCESS", StatusPaid},
+		{"wechat", WechatStatus, "REFUND", StatusRefunded},
+		{"wechat", WechatStatus, "CLOSED", StatusClosed},
+		{"wechat", WechatStatus, "REVOKED", StatusClosed},
+		{"wechat", WechatStatus, "PAYERROR", StatusFailed},
+		{"wechat", WechatStatus, "TRADE_SUCCESS", StatusUnknown},
+	}
+	for _, c := range cases {
+		if status := c.mapping(c.raw); status != c.expect {
+			t.Errorf("%v(%v) = %v, expect %v", c.name, c.raw, status, c.expect)
+		}
+	}
+}
+
+func TestRefundStatusMapping(t *testing.T) {
+	cases := []struct {
+		name    string
+		mapping func(string) RefundStatus
+		raw     string
+		expect  RefundStatus
+	}{
+		{"alipay", AlipayRefundStatus, "REFUND_SUCCESS", RefundSuccess},
+		{"alipay", AlipayRefundStatus, "", RefundProcessing},
+		{"alipay", AlipayRefundStatus, "REFUND_FAIL", RefundUnknown},
+		{"wechat", WechatRefundStatus, "PROCESSING", RefundProcessing},
+		{"wechat", WechatRefundStatus, "SUCCESS", RefundSuccess},
+		{"wechat", WechatRefundStatus, "CLOSED", RefundClosed},
+		{"wechat", WechatRefundStatus, "ABNORMAL", RefundAbnormal},
+		{"wechat", WechatRefundStatus, "", RefundUnknown},
+	}
+	for _, c := range cases {
+		if status := c.mapping(c.raw); status != c.expect {
+			t.Errorf("%v(%v) = %v, expect %v", c.name, c.raw, status, c.expect)
+		}
+	}
+}
+
+func TestStatusPredicate(t *testing.T) {
+	cases := []struct {
+		status                  Status
+		final, paid, refundable bool
+	}{
+		{StatusPending, false, false, false},
+		{StatusPaying, false, false, false},
+		{StatusPaid, true, true, true},
+		{StatusFinished, true, true, false},
+		{StatusRefunded, true, true, true},
+		{StatusClosed, true, false, false},
+		{StatusFailed, true, false, false},
+		{StatusUnknown, false, false, false},
+	}
+	for _, c := range cases {
+		if c.status.IsFinal() != c.final || c.status.IsPaid() != c.paid || c.status.IsRefundable() != c.refundable {
+			t.Errorf("unexpected %v IsFinal(%v) IsPaid(%v) IsRefundable(%v)", c.status, c.status.IsFinal(), c.status.IsPaid(), c.status.IsRefundable())
+		}
+	}
+	for status, final := range map[RefundStatus]bool{RefundProcessing: false, RefundSuccess: true, RefundClosed: true, RefundAbnormal: false, RefundUnknown: false} {
+		if status.IsFinal() != final {
+			t.Errorf("unexpected %v IsFinal(%v)", status, status.IsFinal())
+		}
+	}
+}
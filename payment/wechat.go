@@ -0,0 +1,230 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+	wechatpay "github.com/tanjl855/Sms_Pay_SDK/wechat_pay"
+	"github.com/wechatpay-apiv3/wechatpay-go/core"
+)
+
+// 微信支付配置
+type WechatConfig struct {
+	AppId  string            //应用ID
+	Config *wechatpay.Config //商户配置
+}
+
+type wechatGateway struct {
+	appId  string
+	config *wechatpay.Config
+
+	mu       sync.Mutex
+	notifier *wechatpay.Notifier
+}
+
+var _ Gateway = &wechatGateway{}
+
+/*
+[NewWechatGateway]-> 微信支付网关 下单使用Native支付
+*/
+func NewWechatGateway(config *WechatConfig) (Gateway, error) {
+	if config.Config == nil {
+		return nil, errors.New("NewWechatGateway-> wechatpay.Config can not be nil")
+	}
+	return &wechatGateway{appId: config.AppId, config: config.Config}, nil
+}
+
+func (g *wechatGateway) Channel() Channel {
+	return ChannelWechat
+}
+
+func (g *wechatGateway) Create(ctx context.Context, order *Order) (*CreateResult, error) {
+	if err := order.check(); err != nil {
+		return nil, err
+	}
+	nativeReq := wechatpay.NewNativeReq(order.Subject, order.OutTradeNo, order.NotifyURL, wechatpay.NativeAmount{Total: order.Amount, Currency: string(order.Amount.Currency())})
+	if !order.ExpireAt.IsZero() {
+		nativeReq.TimeExpire = order.ExpireAt.Format(time.RFC3339)
+	}
+	nativeRes, err := g.config.NativePrepay(ctx, g.appId, nativeReq)
+	if err != nil {
+		return nil, err
+	}
+	return &CreateResult{Channel: ChannelWechat, OutTradeNo: order.OutTradeNo, CodeURL: nativeRes.CodeUrl}, nil
+}
+
+func (g *wechatGateway) Query(ctx context.Context, outTradeNo string) (*Transaction, error) {
+	nativeReq, err := g.config.QueryOrder(ctx, outTradeNo)
+	if err != nil {
+		return nil, wechatError(err)
+	}
+	return wechatTransaction(nativeReq), nil
+}
+
+func (g *wechatGateway) Close(ctx context.Context, outTradeNo string) error {
+	return wechatError(g.config.CloseOrder(ctx, outTradeNo))
+}
+
+func (g *wechatGateway) Refund(ctx context.Context, req *RefundRequest) (*Refund, error) {
+	if err := req.check(); err != nil {
+		return nil, err
+	}
+	if !req.Total.IsPositive() {
+		return nil, fmt.Errorf("%w: Total can not be empty", ErrInvalidOrder)
+	}
+	refundReq := wechatpay.NewRefundReq(req.OutTradeNo, req.OutRefundNo, &wechatpay.RefundAmount{Refund: req.Amount, Total: req.Total, Currency: string(req.Amount.Currency())})
+	refundReq.Reason = req.Reason
+	refundReq.NotifyUrl = req.NotifyURL
+	refundRes, err := g.config.Refund(ctx, refundReq)
+	if err != nil {
+		return nil, wechatError(err)
+	}
+	return wechatRefund(refundRes), nil
+}
+
+// 微信支付按商户退款单号查询,outTradeNo仅用于填充结果
+func (g *wechatGateway) QueryRefund(ctx context.Context, outTradeNo, outRefundNo string) (*Refund, error) {
+	refundRes, err := g.config.QueryRefund(ctx, outRefundNo, "")
+	if err != nil {
+		return nil, wechatError(err)
+	}
+	refund := wechatRefund(refundRes)
+	if refund.OutTradeNo == "" {
+		refund.OutTradeNo = outTradeNo
+	}
+	return refund, nil
+}
+
+/*
+[ParseNotification]-> 验签解密微信支付通知
+event_type为TRANSACTION.*时为支付结果通知,REFUND.*时为退款结果通知
+*/
+func (g *wechatGateway) ParseNotification(ctx context.Context, r *http.Request) (*Notification, error) {
+	notifier, err := g.getNotifier(ctx)
+	if err != nil {
+		return nil, err
+	}
+	content := &json.RawMessage{}
+	notifyReq, err := notifier.Parse(ctx, r, content)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidNotification, err)
+	}
+	switch {
+	case strings.HasPrefix(notifyReq.EventType, "TRANSACTION."):
+		nativeReq := &wechatpay.NativeReq{}
+		if err = json.Unmarshal(*content, nativeReq); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidNotification, err)
+		}
+		if nativeReq.MchId != g.config.MchId || nativeReq.AppId != g.appId {
+			return nil, fmt.Errorf("%w: mchid(%v) appid(%v) mismatch", ErrInvalidNotification, nativeReq.MchId, nativeReq.AppId)
+		}
+		return &Notification{Channel: ChannelWechat, Id: notifyReq.ID, Type: NotifyPayment, Transaction: wechatTransaction(nativeReq)}, nil
+	case strings.HasPrefix(notifyReq.EventType, "REFUND."):
+		refundNotify := &wechatpay.RefundNotify{}
+		if err = json.Unmarshal(*content, refundNotify); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidNotification, err)
+		}
+		if refundNotify.MchId != "" && refundNotify.MchId != g.config.MchId {
+			return nil, fmt.Errorf("%w: mchid(%v) mismatch", ErrInvalidNotification, refundNotify.MchId)
+		}
+		refund := &Refund{
+			Channel:       ChannelWechat,
+			OutTradeNo:    refundNotify.OutTradeNo,
+			OutRefundNo:   refundNotify.OutRefundNo,
+			RefundNo:      refundNotify.RefundId,
+			Status:        WechatRefundStatus(refundNotify.RefundStatus),
+			ChannelStatus: refundNotify.RefundStatus,
+			SucceededAt:   parseWechatTime(refundNotify.SuccessTime),
+		}
+		if refundNotify.Amount != nil {
+			refund.Amount = refundNotify.Amount.Refund
+		}
+		return &Notification{Channel: ChannelWechat, Id: notifyReq.ID, Type: NotifyRefund, Refund: refund}, nil
+	}
+	return nil, fmt.Errorf("%w: unexpected event_type(%v)", ErrInvalidNotification, notifyReq.EventType)
+}
+
+// 微信支付v3通知应答 {"code":"SUCCESS","message":"成功"}
+func (g *wechatGateway) WriteNotifyResponse(w http.ResponseWriter, err error) {
+	res := &wechatpay.NotifyRes{Code: "SUCCESS", Msg: "成功"}
+	status := http.StatusOK
+	if err != nil {
+		res = &wechatpay.NotifyRes{Code: "FAIL", Msg: err.Error()}
+		status = notifyErrorStatus(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
+
+// 通知解析器 首次使用时创建,之后复用
+func (g *wechatGateway) getNotifier(ctx context.Context) (*wechatpay.Notifier, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.notifier != nil {
+		return g.notifier, nil
+	}
+	notifier, err := g.config.NewNotifier(ctx)
+	if err != nil {
+		return nil, err
+	}
+	g.notifier = notifier
+	return notifier, nil
+}
+
+func wechatTransaction(nativeReq *wechatpay.NativeReq) *Transaction {
+	transaction := &Transaction{
+		Channel:       ChannelWechat,
+		OutTradeNo:    nativeReq.OutTradeNo,
+		TradeNo:       nativeReq.TransactionId,
+		Status:        WechatStatus(nativeReq.TradeState),
+		ChannelStatus: nativeReq.TradeState,
+		Amount:        money.New(nativeReq.Amount.Total.Minor(), money.Currency(nativeReq.Amount.Currency)),
+		Payer:         nativeReq.Payer.OpenId,
+		PaidAt:        parseWechatTime(nativeReq.SuccessTime),
+	}
+	if nativeReq.Amount.PayerTotal != nil {
+		transaction.PaidAmount = money.New(nativeReq.Amount.PayerTotal.Minor(), money.Currency(nativeReq.Amount.PayerCurrency))
+	}
+	return transaction
+}
+
+func wechatRefund(refundRes *wechatpay.RefundResp) *Refund {
+	refund := &Refund{
+		Channel:       ChannelWechat,
+		OutTradeNo:    refundRes.OutTradeNo,
+		OutRefundNo:   refundRes.OutRefundNo,
+		RefundNo:      refundRes.RefundId,
+		Status:        WechatRefundStatus(refundRes.Status),
+		ChannelStatus: refundRes.Status,
+		SucceededAt:   parseWechatTime(refundRes.SuccessTime),
+	}
+	if refundRes.Amount != nil {
+		refund.Amount = money.New(refundRes.Amount.Refund.Minor(), money.Currency(refundRes.Amount.Currency))
+	}
+	return refund
+}
+
+// 订单不存在(404 ORDER_NOT_EXIST)转换为ErrOrderNotFound
+func wechatError(err error) error {
+	var apiErr *core.APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %v", ErrOrderNotFound, err)
+	}
+	return err
+}
+
+func parseWechatTime(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,150 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+	"github.com/tanjl855/Sms_Pay_SDK/paytest"
+)
+
+func TestWechatGateway(t *testing.T) {
+	fake := paytest.NewWechat()
+	defer fake.Close()
+	notify := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer notify.Close()
+	gateway, err := New(&Config{Channel: ChannelWechat, Wechat: newPaytestWechatConfig(fake)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	createResult, err := gateway.Create(ctx, &Order{OutTradeNo: "1217752501201407033233368018", Subject: "Image形象店-深圳腾大-QQ公仔", Amount: money.Fen(8888), NotifyURL: notify.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if createResult.CodeURL == "" || createResult.Channel != ChannelWechat {
+		t.Errorf("unexpected create result(%+v)", createResult)
+	}
+	if order, _ := fake.Order("1217752501201407033233368018"); order.AppId != "wxd678efh567hg6787" || order.Total.Minor() != 8888 || order.NotifyURL != notify.URL {
+		t.Errorf("unexpected native order(%+v)", order)
+	}
+	if err = fake.Pay("1217752501201407033233368018"); err != nil {
+		t.Fatal(err)
+	}
+
+	transaction, err := gateway.Query(ctx, "1217752501201407033233368018")
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, _ := fake.Order("1217752501201407033233368018")
+	if transaction.Status != StatusPaid || transaction.Amount.Minor() != 8888 || transaction.PaidAmount.Minor() != 8888 || transaction.TradeNo != order.TransactionId ||
+		transaction.Payer != order.OpenId || transaction.PaidAt.IsZero() {
+		t.Errorf("unexpected transaction(%+v)", transaction)
+	}
+	if _, err = gateway.Query(ctx, "unknown"); !errors.Is(err, ErrOrderNotFound) {
+		t.Errorf("expect ErrOrderNotFound, got(%v)", err)
+	}
+	//未支付的订单可关闭
+	if _, err = gateway.Create(ctx, &Order{OutTradeNo: "1217752501201407033233368019", Subject: "Image形象店-深圳腾大-QQ公仔", Amount: money.Fen(100), NotifyURL: notify.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if err = gateway.Close(ctx, "1217752501201407033233368019"); err != nil {
+		t.Error(err)
+	}
+	if order, _ = fake.Order("1217752501201407033233368019"); order.TradeState != paytest.WechatClosed {
+		t.Errorf("unexpected trade state(%v)", order.TradeState)
+	}
+
+	if _, err = gateway.Refund(ctx, &RefundRequest{OutTradeNo: "1217752501201407033233368018", OutRefundNo: "refund-1", Amount: money.Fen(1000)}); !errors.Is(err, ErrInvalidOrder) {
+		t.Errorf("refund without total should fail, got(%v)", err)
+	}
+	refund, err := gateway.Refund(ctx, &RefundRequest{OutTradeNo: "1217752501201407033233368018", OutRefundNo: "refund-1", Amount: money.Fen(1000), Total: money.Fen(8888)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.Status != RefundProcessing || refund.Amount.Minor() != 1000 || refund.RefundNo == "" {
+		t.Errorf("unexpected refund(%+v)", refund)
+	}
+	if err = fake.CompleteRefund("refund-1"); err != nil {
+		t.Fatal(err)
+	}
+	refund, err = gateway.QueryRefund(ctx, "1217752501201407033233368018", "refund-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.Status != RefundSuccess || refund.OutTradeNo != "1217752501201407033233368018" || refund.SucceededAt.IsZero() {
+		t.Errorf("unexpected refund(%+v)", refund)
+	}
+}
+
+func TestWechatGatewayNotify(t *testing.T) {
+	fake := paytest.NewWechat()
+	defer fake.Close()
+	config := newPaytestWechatConfig(fake)
+	gateway, err := NewWechatGateway(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received []*Notification
+	fail := false
+	notify := httptest.NewServer(NotifyHandler(gateway, nil, func(ctx context.Context, n *Notification) error {
+		if fail {
+			return errors.New("db unavailable")
+		}
+		received = append(received, n)
+		return nil
+	}))
+	defer notify.Close()
+	ctx := context.Background()
+
+	outTradeNo := "1217752501201407033233368018"
+	if _, err = gateway.Create(ctx, &Order{OutTradeNo: outTradeNo, Subject: "Image形象店-深圳腾大-QQ公仔", Amount: money.Fen(8888), NotifyURL: notify.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if err = fake.Pay(outTradeNo); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = gateway.Refund(ctx, &RefundRequest{OutTradeNo: outTradeNo, OutRefundNo: "refund-1", Amount: money.Fen(1000), Total: money.Fen(8888), NotifyURL: notify.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if err = fake.CompleteRefund("refund-1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 2 {
+		t.Fatalf("unexpected notifications(%v)", len(received))
+	}
+	if received[0].Type != NotifyPayment || received[0].Id == "" || received[0].Transaction.Status != StatusPaid || received[0].Transaction.Amount.Minor() != 8888 {
+		t.Errorf("unexpected payment notification(%+v)", received[0].Transaction)
+	}
+	if received[1].Type != NotifyRefund || received[1].Refund.Status != RefundSuccess || received[1].Refund.Amount.Minor() != 1000 {
+		t.Errorf("unexpected refund notification(%+v)", received[1].Refund)
+	}
+
+	// 其他商户的通知
+	otherConfig := newPaytestWechatConfig(fake)
+	otherConfig.Config.MchId = "1900000109"
+	other, err := NewWechatGateway(otherConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherNotify := httptest.NewServer(NotifyHandler(other, nil, func(ctx context.Context, n *Notification) error {
+		received = append(received, n)
+		return nil
+	}))
+	defer otherNotify.Close()
+	fake.NotifyURL = otherNotify.URL
+	if err = fake.Notify(outTradeNo); err == nil || len(received) != 2 {
+		t.Errorf("mchid mismatch should fail, got(%v) received(%v)", err, len(received))
+	}
+	// 业务处理失败应答500
+	fake.NotifyURL, fail = notify.URL, true
+	if err = fake.Notify(outTradeNo); err == nil || len(received) != 2 {
+		t.Errorf("callback error should fail, got(%v) received(%v)", err, len(received))
+	}
+}
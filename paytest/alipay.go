@@ -0,0 +1,432 @@
+package paytest
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+)
+
+//模拟支付宝网关 支持电脑网站支付(alipay.trade.page.pay)、交易查询、关闭、退款及退款查询
+//请求使用AppPrivateKey签名,应答及通知使用支付宝私钥签名,SDK使用AlipayPublicKey验签
+
+// 支付宝交易状态
+const (
+	AlipayWaitBuyerPay = "WAIT_BUYER_PAY"
+	AlipayTradeSuccess = "TRADE_SUCCESS"
+	AlipayTradeClosed  = "TRADE_CLOSED"
+)
+
+// 模拟支付宝中的交易
+type AlipayTrade struct {
+	OutTradeNo   string
+	TradeNo      string
+	Subject      string
+	TradeStatus  string
+	TotalAmount  money.Amount
+	RefundAmount money.Amount            //累计退款金额
+	Refunds      map[string]money.Amount //退款请求号->退款金额
+	BuyerId      string
+	NotifyURL    string //下单时的notify_url
+	GmtPayment   time.Time
+}
+
+// 模拟支付宝网关
+type Alipay struct {
+	*httptest.Server
+	AppId           string
+	SellerId        string
+	AppPrivateKey   string       //商户应用私钥 PKCS1 PEM,SDK用于请求签名
+	AlipayPublicKey string       //支付宝公钥 base64编码的PKIX,SDK用于应答及通知验签
+	NotifyURL       string       //[非必填]通知地址 不为空时替换下单时的notify_url
+	Client          *http.Client //[非必填]发送通知的http.Client
+	appPublicKey    *rsa.PublicKey
+	alipayKey       *rsa.PrivateKey
+	mu              sync.Mutex
+	trades          map[string]*AlipayTrade
+	seq             int
+}
+
+// 错误 sub_code以isv.开头时code为40002(参数错误),其余为40004(业务处理失败)
+type alipayError struct {
+	subCode string
+	subMsg  string
+}
+
+/*
+[NewAlipay]-> 启动模拟支付宝网关,网关地址为URL(任意路径均可),使用完毕后调用Close
+*/
+func NewAlipay() *Alipay {
+	appKey, alipayKey := newRSAKey(), newRSAKey()
+	der, err := x509.MarshalPKIXPublicKey(&alipayKey.PublicKey)
+	if err != nil {
+		panic(fmt.Sprintf("paytest: marshal alipay public key error(%v)", err))
+	}
+	a := &Alipay{
+		AppId:           "2014072300007148",
+		SellerId:        "2088101106499364",
+		AppPrivateKey:   string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(appKey)})),
+		AlipayPublicKey: base64.StdEncoding.EncodeToString(der),
+		appPublicKey:    &appKey.PublicKey,
+		alipayKey:       alipayKey,
+		trades:          make(map[string]*AlipayTrade),
+	}
+	a.Server = httptest.NewServer(http.HandlerFunc(a.serve))
+	return a
+}
+
+// [Trade] 交易快照 不存在时返回false
+func (a *Alipay) Trade(outTradeNo string) (AlipayTrade, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	trade, ok := a.trades[outTradeNo]
+	if !ok {
+		return AlipayTrade{}, false
+	}
+	return trade.snapshot(), true
+}
+
+/*
+[Pay]-> 模拟买家付款,交易变为TRADE_SUCCESS并发送支付通知
+交易需已通过支付页面地址创建(GET TradePagePay返回的url);通知地址为空时不发送
+返回通知发送失败或商户未应答success的错误,可再次调用Notify重发
+*/
+func (a *Alipay) Pay(outTradeNo string) error {
+	a.mu.Lock()
+	trade, ok := a.trades[outTradeNo]
+	if !ok || trade.TradeStatus != AlipayWaitBuyerPay {
+		a.mu.Unlock()
+		return fmt.Errorf("paytest: alipay trade(%v) can not be paid", outTradeNo)
+	}
+	a.seq++
+	trade.TradeNo = fmt.Sprintf("%v22001411%016d", time.Now().In(beijing).Format("20060102"), a.seq)
+	trade.TradeStatus = AlipayTradeSuccess
+	trade.BuyerId = "2088102122524333"
+	trade.GmtPayment = time.Now()
+	a.mu.Unlock()
+	return a.Notify(outTradeNo)
+}
+
+// [Notify] 按交易当前状态发送支付通知 可用于模拟重复通知
+func (a *Alipay) Notify(outTradeNo string) error {
+	a.mu.Lock()
+	trade, ok := a.trades[outTradeNo]
+	if !ok {
+		a.mu.Unlock()
+		return fmt.Errorf("paytest: alipay trade(%v) not found", outTradeNo)
+	}
+	form := a.notifyForm(trade)
+	if !trade.GmtPayment.IsZero() {
+		form.Set("gmt_payment", trade.GmtPayment.In(beijing).Format("2006-01-02 15:04:05"))
+	}
+	notifyURL := a.notifyURL(trade)
+	a.mu.Unlock()
+	return a.postNotify(notifyURL, form)
+}
+
+// [NotifyRefund] 发送退款通知 out_biz_no为退款请求号,refund_fee为累计退款金额
+func (a *Alipay) NotifyRefund(outTradeNo, outRequestNo string) error {
+	a.mu.Lock()
+	trade, ok := a.trades[outTradeNo]
+	if !ok || trade.Refunds[outRequestNo].IsZero() {
+		a.mu.Unlock()
+		return fmt.Errorf("paytest: alipay refund(%v %v) not found", outTradeNo, outRequestNo)
+	}
+	form := a.notifyForm(trade)
+	form.Set("out_biz_no", outRequestNo)
+	form.Set("refund_fee", trade.RefundAmount.Format())
+	form.Set("gmt_refund", time.Now().In(beijing).Format("2006-01-02 15:04:05.000"))
+	notifyURL := a.notifyURL(trade)
+	a.mu.Unlock()
+	return a.postNotify(notifyURL, form)
+}
+
+func (a *Alipay) notifyURL(trade *AlipayTrade) string {
+	if a.NotifyURL != "" {
+		return a.NotifyURL
+	}
+	return trade.NotifyURL
+}
+
+func (a *Alipay) notifyForm(trade *AlipayTrade) url.Values {
+	return url.Values{
+		"notify_time":  {time.Now().In(beijing).Format("2006-01-02 15:04:05")},
+		"notify_type":  {"trade_status_sync"},
+		"notify_id":    {randomHex(16)},
+		"charset":      {"utf-8"},
+		"version":      {"1.0"},
+		"app_id":       {a.AppId},
+		"seller_id":    {a.SellerId},
+		"out_trade_no": {trade.OutTradeNo},
+		"trade_no":     {trade.TradeNo},
+		"trade_status": {trade.TradeStatus},
+		"total_amount": {trade.TotalAmount.Format()},
+		"buyer_id":     {trade.BuyerId},
+		"subject":      {trade.Subject},
+	}
+}
+
+// 通知签名 除sign、sign_type外的非空参数排序后签名,商户应答success表示处理成功
+func (a *Alipay) postNotify(notifyURL string, form url.Values) error {
+	form.Set("sign", sign(a.alipayKey, []byte(signContent(form, "sign", "sign_type"))))
+	form.Set("sign_type", "RSA2")
+	return postNotify(a.Client, notifyURL, "application/x-www-form-urlencoded", nil, []byte(form.Encode()), func(status int, body string) bool {
+		return status == http.StatusOK && body == "success"
+	})
+}
+
+func (a *Alipay) serve(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	method := r.Form.Get("method")
+	biz := map[string]interface{}{}
+	err := a.verifyRequest(r.Form)
+	if err == nil && json.Unmarshal([]byte(r.Form.Get("biz_content")), &biz) != nil {
+		err = &alipayError{"isv.invalid-biz-content", "biz_content格式错误"}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var res map[string]interface{}
+	switch {
+	case method == "alipay.trade.page.pay":
+		//浏览器访问支付页面 创建待支付交易
+		if err == nil {
+			err = a.pagePay(r.Form, biz)
+		}
+		if err != nil {
+			http.Error(w, err.subMsg, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html;charset=utf-8")
+		fmt.Fprintf(w, "<html><body>支付宝收银台 %v</body></html>", biz["out_trade_no"])
+		return
+	case err != nil:
+	case method == "alipay.trade.query":
+		res, err = a.query(biz)
+	case method == "alipay.trade.close":
+		res, err = a.close(biz)
+	case method == "alipay.trade.refund":
+		res, err = a.refund(biz)
+	case method == "alipay.trade.fastpay.refund.query":
+		res, err = a.refundQuery(biz)
+	default:
+		err = &alipayError{"isv.invalid-method", "不存在的方法名"}
+	}
+	a.writeResponse(w, method, err, res)
+}
+
+// 校验app_id及请求签名 除sign外的非空参数排序后验签
+func (a *Alipay) verifyRequest(form url.Values) *alipayError {
+	if form.Get("app_id") != a.AppId {
+		return &alipayError{"isv.invalid-app-id", "无效的AppID参数"}
+	}
+	if form.Get("sign_type") != "RSA2" || !verify(a.appPublicKey, []byte(signContent(form, "sign")), form.Get("sign")) {
+		return &alipayError{"isv.invalid-signature", "验签出错"}
+	}
+	return nil
+}
+
+func (a *Alipay) pagePay(form url.Values, biz map[string]interface{}) *alipayError {
+	outTradeNo := bizString(biz, "out_trade_no")
+	total, err := money.ParseYuan(bizString(biz, "total_amount"))
+	if outTradeNo == "" || err != nil || !total.IsPositive() || bizString(biz, "subject") == "" {
+		return &alipayError{"isv.missing-parameter", "缺少必选参数"}
+	}
+	if trade, ok := a.trades[outTradeNo]; ok {
+		if trade.TradeStatus != AlipayWaitBuyerPay || !trade.TotalAmount.Equal(total) {
+			return &alipayError{"ACQ.TRADE_HAS_SUCCESS", "交易已被支付或参数与原交易不一致"}
+		}
+		return nil
+	}
+	a.trades[outTradeNo] = &AlipayTrade{
+		OutTradeNo:  outTradeNo,
+		Subject:     bizString(biz, "subject"),
+		TradeStatus: AlipayWaitBuyerPay,
+		TotalAmount: total,
+		Refunds:     make(map[string]money.Amount),
+		NotifyURL:   form.Get("notify_url"),
+	}
+	return nil
+}
+
+func (a *Alipay) trade(biz map[string]interface{}) (*AlipayTrade, *alipayError) {
+	outTradeNo, tradeNo := bizString(biz, "out_trade_no"), bizString(biz, "trade_no")
+	if trade, ok := a.trades[outTradeNo]; ok {
+		return trade, nil
+	}
+	for _, trade := range a.trades {
+		if tradeNo != "" && trade.TradeNo == tradeNo {
+			return trade, nil
+		}
+	}
+	return nil, &alipayError{"ACQ.TRADE_NOT_EXIST", "交易不存在"}
+}
+
+func (a *Alipay) query(biz map[string]interface{}) (map[string]interface{}, *alipayError) {
+	trade, err := a.trade(biz)
+	if err != nil {
+		return nil, err
+	}
+	res := map[string]interface{}{
+		"out_trade_no": trade.OutTradeNo,
+		"trade_no":     trade.TradeNo,
+		"trade_status": trade.TradeStatus,
+		"total_amount": trade.TotalAmount.Format(),
+	}
+	if !trade.GmtPayment.IsZero() {
+		res["buyer_pay_amount"] = trade.TotalAmount.Format()
+		res["receipt_amount"] = trade.TotalAmount.Format()
+		res["buyer_user_id"] = trade.BuyerId
+		res["send_pay_date"] = trade.GmtPayment.In(beijing).Format("2006-01-02 15:04:05")
+	}
+	return res, nil
+}
+
+func (a *Alipay) close(biz map[string]interface{}) (map[string]interface{}, *alipayError) {
+	trade, err := a.trade(biz)
+	if err != nil {
+		return nil, err
+	}
+	switch trade.TradeStatus {
+	case AlipayWaitBuyerPay:
+		trade.TradeStatus = AlipayTradeClosed
+	case AlipayTradeClosed:
+	default:
+		return nil, &alipayError{"ACQ.TRADE_STATUS_ERROR", "交易状态不合法"}
+	}
+	return map[string]interface{}{"out_trade_no": trade.OutTradeNo, "trade_no": trade.TradeNo}, nil
+}
+
+// 同一退款请求号重复请求时返回原结果,fund_change为N
+func (a *Alipay) refund(biz map[string]interface{}) (map[string]interface{}, *alipayError) {
+	trade, err := a.trade(biz)
+	if err != nil {
+		return nil, err
+	}
+	amount, parseErr := money.ParseYuan(bizString(biz, "refund_amount"))
+	if parseErr != nil || !amount.IsPositive() {
+		return nil, &alipayError{"ACQ.INVALID_PARAMETER", "退款金额不合法"}
+	}
+	outRequestNo := bizString(biz, "out_request_no")
+	if outRequestNo == "" {
+		outRequestNo = trade.OutTradeNo
+	}
+	fundChange := "N"
+	if refunded, ok := trade.Refunds[outRequestNo]; ok {
+		if !refunded.Equal(amount) {
+			return nil, &alipayError{"ACQ.REFUND_AMT_NOT_EQUAL_TOTAL", "退款金额与原退款请求不一致"}
+		}
+	} else {
+		if trade.TradeStatus != AlipayTradeSuccess {
+			return nil, &alipayError{"ACQ.TRADE_STATUS_ERROR", "交易状态不合法"}
+		}
+		total, _ := trade.RefundAmount.Add(amount)
+		if cmp, _ := total.Cmp(trade.TotalAmount); cmp > 0 {
+			return nil, &alipayError{"ACQ.REFUND_AMT_NOT_EQUAL_TOTAL", "退款金额超限"}
+		}
+		trade.Refunds[outRequestNo], trade.RefundAmount, fundChange = amount, total, "Y"
+		if total.Equal(trade.TotalAmount) {
+			trade.TradeStatus = AlipayTradeClosed
+		}
+	}
+	return map[string]interface{}{
+		"out_trade_no":   trade.OutTradeNo,
+		"trade_no":       trade.TradeNo,
+		"buyer_user_id":  trade.BuyerId,
+		"fund_change":    fundChange,
+		"refund_fee":     trade.RefundAmount.Format(),
+		"send_back_fee":  amount.Format(),
+		"gmt_refund_pay": time.Now().In(beijing).Format("2006-01-02 15:04:05"),
+	}, nil
+}
+
+// 退款请求号不存在时不返回refund_status
+func (a *Alipay) refundQuery(biz map[string]interface{}) (map[string]interface{}, *alipayError) {
+	trade, err := a.trade(biz)
+	if err != nil {
+		return nil, err
+	}
+	outRequestNo := bizString(biz, "out_request_no")
+	res := map[string]interface{}{"out_trade_no": trade.OutTradeNo, "trade_no": trade.TradeNo, "out_request_no": outRequestNo}
+	if amount, ok := trade.Refunds[outRequestNo]; ok {
+		res["total_amount"] = trade.TotalAmount.Format()
+		res["refund_amount"] = amount.Format()
+		res["refund_status"] = "REFUND_SUCCESS"
+	}
+	return res, nil
+}
+
+// 应答 {"xxx_response":{...},"sign":"..."} 使用支付宝私钥对xxx_response原文签名
+func (a *Alipay) writeResponse(w http.ResponseWriter, method string, bizErr *alipayError, res map[string]interface{}) {
+	if res == nil {
+		res = map[string]interface{}{}
+	}
+	res["code"], res["msg"] = "10000", "Success"
+	if bizErr != nil {
+		res["code"], res["msg"], res["sub_code"], res["sub_msg"] = "40004", "Business Failed", bizErr.subCode, bizErr.subMsg
+		if strings.HasPrefix(bizErr.subCode, "isv.") {
+			res["code"], res["msg"] = "40002", "Invalid Arguments"
+		}
+	}
+	node, _ := json.Marshal(res)
+	name := "error_response"
+	if method != "" {
+		name = strings.ReplaceAll(method, ".", "_") + "_response"
+	}
+	body, _ := json.Marshal(map[string]interface{}{name: json.RawMessage(node), "sign": sign(a.alipayKey, node)})
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.Write(body)
+}
+
+func (t *AlipayTrade) snapshot() AlipayTrade {
+	trade := *t
+	trade.Refunds = make(map[string]money.Amount, len(t.Refunds))
+	for k, v := range t.Refunds {
+		trade.Refunds[k] = v
+	}
+	return trade
+}
+
+// 待签名字符串 排除exclude及空值参数后按参数名排序,以k=v&k=v拼接
+func signContent(form url.Values, exclude ...string) string {
+	keys := make([]string, 0, len(form))
+	for k := range form {
+		if form.Get(k) == "" || contains(exclude, k) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+form.Get(k))
+	}
+	return strings.Join(pairs, "&")
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func bizString(biz map[string]interface{}, key string) string {
+	s, _ := biz[key].(string)
+	return s
+}
@@ -0,0 +1,116 @@
+package paytest
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//本地模拟支付宝网关及微信支付API v3,用于无网络的集成测试
+//模拟服务自行生成密钥及证书,对应答及通知签名,保存订单状态,并可向通知地址发送签名后的支付/退款通知
+//为避免测试时循环引用,paytest只依赖协议格式,不引用alipay、wechatpay包
+
+// 北京时间 支付宝时间字段及通知时间使用
+var beijing = time.FixedZone("CST", 8*3600)
+
+// 生成测试用RSA密钥 失败时panic,与httptest.NewServer一致
+func newRSAKey() *rsa.PrivateKey {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(fmt.Sprintf("paytest: generate rsa key error(%v)", err))
+	}
+	return privateKey
+}
+
+// 生成自签名证书 证书序列号为serialNo(16进制)
+func newCertificate(privateKey *rsa.PrivateKey, serialNo string) *x509.Certificate {
+	serial, ok := new(big.Int).SetString(serialNo, 16)
+	if !ok {
+		panic(fmt.Sprintf("paytest: invalid serial number(%v)", serialNo))
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "Tenpay.com Root CA", Organization: []string{"Tenpay.com"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		panic(fmt.Sprintf("paytest: create certificate error(%v)", err))
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(fmt.Sprintf("paytest: parse certificate error(%v)", err))
+	}
+	return certificate
+}
+
+// SHA256WithRSA签名 base64编码
+func sign(privateKey *rsa.PrivateKey, data []byte) string {
+	hashed := sha256.Sum256(data)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, hashed[:])
+	if err != nil {
+		panic(fmt.Sprintf("paytest: sign error(%v)", err))
+	}
+	return base64.StdEncoding.EncodeToString(signature)
+}
+
+func verify(publicKey *rsa.PublicKey, data []byte, signature string) bool {
+	signBytes, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	hashed := sha256.Sum256(data)
+	return rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hashed[:], signBytes) == nil
+}
+
+// 随机16进制字符串 n为字节数
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// 发送通知 渠道要求的应答由accept判断
+func postNotify(client *http.Client, url, contentType string, header http.Header, body []byte, accept func(status int, body string) bool) error {
+	if url == "" {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k := range header {
+		req.Header.Set(k, header.Get(k))
+	}
+	req.Header.Set("Content-Type", contentType)
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if !accept(res.StatusCode, strings.TrimSpace(string(resBody))) {
+		return fmt.Errorf("paytest: notify(%v) rejected status(%v) body(%v)", url, res.StatusCode, string(resBody))
+	}
+	return nil
+}
@@ -0,0 +1,547 @@
+package paytest
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+)
+
+//模拟微信支付API v3 支持Native下单、查询订单、关闭订单、申请退款及查询退款,其他接口可通过Handle注册
+//请求使用MchPrivateKey签名(Authorization头),应答及通知使用平台证书私钥签名,通知resource使用APIv3Key加密
+//SDK配置:PrivateKey为MchPrivateKey,PlatformCertificates为PlatformCertificate,Domain为URL
+
+// 微信支付交易状态及退款状态
+const (
+	WechatNotPay        = "NOTPAY"
+	WechatSuccess       = "SUCCESS"
+	WechatRefund        = "REFUND"
+	WechatClosed        = "CLOSED"
+	WechatRefundPending = "PROCESSING"
+)
+
+// 模拟微信支付中的订单
+type WechatOrder struct {
+	AppId         string
+	OutTradeNo    string
+	TransactionId string
+	Description   string
+	TradeState    string
+	Total         money.Amount
+	Refunded      money.Amount //累计退款金额 含处理中的退款
+	OpenId        string
+	NotifyURL     string //下单时的notify_url
+	SuccessTime   time.Time
+}
+
+// 模拟微信支付中的退款
+type WechatRefundOrder struct {
+	OutTradeNo  string
+	OutRefundNo string
+	RefundId    string
+	Status      string
+	Refund      money.Amount
+	Total       money.Amount
+	NotifyURL   string //申请退款时的notify_url
+	CreateTime  time.Time
+	SuccessTime time.Time
+}
+
+// 模拟微信支付API v3
+type Wechat struct {
+	*httptest.Server
+	MchId                      string
+	MchCertificateSerialNumber string
+	APIv3Key                   string
+	MchPrivateKey              *rsa.PrivateKey   //商户私钥 SDK用于请求签名
+	PlatformCertificate        *x509.Certificate //平台证书 SDK用于应答及通知验签
+	NotifyURL                  string            //[非必填]通知地址 不为空时替换下单及退款时的notify_url
+	Client                     *http.Client      //[非必填]发送通知的http.Client
+	platformKey                *rsa.PrivateKey
+	platformSerialNo           string
+	mu                         sync.Mutex
+	orders                     map[string]*WechatOrder
+	refunds                    map[string]*WechatRefundOrder
+	handlers                   map[string]WechatHandler
+	seq                        int
+}
+
+// 自定义接口 body为请求体原文,返回应答状态码及应答内容(为nil时无应答体)
+type WechatHandler func(r *http.Request, body []byte) (int, interface{})
+
+// 应答错误 {"code":"ORDER_NOT_EXIST","message":"订单不存在"}
+type wechatError struct {
+	status  int
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+/*
+[NewWechat]-> 启动模拟微信支付API v3,域名为URL,使用完毕后调用Close
+*/
+func NewWechat() *Wechat {
+	platformKey := newRSAKey()
+	platformCertificate := newCertificate(platformKey, randomHex(20))
+	//与SDK一致按证书序列号的字节生成 随机序列号以00开头时去掉前导0
+	platformSerialNo := fmt.Sprintf("%X", platformCertificate.SerialNumber.Bytes())
+	w := &Wechat{
+		MchId:                      "1230000109",
+		MchCertificateSerialNumber: strings.ToUpper(randomHex(20)),
+		APIv3Key:                   randomHex(16),
+		MchPrivateKey:              newRSAKey(),
+		PlatformCertificate:        platformCertificate,
+		platformKey:                platformKey,
+		platformSerialNo:           platformSerialNo,
+		orders:                     make(map[string]*WechatOrder),
+		refunds:                    make(map[string]*WechatRefundOrder),
+		handlers:                   make(map[string]WechatHandler),
+	}
+	w.Server = httptest.NewServer(http.HandlerFunc(w.serve))
+	return w
+}
+
+// [MchPrivateKeyPEM] 商户私钥 PKCS8 PEM,可写入文件供传入私钥路径的接口使用
+func (w *Wechat) MchPrivateKeyPEM() []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(w.MchPrivateKey)
+	if err != nil {
+		panic(fmt.Sprintf("paytest: marshal merchant private key error(%v)", err))
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+/*
+[Handle]-> 注册未内置的接口(合单、服务商、分账、商家转账等),用于校验SDK发出的请求
+请求同样校验Authorization,应答同样使用平台证书私钥签名;path以/结尾时按前缀匹配
+handler在锁外调用,可在其中调用Order、Refund等方法
+*/
+func (w *Wechat) Handle(method, path string, handler WechatHandler) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.handlers[method+" "+path] = handler
+}
+
+// [PlatformSerialNumber] 平台证书序列号 SDK加密敏感信息后应在请求头Wechatpay-Serial中传入
+func (w *Wechat) PlatformSerialNumber() string {
+	return w.platformSerialNo
+}
+
+// [DecryptSensitive] 使用平台证书私钥解密SDK加密的敏感信息(RSAES-OAEP)
+func (w *Wechat) DecryptSensitive(ciphertext string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, w.platformKey, data, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func (w *Wechat) handler(method, path string) (WechatHandler, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if handler, ok := w.handlers[method+" "+path]; ok {
+		return handler, true
+	}
+	for key, handler := range w.handlers {
+		if strings.HasSuffix(key, "/") && strings.HasPrefix(method+" "+path, key) {
+			return handler, true
+		}
+	}
+	return nil, false
+}
+
+// [Order] 订单快照 不存在时返回false
+func (w *Wechat) Order(outTradeNo string) (WechatOrder, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	order, ok := w.orders[outTradeNo]
+	if !ok {
+		return WechatOrder{}, false
+	}
+	return *order, true
+}
+
+// [Refund] 退款快照 不存在时返回false
+func (w *Wechat) Refund(outRefundNo string) (WechatRefundOrder, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	refund, ok := w.refunds[outRefundNo]
+	if !ok {
+		return WechatRefundOrder{}, false
+	}
+	return *refund, true
+}
+
+/*
+[Pay]-> 模拟用户付款,订单变为SUCCESS并发送TRANSACTION.SUCCESS通知
+通知地址为空时不发送;返回通知发送失败或商户未应答成功的错误,可再次调用Notify重发
+*/
+func (w *Wechat) Pay(outTradeNo string) error {
+	w.mu.Lock()
+	order, ok := w.orders[outTradeNo]
+	if !ok || order.TradeState != WechatNotPay {
+		w.mu.Unlock()
+		return fmt.Errorf("paytest: wechat order(%v) can not be paid", outTradeNo)
+	}
+	w.seq++
+	order.TransactionId = fmt.Sprintf("42000018%v%010d", time.Now().Format("20060102"), w.seq)
+	order.TradeState = WechatSuccess
+	order.OpenId = "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o"
+	order.SuccessTime = time.Now()
+	w.mu.Unlock()
+	return w.Notify(outTradeNo)
+}
+
+// [Notify] 按订单当前状态发送支付通知 可用于模拟重复通知
+func (w *Wechat) Notify(outTradeNo string) error {
+	w.mu.Lock()
+	order, ok := w.orders[outTradeNo]
+	if !ok {
+		w.mu.Unlock()
+		return fmt.Errorf("paytest: wechat order(%v) not found", outTradeNo)
+	}
+	transaction, notifyURL := w.transaction(order), w.notifyURL(order.NotifyURL)
+	w.mu.Unlock()
+	return w.postNotify(notifyURL, "TRANSACTION.SUCCESS", "transaction", "支付成功", transaction)
+}
+
+/*
+[CompleteRefund]-> 模拟退款到账,退款变为SUCCESS并发送REFUND.SUCCESS通知
+申请退款后状态为PROCESSING,调用该方法前查询退款均为处理中
+*/
+func (w *Wechat) CompleteRefund(outRefundNo string) error {
+	w.mu.Lock()
+	refund, ok := w.refunds[outRefundNo]
+	if !ok {
+		w.mu.Unlock()
+		return fmt.Errorf("paytest: wechat refund(%v) not found", outRefundNo)
+	}
+	refund.Status, refund.SuccessTime = WechatSuccess, time.Now()
+	order := w.orders[refund.OutTradeNo]
+	notify := map[string]interface{}{
+		"mchid":                 w.MchId,
+		"out_trade_no":          refund.OutTradeNo,
+		"transaction_id":        order.TransactionId,
+		"out_refund_no":         refund.OutRefundNo,
+		"refund_id":             refund.RefundId,
+		"refund_status":         refund.Status,
+		"success_time":          refund.SuccessTime.Format(time.RFC3339),
+		"user_received_account": "支付用户零钱",
+		"amount":                map[string]interface{}{"total": refund.Total, "refund": refund.Refund, "payer_total": refund.Total, "payer_refund": refund.Refund},
+	}
+	notifyURL := w.notifyURL(refund.NotifyURL)
+	w.mu.Unlock()
+	return w.postNotify(notifyURL, "REFUND.SUCCESS", "refund", "退款成功", notify)
+}
+
+func (w *Wechat) notifyURL(notifyURL string) string {
+	if w.NotifyURL != "" {
+		return w.NotifyURL
+	}
+	return notifyURL
+}
+
+// 通知 resource使用APIv3Key进行AEAD_AES_256_GCM加密,body使用平台证书私钥签名,商户应答2xx表示处理成功
+func (w *Wechat) postNotify(notifyURL, eventType, originalType, summary string, content interface{}) error {
+	plaintext, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	block, err := aes.NewCipher([]byte(w.APIv3Key))
+	if err != nil {
+		return err
+	}
+	aesgcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	nonce := randomHex(6)
+	ciphertext := aesgcm.Seal(nil, []byte(nonce), plaintext, []byte(originalType))
+	body, err := json.Marshal(map[string]interface{}{
+		"id":            randomHex(16),
+		"create_time":   time.Now().Format(time.RFC3339),
+		"resource_type": "encrypt-resource",
+		"event_type":    eventType,
+		"summary":       summary,
+		"resource": map[string]string{
+			"original_type":   originalType,
+			"algorithm":       "AEAD_AES_256_GCM",
+			"ciphertext":      base64.StdEncoding.EncodeToString(ciphertext),
+			"associated_data": originalType,
+			"nonce":           nonce,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	header := w.SignHeader(body)
+	header.Set("Wechatpay-Signature-Type", "WECHATPAY2-SHA256-RSA2048")
+	return postNotify(w.Client, notifyURL, "application/json", header, body, func(status int, body string) bool {
+		return status >= 200 && status < 300
+	})
+}
+
+// [SignHeader] 应答及通知签名头 签名串为 时间戳\n随机串\nbody\n 也可用于给回放的录制应答重新签名
+func (w *Wechat) SignHeader(body []byte) http.Header {
+	timestamp, nonce := strconv.FormatInt(time.Now().Unix(), 10), randomHex(16)
+	header := http.Header{}
+	header.Set("Request-ID", randomHex(16))
+	header.Set("Wechatpay-Timestamp", timestamp)
+	header.Set("Wechatpay-Nonce", nonce)
+	header.Set("Wechatpay-Serial", w.platformSerialNo)
+	header.Set("Wechatpay-Signature", sign(w.platformKey, []byte(timestamp+"\n"+nonce+"\n"+string(body)+"\n")))
+	return header
+}
+
+func (w *Wechat) serve(rw http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var res interface{}
+	status, path := http.StatusOK, r.URL.Path
+	resErr := w.verifyRequest(r, body)
+	if handler, ok := w.handler(r.Method, path); ok && resErr == nil {
+		status, res = handler(r, body)
+		w.writeResponse(rw, status, res)
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch {
+	case resErr != nil:
+	case r.Method == http.MethodPost && path == "/v3/pay/transactions/native":
+		res, resErr = w.native(body)
+	case r.Method == http.MethodPost && strings.HasPrefix(path, "/v3/pay/transactions/out-trade-no/") && strings.HasSuffix(path, "/close"):
+		resErr = w.close(strings.TrimSuffix(strings.TrimPrefix(path, "/v3/pay/transactions/out-trade-no/"), "/close"))
+		status = http.StatusNoContent
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/v3/pay/transactions/out-trade-no/"):
+		res, resErr = w.query(strings.TrimPrefix(path, "/v3/pay/transactions/out-trade-no/"), r.URL.Query().Get("mchid"))
+	case r.Method == http.MethodPost && path == "/v3/refund/domestic/refunds":
+		res, resErr = w.refund(body)
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/v3/refund/domestic/refunds/"):
+		res, resErr = w.queryRefund(strings.TrimPrefix(path, "/v3/refund/domestic/refunds/"))
+	default:
+		resErr = &wechatError{http.StatusNotFound, "NOT_FOUND", "接口不存在"}
+	}
+	if resErr != nil {
+		status, res = resErr.status, resErr
+	}
+	w.writeResponse(rw, status, res)
+}
+
+// 应答 使用平台证书私钥签名
+func (w *Wechat) writeResponse(rw http.ResponseWriter, status int, res interface{}) {
+	var resBody []byte
+	if res != nil {
+		resBody, _ = json.Marshal(res)
+	}
+	for k, v := range w.SignHeader(resBody) {
+		rw.Header()[k] = v
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	rw.Write(resBody)
+}
+
+/*
+校验Authorization 格式:
+WECHATPAY2-SHA256-RSA2048 mchid="...",nonce_str="...",signature="...",timestamp="...",serial_no="..."
+签名串为 请求方法\nURL\n时间戳\n随机串\nbody\n
+*/
+func (w *Wechat) verifyRequest(r *http.Request, body []byte) *wechatError {
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "WECHATPAY2-SHA256-RSA2048 ") {
+		return &wechatError{http.StatusUnauthorized, "SIGN_ERROR", "Authorization不合法"}
+	}
+	params := map[string]string{}
+	for _, pair := range strings.Split(strings.TrimPrefix(authorization, "WECHATPAY2-SHA256-RSA2048 "), ",") {
+		if kv := strings.SplitN(strings.TrimSpace(pair), "=", 2); len(kv) == 2 {
+			params[kv[0]] = strings.Trim(kv[1], `"`)
+		}
+	}
+	if params["mchid"] != w.MchId || params["serial_no"] != w.MchCertificateSerialNumber {
+		return &wechatError{http.StatusUnauthorized, "SIGN_ERROR", "商户号或证书序列号不匹配"}
+	}
+	message := r.Method + "\n" + r.URL.RequestURI() + "\n" + params["timestamp"] + "\n" + params["nonce_str"] + "\n" + string(body) + "\n"
+	if !verify(&w.MchPrivateKey.PublicKey, []byte(message), params["signature"]) {
+		return &wechatError{http.StatusUnauthorized, "SIGN_ERROR", "签名错误"}
+	}
+	return nil
+}
+
+// 同一商户订单号重复下单且参数一致时返回原二维码
+func (w *Wechat) native(body []byte) (interface{}, *wechatError) {
+	req := &struct {
+		AppId       string `json:"appid"`
+		MchId       string `json:"mchid"`
+		Description string `json:"description"`
+		OutTradeNo  string `json:"out_trade_no"`
+		NotifyUrl   string `json:"notify_url"`
+		Amount      struct {
+			Total    money.Amount `json:"total"`
+			Currency string       `json:"currency"`
+		} `json:"amount"`
+	}{}
+	if err := json.Unmarshal(body, req); err != nil {
+		return nil, &wechatError{http.StatusBadRequest, "PARAM_ERROR", "请求体格式错误"}
+	}
+	if req.AppId == "" || req.MchId != w.MchId || req.OutTradeNo == "" || req.Description == "" || req.NotifyUrl == "" || !req.Amount.Total.IsPositive() {
+		return nil, &wechatError{http.StatusBadRequest, "PARAM_ERROR", "参数错误"}
+	}
+	codeURL := "weixin://wxpay/bizpayurl/up?pr=" + req.OutTradeNo
+	if order, ok := w.orders[req.OutTradeNo]; ok {
+		if order.TradeState != WechatNotPay {
+			return nil, &wechatError{http.StatusForbidden, "ORDERPAID", "订单已支付"}
+		}
+		if !order.Total.Equal(req.Amount.Total) {
+			return nil, &wechatError{http.StatusBadRequest, "OUT_TRADE_NO_USED", "商户订单号重复"}
+		}
+		return map[string]string{"code_url": codeURL}, nil
+	}
+	w.orders[req.OutTradeNo] = &WechatOrder{
+		AppId:       req.AppId,
+		OutTradeNo:  req.OutTradeNo,
+		Description: req.Description,
+		TradeState:  WechatNotPay,
+		Total:       req.Amount.Total,
+		NotifyURL:   req.NotifyUrl,
+	}
+	return map[string]string{"code_url": codeURL}, nil
+}
+
+func (w *Wechat) query(outTradeNo, mchId string) (interface{}, *wechatError) {
+	order, ok := w.orders[outTradeNo]
+	if !ok || mchId != w.MchId {
+		return nil, &wechatError{http.StatusNotFound, "ORDER_NOT_EXIST", "订单不存在"}
+	}
+	return w.transaction(order), nil
+}
+
+func (w *Wechat) close(outTradeNo string) *wechatError {
+	order, ok := w.orders[outTradeNo]
+	if !ok {
+		return &wechatError{http.StatusNotFound, "ORDER_NOT_EXIST", "订单不存在"}
+	}
+	switch order.TradeState {
+	case WechatNotPay:
+		order.TradeState = WechatClosed
+	case WechatClosed:
+	default:
+		return &wechatError{http.StatusBadRequest, "ORDERPAID", "订单已支付"}
+	}
+	return nil
+}
+
+// 退款申请后为PROCESSING,同一商户退款单号重复申请时返回原退款
+func (w *Wechat) refund(body []byte) (interface{}, *wechatError) {
+	req := &struct {
+		OutTradeNo  string `json:"out_trade_no"`
+		OutRefundNo string `json:"out_refund_no"`
+		NotifyUrl   string `json:"notify_url"`
+		Amount      struct {
+			Refund   money.Amount `json:"refund"`
+			Total    money.Amount `json:"total"`
+			Currency string       `json:"currency"`
+		} `json:"amount"`
+	}{}
+	if err := json.Unmarshal(body, req); err != nil || req.OutRefundNo == "" || !req.Amount.Refund.IsPositive() {
+		return nil, &wechatError{http.StatusBadRequest, "PARAM_ERROR", "参数错误"}
+	}
+	if refund, ok := w.refunds[req.OutRefundNo]; ok {
+		return w.refundRes(refund), nil
+	}
+	order, ok := w.orders[req.OutTradeNo]
+	if !ok {
+		return nil, &wechatError{http.StatusNotFound, "RESOURCE_NOT_EXISTS", "订单不存在"}
+	}
+	if order.TradeState != WechatSuccess && order.TradeState != WechatRefund {
+		return nil, &wechatError{http.StatusBadRequest, "INVALID_REQUEST", "订单未支付"}
+	}
+	if !req.Amount.Total.Equal(order.Total) {
+		return nil, &wechatError{http.StatusBadRequest, "PARAM_ERROR", "订单金额与原订单不一致"}
+	}
+	refunded, _ := order.Refunded.Add(req.Amount.Refund)
+	if cmp, _ := refunded.Cmp(order.Total); cmp > 0 {
+		return nil, &wechatError{http.StatusForbidden, "NOT_ENOUGH", "可退款金额不足"}
+	}
+	w.seq++
+	refund := &WechatRefundOrder{
+		OutTradeNo:  req.OutTradeNo,
+		OutRefundNo: req.OutRefundNo,
+		RefundId:    fmt.Sprintf("50300000%v%010d", time.Now().Format("20060102"), w.seq),
+		Status:      WechatRefundPending,
+		Refund:      req.Amount.Refund,
+		Total:       order.Total,
+		NotifyURL:   req.NotifyUrl,
+		CreateTime:  time.Now(),
+	}
+	w.refunds[req.OutRefundNo] = refund
+	order.Refunded, order.TradeState = refunded, WechatRefund
+	return w.refundRes(refund), nil
+}
+
+func (w *Wechat) queryRefund(outRefundNo string) (interface{}, *wechatError) {
+	refund, ok := w.refunds[outRefundNo]
+	if !ok {
+		return nil, &wechatError{http.StatusNotFound, "RESOURCE_NOT_EXISTS", "退款单不存在"}
+	}
+	return w.refundRes(refund), nil
+}
+
+func (w *Wechat) transaction(order *WechatOrder) map[string]interface{} {
+	transaction := map[string]interface{}{
+		"appid":            order.AppId,
+		"mchid":            w.MchId,
+		"out_trade_no":     order.OutTradeNo,
+		"trade_type":       "NATIVE",
+		"trade_state":      order.TradeState,
+		"trade_state_desc": order.TradeState,
+		"amount":           map[string]interface{}{"total": order.Total, "currency": "CNY"},
+	}
+	if !order.SuccessTime.IsZero() {
+		transaction["transaction_id"] = order.TransactionId
+		transaction["bank_type"] = "OTHERS"
+		transaction["success_time"] = order.SuccessTime.Format(time.RFC3339)
+		transaction["payer"] = map[string]string{"openid": order.OpenId}
+		transaction["amount"] = map[string]interface{}{"total": order.Total, "payer_total": order.Total, "currency": "CNY", "payer_currency": "CNY"}
+	}
+	return transaction
+}
+
+func (w *Wechat) refundRes(refund *WechatRefundOrder) map[string]interface{} {
+	res := map[string]interface{}{
+		"refund_id":             refund.RefundId,
+		"out_refund_no":         refund.OutRefundNo,
+		"transaction_id":        w.orders[refund.OutTradeNo].TransactionId,
+		"out_trade_no":          refund.OutTradeNo,
+		"channel":               "ORIGINAL",
+		"user_received_account": "支付用户零钱",
+		"create_time":           refund.CreateTime.Format(time.RFC3339),
+		"status":                refund.Status,
+		"amount": map[string]interface{}{"total": refund.Total, "refund": refund.Refund, "payer_total": refund.Total, "payer_refund": refund.Refund,
+			"settlement_total": refund.Total, "settlement_refund": refund.Refund, "discount_refund": 0, "currency": "CNY"},
+	}
+	if !refund.SuccessTime.IsZero() {
+		res["success_time"] = refund.SuccessTime.Format(time.RFC3339)
+	}
+	return res
+}
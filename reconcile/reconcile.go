@@ -0,0 +1,275 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+	"github.com/tanjl855/Sms_Pay_SDK/payment"
+)
+
+//每日对账:以渠道账单(支付宝业务明细、微信支付交易账单)核对我方订单,输出差异及手续费汇总
+//渠道账单通过Source加载,我方订单通过OrderIterator遍历,测试及离线对账可使用FileSource读取本地账单
+//订单应为账单日发生支付或退款的订单;账单日只有退款的订单仅校验当日退款不超过我方累计退款
+//当日支付的订单只与账单日结束前成功的退款比较,避免次日及以后的退款造成误报
+
+// 账单日按北京时间划分
+var beijing = time.FixedZone("CST", 8*3600)
+
+// 账单明细类型
+type RecordType string
+
+const (
+	RecordPayment RecordType = "PAYMENT" //支付成功
+	RecordRefund  RecordType = "REFUND"  //退款成功
+)
+
+// 差异类型
+type MismatchType string
+
+const (
+	MissingLocal    MismatchType = "MISSING_LOCAL"    //渠道有交易,我方无订单
+	MissingProvider MismatchType = "MISSING_PROVIDER" //我方已支付,渠道账单无交易
+	AmountMismatch  MismatchType = "AMOUNT_MISMATCH"  //支付金额或退款金额不一致
+	StatusMismatch  MismatchType = "STATUS_MISMATCH"  //渠道已支付,我方订单未支付
+)
+
+// 渠道账单明细 只包含成功的支付及退款
+type BillRecord struct {
+	Channel     payment.Channel
+	Type        RecordType
+	OutTradeNo  string       //商户订单号
+	TradeNo     string       //渠道交易号
+	OutRefundNo string       //商户退款单号(退款)
+	Amount      money.Amount //支付金额或退款金额 正数
+	Fee         money.Amount //手续费 收取为正数,退款退回为负数
+	Time        string       //交易或退款时间 渠道原始格式
+}
+
+// 渠道账单来源
+type Source interface {
+	Channel() payment.Channel
+	Records(ctx context.Context, date string) ([]*BillRecord, error) //date格式yyyy-MM-dd
+}
+
+// 我方订单迭代器 遍历结束返回io.EOF
+type OrderIterator interface {
+	Next(ctx context.Context) (*payment.OrderRecord, error)
+}
+
+// 差异
+type Mismatch struct {
+	Type           MismatchType       `json:"type"`
+	Channel        payment.Channel    `json:"channel"`
+	OutTradeNo     string             `json:"out_trade_no"`
+	TradeNo        string             `json:"trade_no,omitempty"`
+	LocalState     payment.OrderState `json:"local_state,omitempty"`
+	LocalAmount    money.Amount       `json:"local_amount"`    //我方金额 单位为分
+	ProviderAmount money.Amount       `json:"provider_amount"` //渠道金额 单位为分
+	Detail         string             `json:"detail"`
+}
+
+// 渠道汇总
+type ChannelSummary struct {
+	Channel       payment.Channel `json:"channel"`
+	PaymentCount  int             `json:"payment_count"`  //支付笔数
+	PaymentAmount money.Amount    `json:"payment_amount"` //支付金额
+	RefundCount   int             `json:"refund_count"`   //退款笔数
+	RefundAmount  money.Amount    `json:"refund_amount"`  //退款金额
+	Fee           money.Amount    `json:"fee"`            //手续费合计 已扣除退款退回的手续费
+	Matched       int             `json:"matched"`        //核对一致的订单数
+}
+
+// 对账结果
+type Report struct {
+	Date       string            `json:"date"`
+	Channels   []*ChannelSummary `json:"channels"`
+	Mismatches []*Mismatch       `json:"mismatches"`
+}
+
+// 渠道账单中同一订单的汇总
+type providerTrade struct {
+	channel    payment.Channel
+	outTradeNo string
+	tradeNo    string
+	paid       bool
+	amount     money.Amount
+	refunded   money.Amount
+	seen       bool
+}
+
+/*
+[Reconcile]-> 按账单日核对我方订单与渠道账单
+只核对已加载账单的渠道的订单;同一订单的多条支付/退款明细合并后比较
+*/
+func Reconcile(ctx context.Context, date string, orders OrderIterator, sources ...Source) (*Report, error) {
+	if len(sources) == 0 {
+		return nil, errors.New("Reconcile-> sources can not be empty")
+	}
+	day, err := time.ParseInLocation("2006-01-02", date, beijing)
+	if err != nil {
+		return nil, fmt.Errorf("Reconcile-> invalid date(%v): %w", date, err)
+	}
+	dayEnd := day.AddDate(0, 0, 1)
+	report := &Report{Date: date}
+	summaries := make(map[payment.Channel]*ChannelSummary)
+	trades := make(map[payment.Channel]map[string]*providerTrade)
+	var tradeList []*providerTrade
+	for _, source := range sources {
+		records, err := source.Records(ctx, date)
+		if err != nil {
+			fmt.Printf("Reconcile-> load %v bill(%v) error(%v)", source.Channel(), date, err)
+			return nil, err
+		}
+		summary, ok := summaries[source.Channel()]
+		if !ok {
+			summary = &ChannelSummary{Channel: source.Channel()}
+			summaries[source.Channel()] = summary
+			report.Channels = append(report.Channels, summary)
+			trades[source.Channel()] = make(map[string]*providerTrade)
+		}
+		for _, record := range records {
+			trade, ok := trades[source.Channel()][record.OutTradeNo]
+			if !ok {
+				trade = &providerTrade{channel: source.Channel(), outTradeNo: record.OutTradeNo}
+				trades[source.Channel()][record.OutTradeNo] = trade
+				tradeList = append(tradeList, trade)
+			}
+			if err = trade.add(summary, record); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for {
+		order, err := orders.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		channelTrades, ok := trades[order.Channel]
+		if !ok {
+			continue
+		}
+		trade, ok := channelTrades[order.OutTradeNo]
+		if !ok {
+			if isPaid(order.State) {
+				report.Mismatches = append(report.Mismatches, &Mismatch{Type: MissingProvider, Channel: order.Channel, OutTradeNo: order.OutTradeNo,
+					TradeNo: order.TradeNo, LocalState: order.State, LocalAmount: order.Amount, Detail: "paid order not found in bill"})
+			}
+			continue
+		}
+		trade.seen = true
+		if mismatch := trade.check(order, dayEnd); mismatch != nil {
+			report.Mismatches = append(report.Mismatches, mismatch)
+			continue
+		}
+		summaries[order.Channel].Matched++
+	}
+	for _, trade := range tradeList {
+		if trade.seen {
+			continue
+		}
+		mismatch := &Mismatch{Type: MissingLocal, Channel: trade.channel, OutTradeNo: trade.outTradeNo, TradeNo: trade.tradeNo, ProviderAmount: trade.amount, Detail: "bill trade not found in orders"}
+		if !trade.paid {
+			mismatch.ProviderAmount, mismatch.Detail = trade.refunded, "bill refund not found in orders"
+		}
+		report.Mismatches = append(report.Mismatches, mismatch)
+	}
+	return report, nil
+}
+
+// 累计账单明细到订单及渠道汇总
+func (t *providerTrade) add(summary *ChannelSummary, record *BillRecord) error {
+	var err error
+	if record.TradeNo != "" {
+		t.tradeNo = record.TradeNo
+	}
+	switch record.Type {
+	case RecordPayment:
+		t.paid = true
+		if t.amount, err = t.amount.Add(record.Amount); err != nil {
+			return fmt.Errorf("reconcile: order(%v) %w", record.OutTradeNo, err)
+		}
+		summary.PaymentCount++
+		summary.PaymentAmount, err = summary.PaymentAmount.Add(record.Amount)
+	case RecordRefund:
+		if t.refunded, err = t.refunded.Add(record.Amount); err != nil {
+			return fmt.Errorf("reconcile: order(%v) %w", record.OutTradeNo, err)
+		}
+		summary.RefundCount++
+		summary.RefundAmount, err = summary.RefundAmount.Add(record.Amount)
+	default:
+		return fmt.Errorf("reconcile: order(%v) unexpected record type(%v)", record.OutTradeNo, record.Type)
+	}
+	if err != nil {
+		return fmt.Errorf("reconcile: %v summary %w", summary.Channel, err)
+	}
+	if summary.Fee, err = summary.Fee.Add(record.Fee); err != nil {
+		return fmt.Errorf("reconcile: %v fee %w", summary.Channel, err)
+	}
+	return nil
+}
+
+// 核对订单 一致时返回nil
+func (t *providerTrade) check(order *payment.OrderRecord, dayEnd time.Time) *Mismatch {
+	mismatch := &Mismatch{Channel: order.Channel, OutTradeNo: order.OutTradeNo, TradeNo: t.tradeNo, LocalState: order.State}
+	if t.paid {
+		if !isPaid(order.State) {
+			mismatch.Type, mismatch.LocalAmount, mismatch.ProviderAmount = StatusMismatch, order.Amount, t.amount
+			mismatch.Detail = fmt.Sprintf("bill paid but order state is %v", order.State)
+			return mismatch
+		}
+		if !t.amount.Equal(order.Amount) {
+			mismatch.Type, mismatch.LocalAmount, mismatch.ProviderAmount = AmountMismatch, order.Amount, t.amount
+			mismatch.Detail = "paid amount mismatch"
+			return mismatch
+		}
+	}
+	if t.refunded.IsZero() && order.RefundedAmount.IsZero() {
+		return nil
+	}
+	//当日支付的订单,账单日结束前成功的退款应全部在当日账单中
+	local, exact := refundedBefore(order, dayEnd)
+	cmp, err := t.refunded.Cmp(local)
+	if err != nil || cmp > 0 || (t.paid && exact && cmp != 0) {
+		mismatch.Type, mismatch.LocalAmount, mismatch.ProviderAmount = AmountMismatch, local, t.refunded
+		mismatch.Detail = "refunded amount mismatch"
+		return mismatch
+	}
+	return nil
+}
+
+// 订单在end之前成功的退款金额
+// 退款缺少成功时间或累计退款金额无法按退款单拆分(如通知只带累计金额)时返回累计退款金额,exact为false
+func refundedBefore(order *payment.OrderRecord, end time.Time) (refunded money.Amount, exact bool) {
+	refunded, sum := money.New(0, order.Amount.Currency()), money.New(0, order.Amount.Currency())
+	for outRefundNo, amount := range order.Refunds {
+		at, ok := order.RefundedAt[outRefundNo]
+		if !ok {
+			return order.RefundedAmount, false
+		}
+		var err error
+		if sum, err = sum.Add(amount); err != nil {
+			return order.RefundedAmount, false
+		}
+		if !at.Before(end) {
+			continue
+		}
+		if refunded, err = refunded.Add(amount); err != nil {
+			return order.RefundedAmount, false
+		}
+	}
+	if !sum.Equal(order.RefundedAmount) {
+		return order.RefundedAmount, false
+	}
+	return refunded, true
+}
+
+func isPaid(state payment.OrderState) bool {
+	return state == payment.OrderPaid || state == payment.OrderPartiallyRefunded || state == payment.OrderRefunded
+}
@@ -0,0 +1,199 @@
+package reconcile
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+	"github.com/tanjl855/Sms_Pay_SDK/payment"
+)
+
+func newTestSources() []Source {
+	return []Source{
+		NewFileSource(payment.ChannelAlipay, "testdata/alipay_%s.csv"),
+		NewFileSource(payment.ChannelWechat, "testdata/wechat_%s.csv"),
+	}
+}
+
+func newTestOrders() []*payment.OrderRecord {
+	return []*payment.OrderRecord{
+		//核对一致
+		{OutTradeNo: "native123", Channel: payment.ChannelWechat, Amount: money.Fen(10000), RefundedAmount: money.Fen(3000), State: payment.OrderPartiallyRefunded},
+		{OutTradeNo: "6823789339978248", Channel: payment.ChannelAlipay, Amount: money.Fen(8888), RefundedAmount: money.Fen(1000), State: payment.OrderPartiallyRefunded},
+		//前一日支付,当日退款
+		{OutTradeNo: "6823789339978200", Channel: payment.ChannelAlipay, Amount: money.Fen(2000), RefundedAmount: money.Fen(100), State: payment.OrderPartiallyRefunded},
+		//使用商家优惠支付后全额退款 退款金额为订单金额而非商家实收
+		{OutTradeNo: "6823789339978251", Channel: payment.ChannelAlipay, Amount: money.Fen(3000), RefundedAmount: money.Fen(3000), State: payment.OrderRefunded},
+		//未支付且不在账单中
+		{OutTradeNo: "native126", Channel: payment.ChannelWechat, Amount: money.Fen(100), State: payment.OrderCreated},
+		//差异
+		{OutTradeNo: "native124", Channel: payment.ChannelWechat, Amount: money.Fen(1200), State: payment.OrderPaid},
+		{OutTradeNo: "native125", Channel: payment.ChannelWechat, Amount: money.Fen(500), State: payment.OrderPaid, TradeNo: "4200001870202306013456789099"},
+		{OutTradeNo: "6823789339978249", Channel: payment.ChannelAlipay, Amount: money.Fen(1234), State: payment.OrderPaying},
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	report, err := Reconcile(context.Background(), "2023-06-01", SliceOrders(newTestOrders()...), newTestSources()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Channels) != 2 {
+		t.Fatalf("unexpected channels(%v)", len(report.Channels))
+	}
+	alipaySummary, wechatSummary := report.Channels[0], report.Channels[1]
+	if alipaySummary.Channel != payment.ChannelAlipay || alipaySummary.PaymentCount != 4 || alipaySummary.PaymentAmount.Minor() != 13622 ||
+		alipaySummary.RefundCount != 3 || alipaySummary.RefundAmount.Minor() != 4100 || alipaySummary.Fee.Minor() != 56 || alipaySummary.Matched != 3 {
+		t.Errorf("unexpected alipay summary(%+v)", alipaySummary)
+	}
+	if wechatSummary.Channel != payment.ChannelWechat || wechatSummary.PaymentCount != 2 || wechatSummary.PaymentAmount.Minor() != 11234 ||
+		wechatSummary.RefundCount != 1 || wechatSummary.RefundAmount.Minor() != 3000 || wechatSummary.Fee.Minor() != 49 || wechatSummary.Matched != 1 {
+		t.Errorf("unexpected wechat summary(%+v)", wechatSummary)
+	}
+
+	expect := []struct {
+		typ            MismatchType
+		outTradeNo     string
+		local, provide int64
+	}{
+		{AmountMismatch, "native124", 1200, 1234},
+		{MissingProvider, "native125", 500, 0},
+		{StatusMismatch, "6823789339978249", 1234, 1234},
+		{MissingLocal, "6823789339978250", 0, 500},
+	}
+	if len(report.Mismatches) != len(expect) {
+		t.Fatalf("unexpected mismatches(%v)", len(report.Mismatches))
+	}
+	for i, m := range report.Mismatches {
+		if m.Type != expect[i].typ || m.OutTradeNo != expect[i].outTradeNo || m.LocalAmount.Minor() != expect[i].local || m.ProviderAmount.Minor() != expect[i].provide {
+			t.Errorf("unexpected mismatch(%+v)", m)
+		}
+	}
+	if report.Mismatches[3].TradeNo != "2023060122001411111111111113" || report.Mismatches[2].LocalState != payment.OrderPaying {
+		t.Errorf("unexpected mismatch detail(%+v %+v)", report.Mismatches[2], report.Mismatches[3])
+	}
+}
+
+// 当日支付并退款的订单,我方退款金额与账单不一致
+func TestReconcileRefundMismatch(t *testing.T) {
+	orders := SliceOrders(&payment.OrderRecord{OutTradeNo: "native123", Channel: payment.ChannelWechat, Amount: money.Fen(10000), State: payment.OrderPaid},
+		&payment.OrderRecord{OutTradeNo: "native124", Channel: payment.ChannelWechat, Amount: money.Fen(1234), State: payment.OrderPaid},
+		//未加载账单的渠道不核对
+		&payment.OrderRecord{OutTradeNo: "6823789339978248", Channel: payment.ChannelAlipay, Amount: money.Fen(1), State: payment.OrderPaid})
+	report, err := Reconcile(context.Background(), "2023-06-01", orders, NewFileSource(payment.ChannelWechat, "testdata/wechat_%s.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Mismatches) != 1 {
+		t.Fatalf("unexpected mismatches(%v)", len(report.Mismatches))
+	}
+	m := report.Mismatches[0]
+	if m.Type != AmountMismatch || m.OutTradeNo != "native123" || m.LocalAmount.Minor() != 0 || m.ProviderAmount.Minor() != 3000 || m.Detail != "refunded amount mismatch" {
+		t.Errorf("unexpected mismatch(%+v)", m)
+	}
+	if report.Channels[0].Matched != 1 {
+		t.Errorf("unexpected matched(%v)", report.Channels[0].Matched)
+	}
+
+	if _, err = Reconcile(context.Background(), "2023-06-02", SliceOrders(), newTestSources()...); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expect os.ErrNotExist, got(%v)", err)
+	}
+	if _, err = Reconcile(context.Background(), "2023-06-01", SliceOrders()); err == nil {
+		t.Error("expect error without sources")
+	}
+}
+
+// 当日支付的订单次日再次退款,只比较账单日结束前成功的退款
+func TestReconcileLaterRefund(t *testing.T) {
+	refundedAt := func(s string) time.Time {
+		at, err := time.ParseInLocation("2006-01-02 15:04:05", s, beijing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return at
+	}
+	newOrder := func(refunds map[string]money.Amount, refundedAt map[string]time.Time, refunded int64) *payment.OrderRecord {
+		return &payment.OrderRecord{OutTradeNo: "native123", Channel: payment.ChannelWechat, Amount: money.Fen(10000), State: payment.OrderPartiallyRefunded,
+			RefundedAmount: money.Fen(refunded), Refunds: refunds, RefundedAt: refundedAt}
+	}
+	source := NewFileSource(payment.ChannelWechat, "testdata/wechat_%s.csv")
+	orders := SliceOrders(newOrder(map[string]money.Amount{"refund123": money.Fen(3000), "refund124": money.Fen(2000)},
+		map[string]time.Time{"refund123": refundedAt("2023-06-01 15:02:45"), "refund124": refundedAt("2023-06-02 00:00:00")}, 5000))
+	report, err := Reconcile(context.Background(), "2023-06-01", orders, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Mismatches) != 1 || report.Mismatches[0].OutTradeNo != "native124" {
+		t.Errorf("refund after bill date should not mismatch, got(%+v)", report.Mismatches)
+	}
+
+	//账单日的退款我方已登记而账单缺失
+	orders = SliceOrders(newOrder(map[string]money.Amount{"refund123": money.Fen(3000), "refund124": money.Fen(2000)},
+		map[string]time.Time{"refund123": refundedAt("2023-06-01 15:02:45"), "refund124": refundedAt("2023-06-01 23:59:59")}, 5000))
+	if report, err = Reconcile(context.Background(), "2023-06-01", orders, source); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Mismatches) != 2 || report.Mismatches[0].Type != AmountMismatch || report.Mismatches[0].LocalAmount.Minor() != 5000 || report.Mismatches[0].ProviderAmount.Minor() != 3000 {
+		t.Errorf("unexpected mismatches(%+v)", report.Mismatches)
+	}
+
+	//累计退款金额无法按退款单拆分时 只校验账单退款不超过累计退款
+	orders = SliceOrders(newOrder(map[string]money.Amount{"refund123": money.Fen(0)}, map[string]time.Time{"refund123": refundedAt("2023-06-01 15:02:45")}, 5000))
+	if report, err = Reconcile(context.Background(), "2023-06-01", orders, source); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Mismatches) != 1 {
+		t.Errorf("unexpected mismatches(%+v)", report.Mismatches)
+	}
+
+	if _, err = Reconcile(context.Background(), "20230601", SliceOrders(), source); err == nil {
+		t.Error("expect error for invalid date")
+	}
+}
+
+func TestReportWrite(t *testing.T) {
+	report, err := Reconcile(context.Background(), "2023-06-01", SliceOrders(newTestOrders()...), newTestSources()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = report.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 5 || records[1][1] != string(AmountMismatch) || records[1][6] != "12.00" || records[1][7] != "12.34" {
+		t.Errorf("unexpected csv(%v)", records)
+	}
+
+	buf.Reset()
+	if err = report.WriteSummaryCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if records, err = csv.NewReader(&buf).ReadAll(); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[1][1] != "alipay" || records[1][6] != "0.56" || records[2][6] != "0.49" {
+		t.Errorf("unexpected summary csv(%v)", records)
+	}
+
+	buf.Reset()
+	if err = report.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Report
+	if err = json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Date != "2023-06-01" || len(decoded.Mismatches) != 4 || decoded.Channels[1].Fee.Minor() != 49 || decoded.Mismatches[0].ProviderAmount.Minor() != 1234 {
+		t.Errorf("unexpected json(%s)", buf.String())
+	}
+}
@@ -0,0 +1,39 @@
+package reconcile
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+// [WriteCSV] 输出差异明细 金额单位为元
+func (r *Report) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"date", "type", "channel", "out_trade_no", "trade_no", "local_state", "local_amount", "provider_amount", "detail"})
+	for _, m := range r.Mismatches {
+		writer.Write([]string{r.Date, string(m.Type), string(m.Channel), m.OutTradeNo, m.TradeNo, string(m.LocalState),
+			m.LocalAmount.Format(), m.ProviderAmount.Format(), m.Detail})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// [WriteSummaryCSV] 输出各渠道汇总及手续费合计 金额单位为元
+func (r *Report) WriteSummaryCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"date", "channel", "payment_count", "payment_amount", "refund_count", "refund_amount", "fee", "matched"})
+	for _, s := range r.Channels {
+		writer.Write([]string{r.Date, string(s.Channel), strconv.Itoa(s.PaymentCount), s.PaymentAmount.Format(),
+			strconv.Itoa(s.RefundCount), s.RefundAmount.Format(), s.Fee.Format(), strconv.Itoa(s.Matched)})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// [WriteJSON] 输出完整对账结果 金额单位为分
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
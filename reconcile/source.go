@@ -0,0 +1,169 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/tanjl855/Sms_Pay_SDK/alipay"
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+	"github.com/tanjl855/Sms_Pay_SDK/payment"
+	wechatpay "github.com/tanjl855/Sms_Pay_SDK/wechat_pay"
+)
+
+var (
+	_ Source        = &FileSource{}
+	_ Source        = &WechatSource{}
+	_ OrderIterator = &sliceOrders{}
+)
+
+/*
+[WechatRecords]-> 微信支付交易账单转为账单明细
+交易状态为SUCCESS的记录为支付,REFUND且退款成功的记录为退款,其余(如REVOKED)忽略
+*/
+func WechatRecords(bill *wechatpay.TradeBill) []*BillRecord {
+	var records []*BillRecord
+	for _, row := range bill.Rows {
+		currency := money.Currency(row.Currency)
+		record := &BillRecord{Channel: payment.ChannelWechat, OutTradeNo: row.OutTradeNo, TradeNo: row.TransactionId,
+			Fee: money.New(row.Fee.Minor(), currency), Time: row.TradeTime}
+		switch row.TradeState {
+		case wechatpay.TradeStateSuccess:
+			record.Type, record.Amount = RecordPayment, money.New(row.Total.Minor(), currency)
+		case wechatpay.TradeStateRefund:
+			if row.RefundStatus != "" && row.RefundStatus != wechatpay.RefundStatusSuccess {
+				continue
+			}
+			//申请退款金额为商户发起的退款金额,退款金额扣除了代金券部分
+			refund := row.ApplyRefundAmount
+			if !refund.IsPositive() {
+				refund = row.RefundAmount
+			}
+			record.Type, record.OutRefundNo, record.Amount = RecordRefund, row.OutRefundNo, money.New(refund.Minor(), currency)
+		default:
+			continue
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+/*
+[AlipayRecords]-> 支付宝业务明细转为账单明细
+业务类型为交易的记录为支付,退款的记录为退款(金额取订单金额列);服务费收取时为负数,转换为正数的手续费
+*/
+func AlipayRecords(bill *alipay.TradeBill) []*BillRecord {
+	var records []*BillRecord
+	for _, row := range bill.Rows {
+		record := &BillRecord{Channel: payment.ChannelAlipay, OutTradeNo: row.OutTradeNo, TradeNo: row.TradeNo,
+			Fee: neg(row.ServiceFee), Time: row.FinishTime}
+		switch row.BizType {
+		case alipay.BillBizTypeTrade:
+			record.Type, record.Amount = RecordPayment, row.TotalAmount
+		case alipay.BillBizTypeRefund:
+			//退款行的订单金额为本次退款金额(含退回的优惠),以负数导出时取反;商家实收扣除了商家优惠,不作为退款金额
+			refund := row.TotalAmount
+			if refund.IsNegative() {
+				refund = neg(refund)
+			}
+			record.Type, record.OutRefundNo, record.Amount = RecordRefund, row.OutRequestNo, refund
+		default:
+			continue
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func neg(a money.Amount) money.Amount {
+	return money.New(-a.Minor(), a.Currency())
+}
+
+// [ParseBill] 按渠道解析账单文件(UTF-8),支付宝为业务明细,微信支付为交易账单
+func ParseBill(channel payment.Channel, r io.Reader) ([]*BillRecord, error) {
+	switch channel {
+	case payment.ChannelAlipay:
+		bill, err := alipay.ParseTradeBill(r)
+		if err != nil {
+			return nil, err
+		}
+		return AlipayRecords(bill), nil
+	case payment.ChannelWechat:
+		bill, err := wechatpay.ParseTradeBill(r)
+		if err != nil {
+			return nil, err
+		}
+		return WechatRecords(bill), nil
+	}
+	return nil, fmt.Errorf("%w: %v", payment.ErrUnsupportedChannel, channel)
+}
+
+// 本地账单文件 用于测试及已下载账单的对账
+type FileSource struct {
+	channel payment.Channel
+	pattern string //文件路径 包含%s时替换为账单日期,如testdata/alipay_%s.csv
+}
+
+func NewFileSource(channel payment.Channel, pattern string) *FileSource {
+	return &FileSource{channel: channel, pattern: pattern}
+}
+
+func (f *FileSource) Channel() payment.Channel {
+	return f.channel
+}
+
+func (f *FileSource) Records(ctx context.Context, date string) ([]*BillRecord, error) {
+	path := f.pattern
+	if strings.Contains(path, "%s") {
+		path = fmt.Sprintf(path, date)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Printf("FileSource.Records-> open bill(%v) error(%v)", path, err)
+		return nil, err
+	}
+	defer file.Close()
+	return ParseBill(f.channel, file)
+}
+
+// 微信支付交易账单 通过账单API下载,支付宝账单为GBK编码的zip包,需下载转码后使用FileSource
+type WechatSource struct {
+	path string //本地文件中商户私钥的位置,同wechatpay.TradeBillCommit
+}
+
+func NewWechatSource(path string) *WechatSource {
+	return &WechatSource{path: path}
+}
+
+func (w *WechatSource) Channel() payment.Channel {
+	return payment.ChannelWechat
+}
+
+func (w *WechatSource) Records(ctx context.Context, date string) ([]*BillRecord, error) {
+	bill, err := wechatpay.TradeBillCommit(w.path, wechatpay.NewTradeBillReq(date, wechatpay.BillTypeAll, wechatpay.TarTypeGzip))
+	if err != nil {
+		return nil, err
+	}
+	return WechatRecords(bill), nil
+}
+
+// 按切片遍历订单
+type sliceOrders struct {
+	orders []*payment.OrderRecord
+}
+
+// [SliceOrders] 订单切片转为OrderIterator
+func SliceOrders(orders ...*payment.OrderRecord) OrderIterator {
+	return &sliceOrders{orders: orders}
+}
+
+func (s *sliceOrders) Next(ctx context.Context) (*payment.OrderRecord, error) {
+	if len(s.orders) == 0 {
+		return nil, io.EOF
+	}
+	order := s.orders[0]
+	s.orders = s.orders[1:]
+	return order, nil
+}
@@ -0,0 +1,228 @@
+package smstest
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+//模拟阿里云短信SendSms RPC接口
+//签名:除Signature外的全部参数按参数名排序后百分号编码,StringToSign为 Method&%2F&编码后的参数串,HMAC-SHA1(AccessKeySecret+"&")
+//SDK配置:AccessKeyId/AccessKeySecret为模拟服务的密钥,请求的Scheme为http,Domain为Host()
+
+// 阿里云短信返回码
+const (
+	AliyunOK                    = "OK"
+	AliyunBusinessLimitControl  = "isv.BUSINESS_LIMIT_CONTROL"
+	AliyunMobileNumberIllegal   = "isv.MOBILE_NUMBER_ILLEGAL"
+	AliyunMobileCountOverLimit  = "isv.MOBILE_COUNT_OVER_LIMIT"
+	AliyunInvalidJsonParam      = "isv.INVALID_JSON_PARAM"
+	AliyunSignatureNonceUsed    = "SignatureNonceUsed"
+	AliyunSignatureDoesNotMatch = "SignatureDoesNotMatch"
+	AliyunInvalidAccessKeyId    = "InvalidAccessKeyId.NotFound"
+	AliyunInvalidTimeStamp      = "InvalidTimeStamp.Expired"
+)
+
+// 返回码描述 未列出的返回码描述与返回码相同
+var aliyunMessages = map[string]string{
+	AliyunOK:                    "OK",
+	AliyunBusinessLimitControl:  "触发云通信流控限制",
+	AliyunMobileNumberIllegal:   "手机号码格式错误",
+	AliyunMobileCountOverLimit:  "手机号码数量超过限制",
+	AliyunInvalidJsonParam:      "参数格式错误，请修改为字符串值",
+	AliyunSignatureNonceUsed:    "Specified signature nonce was used already.",
+	AliyunSignatureDoesNotMatch: "Specified signature is not matched with our calculation.",
+	AliyunInvalidAccessKeyId:    "Specified access key is not found.",
+	AliyunInvalidTimeStamp:      "Specified time stamp or date value is expired.",
+}
+
+// 模拟阿里云收到的短信 通过鉴权的请求均会记录,Code为返回码
+type AliyunMessage struct {
+	RequestId       string
+	BizId           string //发送成功时的回执ID
+	Code            string
+	PhoneNumbers    string
+	SignName        string
+	TemplateCode    string
+	TemplateParam   string
+	OutId           string
+	SmsUpExtendCode string
+	ReceivedAt      time.Time
+}
+
+// 模拟阿里云短信服务
+type Aliyun struct {
+	*httptest.Server
+	AccessKeyId     string
+	AccessKeySecret string
+	script          script
+	mu              sync.Mutex
+	nonces          map[string]bool
+	messages        []AliyunMessage
+	seq             int
+}
+
+/*
+[NewAliyun]-> 启动模拟阿里云短信服务,任意路径均可,使用完毕后调用Close
+*/
+func NewAliyun() *Aliyun {
+	a := &Aliyun{
+		AccessKeyId:     "LTAI" + randomHex(10),
+		AccessKeySecret: randomHex(15),
+		nonces:          make(map[string]bool),
+	}
+	a.Server = httptest.NewServer(http.HandlerFunc(a.serve))
+	return a
+}
+
+// [Host] 接入点地址(ip:port) 用于请求的Domain
+func (a *Aliyun) Host() string {
+	return strings.TrimPrefix(a.URL, "http://")
+}
+
+/*
+[Script]-> 预设之后请求的返回码,按调用顺序依次使用,用完后恢复正常处理
+示例: Script(AliyunSignatureNonceUsed, AliyunOK) 第一次返回签名随机数重复,第二次成功
+isv./isp.开头及OK以HTTP 200返回,其余(如SignatureNonceUsed)以HTTP 400返回
+*/
+func (a *Aliyun) Script(codes ...string) {
+	a.script.push(codes...)
+}
+
+// [Messages] 已收到的短信
+func (a *Aliyun) Messages() []AliyunMessage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]AliyunMessage(nil), a.messages...)
+}
+
+func (a *Aliyun) serve(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeAliyun(w, http.StatusBadRequest, map[string]string{"Code": "InvalidParameter", "Message": err.Error()})
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.seq++
+	requestId := fmt.Sprintf("F655A8D5-B967-440B-8683-%012d", a.seq)
+	if code := a.verifyRequest(r); code != "" {
+		writeAliyun(w, aliyunStatus(code), aliyunResponse(requestId, code))
+		return
+	}
+	a.nonces[r.Form.Get("SignatureNonce")] = true
+
+	message := AliyunMessage{
+		RequestId:       requestId,
+		PhoneNumbers:    r.Form.Get("PhoneNumbers"),
+		SignName:        r.Form.Get("SignName"),
+		TemplateCode:    r.Form.Get("TemplateCode"),
+		TemplateParam:   r.Form.Get("TemplateParam"),
+		OutId:           r.Form.Get("OutId"),
+		SmsUpExtendCode: r.Form.Get("SmsUpExtendCode"),
+		ReceivedAt:      time.Now(),
+	}
+	message.Code = a.script.pop()
+	if message.Code == "" {
+		message.Code = checkAliyunParams(r.Form)
+	}
+	res := aliyunResponse(requestId, message.Code)
+	if message.Code == AliyunOK {
+		message.BizId = fmt.Sprintf("%v^0", time.Now().UnixNano())
+		res["BizId"] = message.BizId
+	}
+	a.messages = append(a.messages, message)
+	writeAliyun(w, aliyunStatus(message.Code), res)
+}
+
+// 校验接口、AccessKey、签名、时间戳及签名随机数 返回错误码,通过时返回""
+func (a *Aliyun) verifyRequest(r *http.Request) string {
+	form := r.Form
+	switch {
+	case form.Get("Action") != "SendSms":
+		return "InvalidAction.NotFound"
+	case form.Get("AccessKeyId") != a.AccessKeyId:
+		return AliyunInvalidAccessKeyId
+	case form.Get("Signature") != a.sign(r.Method, form):
+		return AliyunSignatureDoesNotMatch
+	}
+	timestamp, err := time.Parse("2006-01-02T15:04:05Z", form.Get("Timestamp"))
+	if err != nil || time.Since(timestamp) > 15*time.Minute || time.Until(timestamp) > 15*time.Minute {
+		return AliyunInvalidTimeStamp
+	}
+	if nonce := form.Get("SignatureNonce"); nonce == "" || a.nonces[nonce] {
+		return AliyunSignatureNonceUsed
+	}
+	return ""
+}
+
+func (a *Aliyun) sign(method string, form url.Values) string {
+	params := url.Values{}
+	for k := range form {
+		if k != "Signature" {
+			params.Set(k, form.Get(k))
+		}
+	}
+	query := params.Encode()
+	query = strings.Replace(query, "+", "%20", -1)
+	query = strings.Replace(query, "*", "%2A", -1)
+	query = strings.Replace(query, "%7E", "~", -1)
+	mac := hmac.New(sha1.New, []byte(a.AccessKeySecret+"&"))
+	mac.Write([]byte(method + "&%2F&" + url.QueryEscape(query)))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// 业务参数校验 返回码与阿里云一致
+func checkAliyunParams(form url.Values) string {
+	for _, name := range []string{"PhoneNumbers", "SignName", "TemplateCode"} {
+		if form.Get(name) == "" {
+			return "Missing" + name
+		}
+	}
+	count := checkPhones(form.Get("PhoneNumbers"))
+	if count == 0 {
+		return AliyunMobileNumberIllegal
+	}
+	if count > 1000 {
+		return AliyunMobileCountOverLimit
+	}
+	if param := form.Get("TemplateParam"); param != "" {
+		var params map[string]interface{}
+		if json.Unmarshal([]byte(param), &params) != nil {
+			return AliyunInvalidJsonParam
+		}
+	}
+	return AliyunOK
+}
+
+func aliyunStatus(code string) int {
+	if code == AliyunOK || strings.HasPrefix(code, "isv.") || strings.HasPrefix(code, "isp.") {
+		return http.StatusOK
+	}
+	if code == AliyunInvalidAccessKeyId || code == "InvalidAction.NotFound" {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
+
+func aliyunResponse(requestId, code string) map[string]string {
+	message, ok := aliyunMessages[code]
+	if !ok {
+		message = code
+	}
+	return map[string]string{"RequestId": requestId, "Code": code, "Message": message}
+}
+
+func writeAliyun(w http.ResponseWriter, status int, res map[string]string) {
+	body, _ := json.Marshal(res)
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(body)
+}
@@ -0,0 +1,60 @@
+package smstest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+//本地模拟阿里云短信(dysmsapi SendSms)及天翼云短信(/json/submit)接口,用于无网络的短信测试
+//模拟服务校验签名及参数,按Script预设的Code依次返回(未预设时校验通过即成功),并记录收到的短信供测试断言
+//为避免测试时循环引用,smstest只依赖协议格式,不引用kxsmsapi包
+
+// 预设返回码 按调用顺序依次使用,用完后恢复正常处理
+type script struct {
+	mu    sync.Mutex
+	codes []string
+}
+
+func (s *script) push(codes ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.codes = append(s.codes, codes...)
+}
+
+// 取出下一个预设返回码 没有时返回""
+func (s *script) pop() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.codes) == 0 {
+		return ""
+	}
+	code := s.codes[0]
+	s.codes = s.codes[1:]
+	return code
+}
+
+var mobileRegexp = regexp.MustCompile(`^1\d{10}$`)
+
+// 校验逗号分隔的手机号 返回号码数量,存在非法号码时返回0
+func checkPhones(phones string) int {
+	if phones == "" {
+		return 0
+	}
+	list := strings.Split(phones, ",")
+	for _, phone := range list {
+		if !mobileRegexp.MatchString(strings.TrimSpace(phone)) {
+			return 0
+		}
+	}
+	return len(list)
+}
+
+// 随机16进制字符串 n为字节数
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,171 @@
+package smstest
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+//模拟天翼云短信 POST /json/submit
+//签名:MD5(enterprise_no+account+timestamp+HttpSignKey) 32位大写,应答使用相同方式对应答时间戳签名
+//timestamp不是yyyyMMddHHmmssSSS格式时返回请求格式错误
+//SDK配置:Ip为Host(),企业编号、账号、密钥为模拟服务的EnterpriseNo/Account/HttpSignKey
+
+// 天翼云返回描述 成功时result为0,其余为-1000
+const (
+	TianYiyunOK           = "成功"
+	TianYiyunSignError    = "签名错误"
+	TianYiyunAccountError = "账号不存在"
+	TianYiyunPhoneError   = "手机号码格式错误"
+	TianYiyunContentEmpty = "短信内容为空"
+	TianYiyunParamError   = "请求格式错误"
+)
+
+// 模拟天翼云收到的短信 Desc为返回描述
+type TianYiyunMessage struct {
+	MsgId      string
+	Desc       string
+	Phones     string
+	Content    string
+	ReceivedAt time.Time
+}
+
+// 模拟天翼云短信服务
+type TianYiyun struct {
+	*httptest.Server
+	EnterpriseNo string
+	Account      string
+	HttpSignKey  string
+	script       script
+	mu           sync.Mutex
+	messages     []TianYiyunMessage
+	seq          int
+}
+
+/*
+[NewTianYiyun]-> 启动模拟天翼云短信服务,使用完毕后调用Close
+*/
+func NewTianYiyun() *TianYiyun {
+	t := &TianYiyun{
+		EnterpriseNo: "10001",
+		Account:      "tanjl",
+		HttpSignKey:  randomHex(16),
+	}
+	t.Server = httptest.NewServer(http.HandlerFunc(t.serve))
+	return t
+}
+
+// [Host] 接口地址(ip:port) 用于TianYiyunAdaptor.Ip
+func (t *TianYiyun) Host() string {
+	return strings.TrimPrefix(t.URL, "http://")
+}
+
+/*
+[Script]-> 预设之后请求的返回描述,按调用顺序依次使用,用完后恢复正常处理
+示例: Script("发送频率过快", TianYiyunOK)
+*/
+func (t *TianYiyun) Script(descs ...string) {
+	t.script.push(descs...)
+}
+
+// [Messages] 已收到的短信
+func (t *TianYiyun) Messages() []TianYiyunMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]TianYiyunMessage(nil), t.messages...)
+}
+
+func (t *TianYiyun) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/json/submit" {
+		http.NotFound(w, r)
+		return
+	}
+	req := &struct {
+		Sign         string `json:"sign"`
+		EnterpriseNo string `json:"enterprise_no"`
+		Account      string `json:"account"`
+		Phones       string `json:"phones"`
+		Content      string `json:"content"`
+		TimeStamp    string `json:"timestamp"`
+	}{}
+	body, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, req)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var desc string
+	switch {
+	case err != nil:
+		desc = TianYiyunParamError
+	case !validTimeStamp(req.TimeStamp):
+		desc = TianYiyunParamError
+	case req.EnterpriseNo != t.EnterpriseNo || req.Account != t.Account:
+		desc = TianYiyunAccountError
+	case req.Sign != t.sign(req.TimeStamp):
+		desc = TianYiyunSignError
+	}
+	if desc != "" {
+		t.write(w, desc, "")
+		return
+	}
+
+	t.seq++
+	message := TianYiyunMessage{
+		MsgId:      fmt.Sprintf("%v%06d", time.Now().Format("20060102150405"), t.seq),
+		Desc:       t.script.pop(),
+		Phones:     req.Phones,
+		Content:    req.Content,
+		ReceivedAt: time.Now(),
+	}
+	if message.Desc == "" {
+		switch {
+		case checkPhones(req.Phones) == 0:
+			message.Desc = TianYiyunPhoneError
+		case req.Content == "":
+			message.Desc = TianYiyunContentEmpty
+		default:
+			message.Desc = TianYiyunOK
+		}
+	}
+	t.messages = append(t.messages, message)
+	t.write(w, message.Desc, message.MsgId)
+}
+
+// 时间戳格式yyyyMMddHHmmssSSS
+func validTimeStamp(timestamp string) bool {
+	if len(timestamp) != 17 {
+		return false
+	}
+	_, err := time.Parse("20060102150405.000", timestamp[:14]+"."+timestamp[14:])
+	return err == nil
+}
+
+// MD5(enterprise_no+account+timestamp+http_sign_Key) 32位大写
+func (t *TianYiyun) sign(timestamp string) string {
+	return strings.ToUpper(fmt.Sprintf("%x", md5.Sum([]byte(t.EnterpriseNo+t.Account+timestamp+t.HttpSignKey))))
+}
+
+func (t *TianYiyun) write(w http.ResponseWriter, desc, msgId string) {
+	result := "0"
+	if desc != TianYiyunOK {
+		result = "-1000"
+	}
+	now := time.Now()
+	timestamp := fmt.Sprintf("%v%03d", now.Format("20060102150405"), now.Nanosecond()/int(time.Millisecond))
+	body, _ := json.Marshal(map[string]string{
+		"result":    result,
+		"desc":      desc,
+		"timestamp": timestamp,
+		"msgid":     msgId,
+		"sign":      t.sign(timestamp),
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
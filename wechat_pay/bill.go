@@ -0,0 +1,506 @@
+package wechatpay
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+	"github.com/wechatpay-apiv3/wechatpay-go/core"
+	"github.com/wechatpay-apiv3/wechatpay-go/core/auth/validators"
+)
+
+//申请交易账单API详情请查阅:https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_4_6.shtml
+//申请资金账单API详情请查阅:https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_4_7.shtml
+//下载账单API详情请查阅:https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_4_8.shtml
+
+// 对账流程
+// 1.申请账单 -> 返回download_url及账单摘要(hash_type/hash_value)
+// 2.下载账单 -> tar_type=GZIP时需要解压缩,再与摘要比对
+// 3.解析账单 -> 每个字段前带`符号,最后两行为汇总表头及汇总数据
+
+/*
+账单示例：
+交易时间,公众账号ID,商户号,特约商户号,设备号,微信订单号,商户订单号,用户标识,交易类型,交易状态,...
+`2023-06-01 10:00:00,`wx2421b1c4370ec43b,`10000100,`0,`,`4200001870202306013456789012,`native123,...
+总交易单数,应结订单总金额,退款总金额,充值券退款总金额,手续费总金额,订单总金额,申请退款总金额
+`2,`0.02,`0.00,`0.00,`0.00,`0.02,`0.00
+*/
+
+type WechatBill interface {
+	Download(path string) ([]byte, error)
+}
+
+var _ WechatBill = &TradeBillReq{}
+var _ WechatBill = &FundFlowBillReq{}
+
+// 账单类型
+const (
+	BillTypeAll     = "ALL"     //返回当日所有订单信息(不含充值退款订单)
+	BillTypeSuccess = "SUCCESS" //返回当日成功支付的订单(不含充值退款订单)
+	BillTypeRefund  = "REFUND"  //返回当日退款订单(不含充值退款订单)
+
+	AccountTypeBasic     = "BASIC"     //基本账户
+	AccountTypeOperation = "OPERATION" //运营账户
+	AccountTypeFees      = "FEES"      //手续费账户
+
+	TarTypeGzip = "GZIP" //压缩格式 不填则默认是数据流
+)
+
+// 申请交易账单req GET https://api.mch.weixin.qq.com/v3/bill/tradebill
+type TradeBillReq struct {
+	BillDate string //账单日期 格式yyyy-MM-DD 仅支持三个月内的账单下载申请
+	BillType string //[非必填]账单类型 ALL/SUCCESS/REFUND 默认ALL
+	TarType  string //[非必填]压缩类型 GZIP 不填则默认是数据流
+	Debug    bool
+}
+
+// 申请资金账单req GET https://api.mch.weixin.qq.com/v3/bill/fundflowbill
+type FundFlowBillReq struct {
+	BillDate    string //账单日期 格式yyyy-MM-DD 仅支持三个月内的账单下载申请
+	AccountType string //[非必填]资金账户类型 BASIC/OPERATION/FEES 默认BASIC
+	TarType     string //[非必填]压缩类型 GZIP 不填则默认是数据流
+	Debug       bool
+}
+
+// 申请账单res
+type BillRes struct {
+	HashType    string `json:"hash_type"`    //哈希类型 示例值：SHA1
+	HashValue   string `json:"hash_value"`   //哈希值 原始账单(gzip需要解压缩)的摘要值
+	DownloadUrl string `json:"download_url"` //账单下载地址 30s内有效
+}
+
+// 交易账单
+type TradeBill struct {
+	Rows    []*TradeBillRow
+	Summary *TradeBillSummary
+}
+
+// 交易账单明细 金额字段单位为分
+type TradeBillRow struct {
+	TradeTime            string       //交易时间
+	AppId                string       //公众账号ID
+	MchId                string       //商户号
+	SubMchId             string       //特约商户号
+	DeviceInfo           string       //设备号
+	TransactionId        string       //微信订单号
+	OutTradeNo           string       //商户订单号
+	OpenId               string       //用户标识
+	TradeType            string       //交易类型
+	TradeState           string       //交易状态
+	BankType             string       //付款银行
+	Currency             string       //货币种类
+	SettlementTotal      money.Amount //应结订单金额
+	CouponAmount         money.Amount //代金券金额
+	RefundId             string       //微信退款单号
+	OutRefundNo          string       //商户退款单号
+	RefundAmount         money.Amount //退款金额
+	RechargeCouponRefund money.Amount //充值券退款金额
+	RefundType           string       //退款类型
+	RefundStatus         string       //退款状态
+	Body                 string       //商品名称
+	Attach               string       //商户数据包
+	Fee                  money.Amount //手续费
+	Rate                 string       //费率 示例值：0.60%
+	Total                money.Amount //订单金额
+	ApplyRefundAmount    money.Amount //申请退款金额
+	RateRemark           string       //费率备注
+}
+
+// 交易账单汇总
+type TradeBillSummary struct {
+	TotalCount           int          //总交易单数
+	SettlementTotal      money.Amount //应结订单总金额
+	RefundAmount         money.Amount //退款总金额
+	RechargeCouponRefund money.Amount //充值券退款总金额
+	Fee                  money.Amount //手续费总金额
+	Total                money.Amount //订单总金额
+	ApplyRefundAmount    money.Amount //申请退款总金额
+}
+
+// 资金账单
+type FundFlowBill struct {
+	Rows    []*FundFlowBillRow
+	Summary *FundFlowBillSummary
+}
+
+// 资金账单明细 金额字段单位为分
+type FundFlowBillRow struct {
+	BookTime      string       //记账时间
+	TransactionId string       //微信支付业务单号
+	FlowId        string       //资金流水单号
+	BizName       string       //业务名称
+	BizType       string       //业务类型
+	IncomeType    string       //收支类型 收入/支出
+	Amount        money.Amount //收支金额
+	Balance       money.Amount //账户结余
+	Applicant     string       //资金变更提交申请人
+	Remark        string       //备注
+	VoucherNo     string       //业务凭证号
+}
+
+// 资金账单汇总
+type FundFlowBillSummary struct {
+	TotalCount    int          //资金流水总笔数
+	IncomeCount   int          //收入笔数
+	IncomeAmount  money.Amount //收入金额
+	ExpenseCount  int          //支出笔数
+	ExpenseAmount money.Amount //支出金额
+}
+
+func NewTradeBillReq(billDate, billType, tarType string) *TradeBillReq {
+	return &TradeBillReq{
+		BillDate: billDate,
+		BillType: billType,
+		TarType:  tarType,
+	}
+}
+
+func NewFundFlowBillReq(billDate, accountType, tarType string) *FundFlowBillReq {
+	return &FundFlowBillReq{
+		BillDate:    billDate,
+		AccountType: accountType,
+		TarType:     tarType,
+	}
+}
+
+/*
+[Download]-> 申请并下载交易账单,返回校验摘要后的原始账单
+path:本地文件中商户私钥的位置
+*/
+func (b *TradeBillReq) Download(path string) ([]byte, error) {
+	query := url.Values{}
+	query.Set("bill_date", b.BillDate)
+	if b.BillType != "" {
+		query.Set("bill_type", b.BillType)
+	}
+	if b.TarType != "" {
+		query.Set("tar_type", b.TarType)
+	}
+	return downloadBill(path, DefaultConfig.domain()+"/v3/bill/tradebill?"+query.Encode(), b.TarType, b.Debug)
+}
+
+/*
+[Download]-> 申请并下载资金账单,返回校验摘要后的原始账单
+path:本地文件中商户私钥的位置
+*/
+func (b *FundFlowBillReq) Download(path string) ([]byte, error) {
+	query := url.Values{}
+	query.Set("bill_date", b.BillDate)
+	if b.AccountType != "" {
+		query.Set("account_type", b.AccountType)
+	}
+	if b.TarType != "" {
+		query.Set("tar_type", b.TarType)
+	}
+	return downloadBill(path, DefaultConfig.domain()+"/v3/bill/fundflowbill?"+query.Encode(), b.TarType, b.Debug)
+}
+
+/*
+[TradeBillCommit]->上层调用下载并解析交易账单
+path:本地文件中商户私钥的位置
+*/
+func TradeBillCommit(path string, tradeBillReq *TradeBillReq) (*TradeBill, error) {
+	if tradeBillReq == nil {
+		fmt.Printf("TradeBillCommit-> tradeBillReq can not be nil")
+		return nil, errors.New("TradeBillCommit-> tradeBillReq can not be nil")
+	}
+	data, err := tradeBillReq.Download(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTradeBill(bytes.NewReader(data))
+}
+
+/*
+[FundFlowBillCommit]->上层调用下载并解析资金账单
+path:本地文件中商户私钥的位置
+*/
+func FundFlowBillCommit(path string, fundFlowBillReq *FundFlowBillReq) (*FundFlowBill, error) {
+	if fundFlowBillReq == nil {
+		fmt.Printf("FundFlowBillCommit-> fundFlowBillReq can not be nil")
+		return nil, errors.New("FundFlowBillCommit-> fundFlowBillReq can not be nil")
+	}
+	data, err := fundFlowBillReq.Download(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseFundFlowBill(bytes.NewReader(data))
+}
+
+// 申请账单 -> 下载账单 -> 解压缩及校验摘要
+func downloadBill(path, applyUrl, tarType string, debug bool) ([]byte, error) {
+	ctx := context.Background()
+	client, err := newClient(ctx, path)
+	if err != nil {
+		fmt.Printf("downloadBill-> newClient error(%v)", err)
+		return nil, err
+	}
+	billRes := &BillRes{}
+	if err = doRequest(ctx, client, http.MethodGet, applyUrl, nil, nil, billRes); err != nil {
+		return nil, err
+	}
+	Debug(debug, "downloadBill-> apply bill success, billRes: %v", billRes)
+
+	raw, err := downloadFile(ctx, client, billRes.DownloadUrl)
+	if err != nil {
+		return nil, err
+	}
+	Debug(debug, "downloadBill-> download bill(%v bytes) success", len(raw))
+	return readBill(raw, tarType, billRes.HashType, billRes.HashValue)
+}
+
+// 下载账单/电子回单等文件,下载应答不带微信支付签名,跳过应答验签
+func downloadFile(ctx context.Context, client *core.Client, downloadUrl string) ([]byte, error) {
+	downloadClient := core.NewClientWithValidator(client, &validators.NullValidator{})
+	result, err := downloadClient.Get(ctx, downloadUrl)
+	if err != nil {
+		fmt.Printf("downloadFile-> Get (%v) error(%v)", downloadUrl, err)
+		return nil, err
+	}
+	defer result.Response.Body.Close()
+	raw, err := ioutil.ReadAll(result.Response.Body)
+	if err != nil {
+		fmt.Printf("downloadFile-> read body error(%v)", err)
+		return nil, err
+	}
+	return raw, nil
+}
+
+// 按tarType解压缩账单,并校验原始账单的摘要
+func readBill(raw []byte, tarType, hashType, hashValue string) ([]byte, error) {
+	data := raw
+	if strings.EqualFold(tarType, TarTypeGzip) {
+		gzipReader, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			fmt.Printf("readBill-> new gzip reader error(%v)", err)
+			return nil, err
+		}
+		defer gzipReader.Close()
+		if data, err = ioutil.ReadAll(gzipReader); err != nil {
+			fmt.Printf("readBill-> gunzip bill error(%v)", err)
+			return nil, err
+		}
+	}
+	if err := verifyHash(data, hashType, hashValue); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// 校验文件摘要 账单使用SHA1,电子回单使用SHA256
+func verifyHash(data []byte, hashType, hashValue string) error {
+	var sum []byte
+	switch strings.ToUpper(hashType) {
+	case "SHA1":
+		h := sha1.Sum(data)
+		sum = h[:]
+	case "SHA256":
+		h := sha256.Sum256(data)
+		sum = h[:]
+	default:
+		return fmt.Errorf("verifyHash-> unsupported hash_type(%v)", hashType)
+	}
+	if !strings.EqualFold(hex.EncodeToString(sum), hashValue) {
+		return errors.New("verifyHash-> file hash mismatch")
+	}
+	return nil
+}
+
+// [ParseTradeBill] 解析交易账单,按表头字段名取值,兼容ALL/SUCCESS/REFUND不同列数的账单
+func ParseTradeBill(r io.Reader) (*TradeBill, error) {
+	header, rows, summaryHeader, summary, err := readBillRecords(r, "总交易单数")
+	if err != nil {
+		return nil, err
+	}
+	tradeBill := &TradeBill{}
+	for _, record := range rows {
+		row := billRecord{header: header, values: record}
+		tradeBillRow := &TradeBillRow{
+			TradeTime:     row.get("交易时间"),
+			AppId:         row.get("公众账号ID"),
+			MchId:         row.get("商户号"),
+			SubMchId:      row.get("特约商户号"),
+			DeviceInfo:    row.get("设备号"),
+			TransactionId: row.get("微信订单号"),
+			OutTradeNo:    row.get("商户订单号"),
+			OpenId:        row.get("用户标识"),
+			TradeType:     row.get("交易类型"),
+			TradeState:    row.get("交易状态"),
+			BankType:      row.get("付款银行"),
+			Currency:      row.get("货币种类"),
+			RefundId:      row.get("微信退款单号"),
+			OutRefundNo:   row.get("商户退款单号"),
+			RefundType:    row.get("退款类型"),
+			RefundStatus:  row.get("退款状态"),
+			Body:          row.get("商品名称"),
+			Attach:        row.get("商户数据包"),
+			Rate:          row.get("费率"),
+			RateRemark:    row.get("费率备注"),
+		}
+		tradeBillRow.SettlementTotal = row.fen("应结订单金额", &err)
+		tradeBillRow.CouponAmount = row.fen("代金券金额", &err)
+		tradeBillRow.RefundAmount = row.fen("退款金额", &err)
+		tradeBillRow.RechargeCouponRefund = row.fen("充值券退款金额", &err)
+		tradeBillRow.Fee = row.fen("手续费", &err)
+		tradeBillRow.Total = row.fen("订单金额", &err)
+		tradeBillRow.ApplyRefundAmount = row.fen("申请退款金额", &err)
+		if err != nil {
+			return nil, err
+		}
+		tradeBill.Rows = append(tradeBill.Rows, tradeBillRow)
+	}
+	if summaryHeader != nil {
+		row := billRecord{header: summaryHeader, values: summary}
+		tradeBill.Summary = &TradeBillSummary{}
+		tradeBill.Summary.TotalCount = row.int("总交易单数", &err)
+		tradeBill.Summary.SettlementTotal = row.fen("应结订单总金额", &err)
+		tradeBill.Summary.RefundAmount = row.fen("退款总金额", &err)
+		tradeBill.Summary.RechargeCouponRefund = row.fen("充值券退款总金额", &err)
+		tradeBill.Summary.Fee = row.fen("手续费总金额", &err)
+		tradeBill.Summary.Total = row.fen("订单总金额", &err)
+		tradeBill.Summary.ApplyRefundAmount = row.fen("申请退款总金额", &err)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tradeBill, nil
+}
+
+// [ParseFundFlowBill] 解析资金账单
+func ParseFundFlowBill(r io.Reader) (*FundFlowBill, error) {
+	header, rows, summaryHeader, summary, err := readBillRecords(r, "资金流水总笔数")
+	if err != nil {
+		return nil, err
+	}
+	fundFlowBill := &FundFlowBill{}
+	for _, record := range rows {
+		row := billRecord{header: header, values: record}
+		fundFlowBillRow := &FundFlowBillRow{
+			BookTime:      row.get("记账时间"),
+			TransactionId: row.get("微信支付业务单号"),
+			FlowId:        row.get("资金流水单号"),
+			BizName:       row.get("业务名称"),
+			BizType:       row.get("业务类型"),
+			IncomeType:    row.get("收支类型"),
+			Applicant:     row.get("资金变更提交申请人"),
+			Remark:        row.get("备注"),
+			VoucherNo:     row.get("业务凭证号"),
+		}
+		fundFlowBillRow.Amount = row.fen("收支金额(元)", &err)
+		fundFlowBillRow.Balance = row.fen("账户结余(元)", &err)
+		if err != nil {
+			return nil, err
+		}
+		fundFlowBill.Rows = append(fundFlowBill.Rows, fundFlowBillRow)
+	}
+	if summaryHeader != nil {
+		row := billRecord{header: summaryHeader, values: summary}
+		fundFlowBill.Summary = &FundFlowBillSummary{}
+		fundFlowBill.Summary.TotalCount = row.int("资金流水总笔数", &err)
+		fundFlowBill.Summary.IncomeCount = row.int("收入笔数", &err)
+		fundFlowBill.Summary.IncomeAmount = row.fen("收入金额", &err)
+		fundFlowBill.Summary.ExpenseCount = row.int("支出笔数", &err)
+		fundFlowBill.Summary.ExpenseAmount = row.fen("支出金额", &err)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fundFlowBill, nil
+}
+
+// 读取账单记录: 明细表头,明细数据,汇总表头,汇总数据
+func readBillRecords(r io.Reader, summaryTitle string) (header map[string]int, rows [][]string,
+	summaryHeader map[string]int, summary []string, err error) {
+	csvReader := csv.NewReader(r)
+	csvReader.FieldsPerRecord = -1
+	csvReader.LazyQuotes = true
+	records, err := csvReader.ReadAll()
+	if err != nil {
+		fmt.Printf("readBillRecords-> read csv error(%v)", err)
+		return nil, nil, nil, nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil, nil, nil, errors.New("readBillRecords-> empty bill")
+	}
+	header = billHeader(records[0])
+	for i := 1; i < len(records); i++ {
+		if cleanBillField(records[i][0]) == summaryTitle {
+			summaryHeader = billHeader(records[i])
+			if i+1 < len(records) {
+				summary = records[i+1]
+			}
+			break
+		}
+		rows = append(rows, records[i])
+	}
+	return header, rows, summaryHeader, summary, nil
+}
+
+// 表头字段名 -> 列下标 全角括号转为半角,兼容"收支金额（元）"与"收支金额(元)"
+func billHeader(record []string) map[string]int {
+	header := make(map[string]int, len(record))
+	for i, name := range record {
+		name = cleanBillField(name)
+		name = strings.NewReplacer("（", "(", "）", ")").Replace(name)
+		header[name] = i
+	}
+	return header
+}
+
+// 去掉BOM、字段前的`符号及空白
+func cleanBillField(field string) string {
+	field = strings.TrimPrefix(field, "\ufeff")
+	field = strings.TrimSpace(field)
+	return strings.TrimSpace(strings.TrimPrefix(field, "`"))
+}
+
+type billRecord struct {
+	header map[string]int
+	values []string
+}
+
+func (b billRecord) get(name string) string {
+	i, ok := b.header[name]
+	if !ok || i >= len(b.values) {
+		return ""
+	}
+	return cleanBillField(b.values[i])
+}
+
+// 金额字段 元 -> 分,出错时记录第一个错误
+func (b billRecord) fen(name string, errp *error) money.Amount {
+	value := b.get(name)
+	if value == "" || *errp != nil {
+		return money.Amount{}
+	}
+	fen, err := money.ParseYuan(value)
+	if err != nil {
+		*errp = fmt.Errorf("bill field %v: %w", name, err)
+	}
+	return fen
+}
+
+func (b billRecord) int(name string, errp *error) int {
+	value := b.get(name)
+	if value == "" || *errp != nil {
+		return 0
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		*errp = fmt.Errorf("bill field %v: %w", name, err)
+	}
+	return v
+}
@@ -0,0 +1,98 @@
+package wechatpay
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"testing"
+)
+
+func TestParseTradeBill(t *testing.T) {
+	f, err := os.Open("testdata/tradebill.csv")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer f.Close()
+	tradeBill, err := ParseTradeBill(f)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(tradeBill.Rows) != 3 {
+		t.Errorf("unexpected rows(%v)", len(tradeBill.Rows))
+		return
+	}
+	row := tradeBill.Rows[1]
+	if row.OutTradeNo != "native124" || row.TradeState != "SUCCESS" || row.SettlementTotal.Minor() != 1234 || row.Fee.Minor() != 7 || row.Attach != "order=124" {
+		t.Errorf("unexpected row(%+v)", row)
+	}
+	refundRow := tradeBill.Rows[2]
+	if refundRow.OutRefundNo != "refund123" || refundRow.RefundAmount.Minor() != 3000 || refundRow.Fee.Minor() != -18 {
+		t.Errorf("unexpected refund row(%+v)", refundRow)
+	}
+	summary := tradeBill.Summary
+	if summary == nil || summary.TotalCount != 3 || summary.SettlementTotal.Minor() != 11234 || summary.Fee.Minor() != 49 || summary.RefundAmount.Minor() != 3000 {
+		t.Errorf("unexpected summary(%+v)", summary)
+	}
+}
+
+func TestParseFundFlowBill(t *testing.T) {
+	f, err := os.Open("testdata/fundflowbill.csv")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer f.Close()
+	fundFlowBill, err := ParseFundFlowBill(f)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(fundFlowBill.Rows) != 2 {
+		t.Errorf("unexpected rows(%v)", len(fundFlowBill.Rows))
+		return
+	}
+	row := fundFlowBill.Rows[1]
+	if row.IncomeType != "支出" || row.Amount.Minor() != 2982 || row.Balance.Minor() != 106958 || row.VoucherNo != "refund123" {
+		t.Errorf("unexpected row(%+v)", row)
+	}
+	summary := fundFlowBill.Summary
+	if summary == nil || summary.TotalCount != 2 || summary.IncomeAmount.Minor() != 9940 || summary.ExpenseCount != 1 {
+		t.Errorf("unexpected summary(%+v)", summary)
+	}
+}
+
+func TestReadBill(t *testing.T) {
+	data, err := os.ReadFile("testdata/tradebill.csv")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	sum := sha1.Sum(data)
+	hashValue := hex.EncodeToString(sum[:])
+
+	// 数据流
+	if _, err = readBill(data, "", "SHA1", hashValue); err != nil {
+		t.Error(err)
+	}
+	// GZIP
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	gzipWriter.Write(data)
+	gzipWriter.Close()
+	raw, err := readBill(buf.Bytes(), TarTypeGzip, "SHA1", hashValue)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !bytes.Equal(raw, data) {
+		t.Error("gunzip bill mismatch")
+	}
+	// 摘要不一致
+	if _, err = readBill(data, "", "SHA1", "da39a3ee5e6b4b0d3255bfef95601890afd80709"); err == nil {
+		t.Error("bill hash mismatch but no return err")
+	}
+}
@@ -0,0 +1,50 @@
+package wechatpay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/wechatpay-apiv3/wechatpay-go/core"
+)
+
+// 微信支付API v3 域名
+const apiDomain = "https://api.mch.weixin.qq.com"
+
+/*
+[newClient] 使用DefaultConfig及path处的商户私钥初始化 client，并使它具有自动定时获取微信支付平台证书的能力
+path:本地文件中商户私钥的位置
+*/
+func newClient(ctx context.Context, path string) (*core.Client, error) {
+	return DefaultConfig.withPrivateKeyPath(path).NewClient(ctx)
+}
+
+/*
+[doRequest] 发起请求并将应答body解析到res
+header: 额外的请求头,如敏感信息加密时的Wechatpay-Serial
+body: 请求体,GET请求传nil
+res: 应答结构,传nil时忽略应答body(如204 No Content)
+*/
+func doRequest(ctx context.Context, client *core.Client, method, url string, header http.Header, body, res interface{}) error {
+	result, err := client.Request(ctx, method, url, header, nil, body, "")
+	if err != nil {
+		fmt.Printf("doRequest-> %v (%v) error(%v)", method, url, err)
+		return err
+	}
+	defer result.Response.Body.Close()
+	if res == nil {
+		return nil
+	}
+	byteBody, err := ioutil.ReadAll(result.Response.Body)
+	if err != nil {
+		fmt.Printf("doRequest-> read response(%v) body error(%v)", result.Response, err)
+		return err
+	}
+	if err = json.Unmarshal(byteBody, res); err != nil {
+		fmt.Printf("doRequest-> Unmarshal body(%v) error(%v)", string(byteBody), err)
+		return err
+	}
+	return nil
+}
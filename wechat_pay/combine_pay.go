@@ -0,0 +1,332 @@
+package wechatpay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+)
+
+//合单支付API详情请查阅:https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter5_1_1.shtml
+//一笔合单支付由多个子单(sub_orders)组成,每个子单对应一个子商户订单,最多支持50个子单
+
+//示例
+/*
+{
+	"combine_appid": "wxd678efh567hg6787",
+	"combine_mchid": "1900000109",
+	"combine_out_trade_no": "P20150806125346",
+	"sub_orders": [{
+		"mchid": "1900000109",
+		"attach": "深圳分店",
+		"amount": {
+			"total_amount": 10,
+			"currency": "CNY"
+		},
+		"out_trade_no": "20150806125346",
+		"description": "腾讯充值中心-QQ会员充值"
+	}],
+	"notify_url": "https://yourapp.com/notify"
+}
+*/
+
+// 合单交易类型
+const (
+	CombineTradeTypeNative = "native"
+	CombineTradeTypeJsapi  = "jsapi"
+	CombineTradeTypeH5     = "h5"
+)
+
+type CombinePay interface {
+	CombineNative(path string) (*NativeRes, error)
+	CombineJsapi(path string) (*JsapiRes, error)
+	CombineH5(path string) (*H5Res, error)
+	QueryCombine(path string) (*CombineTransaction, error)
+	CloseCombine(path string) error
+}
+
+var _ CombinePay = &CombineReq{}
+
+// 合单下单req
+type CombineReq struct {
+	CombineAppId      string            `json:"combine_appid"`                //合单发起方的appid
+	CombineMchId      string            `json:"combine_mchid"`                //合单发起方商户号
+	CombineOutTradeNo string            `json:"combine_out_trade_no"`         //合单商户订单号
+	SceneInfo         *CombineSceneInfo `json:"scene_info,omitempty"`         //[非必填]场景信息 H5下单必填
+	SubOrders         []*SubOrder       `json:"sub_orders"`                   //子单信息
+	CombinePayerInfo  *CombinePayerInfo `json:"combine_payer_info,omitempty"` //[非必填]支付者 JSAPI下单必填
+	TimeStart         string            `json:"time_start,omitempty"`         //[非必填]交易起始时间
+	TimeExpire        string            `json:"time_expire,omitempty"`        //[非必填]交易结束时间
+	NotifyUrl         string            `json:"notify_url"`                   //通知地址
+	Debug             bool              `json:"-"`
+}
+
+// 子单信息
+type SubOrder struct {
+	MchId       string         `json:"mchid"`                 //子单商户号
+	SubMchId    string         `json:"sub_mchid,omitempty"`   //[非必填]二级商户号 服务商模式下必填
+	Attach      string         `json:"attach"`                //附加数据
+	Amount      *CombineAmount `json:"amount"`                //订单金额
+	OutTradeNo  string         `json:"out_trade_no"`          //子单商户订单号
+	Description string         `json:"description"`           //商品描述
+	SettleInfo  *SettleInfo    `json:"settle_info,omitempty"` //[非必填]结算信息
+}
+
+// 子单金额
+type CombineAmount struct {
+	TotalAmount   money.Amount  `json:"total_amount"`             //标价金额 单位为分
+	Currency      string        `json:"currency"`                 //标价币种 CNY
+	PayerAmount   *money.Amount `json:"payer_amount,omitempty"`   //现金支付金额(查询及通知)
+	PayerCurrency string        `json:"payer_currency,omitempty"` //现金支付币种(查询及通知)
+}
+
+// 结算信息
+type SettleInfo struct {
+	ProfitSharing bool          `json:"profit_sharing"`           //是否指定分账
+	SubsidyAmount *money.Amount `json:"subsidy_amount,omitempty"` //[非必填]补差金额
+}
+
+// 场景信息
+type CombineSceneInfo struct {
+	DeviceId      string  `json:"device_id,omitempty"` //[非必填]商户端设备号
+	PayerClientIp string  `json:"payer_client_ip"`     //用户终端IP
+	H5Info        *H5Info `json:"h5_info,omitempty"`   //[非必填]H5场景信息 H5下单必填
+}
+
+// H5场景信息
+type H5Info struct {
+	Type        string `json:"type"`                   //场景类型 示例值：iOS, Android, Wap
+	AppName     string `json:"app_name,omitempty"`     //[非必填]应用名称
+	AppUrl      string `json:"app_url,omitempty"`      //[非必填]网站URL
+	BundleId    string `json:"bundle_id,omitempty"`    //[非必填]iOS平台BundleID
+	PackageName string `json:"package_name,omitempty"` //[非必填]Android平台PackageName
+}
+
+// 合单支付者
+type CombinePayerInfo struct {
+	OpenId string `json:"openid"` //用户在合单发起方appid下的唯一标识
+}
+
+type JsapiRes struct {
+	PrepayId string `json:"prepay_id"` //预支付交易会话标识 有效期为2小时
+}
+
+type H5Res struct {
+	H5Url string `json:"h5_url"` //支付跳转链接 有效期为5分钟
+}
+
+// 合单查询结果&合单支付通知resource解密后的结构
+type CombineTransaction struct {
+	CombineAppId      string                 `json:"combine_appid"`                //合单发起方的appid
+	CombineMchId      string                 `json:"combine_mchid"`                //合单发起方商户号
+	CombineOutTradeNo string                 `json:"combine_out_trade_no"`         //合单商户订单号
+	SceneInfo         *CombineSceneInfo      `json:"scene_info,omitempty"`         //场景信息
+	SubOrders         []*CombineSubOrderInfo `json:"sub_orders"`                   //子单信息
+	CombinePayerInfo  *CombinePayerInfo      `json:"combine_payer_info,omitempty"` //支付者
+}
+
+// 子单支付结果
+type CombineSubOrderInfo struct {
+	MchId          string         `json:"mchid"`                      //子单商户号
+	SubMchId       string         `json:"sub_mchid,omitempty"`        //二级商户号
+	TradeType      string         `json:"trade_type"`                 //交易类型
+	TradeState     string         `json:"trade_state"`                //交易状态 示例值：SUCCESS
+	TradeStateDesc string         `json:"trade_state_desc,omitempty"` //交易状态描述
+	BankType       string         `json:"bank_type,omitempty"`        //付款银行
+	Attach         string         `json:"attach"`                     //附加数据
+	SuccessTime    string         `json:"success_time,omitempty"`     //支付完成时间
+	TransactionId  string         `json:"transaction_id,omitempty"`   //微信支付订单号
+	OutTradeNo     string         `json:"out_trade_no"`               //子单商户订单号
+	Amount         *CombineAmount `json:"amount"`                     //订单金额
+}
+
+// 关闭合单req
+type combineCloseReq struct {
+	CombineAppId string                  `json:"combine_appid"`
+	SubOrders    []*combineCloseSubOrder `json:"sub_orders"`
+}
+
+type combineCloseSubOrder struct {
+	MchId      string `json:"mchid"`
+	SubMchId   string `json:"sub_mchid,omitempty"`
+	OutTradeNo string `json:"out_trade_no"`
+}
+
+func NewCombineReq(combineAppId, combineMchId, combineOutTradeNo, notifyUrl string, subOrders ...*SubOrder) *CombineReq {
+	return &CombineReq{
+		CombineAppId:      combineAppId,
+		CombineMchId:      combineMchId,
+		CombineOutTradeNo: combineOutTradeNo,
+		NotifyUrl:         notifyUrl,
+		SubOrders:         subOrders,
+	}
+}
+
+/*
+[CombineNative]-> 合单Native下单 POST https://api.mch.weixin.qq.com/v3/combine-transactions/native
+path:本地文件中商户私钥的位置
+*/
+func (c *CombineReq) CombineNative(path string) (*NativeRes, error) {
+	nativeRes := &NativeRes{}
+	if err := c.commit(path, CombineTradeTypeNative, nativeRes); err != nil {
+		return nil, err
+	}
+	return nativeRes, nil
+}
+
+/*
+[CombineJsapi]-> 合单JSAPI下单 POST https://api.mch.weixin.qq.com/v3/combine-transactions/jsapi
+需设置CombinePayerInfo
+path:本地文件中商户私钥的位置
+*/
+func (c *CombineReq) CombineJsapi(path string) (*JsapiRes, error) {
+	if c.CombinePayerInfo == nil || c.CombinePayerInfo.OpenId == "" {
+		return nil, errors.New("CombineJsapi-> CombinePayerInfo.OpenId can not be empty")
+	}
+	jsapiRes := &JsapiRes{}
+	if err := c.commit(path, CombineTradeTypeJsapi, jsapiRes); err != nil {
+		return nil, err
+	}
+	return jsapiRes, nil
+}
+
+/*
+[CombineH5]-> 合单H5下单 POST https://api.mch.weixin.qq.com/v3/combine-transactions/h5
+需设置SceneInfo.H5Info
+path:本地文件中商户私钥的位置
+*/
+func (c *CombineReq) CombineH5(path string) (*H5Res, error) {
+	if c.SceneInfo == nil || c.SceneInfo.H5Info == nil {
+		return nil, errors.New("CombineH5-> SceneInfo.H5Info can not be nil")
+	}
+	h5Res := &H5Res{}
+	if err := c.commit(path, CombineTradeTypeH5, h5Res); err != nil {
+		return nil, err
+	}
+	return h5Res, nil
+}
+
+func (c *CombineReq) commit(path, tradeType string, res interface{}) error {
+	if len(c.SubOrders) == 0 {
+		return errors.New("CombineReq-> SubOrders can not be empty")
+	}
+	ctx := context.Background()
+	client, err := newClient(ctx, path)
+	if err != nil {
+		fmt.Printf("CombineReq-> newClient error(%v)", err)
+		return err
+	}
+	Debug(c.Debug, "Init client(%v) done", client)
+	url := DefaultConfig.domain() + "/v3/combine-transactions/" + tradeType
+	if err = doRequest(ctx, client, http.MethodPost, url, nil, c, res); err != nil {
+		fmt.Printf("CombineReq-> commit %v error(%v)", tradeType, err)
+		return err
+	}
+	Debug(c.Debug, "Combine %v pre pay success, res: %v", tradeType, res)
+	return nil
+}
+
+/*
+[QueryCombine]-> 合单查询 GET https://api.mch.weixin.qq.com/v3/combine-transactions/out-trade-no/{combine_out_trade_no}
+path:本地文件中商户私钥的位置
+*/
+func (c *CombineReq) QueryCombine(path string) (*CombineTransaction, error) {
+	if c.CombineOutTradeNo == "" {
+		return nil, errors.New("QueryCombine-> CombineOutTradeNo can not be empty")
+	}
+	ctx := context.Background()
+	client, err := newClient(ctx, path)
+	if err != nil {
+		fmt.Printf("QueryCombine-> newClient error(%v)", err)
+		return nil, err
+	}
+	combineTransaction := &CombineTransaction{}
+	url := DefaultConfig.domain() + "/v3/combine-transactions/out-trade-no/" + neturl.PathEscape(c.CombineOutTradeNo)
+	if err = doRequest(ctx, client, http.MethodGet, url, nil, nil, combineTransaction); err != nil {
+		return nil, err
+	}
+	Debug(c.Debug, "query combine transaction(%v)", combineTransaction)
+	return combineTransaction, nil
+}
+
+/*
+[CloseCombine]-> 合单关单 POST https://api.mch.weixin.qq.com/v3/combine-transactions/out-trade-no/{combine_out_trade_no}/close
+关闭CombineReq.SubOrders中的所有子单
+path:本地文件中商户私钥的位置
+*/
+func (c *CombineReq) CloseCombine(path string) error {
+	if c.CombineOutTradeNo == "" || len(c.SubOrders) == 0 {
+		return errors.New("CloseCombine-> CombineOutTradeNo and SubOrders can not be empty")
+	}
+	closeReq := &combineCloseReq{CombineAppId: c.CombineAppId}
+	for _, subOrder := range c.SubOrders {
+		closeReq.SubOrders = append(closeReq.SubOrders, &combineCloseSubOrder{
+			MchId:      subOrder.MchId,
+			SubMchId:   subOrder.SubMchId,
+			OutTradeNo: subOrder.OutTradeNo,
+		})
+	}
+	ctx := context.Background()
+	client, err := newClient(ctx, path)
+	if err != nil {
+		fmt.Printf("CloseCombine-> newClient error(%v)", err)
+		return err
+	}
+	url := DefaultConfig.domain() + "/v3/combine-transactions/out-trade-no/" + neturl.PathEscape(c.CombineOutTradeNo) + "/close"
+	if err = doRequest(ctx, client, http.MethodPost, url, nil, closeReq, nil); err != nil {
+		return err
+	}
+	Debug(c.Debug, "close combine transaction(%v) done", c.CombineOutTradeNo)
+	return nil
+}
+
+/*
+[CombineCommit]->上层调用进行合单下单
+path:本地文件中商户私钥的位置
+tradeType: native/jsapi/h5
+返回值分别为*NativeRes/*JsapiRes/*H5Res
+*/
+func CombineCommit(path, tradeType string, combineReq CombinePay) (interface{}, error) {
+	if combineReq == nil {
+		fmt.Printf("CombineCommit-> combineReq can not be nil")
+		return nil, errors.New("CombineCommit-> combineReq can not be nil")
+	}
+	switch tradeType {
+	case CombineTradeTypeNative:
+		return combineReq.CombineNative(path)
+	case CombineTradeTypeJsapi:
+		return combineReq.CombineJsapi(path)
+	case CombineTradeTypeH5:
+		return combineReq.CombineH5(path)
+	}
+	return nil, fmt.Errorf("CombineCommit-> unsupported tradeType(%v)", tradeType)
+}
+
+/*
+[CombineNotifyHandle]
+处理合单支付通知,每次通知都解密到新的*CombineTransaction并传给options.OnCombine
+ctx: 上下文信息
+path: 示例 "/path/to/merchant/apiclient_key.pem"
+options: 提供钩子函数
+*/
+func CombineNotifyHandle(ctx context.Context, path string, options *Option) http.HandlerFunc {
+	return notifierHandle(ctx, path, func(n *Notifier) http.HandlerFunc {
+		return n.CombineNotifyHandle(ctx, options)
+	})
+}
+
+// [CombineNotifyHandle]-> 使用已创建的Notifier处理合单支付通知,见CombineNotifyHandle
+func (n *Notifier) CombineNotifyHandle(ctx context.Context, options *Option) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		combineTransaction := &CombineTransaction{}
+		n.serve(ctx, w, r, "CombineNotifyHandle", eventTransaction, combineTransaction, options, func(myNotifyReq *NotifyReq) error {
+			if options == nil || options.OnCombine == nil {
+				return nil
+			}
+			return options.OnCombine(ctx, myNotifyReq, combineTransaction)
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package wechatpay
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+	"github.com/tanjl855/Sms_Pay_SDK/paytest"
+)
+
+func TestCombineReqJSON(t *testing.T) {
+	subOrder := &SubOrder{
+		MchId:       "1900000109",
+		Attach:      "深圳分店",
+		Amount:      &CombineAmount{TotalAmount: money.Fen(10), Currency: "CNY"},
+		OutTradeNo:  "20150806125346",
+		Description: "腾讯充值中心-QQ会员充值",
+	}
+	c := NewCombineReq("wxd678efh567hg6787", "1900000109", "P20150806125346", "https://yourapp.com/notify", subOrder)
+	c.Debug = true
+	byteReq, err := json.Marshal(c)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	m := map[string]interface{}{}
+	if err = json.Unmarshal(byteReq, &m); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, ok := m["Debug"]; ok {
+		t.Error("Debug should not be sent to wechat pay")
+	}
+	subOrders := m["sub_orders"].([]interface{})
+	amount := subOrders[0].(map[string]interface{})["amount"].(map[string]interface{})
+	if amount["total_amount"] != float64(10) {
+		t.Errorf("unexpected amount(%v)", amount)
+	}
+}
+
+func TestCombineCommit(t *testing.T) {
+	c := NewCombineReq("wxd678efh567hg6787", "1900000109", "P20150806125346", "https://yourapp.com/notify")
+	if _, err := CombineCommit(".././key.pem", CombineTradeTypeNative, c); err == nil {
+		t.Error("empty sub_orders but no return err")
+	}
+	if _, err := CombineCommit(".././key.pem", CombineTradeTypeJsapi, c); err == nil {
+		t.Error("jsapi without combine_payer_info but no return err")
+	}
+	if _, err := CombineCommit(".././key.pem", "app", c); err == nil {
+		t.Error("unsupported tradeType but no return err")
+	}
+}
+
+func TestCombineTransactionUnmarshal(t *testing.T) {
+	plaintext := `{"combine_appid":"wxd678efh567hg6787","combine_mchid":"1900000109","combine_out_trade_no":"P20150806125346",
+	"sub_orders":[{"mchid":"1900000109","trade_type":"NATIVE","trade_state":"SUCCESS","bank_type":"CMC","attach":"深圳分店",
+	"success_time":"2015-05-20T13:29:35+08:00","transaction_id":"1009660380201506130728806387","out_trade_no":"20150806125346",
+	"amount":{"total_amount":10,"currency":"CNY","payer_amount":10,"payer_currency":"CNY"}}],
+	"combine_payer_info":{"openid":"oUpF8uMuAJO_M2pxb1Q9zNjWeS6o"}}`
+	combineTransaction := &CombineTransaction{}
+	if err := json.Unmarshal([]byte(plaintext), combineTransaction); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(combineTransaction.SubOrders) != 1 || combineTransaction.SubOrders[0].Amount.PayerAmount.Minor() != 10 {
+		t.Errorf("unexpected combineTransaction(%+v)", combineTransaction)
+	}
+}
+
+func TestCombineRequest(t *testing.T) {
+	fake := paytest.NewWechat()
+	defer fake.Close()
+	path := usePaytestConfig(t, fake)
+	var bodies []map[string]interface{}
+	record := func(res interface{}) paytest.WechatHandler {
+		return func(r *http.Request, body []byte) (int, interface{}) {
+			m := map[string]interface{}{}
+			if err := json.Unmarshal(body, &m); err != nil {
+				t.Error(err)
+			}
+			bodies = append(bodies, m)
+			if res == nil {
+				return http.StatusNoContent, nil
+			}
+			return http.StatusOK, res
+		}
+	}
+	fake.Handle(http.MethodPost, "/v3/combine-transactions/native", record(map[string]string{"code_url": "weixin://wxpay/bizpayurl/up?pr=NwY5Mz9"}))
+	fake.Handle(http.MethodPost, "/v3/combine-transactions/jsapi", record(map[string]string{"prepay_id": "wx201410272009395522657a690389285100"}))
+	fake.Handle(http.MethodPost, "/v3/combine-transactions/h5", record(map[string]string{"h5_url": "https://wx.tenpay.com/cgi-bin/mmpayweb-bin/checkmweb"}))
+	fake.Handle(http.MethodPost, "/v3/combine-transactions/out-trade-no/P20150806125346/close", record(nil))
+	fake.Handle(http.MethodGet, "/v3/combine-transactions/out-trade-no/", func(r *http.Request, body []byte) (int, interface{}) {
+		if r.URL.Path != "/v3/combine-transactions/out-trade-no/P20150806125346" {
+			t.Errorf("unexpected query path(%v)", r.URL.Path)
+		}
+		return http.StatusOK, map[string]interface{}{
+			"combine_appid":        "wxd678efh567hg6787",
+			"combine_mchid":        fake.MchId,
+			"combine_out_trade_no": "P20150806125346",
+			"sub_orders": []map[string]interface{}{{"mchid": fake.MchId, "trade_type": "NATIVE", "trade_state": "NOTPAY", "out_trade_no": "20150806125346",
+				"amount": map[string]interface{}{"total_amount": 10, "currency": "CNY"}}},
+		}
+	})
+
+	subOrder := &SubOrder{MchId: fake.MchId, Attach: "深圳分店", Amount: &CombineAmount{TotalAmount: money.Fen(10), Currency: "CNY"}, OutTradeNo: "20150806125346", Description: "腾讯充值中心-QQ会员充值"}
+	c := NewCombineReq("wxd678efh567hg6787", fake.MchId, "P20150806125346", "https://yourapp.com/notify", subOrder)
+	nativeRes, err := c.CombineNative(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nativeRes.CodeUrl != "weixin://wxpay/bizpayurl/up?pr=NwY5Mz9" {
+		t.Errorf("unexpected native res(%+v)", nativeRes)
+	}
+	c.CombinePayerInfo = &CombinePayerInfo{OpenId: "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o"}
+	if jsapiRes, err := c.CombineJsapi(path); err != nil || jsapiRes.PrepayId == "" {
+		t.Errorf("unexpected jsapi res(%+v) error(%v)", jsapiRes, err)
+	}
+	c.CombinePayerInfo = nil
+	c.SceneInfo = &CombineSceneInfo{PayerClientIp: "14.23.150.211", H5Info: &H5Info{Type: "iOS"}}
+	if h5Res, err := c.CombineH5(path); err != nil || h5Res.H5Url == "" {
+		t.Errorf("unexpected h5 res(%+v) error(%v)", h5Res, err)
+	}
+	transaction, err := c.QueryCombine(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transaction.CombineOutTradeNo != "P20150806125346" || len(transaction.SubOrders) != 1 || transaction.SubOrders[0].Amount.TotalAmount.Minor() != 10 {
+		t.Errorf("unexpected combine transaction(%+v)", transaction)
+	}
+	if err = c.CloseCombine(path); err != nil {
+		t.Error(err)
+	}
+
+	if len(bodies) != 4 {
+		t.Fatalf("unexpected requests(%v)", len(bodies))
+	}
+	for _, m := range bodies[:3] {
+		subOrders, _ := m["sub_orders"].([]interface{})
+		if m["combine_appid"] != "wxd678efh567hg6787" || m["combine_mchid"] != fake.MchId || m["combine_out_trade_no"] != "P20150806125346" ||
+			m["notify_url"] != "https://yourapp.com/notify" || len(subOrders) != 1 {
+			t.Errorf("unexpected combine request(%v)", m)
+			continue
+		}
+		amount, _ := subOrders[0].(map[string]interface{})["amount"].(map[string]interface{})
+		if amount["total_amount"] != float64(10) || amount["currency"] != "CNY" {
+			t.Errorf("unexpected sub order amount(%v)", amount)
+		}
+	}
+	if payer, _ := bodies[1]["combine_payer_info"].(map[string]interface{}); payer["openid"] != "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o" {
+		t.Errorf("unexpected combine_payer_info(%v)", bodies[1]["combine_payer_info"])
+	}
+	if sceneInfo, _ := bodies[2]["scene_info"].(map[string]interface{}); sceneInfo["payer_client_ip"] != "14.23.150.211" || sceneInfo["h5_info"] == nil {
+		t.Errorf("unexpected scene_info(%v)", bodies[2]["scene_info"])
+	}
+	//关单只传combine_appid及子单的mchid、out_trade_no
+	closeBody, _ := json.Marshal(bodies[3])
+	if string(closeBody) != `{"combine_appid":"wxd678efh567hg6787","sub_orders":[{"mchid":"`+fake.MchId+`","out_trade_no":"20150806125346"}]}` {
+		t.Errorf("unexpected close request(%s)", closeBody)
+	}
+}
@@ -0,0 +1,249 @@
+package wechatpay
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+
+	"github.com/wechatpay-apiv3/wechatpay-go/core"
+	"github.com/wechatpay-apiv3/wechatpay-go/core/downloader"
+	"github.com/wechatpay-apiv3/wechatpay-go/core/option"
+	"github.com/wechatpay-apiv3/wechatpay-go/utils"
+)
+
+//Config 商户配置(商户号、证书序列号、APIv3密钥、商户私钥、平台证书、域名)
+//传入path的接口(NativeCommit、RefundCommit、NotifyHandle等)使用DefaultConfig,商户私钥取传入的path
+//多商户或需要替换域名(如测试环境)时,创建Config并调用其方法
+
+type Config struct {
+	MchId                      string              //商户号
+	MchCertificateSerialNumber string              //商户API证书序列号
+	MchAPIv3Key                string              //商户APIv3密钥
+	PrivateKeyPath             string              //商户私钥位置 示例 "/path/to/merchant/apiclient_key.pem"
+	PrivateKey                 *rsa.PrivateKey     //[非必填]已加载的商户私钥,不为nil时忽略PrivateKeyPath
+	PlatformCertificates       []*x509.Certificate //[非必填]微信支付平台证书,为空时注册证书下载器自动更新(下载器固定访问微信支付域名)
+	Domain                     string              //[非必填]默认https://api.mch.weixin.qq.com
+	HttpClient                 *http.Client        //[非必填]默认使用wechatpay-go的http.Client
+	Debug                      bool
+}
+
+// 默认商户配置 使用传入path的接口前请替换为真实的商户信息
+var DefaultConfig = &Config{
+	MchId:                      "190000****",                               // 商户号
+	MchCertificateSerialNumber: "3775B6A45ACD588826D15E583A95F5DD********", // 商户证书序列号
+	MchAPIv3Key:                "2ab9****************************",         // 商户APIv3密钥
+}
+
+func NewConfig(mchId, mchCertificateSerialNumber, mchAPIv3Key, privateKeyPath string) *Config {
+	return &Config{
+		MchId:                      mchId,
+		MchCertificateSerialNumber: mchCertificateSerialNumber,
+		MchAPIv3Key:                mchAPIv3Key,
+		PrivateKeyPath:             privateKeyPath,
+	}
+}
+
+// 复制配置并使用path处的商户私钥
+func (c *Config) withPrivateKeyPath(path string) *Config {
+	config := *c
+	config.PrivateKeyPath = path
+	config.PrivateKey = nil
+	return &config
+}
+
+func (c *Config) domain() string {
+	if c.Domain == "" {
+		return apiDomain
+	}
+	return c.Domain
+}
+
+func (c *Config) privateKey() (*rsa.PrivateKey, error) {
+	if c.PrivateKey != nil {
+		return c.PrivateKey, nil
+	}
+	mchPrivateKey, err := utils.LoadPrivateKeyWithPath(c.PrivateKeyPath)
+	if err != nil {
+		fmt.Printf("Config-> LoadPrivateKeyWithPath(%v) error(%v)", c.PrivateKeyPath, err)
+		return nil, err
+	}
+	return mchPrivateKey, nil
+}
+
+/*
+[NewClient]-> 使用商户私钥等初始化client
+配置了PlatformCertificates时使用固定的平台证书,否则具有自动定时获取微信支付平台证书的能力
+*/
+func (c *Config) NewClient(ctx context.Context) (*core.Client, error) {
+	mchPrivateKey, err := c.privateKey()
+	if err != nil {
+		return nil, err
+	}
+	var opts []core.ClientOption
+	if len(c.PlatformCertificates) > 0 {
+		opts = append(opts, option.WithWechatPayAuthCipher(c.MchId, c.MchCertificateSerialNumber, mchPrivateKey, c.PlatformCertificates))
+	} else {
+		opts = append(opts, option.WithWechatPayAutoAuthCipher(c.MchId, c.MchCertificateSerialNumber, mchPrivateKey, c.MchAPIv3Key))
+	}
+	if c.HttpClient != nil {
+		opts = append(opts, option.WithHTTPClient(c.HttpClient))
+	}
+	client, err := core.NewClient(ctx, opts...)
+	if err != nil {
+		fmt.Printf("Config.NewClient-> NewClient error(%v)", err)
+		return nil, err
+	}
+	return client, nil
+}
+
+/*
+[NewNotifier]-> 创建通知验签及解密器
+配置了PlatformCertificates时使用固定的平台证书,否则注册证书下载器
+*/
+func (c *Config) NewNotifier(ctx context.Context) (*Notifier, error) {
+	if len(c.PlatformCertificates) > 0 {
+		return NewNotifierWithCertificates(c.MchAPIv3Key, c.PlatformCertificates...)
+	}
+	mchPrivateKey, err := c.privateKey()
+	if err != nil {
+		return nil, err
+	}
+	// 1. 使用 `RegisterDownloaderWithPrivateKey` 注册下载器
+	err = downloader.MgrInstance().RegisterDownloaderWithPrivateKey(ctx, mchPrivateKey, c.MchCertificateSerialNumber, c.MchId, c.MchAPIv3Key)
+	if err != nil {
+		fmt.Printf("Config.NewNotifier-> RegisterDownloaderWithPrivateKey error(%v)", err)
+		return nil, err
+	}
+	// 2. 获取商户号对应的微信支付平台证书访问器
+	// 3. 使用证书访问器初始化 `notify.Handler`
+	return newNotifier(c.MchAPIv3Key, downloader.MgrInstance().GetCertificateVisitor(c.MchId))
+}
+
+// 平台证书 未配置PlatformCertificates时使用NewClient/NewNotifier注册的证书下载器
+func (c *Config) certificateGetter() core.CertificateGetter {
+	if len(c.PlatformCertificates) > 0 {
+		return core.NewCertificateMapWithList(c.PlatformCertificates)
+	}
+	return downloader.MgrInstance().GetCertificateVisitor(c.MchId)
+}
+
+/*
+[NativePrepay]-> Native下单 POST https://api.mch.weixin.qq.com/v3/pay/transactions/native
+appId: 应用ID,商户号使用Config.MchId
+*/
+func (c *Config) NativePrepay(ctx context.Context, appId string, n *NativeReq) (*NativeRes, error) {
+	if n == nil {
+		return nil, errors.New("NativePrepay-> NativeReq can not be nil")
+	}
+	client, err := c.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	n.AppId = appId
+	n.MchId = c.MchId
+	n.PayType = payTpye //更新当前支付类型
+	nativeRes := &NativeRes{}
+	url := c.domain() + "/v3/pay/transactions/native"
+	if err = doRequest(ctx, client, http.MethodPost, url, nil, n, nativeRes); err != nil {
+		return nil, err
+	}
+	Debug(c.Debug || n.Debug, "Pre pay success, nativeRes: %v", nativeRes)
+	return nativeRes, nil
+}
+
+/*
+[QueryOrder]-> 商户订单号查询订单 GET https://api.mch.weixin.qq.com/v3/pay/transactions/out-trade-no/{out_trade_no}?mchid=
+返回的*NativeReq与支付通知解密后的结构一致
+*/
+func (c *Config) QueryOrder(ctx context.Context, outTradeNo string) (*NativeReq, error) {
+	if outTradeNo == "" {
+		return nil, errors.New("QueryOrder-> outTradeNo can not be empty")
+	}
+	client, err := c.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	url := c.domain() + "/v3/pay/transactions/out-trade-no/" + neturl.PathEscape(outTradeNo) + "?mchid=" + neturl.QueryEscape(c.MchId)
+	transaction := &NativeReq{}
+	if err = doRequest(ctx, client, http.MethodGet, url, nil, nil, transaction); err != nil {
+		return nil, err
+	}
+	Debug(c.Debug, "query transaction(%v)", transaction)
+	return transaction, nil
+}
+
+// 关闭订单请求
+type closeReq struct {
+	MchId string `json:"mchid"` //直连商户号
+}
+
+/*
+[CloseOrder]-> 关闭订单 POST https://api.mch.weixin.qq.com/v3/pay/transactions/out-trade-no/{out_trade_no}/close
+*/
+func (c *Config) CloseOrder(ctx context.Context, outTradeNo string) error {
+	if outTradeNo == "" {
+		return errors.New("CloseOrder-> outTradeNo can not be empty")
+	}
+	client, err := c.NewClient(ctx)
+	if err != nil {
+		return err
+	}
+	url := c.domain() + "/v3/pay/transactions/out-trade-no/" + neturl.PathEscape(outTradeNo) + "/close"
+	if err = doRequest(ctx, client, http.MethodPost, url, nil, &closeReq{MchId: c.MchId}, nil); err != nil {
+		return err
+	}
+	Debug(c.Debug, "close transaction(%v) done", outTradeNo)
+	return nil
+}
+
+/*
+[Refund]-> 申请退款 POST https://api.mch.weixin.qq.com/v3/refund/domestic/refunds
+设置SuccessTime时校验支付完成时间未超过一年,为空时不校验
+*/
+func (c *Config) Refund(ctx context.Context, refund *RefundReq) (*RefundResp, error) {
+	if refund == nil {
+		return nil, errors.New("Refund-> refundReq can not be nil")
+	}
+	if refund.SuccessTime != "" && !CheckDate(refund.SuccessTime) {
+		return nil, errors.New("Refund-> SuccessTime more than a year")
+	}
+	client, err := c.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	refundRes := &RefundResp{}
+	url := c.domain() + "/v3/refund/domestic/refunds"
+	if err = doRequest(ctx, client, http.MethodPost, url, nil, refund, refundRes); err != nil {
+		return nil, err
+	}
+	Debug(c.Debug || refund.Debug, "refund response(%v)", refundRes)
+	return refundRes, nil
+}
+
+/*
+[QueryRefund]-> 查询单笔退款 GET https://api.mch.weixin.qq.com/v3/refund/domestic/refunds/{out_refund_no}
+subMchId: 服务商模式下的子商户号,直连商户传空
+*/
+func (c *Config) QueryRefund(ctx context.Context, outRefundNo, subMchId string) (*RefundResp, error) {
+	if outRefundNo == "" {
+		return nil, errors.New("QueryRefund-> OutRefundNo can not be empty")
+	}
+	client, err := c.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	url := c.domain() + "/v3/refund/domestic/refunds/" + neturl.PathEscape(outRefundNo)
+	if subMchId != "" {
+		url += "?sub_mchid=" + neturl.QueryEscape(subMchId)
+	}
+	refundRes := &RefundResp{}
+	if err = doRequest(ctx, client, http.MethodGet, url, nil, nil, refundRes); err != nil {
+		return nil, err
+	}
+	Debug(c.Debug, "query refund response(%v)", refundRes)
+	return refundRes, nil
+}
@@ -0,0 +1,120 @@
+package wechatpay
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+	"github.com/tanjl855/Sms_Pay_SDK/paytest"
+)
+
+// 接入模拟微信支付的商户配置
+func newTestConfig(fake *paytest.Wechat) *Config {
+	config := NewConfig(fake.MchId, fake.MchCertificateSerialNumber, fake.APIv3Key, "")
+	config.PrivateKey = fake.MchPrivateKey
+	config.PlatformCertificates = []*x509.Certificate{fake.PlatformCertificate}
+	config.Domain = fake.URL
+	return config
+}
+
+func TestConfig(t *testing.T) {
+	fake := paytest.NewWechat()
+	defer fake.Close()
+	config := newTestConfig(fake)
+	ctx := context.Background()
+
+	nativeRes, err := config.NativePrepay(ctx, "wxd678efh567hg6787", NewNativeReq("Image形象店-深圳腾大-QQ公仔", "1217752501201407033233368018", "https://www.weixin.qq.com/wxpay/pay.php", NativeAmount{Total: money.Fen(100)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nativeRes.CodeUrl == "" {
+		t.Error("code_url should not be empty")
+	}
+	if order, ok := fake.Order("1217752501201407033233368018"); !ok || order.AppId != "wxd678efh567hg6787" || order.Total.Minor() != 100 {
+		t.Errorf("unexpected native order(%+v, %v)", order, ok)
+	}
+
+	transaction, err := config.QueryOrder(ctx, "1217752501201407033233368018")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transaction.TradeState != TradeStateNotPay || transaction.Amount.Total.Minor() != 100 {
+		t.Errorf("unexpected transaction(%+v)", transaction)
+	}
+
+	if err = config.CloseOrder(ctx, "1217752501201407033233368018"); err != nil {
+		t.Error(err)
+	}
+	if order, _ := fake.Order("1217752501201407033233368018"); order.TradeState != paytest.WechatClosed {
+		t.Errorf("unexpected trade state(%v)", order.TradeState)
+	}
+
+	//已支付的订单申请退款
+	notify := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer notify.Close()
+	if _, err = config.NativePrepay(ctx, "wxd678efh567hg6787", NewNativeReq("Image形象店-深圳腾大-QQ公仔", "1217752501201407033233368019", notify.URL, NativeAmount{Total: money.Fen(100)})); err != nil {
+		t.Fatal(err)
+	}
+	if err = fake.Pay("1217752501201407033233368019"); err != nil {
+		t.Fatal(err)
+	}
+	refundRes, err := config.Refund(ctx, NewRefundReq("1217752501201407033233368019", "1217752501201407033233368019-1", &RefundAmount{Refund: money.Fen(50), Total: money.Fen(100), Currency: "CNY"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refundRes.Status != RefundStatusProcessing || refundRes.Amount.Refund.Minor() != 50 {
+		t.Errorf("unexpected refund(%+v)", refundRes)
+	}
+	if err = fake.CompleteRefund("1217752501201407033233368019-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	refundRes, err = config.QueryRefund(ctx, "1217752501201407033233368019-1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refundRes.Status != RefundStatusSuccess {
+		t.Errorf("unexpected refund status(%v)", refundRes.Status)
+	}
+
+	if _, err = config.QueryOrder(ctx, "unknown"); err == nil {
+		t.Error("query unknown order should fail")
+	}
+}
+
+func TestConfigNotifier(t *testing.T) {
+	fake := paytest.NewWechat()
+	defer fake.Close()
+	config := newTestConfig(fake)
+	notifier, err := config.NewNotifier(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	nativeReq := &NativeReq{}
+	notify := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := notifier.Parse(r.Context(), r, nativeReq); err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer notify.Close()
+	if _, err = config.NativePrepay(context.Background(), "wxd678efh567hg6787", NewNativeReq("Image形象店-深圳腾大-QQ公仔", "1217752501201407033233368018", notify.URL, NativeAmount{Total: money.Fen(100)})); err != nil {
+		t.Fatal(err)
+	}
+	if err = fake.Pay("1217752501201407033233368018"); err != nil {
+		t.Fatal(err)
+	}
+	if nativeReq.OutTradeNo != "1217752501201407033233368018" || nativeReq.TradeState != TradeStateSuccess {
+		t.Errorf("unexpected transaction(%+v)", nativeReq)
+	}
+	if config.withPrivateKeyPath("/path/to/key.pem").PrivateKey != nil || config.PrivateKey == nil {
+		t.Error("withPrivateKeyPath should copy config")
+	}
+}
@@ -0,0 +1,259 @@
+package wechatpay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+)
+
+//付款码支付API详情请查阅:https://pay.weixin.qq.com/wiki/doc/api/micropay.php?chapter=9_10&index=1
+//v3没有直连商户付款码支付,使用v2 micropay
+//1.提交付款码支付,用户需要输入密码时返回USERPAYING
+//2.USERPAYING或结果未知时轮询orderquery,直到SUCCESS或失败
+//3.ctx超时仍未支付成功时调用reverse撤销订单(撤销接口需要商户API证书,见V2Client.WithCertificate)
+
+// 收银台推荐的轮询间隔
+const defaultPollInterval = 5 * time.Second
+
+// 撤销订单最多重试次数(recall=Y时需要重试)
+const maxReverseTimes = 3
+
+// 需要轮询查单的错误码
+var micropayUnknownErrCodes = map[string]bool{
+	"USERPAYING":  true, //用户支付中,需要输入密码
+	"SYSTEMERROR": true, //系统超时
+	"BANKERROR":   true, //银行系统异常
+}
+
+type Micropay interface {
+	Micropay(ctx context.Context, client *V2Client) (*MicropayResult, error)
+}
+
+var _ Micropay = &MicropayReq{}
+
+// 付款码支付req POST https://api.mch.weixin.qq.com/pay/micropay
+type MicropayReq struct {
+	Body           string        //商品描述
+	OutTradeNo     string        //商户订单号
+	TotalFee       money.Amount  //订单金额 单位为分
+	FeeType        string        //[非必填]货币类型 默认CNY
+	SpbillCreateIp string        //终端IP
+	AuthCode       string        //付款码 用户付款码18位纯数字,以10、11、12、13、14、15开头
+	Attach         string        //[非必填]附加数据
+	DeviceInfo     string        //[非必填]终端设备号
+	TimeExpire     string        //[非必填]交易结束时间 格式yyyyMMddHHmmss
+	PollInterval   time.Duration //[非必填]查单间隔 默认5s,撤销需要重试时以该间隔起逐次翻倍
+	Debug          bool
+}
+
+// 付款码支付结果
+type MicropayResult struct {
+	OutTradeNo     string       //商户订单号
+	TransactionId  string       //微信支付订单号
+	TradeState     string       //交易状态 与NativeReq.TradeState取值一致
+	TradeStateDesc string       //交易状态描述
+	OpenId         string       //用户标识
+	BankType       string       //付款银行
+	TotalFee       money.Amount //订单金额
+	CashFee        money.Amount //现金支付金额
+	TimeEnd        string       //支付完成时间 格式yyyyMMddHHmmss
+	Attach         string       //附加数据
+}
+
+func NewMicropayReq(body, outTradeNo, authCode, spbillCreateIp string, totalFee money.Amount) *MicropayReq {
+	return &MicropayReq{
+		Body:           body,
+		OutTradeNo:     outTradeNo,
+		AuthCode:       authCode,
+		SpbillCreateIp: spbillCreateIp,
+		TotalFee:       totalFee,
+	}
+}
+
+/*
+[Micropay]-> 付款码支付 POST https://api.mch.weixin.qq.com/pay/micropay
+支付成功返回TradeState为SUCCESS的结果且error为nil,其余情况均返回error
+ctx到期仍未成功时撤销订单,返回TradeState为REVOKED的结果及包含ctx.Err()的error
+*/
+func (m *MicropayReq) Micropay(ctx context.Context, client *V2Client) (*MicropayResult, error) {
+	if client == nil {
+		return nil, errors.New("Micropay-> client can not be nil")
+	}
+	if m.OutTradeNo == "" || m.AuthCode == "" || !m.TotalFee.IsPositive() {
+		return nil, errors.New("Micropay-> OutTradeNo, AuthCode and TotalFee can not be empty")
+	}
+	params := V2Params{
+		"appid":            client.AppId,
+		"mch_id":           client.MchId,
+		"body":             m.Body,
+		"out_trade_no":     m.OutTradeNo,
+		"total_fee":        strconv.FormatInt(m.TotalFee.Minor(), 10),
+		"fee_type":         m.FeeType,
+		"spbill_create_ip": m.SpbillCreateIp,
+		"auth_code":        m.AuthCode,
+		"attach":           m.Attach,
+		"device_info":      m.DeviceInfo,
+		"time_expire":      m.TimeExpire,
+	}
+	res, err := client.Post(ctx, "/pay/micropay", params)
+	if err == nil {
+		result := toMicropayResult(res)
+		result.TradeState = TradeStateSuccess
+		Debug(m.Debug, "micropay(%v) success", m.OutTradeNo)
+		return result, nil
+	}
+	var v2Err *V2Error
+	if errors.As(err, &v2Err) && (v2Err.ReturnCode != v2Success || !micropayUnknownErrCodes[v2Err.ErrCode]) {
+		//明确失败 如付款码过期、余额不足
+		fmt.Printf("Micropay-> micropay(%v) error(%v)", m.OutTradeNo, err)
+		return &MicropayResult{OutTradeNo: m.OutTradeNo, TradeState: TradeStatePayError, TradeStateDesc: v2Err.ErrCodeDes}, err
+	}
+	Debug(m.Debug, "micropay(%v) unknown(%v), polling", m.OutTradeNo, err)
+
+	ticker := time.NewTicker(m.pollInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return m.reverse(ctx, client)
+		case <-ticker.C:
+		}
+		result, err := client.OrderQuery(ctx, m.OutTradeNo)
+		if err != nil {
+			//查单失败继续轮询,直到ctx到期
+			Debug(m.Debug, "micropay(%v) orderquery error(%v)", m.OutTradeNo, err)
+			continue
+		}
+		Debug(m.Debug, "micropay(%v) trade_state(%v)", m.OutTradeNo, result.TradeState)
+		switch result.TradeState {
+		case TradeStateSuccess:
+			return result, nil
+		case TradeStateUserPaying, TradeStateNotPay:
+			continue
+		default:
+			return result, fmt.Errorf("Micropay-> trade(%v) state(%v) desc(%v)", m.OutTradeNo, result.TradeState, result.TradeStateDesc)
+		}
+	}
+}
+
+func (m *MicropayReq) pollInterval() time.Duration {
+	if m.PollInterval <= 0 {
+		return defaultPollInterval
+	}
+	return m.PollInterval
+}
+
+// 超时撤销订单 ctx已到期,撤销使用新的context
+// recall=Y或撤销失败时等待后重试,间隔从查单间隔起逐次翻倍,总时长不超过reverseCtx
+func (m *MicropayReq) reverse(ctx context.Context, client *V2Client) (*MicropayResult, error) {
+	reverseCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var err error
+	interval := m.pollInterval()
+	for i := 0; i < maxReverseTimes; i++ {
+		if i > 0 {
+			if waitErr := sleepContext(reverseCtx, interval); waitErr != nil {
+				if err == nil {
+					err = waitErr
+				}
+				break
+			}
+			interval *= 2
+		}
+		var recall bool
+		recall, err = client.Reverse(reverseCtx, m.OutTradeNo)
+		if err == nil && !recall {
+			Debug(m.Debug, "micropay(%v) reversed", m.OutTradeNo)
+			return &MicropayResult{OutTradeNo: m.OutTradeNo, TradeState: TradeStateRevoked}, fmt.Errorf("Micropay-> trade(%v) reversed: %w", m.OutTradeNo, ctx.Err())
+		}
+		if err != nil {
+			fmt.Printf("Micropay-> reverse(%v) error(%v)", m.OutTradeNo, err)
+		}
+	}
+	if err == nil {
+		err = errors.New("reverse still need recall")
+	}
+	return &MicropayResult{OutTradeNo: m.OutTradeNo, TradeState: TradeStateUserPaying}, fmt.Errorf("Micropay-> trade(%v) reverse failed(%v): %w", m.OutTradeNo, err, ctx.Err())
+}
+
+// 等待d 期间ctx结束时返回ctx.Err()
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+/*
+[OrderQuery]-> 查询订单 POST https://api.mch.weixin.qq.com/pay/orderquery
+*/
+func (c *V2Client) OrderQuery(ctx context.Context, outTradeNo string) (*MicropayResult, error) {
+	params := V2Params{
+		"appid":        c.AppId,
+		"mch_id":       c.MchId,
+		"out_trade_no": outTradeNo,
+	}
+	res, err := c.Post(ctx, "/pay/orderquery", params)
+	if err != nil {
+		return nil, err
+	}
+	return toMicropayResult(res), nil
+}
+
+/*
+[Reverse]-> 撤销订单 POST https://api.mch.weixin.qq.com/secapi/pay/reverse
+需要商户API证书;recall为true时需要重新调用撤销
+*/
+func (c *V2Client) Reverse(ctx context.Context, outTradeNo string) (recall bool, err error) {
+	params := V2Params{
+		"appid":        c.AppId,
+		"mch_id":       c.MchId,
+		"out_trade_no": outTradeNo,
+	}
+	res, err := c.PostWithCert(ctx, "/secapi/pay/reverse", params)
+	if err != nil {
+		var v2Err *V2Error
+		if errors.As(err, &v2Err) && res != nil && res["recall"] == "Y" {
+			return true, nil
+		}
+		return false, err
+	}
+	return res["recall"] == "Y", nil
+}
+
+/*
+[MicropayCommit]->上层调用进行付款码支付
+ctx: 控制整个支付(含轮询)的截止时间
+*/
+func MicropayCommit(ctx context.Context, client *V2Client, micropayReq Micropay) (*MicropayResult, error) {
+	if micropayReq == nil {
+		fmt.Printf("MicropayCommit-> micropayReq can not be nil")
+		return nil, errors.New("MicropayCommit-> micropayReq can not be nil")
+	}
+	return micropayReq.Micropay(ctx, client)
+}
+
+func toMicropayResult(res V2Params) *MicropayResult {
+	totalFee, _ := strconv.ParseInt(res["total_fee"], 10, 64)
+	cashFee, _ := strconv.ParseInt(res["cash_fee"], 10, 64)
+	return &MicropayResult{
+		OutTradeNo:     res["out_trade_no"],
+		TransactionId:  res["transaction_id"],
+		TradeState:     res["trade_state"],
+		TradeStateDesc: res["trade_state_desc"],
+		OpenId:         res["openid"],
+		BankType:       res["bank_type"],
+		TotalFee:       money.Fen(totalFee),
+		CashFee:        money.Fen(cashFee),
+		TimeEnd:        res["time_end"],
+		Attach:         res["attach"],
+	}
+}
@@ -0,0 +1,152 @@
+package wechatpay
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+)
+
+// 模拟v2付款码支付接口,orderquery在第paidAfter次查询时返回SUCCESS,paidAfter为0时一直USERPAYING
+// reverse前recalls次返回recall=Y
+type fakeMicropayServer struct {
+	t         *testing.T
+	client    *V2Client
+	paidAfter int
+	recalls   int
+
+	mu         sync.Mutex
+	queries    int
+	reversed   bool
+	reverseAts []time.Time
+}
+
+func (f *fakeMicropayServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	req, err := ParseV2XML(body)
+	if err != nil || !f.client.Verify(req, f.client.signType()) {
+		w.Write(V2Params{"return_code": v2Fail, "return_msg": "签名错误"}.ToXML())
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	res := V2Params{"return_code": v2Success, "return_msg": "OK", "appid": req["appid"], "mch_id": req["mch_id"], "nonce_str": nonceStr()}
+	switch r.URL.Path {
+	case "/pay/micropay":
+		res["result_code"] = v2Fail
+		res["err_code"] = "USERPAYING"
+		res["err_code_des"] = "需要用户输入支付密码"
+	case "/pay/orderquery":
+		f.queries++
+		res["result_code"] = v2Success
+		res["out_trade_no"] = req["out_trade_no"]
+		res["trade_state"] = TradeStateUserPaying
+		if f.paidAfter > 0 && f.queries >= f.paidAfter {
+			res["trade_state"] = TradeStateSuccess
+			res["transaction_id"] = "1217752501201407033233368018"
+			res["total_fee"] = "101"
+			res["cash_fee"] = "101"
+		}
+	case "/secapi/pay/reverse":
+		f.reverseAts = append(f.reverseAts, time.Now())
+		if len(f.reverseAts) <= f.recalls {
+			res["result_code"] = v2Fail
+			res["err_code"] = "SYSTEMERROR"
+			res["err_code_des"] = "系统超时"
+			res["recall"] = "Y"
+			break
+		}
+		f.reversed = true
+		res["result_code"] = v2Success
+		res["recall"] = "N"
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	res["sign"] = f.client.Sign(res, f.client.signType())
+	w.Write(res.ToXML())
+}
+
+func newFakeMicropay(t *testing.T, signType string, paidAfter int) (*fakeMicropayServer, *V2Client, func()) {
+	client := NewV2Client("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+	client.SignType = signType
+	fake := &fakeMicropayServer{t: t, client: client, paidAfter: paidAfter}
+	server := httptest.NewServer(fake)
+	client.Domain = server.URL
+	client.TLSClient = server.Client()
+	return fake, client, server.Close
+}
+
+func TestMicropayPolling(t *testing.T) {
+	for _, signType := range []string{SignTypeMD5, SignTypeHMACSHA256} {
+		fake, client, closeFn := newFakeMicropay(t, signType, 2)
+		micropayReq := NewMicropayReq("image形象店-深圳腾大- QQ公仔", "1415757673", "120061098828009406", "14.17.22.52", money.Fen(101))
+		micropayReq.PollInterval = 10 * time.Millisecond
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		result, err := MicropayCommit(ctx, client, micropayReq)
+		cancel()
+		closeFn()
+		if err != nil {
+			t.Fatalf("%v: %v", signType, err)
+		}
+		if result.TradeState != TradeStateSuccess || result.TotalFee.Minor() != 101 || fake.queries != 2 || fake.reversed {
+			t.Errorf("%v: unexpected result(%+v) queries(%v) reversed(%v)", signType, result, fake.queries, fake.reversed)
+		}
+	}
+}
+
+func TestMicropayTimeoutReverse(t *testing.T) {
+	fake, client, closeFn := newFakeMicropay(t, SignTypeMD5, 0)
+	defer closeFn()
+	micropayReq := NewMicropayReq("image形象店-深圳腾大- QQ公仔", "1415757673", "120061098828009406", "14.17.22.52", money.Fen(101))
+	micropayReq.PollInterval = 10 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	result, err := micropayReq.Micropay(ctx, client)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, got(%v)", err)
+	}
+	if result.TradeState != TradeStateRevoked || !fake.reversed {
+		t.Errorf("unexpected result(%+v) reversed(%v)", result, fake.reversed)
+	}
+}
+
+// recall=Y时间隔重试撤销 间隔从查单间隔起翻倍
+func TestMicropayReverseRecall(t *testing.T) {
+	fake, client, closeFn := newFakeMicropay(t, SignTypeMD5, 0)
+	defer closeFn()
+	fake.recalls = 2
+	micropayReq := NewMicropayReq("image形象店-深圳腾大- QQ公仔", "1415757673", "120061098828009406", "14.17.22.52", money.Fen(101))
+	micropayReq.PollInterval = 20 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	result, err := micropayReq.Micropay(ctx, client)
+	if !errors.Is(err, context.DeadlineExceeded) || result.TradeState != TradeStateRevoked || !fake.reversed {
+		t.Fatalf("unexpected result(%+v, %v) reversed(%v)", result, err, fake.reversed)
+	}
+	if len(fake.reverseAts) != 3 {
+		t.Fatalf("unexpected reverse times(%v)", len(fake.reverseAts))
+	}
+	for i, expect := range []time.Duration{20 * time.Millisecond, 40 * time.Millisecond} {
+		if gap := fake.reverseAts[i+1].Sub(fake.reverseAts[i]); gap < expect {
+			t.Errorf("reverse retry %v after %v, expect at least %v", i+1, gap, expect)
+		}
+	}
+
+	//一直recall=Y 重试maxReverseTimes次后失败
+	fake, client, closeFn = newFakeMicropay(t, SignTypeMD5, 0)
+	defer closeFn()
+	fake.recalls = maxReverseTimes
+	micropayReq.PollInterval = time.Millisecond
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel2()
+	if result, err = micropayReq.Micropay(ctx2, client); err == nil || result.TradeState != TradeStateUserPaying || len(fake.reverseAts) != maxReverseTimes {
+		t.Errorf("unexpected result(%+v, %v) reverse times(%v)", result, err, len(fake.reverseAts))
+	}
+}
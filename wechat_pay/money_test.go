@@ -0,0 +1,23 @@
+package wechatpay
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+)
+
+func TestNativeAmountJSON(t *testing.T) {
+	amount := NativeAmount{Total: money.Fen(1), Currency: "CNY"}
+	byteAmount, err := json.Marshal(amount)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(byteAmount) != `{"total":1,"currency":"CNY"}` {
+		t.Errorf("unexpected json(%v)", string(byteAmount))
+	}
+	if err = json.Unmarshal([]byte(`{"total":100.5}`), &amount); err == nil {
+		t.Error("float total but no return err")
+	}
+}
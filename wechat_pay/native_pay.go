@@ -2,14 +2,11 @@ package wechatpay
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 
-	"github.com/wechatpay-apiv3/wechatpay-go/core"
-	"github.com/wechatpay-apiv3/wechatpay-go/core/option"
-	"github.com/wechatpay-apiv3/wechatpay-go/utils"
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+	"github.com/tanjl855/Sms_Pay_SDK/notifyguard"
 )
 
 //API字典详情请查阅:https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_4_1.shtml
@@ -36,6 +33,17 @@ import (
 
 const payTpye = "WechatPay"
 
+// 交易状态 NativeReq.TradeState,v2付款码支付的结果使用同一套取值
+const (
+	TradeStateSuccess    = "SUCCESS"    //支付成功
+	TradeStateRefund     = "REFUND"     //转入退款
+	TradeStateNotPay     = "NOTPAY"     //未支付
+	TradeStateClosed     = "CLOSED"     //已关闭
+	TradeStateRevoked    = "REVOKED"    //已撤销(付款码支付)
+	TradeStateUserPaying = "USERPAYING" //用户支付中(付款码支付)
+	TradeStatePayError   = "PAYERROR"   //支付失败(其他原因,如银行返回失败)
+)
+
 type NativePay interface {
 	GetNativeCodeUrl(appId, mchId, path string, option *Option) (*NativeRes, error)
 }
@@ -43,7 +51,39 @@ type NativePay interface {
 var _ NativePay = &NativeReq{}
 
 type Option struct {
-	OnCallBack func(context.Context, ...interface{}) error
+	OnCallBack func(context.Context, *NotifyReq, *NativeReq) error          //支付成功通知回调
+	OnRefund   func(context.Context, *NotifyReq, *RefundNotify) error       //退款结果通知回调
+	OnCombine  func(context.Context, *NotifyReq, *CombineTransaction) error //合单支付通知回调
+	OnPartner  func(context.Context, *NotifyReq, *PartnerTransaction) error //服务商模式支付通知回调
+
+	OnProfitSharing func(context.Context, *NotifyReq, *ProfitSharingNotify) error //分账动账通知回调
+
+	// [非必填]按商户订单号查询下单时的*NativeReq,NotifyHandle据此校验通知的mchid/appid/amount,不一致时拒绝且不调用OnCallBack
+	LookupOrder func(ctx context.Context, outTradeNo string) (*NativeReq, error)
+
+	// [非必填]按商户订单号查询服务商下单时的*PartnerNativeReq,PartnerNotifyHandle据此校验通知的sp_mchid/sub_mchid/amount,不一致时拒绝且不调用OnPartner
+	LookupPartnerOrder func(ctx context.Context, outTradeNo string) (*PartnerNativeReq, error)
+
+	// [非必填]按通知ID去重,各类通知处理成功后标记,重复通知直接应答成功且不再回调
+	Store notifyguard.NotificationStore
+
+	// [非必填]防重放,验签后校验Wechatpay-Timestamp在时间窗口内且Wechatpay-Nonce未出现过
+	// 微信支付SDK验签时已拒绝超过5分钟的通知,Window大于5分钟不会放宽该限制
+	Replay *notifyguard.ReplayGuard
+}
+
+func (o *Option) notificationStore() notifyguard.NotificationStore {
+	if o == nil {
+		return nil
+	}
+	return o.Store
+}
+
+func (o *Option) replayGuard() *notifyguard.ReplayGuard {
+	if o == nil {
+		return nil
+	}
+	return o.Replay
 }
 
 // Resource解密后的结构&Native下单req
@@ -62,7 +102,6 @@ type NativeReq struct {
 	BankType       string       `json:"bank_type,omitempty"`        //付款银行
 	SuccessTime    string       `json:"success_time,omitempty"`     //支付完成时间
 	Payer          Payer        `json:"payer,omitempty"`            //支付者
-	IsHandle       bool         //是否已处理回调
 	PayType        string       //支付类型 1.WechatPay 2.AliPay
 	Debug          bool
 }
@@ -74,8 +113,10 @@ type Payer struct {
 
 // 订单金额
 type NativeAmount struct {
-	Total    float32 //是 总金额
-	Currency string  //否 货币类型
+	Total         money.Amount  `json:"total"`                    //是 总金额 单位为分
+	Currency      string        `json:"currency,omitempty"`       //否 货币类型 CNY
+	PayerTotal    *money.Amount `json:"payer_total,omitempty"`    //用户支付金额 单位为分(支付通知)
+	PayerCurrency string        `json:"payer_currency,omitempty"` //用户支付币种(支付通知)
 }
 
 type NativeRes struct {
@@ -106,44 +147,16 @@ path:本地文件中商户私钥的位置
 */
 func (n *NativeReq) GetNativeCodeUrl(appId, mchId, path string, options *Option) (*NativeRes, error) {
 	Debug(n.Debug, "WechatPrePay here")
-	ctx := context.Background()
-	// 1. 使用商户私钥等初始化 client，并使它具有自动定时获取微信支付平台证书的能力
-	mchPrivateKey, err := utils.LoadPrivateKeyWithPath(path)
-	if err != nil {
-		fmt.Printf("Refund-> LoadPrivateKeyWithPath error(%v)", err)
-		return nil, err
-	}
-	opts := []core.ClientOption{
-		option.WithWechatPayAutoAuthCipher(mchID, mchCertificateSerialNumber, mchPrivateKey, mchAPIv3Key),
-	}
-	client, err := core.NewClient(ctx, opts...)
-	if err != nil {
-		fmt.Printf("Refund-> NewClient error(%v)", err)
-		return nil, err
-	}
-	Debug(n.Debug, "Init client(%v) done", client)
-	n.AppId = appId
-	n.MchId = mchId
-	n.PayType = payTpye //更新当前支付类型
-	url := "https://api.mch.weixin.qq.com/v3/pay/transactions/native"
-	result, err := client.Post(context.Background(), url, n)
-	if err != nil {
-		fmt.Printf("getNativeCodeUrl-> Post (%v) error(%v)", url, err)
-		return nil, err
+	config := DefaultConfig.withPrivateKeyPath(path)
+	if mchId != "" {
+		config.MchId = mchId
 	}
-	defer result.Response.Body.Close()
-	body, err := ioutil.ReadAll(result.Response.Body)
+	nativeRes, err := config.NativePrepay(context.Background(), appId, n)
 	if err != nil {
-		fmt.Printf("getNativeCodeUrl-> read response(%v) body error(%v)", result.Response, err)
+		fmt.Printf("getNativeCodeUrl-> NativePrepay error(%v)", err)
 		return nil, err
 	}
-	nativeRes := &NativeRes{}
-	if err = json.Unmarshal(body, nativeRes); err != nil {
-		fmt.Printf("getNativeCodeUrl-> Unmarshal body(%v) to nativeRes error(%v)", body, err)
-		return nil, err
-	}
-	Debug(n.Debug, "Pre pay success, nativeRes: %v", nativeRes)
-	return nativeRes, err
+	return nativeRes, nil
 }
 
 /*
@@ -159,3 +172,21 @@ func NativeCommit(appId, mchId, path string, option *Option, nativeReq NativePay
 	}
 	return nativeReq.GetNativeCodeUrl(appId, mchId, path, option)
 }
+
+/*
+[QueryOrderCommit]->上层调用按商户订单号查询订单
+path:本地文件中商户私钥的位置
+outTradeNo:商户订单号
+*/
+func QueryOrderCommit(path, outTradeNo string) (*NativeReq, error) {
+	return DefaultConfig.withPrivateKeyPath(path).QueryOrder(context.Background(), outTradeNo)
+}
+
+/*
+[CloseOrderCommit]->上层调用关闭未支付的订单
+path:本地文件中商户私钥的位置
+outTradeNo:商户订单号
+*/
+func CloseOrderCommit(path, outTradeNo string) error {
+	return DefaultConfig.withPrivateKeyPath(path).CloseOrder(context.Background(), outTradeNo)
+}
@@ -1,19 +1,62 @@
 package wechatpay
 
 import (
-	"fmt"
+	"crypto/x509"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+	"github.com/tanjl855/Sms_Pay_SDK/paytest"
 )
 
+// 传入path的接口使用DefaultConfig 测试期间替换为模拟微信支付的商户配置,返回商户私钥文件位置
+func usePaytestConfig(t *testing.T, fake *paytest.Wechat) string {
+	path := filepath.Join(t.TempDir(), "apiclient_key.pem")
+	if err := os.WriteFile(path, fake.MchPrivateKeyPEM(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defaultConfig := DefaultConfig
+	DefaultConfig = &Config{
+		MchId:                      fake.MchId,
+		MchCertificateSerialNumber: fake.MchCertificateSerialNumber,
+		MchAPIv3Key:                fake.APIv3Key,
+		PlatformCertificates:       []*x509.Certificate{fake.PlatformCertificate},
+		Domain:                     fake.URL,
+	}
+	t.Cleanup(func() {
+		DefaultConfig = defaultConfig
+	})
+	return path
+}
+
 func TestNativeCommit(t *testing.T) {
+	fake := paytest.NewWechat()
+	defer fake.Close()
+	path := usePaytestConfig(t, fake)
 	amount := NativeAmount{}
-	amount.Total = 1231.11
+	total, err := money.ParseYuan("1231.11")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	amount.Total = total
 	n := NewNativeReq("lalla", "123aba", "https://xxx.com", amount)
-	appId, mchId, path := "", "", ".././xx.pem"
+	appId, mchId := "wxd678efh567hg6787", ""
 	res, err := NativeCommit(appId, mchId, path, nil, n)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	fmt.Println(res)
+	if res.CodeUrl == "" {
+		t.Errorf("unexpected res(%+v)", res)
+	}
+	order, ok := fake.Order("123aba")
+	if !ok || order.TradeState != paytest.WechatNotPay || order.Total.Minor() != 123111 || order.NotifyURL != "https://xxx.com" {
+		t.Errorf("unexpected order(%+v, %v)", order, ok)
+	}
+	//商户号与私钥不匹配
+	if _, err = NativeCommit(appId, "1900000000", path, nil, NewNativeReq("lalla", "123abb", "https://xxx.com", amount)); err == nil {
+		t.Error("wrong mchId but no return err")
+	}
 }
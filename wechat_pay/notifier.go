@@ -0,0 +1,143 @@
+package wechatpay
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/tanjl855/Sms_Pay_SDK/notifyguard"
+	"github.com/wechatpay-apiv3/wechatpay-go/core"
+	"github.com/wechatpay-apiv3/wechatpay-go/core/auth/verifiers"
+	"github.com/wechatpay-apiv3/wechatpay-go/core/consts"
+	"github.com/wechatpay-apiv3/wechatpay-go/core/notify"
+)
+
+// 通知事件类型前缀
+const (
+	eventTransaction   = "TRANSACTION."   //支付成功通知 TRANSACTION.SUCCESS
+	eventRefund        = "REFUND."        //退款结果通知
+	eventProfitSharing = "PROFITSHARING." //分账动账通知
+)
+
+// Notifier 通知验签及解密器,不保存任何订单状态,可被多个handler并发复用
+type Notifier struct {
+	handler *notify.Handler
+}
+
+/*
+[NewNotifier]-> 使用商户私钥注册平台证书下载器,平台证书自动定时更新
+ctx: 上下文信息
+path: 示例 "/path/to/merchant/apiclient_key.pem"
+*/
+func NewNotifier(ctx context.Context, path string) (*Notifier, error) {
+	return DefaultConfig.withPrivateKeyPath(path).NewNotifier(ctx)
+}
+
+/*
+[NewNotifierWithCertificates]-> 使用已有的微信支付平台证书验签,不下载证书
+apiV3Key: 商户APIv3密钥
+certificates: 微信支付平台证书
+*/
+func NewNotifierWithCertificates(apiV3Key string, certificates ...*x509.Certificate) (*Notifier, error) {
+	if len(certificates) == 0 {
+		return nil, errors.New("NewNotifierWithCertificates-> certificates can not be empty")
+	}
+	return newNotifier(apiV3Key, core.NewCertificateMapWithList(certificates))
+}
+
+func newNotifier(apiV3Key string, getter core.CertificateGetter) (*Notifier, error) {
+	handler, err := notify.NewRSANotifyHandler(apiV3Key, verifiers.NewSHA256WithRSAVerifier(getter))
+	if err != nil {
+		fmt.Printf("newNotifier-> NewRSANotifyHandler error(%v)", err)
+		return nil, err
+	}
+	return &Notifier{handler: handler}, nil
+}
+
+/*
+[Parse]-> 回调通知的验签和解密
+content: resource解密后的结构,需传入指针,每次通知都应传入新的结构
+*/
+func (n *Notifier) Parse(ctx context.Context, r *http.Request, content interface{}) (*NotifyReq, error) {
+	notifyReq, err := n.handler.ParseNotifyRequest(ctx, r, content)
+	if err != nil {
+		return nil, err
+	}
+	return toNotifyReq(notifyReq), nil
+}
+
+/*
+[serve] 验签解密到content,校验事件类型后调用callback,并按v3格式{code,message}应答
+验签解密失败、事件类型不符及与订单不一致(ErrNotifyMismatch)应答400,callback失败应答500,微信支付会按策略重新发送通知
+options.Replay不为nil时校验Wechatpay-Timestamp及Wechatpay-Nonce,疑似重放应答400
+options.Store不为nil时按通知ID去重,已处理的通知直接应答成功且不调用callback
+*/
+func (n *Notifier) serve(ctx context.Context, w http.ResponseWriter, r *http.Request, name, eventPrefix string, content interface{}, options *Option, callback func(*NotifyReq) error) {
+	myNotifyReq, err := n.Parse(ctx, r, content)
+	if err != nil {
+		fmt.Printf("%v-> Parse error(%v)", name, err)
+		writeNotifyRes(w, http.StatusBadRequest, "FAIL", err.Error())
+		return
+	}
+	if replay := options.replayGuard(); replay != nil {
+		if err = replay.CheckUnix(r.Header.Get(consts.WechatPayTimestamp), r.Header.Get(consts.WechatPayNonce)); err != nil {
+			fmt.Printf("%v-> replay check error(%v)", name, err)
+			status := http.StatusInternalServerError
+			if errors.Is(err, notifyguard.ErrReplay) {
+				status = http.StatusBadRequest
+			}
+			writeNotifyRes(w, status, "FAIL", err.Error())
+			return
+		}
+	}
+	if eventPrefix != "" && !strings.HasPrefix(myNotifyReq.EventType, eventPrefix) {
+		fmt.Printf("%v-> unexpected event_type(%v)", name, myNotifyReq.EventType)
+		writeNotifyRes(w, http.StatusBadRequest, "FAIL", "unexpected event_type "+myNotifyReq.EventType)
+		return
+	}
+	if callback != nil {
+		_, err = notifyguard.Process(options.notificationStore(), myNotifyReq.ID, func() error {
+			return callback(myNotifyReq)
+		})
+		if err != nil {
+			fmt.Printf("%v-> callback error(%v)", name, err)
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrNotifyMismatch) {
+				status = http.StatusBadRequest
+			}
+			writeNotifyRes(w, status, "FAIL", err.Error())
+			return
+		}
+	}
+
+	//接收成功
+	writeNotifyRes(w, http.StatusOK, "SUCCESS", "成功")
+}
+
+/*
+[notifierHandle] 按商户私钥路径创建Notifier,创建成功后复用
+创建失败(如证书下载失败)时应答500,下一次通知重新创建
+*/
+func notifierHandle(ctx context.Context, path string, handle func(*Notifier) http.HandlerFunc) http.HandlerFunc {
+	var mu sync.Mutex
+	var handler http.HandlerFunc
+	return func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if handler == nil {
+			notifier, err := NewNotifier(ctx, path)
+			if err != nil {
+				mu.Unlock()
+				writeNotifyRes(w, http.StatusInternalServerError, "FAIL", err.Error())
+				return
+			}
+			handler = handle(notifier)
+		}
+		h := handler
+		mu.Unlock()
+		h(w, r)
+	}
+}
@@ -0,0 +1,376 @@
+package wechatpay
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+	"github.com/tanjl855/Sms_Pay_SDK/notifyguard"
+	"github.com/wechatpay-apiv3/wechatpay-go/utils"
+)
+
+const testAPIv3Key = "0123456789abcdef0123456789abcdef"
+
+// 使用本地生成的平台证书模拟微信支付通知
+type testNotifySigner struct {
+	privateKey  *rsa.PrivateKey
+	certificate *x509.Certificate
+}
+
+func newTestNotifySigner(t *testing.T) *testNotifySigner {
+	privateKey, certificate := newTestCertificate(t)
+	return &testNotifySigner{privateKey: privateKey, certificate: certificate}
+}
+
+func (s *testNotifySigner) notifier(t *testing.T) *Notifier {
+	notifier, err := NewNotifierWithCertificates(testAPIv3Key, s.certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return notifier
+}
+
+// 加密resource并对body签名
+func (s *testNotifySigner) request(t *testing.T, id, eventType string, content interface{}) *http.Request {
+	plaintext, err := json.Marshal(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher([]byte(testAPIv3Key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aesgcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce, associatedData := "fdasflkja484", "transaction"
+	ciphertext := aesgcm.Seal(nil, []byte(nonce), plaintext, []byte(associatedData))
+	body, err := json.Marshal(map[string]interface{}{
+		"id":            id,
+		"create_time":   time.Now().Format(time.RFC3339),
+		"resource_type": "encrypt-resource",
+		"event_type":    eventType,
+		"summary":       "支付成功",
+		"resource": map[string]string{
+			"original_type":   "transaction",
+			"algorithm":       "AEAD_AES_256_GCM",
+			"ciphertext":      base64.StdEncoding.EncodeToString(ciphertext),
+			"associated_data": associatedData,
+			"nonce":           nonce,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp, headerNonce := strconv.FormatInt(time.Now().Unix(), 10), nonceStr()
+	hashed := sha256.Sum256([]byte(timestamp + "\n" + headerNonce + "\n" + string(body) + "\n"))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.privateKey, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/notify", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Wechatpay-Timestamp", timestamp)
+	r.Header.Set("Wechatpay-Nonce", headerNonce)
+	r.Header.Set("Wechatpay-Signature", base64.StdEncoding.EncodeToString(signature))
+	r.Header.Set("Wechatpay-Serial", utils.GetCertificateSerialNumber(*s.certificate))
+	r.Header.Set("Wechatpay-Signature-Type", "WECHATPAY2-SHA256-RSA2048")
+	return r
+}
+
+func decodeNotifyRes(t *testing.T, w *httptest.ResponseRecorder) *NotifyRes {
+	res := &NotifyRes{}
+	if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+		t.Fatalf("unmarshal body(%v) error(%v)", w.Body.String(), err)
+	}
+	return res
+}
+
+func TestNotifyHandleConcurrent(t *testing.T) {
+	signer := newTestNotifySigner(t)
+	var received sync.Map
+	options := &Option{OnCallBack: func(ctx context.Context, notifyReq *NotifyReq, nativeReq *NativeReq) error {
+		received.Store(notifyReq.ID, nativeReq)
+		return nil
+	}}
+	server := httptest.NewServer(signer.notifier(t).NotifyHandle(context.Background(), options))
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			amount := money.Fen(int64(100 + i))
+			transaction := &NativeReq{
+				AppId:      "wxd678efh567hg6787",
+				MchId:      "1230000109",
+				OutTradeNo: fmt.Sprintf("order-%d", i),
+				TradeState: TradeStateSuccess,
+				Amount:     NativeAmount{Total: amount, PayerTotal: &amount},
+			}
+			r := signer.request(t, fmt.Sprintf("notify-%d", i), "TRANSACTION.SUCCESS", transaction)
+			req, _ := http.NewRequest(http.MethodPost, server.URL, r.Body)
+			req.Header = r.Header
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer res.Body.Close()
+			notifyRes := &NotifyRes{}
+			if err = json.NewDecoder(res.Body).Decode(notifyRes); err != nil || res.StatusCode != http.StatusOK || notifyRes.Code != "SUCCESS" {
+				t.Errorf("order-%d unexpected status(%v) res(%+v) err(%v)", i, res.StatusCode, notifyRes, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < 20; i++ {
+		v, ok := received.Load(fmt.Sprintf("notify-%d", i))
+		if !ok {
+			t.Errorf("notify-%d not received", i)
+			continue
+		}
+		nativeReq := v.(*NativeReq)
+		if nativeReq.OutTradeNo != fmt.Sprintf("order-%d", i) || nativeReq.Amount.Total.Minor() != int64(100+i) {
+			t.Errorf("notify-%d got other order(%+v)", i, nativeReq)
+		}
+	}
+}
+
+func TestNotifyHandleReject(t *testing.T) {
+	signer := newTestNotifySigner(t)
+	called := false
+	options := &Option{OnCallBack: func(ctx context.Context, notifyReq *NotifyReq, nativeReq *NativeReq) error {
+		called = true
+		return errors.New("order not found")
+	}}
+	handler := signer.notifier(t).NotifyHandle(context.Background(), options)
+	transaction := &NativeReq{OutTradeNo: "1217752501201407033233368018", TradeState: TradeStateSuccess}
+
+	//篡改body后验签失败
+	r := signer.request(t, "notify-1", "TRANSACTION.SUCCESS", transaction)
+	r.Body = http.NoBody
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusBadRequest || decodeNotifyRes(t, w).Code != "FAIL" || called {
+		t.Errorf("tampered notify: status(%v) called(%v)", w.Code, called)
+	}
+
+	//其他平台证书签名
+	other := newTestNotifySigner(t)
+	w = httptest.NewRecorder()
+	handler(w, other.request(t, "notify-2", "TRANSACTION.SUCCESS", transaction))
+	if w.Code != http.StatusBadRequest || called {
+		t.Errorf("unknown certificate: status(%v) called(%v)", w.Code, called)
+	}
+
+	//事件类型不符
+	w = httptest.NewRecorder()
+	handler(w, signer.request(t, "notify-3", "REFUND.SUCCESS", transaction))
+	if w.Code != http.StatusBadRequest || called {
+		t.Errorf("unexpected event: status(%v) called(%v)", w.Code, called)
+	}
+
+	//回调失败
+	w = httptest.NewRecorder()
+	handler(w, signer.request(t, "notify-4", "TRANSACTION.SUCCESS", transaction))
+	if res := decodeNotifyRes(t, w); w.Code != http.StatusInternalServerError || res.Code != "FAIL" || res.Msg != "order not found" || !called {
+		t.Errorf("callback error: status(%v) res(%+v) called(%v)", w.Code, res, called)
+	}
+
+	//options为nil
+	w = httptest.NewRecorder()
+	signer.notifier(t).NotifyHandle(context.Background(), nil)(w, signer.request(t, "notify-5", "TRANSACTION.SUCCESS", transaction))
+	if w.Code != http.StatusOK || decodeNotifyRes(t, w).Code != "SUCCESS" {
+		t.Errorf("nil options: status(%v)", w.Code)
+	}
+}
+
+func TestNotifierHandles(t *testing.T) {
+	signer := newTestNotifySigner(t)
+	notifier := signer.notifier(t)
+	ctx := context.Background()
+	var refundNotify *RefundNotify
+	var profitSharingNotify *ProfitSharingNotify
+	options := &Option{
+		OnRefund: func(ctx context.Context, notifyReq *NotifyReq, content *RefundNotify) error {
+			refundNotify = content
+			return nil
+		},
+		OnProfitSharing: func(ctx context.Context, notifyReq *NotifyReq, content *ProfitSharingNotify) error {
+			profitSharingNotify = content
+			return nil
+		},
+	}
+	w := httptest.NewRecorder()
+	notifier.RefundNotifyHandle(ctx, options)(w, signer.request(t, "notify-1", "REFUND.SUCCESS", &RefundNotify{OutRefundNo: "1217752501201407033233368018", RefundStatus: RefundStatusSuccess}))
+	if w.Code != http.StatusOK || refundNotify == nil || refundNotify.OutRefundNo != "1217752501201407033233368018" {
+		t.Errorf("refund notify: status(%v) content(%+v)", w.Code, refundNotify)
+	}
+	w = httptest.NewRecorder()
+	notifier.ProfitSharingNotifyHandle(ctx, options)(w, signer.request(t, "notify-2", "PROFITSHARING.SUCCESS", &ProfitSharingNotify{OutOrderNo: "P20150806125346"}))
+	if w.Code != http.StatusOK || profitSharingNotify == nil || profitSharingNotify.OutOrderNo != "P20150806125346" {
+		t.Errorf("profit sharing notify: status(%v) content(%+v)", w.Code, profitSharingNotify)
+	}
+	w = httptest.NewRecorder()
+	notifier.CombineNotifyHandle(ctx, nil)(w, signer.request(t, "notify-3", "TRANSACTION.SUCCESS", &CombineTransaction{CombineOutTradeNo: "P20150806125346"}))
+	if w.Code != http.StatusOK {
+		t.Errorf("combine notify: status(%v)", w.Code)
+	}
+}
+
+func TestNewNotifier(t *testing.T) {
+	if _, err := NewNotifierWithCertificates(testAPIv3Key); err == nil {
+		t.Error("no certificates but no return err")
+	}
+	if _, err := NewNotifierWithCertificates("short", newTestNotifySigner(t).certificate); err == nil {
+		t.Error("invalid apiv3 key but no return err")
+	}
+	//商户私钥不存在时应答FAIL
+	w := httptest.NewRecorder()
+	NotifyHandle(context.Background(), ".././key.pem", nil)(w, httptest.NewRequest(http.MethodPost, "/notify", http.NoBody))
+	if w.Code != http.StatusInternalServerError || decodeNotifyRes(t, w).Code != "FAIL" {
+		t.Errorf("unexpected status(%v)", w.Code)
+	}
+}
+
+func TestNotifyHandleLookupOrder(t *testing.T) {
+	signer := newTestNotifySigner(t)
+	order := &NativeReq{AppId: "wxd678efh567hg6787", MchId: "1230000109", OutTradeNo: "1217752501201407033233368018", Amount: NativeAmount{Total: money.Fen(100)}}
+	called := 0
+	options := &Option{
+		LookupOrder: func(ctx context.Context, outTradeNo string) (*NativeReq, error) {
+			if outTradeNo != order.OutTradeNo {
+				return nil, errors.New("order not found")
+			}
+			return order, nil
+		},
+		OnCallBack: func(ctx context.Context, notifyReq *NotifyReq, nativeReq *NativeReq) error {
+			called++
+			return nil
+		},
+	}
+	handler := signer.notifier(t).NotifyHandle(context.Background(), options)
+	cases := []struct {
+		name   string
+		modify func(*NativeReq)
+		status int
+	}{
+		{"match", func(n *NativeReq) {}, http.StatusOK},
+		{"mchid", func(n *NativeReq) { n.MchId = "1230000110" }, http.StatusBadRequest},
+		{"appid", func(n *NativeReq) { n.AppId = "wxd678efh567hg6788" }, http.StatusBadRequest},
+		{"amount", func(n *NativeReq) { n.Amount.Total = money.Fen(1) }, http.StatusBadRequest},
+		{"not found", func(n *NativeReq) { n.OutTradeNo = "1217752501201407033233368019" }, http.StatusInternalServerError},
+	}
+	for i, c := range cases {
+		paid := money.Fen(100)
+		transaction := &NativeReq{AppId: order.AppId, MchId: order.MchId, OutTradeNo: order.OutTradeNo, TradeState: TradeStateSuccess, Amount: NativeAmount{Total: money.Fen(100), PayerTotal: &paid}}
+		c.modify(transaction)
+		w := httptest.NewRecorder()
+		handler(w, signer.request(t, fmt.Sprintf("notify-%d", i), "TRANSACTION.SUCCESS", transaction))
+		if w.Code != c.status {
+			t.Errorf("%v: unexpected status(%v) body(%v)", c.name, w.Code, w.Body.String())
+		}
+	}
+	if called != 1 {
+		t.Errorf("OnCallBack called(%v) times, expect 1", called)
+	}
+}
+
+func TestNotifyHandleStore(t *testing.T) {
+	signer := newTestNotifySigner(t)
+	var called int32
+	var fail int32 = 1
+	options := &Option{
+		Store: notifyguard.NewMemoryStore(),
+		OnCallBack: func(ctx context.Context, notifyReq *NotifyReq, nativeReq *NativeReq) error {
+			//第一次回调失败,微信支付重新通知后再处理
+			if atomic.CompareAndSwapInt32(&fail, 1, 0) {
+				return errors.New("db error")
+			}
+			atomic.AddInt32(&called, 1)
+			return nil
+		},
+	}
+	handler := signer.notifier(t).NotifyHandle(context.Background(), options)
+	transaction := &NativeReq{AppId: "wxd678efh567hg6787", MchId: "1230000109", OutTradeNo: "1217752501201407033233368018", TradeState: TradeStateSuccess, Amount: NativeAmount{Total: money.Fen(100)}}
+	statuses := []int{http.StatusInternalServerError, http.StatusOK, http.StatusOK, http.StatusOK}
+	for i, status := range statuses {
+		w := httptest.NewRecorder()
+		handler(w, signer.request(t, "EV-2018022511223320873", "TRANSACTION.SUCCESS", transaction))
+		if w.Code != status {
+			t.Errorf("notify %v: unexpected status(%v) expect(%v)", i, w.Code, status)
+		}
+	}
+	if called != 1 {
+		t.Errorf("OnCallBack called(%v) times, expect 1", called)
+	}
+}
+
+func TestNotifyHandleReplay(t *testing.T) {
+	signer := newTestNotifySigner(t)
+	called := 0
+	var rejects []string
+	replay := notifyguard.NewReplayGuard(time.Minute)
+	replay.OnReject = func(err *notifyguard.RejectError) {
+		rejects = append(rejects, err.Reason)
+	}
+	options := &Option{
+		Replay: replay,
+		OnCallBack: func(ctx context.Context, notifyReq *NotifyReq, nativeReq *NativeReq) error {
+			called++
+			return nil
+		},
+	}
+	handler := signer.notifier(t).NotifyHandle(context.Background(), options)
+	transaction := &NativeReq{OutTradeNo: "1217752501201407033233368018", TradeState: TradeStateSuccess, Amount: NativeAmount{Total: money.Fen(100)}}
+
+	//截获的通知原样重放
+	captured := signer.request(t, "EV-2018022511223320873", "TRANSACTION.SUCCESS", transaction)
+	body, err := ioutil.ReadAll(captured.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, status := range []int{http.StatusOK, http.StatusBadRequest} {
+		r := httptest.NewRequest(http.MethodPost, "/notify", bytes.NewReader(body))
+		r.Header = captured.Header.Clone()
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != status {
+			t.Errorf("request %v: unexpected status(%v) expect(%v)", i, w.Code, status)
+		}
+	}
+	//微信支付重新通知使用新的nonce
+	w := httptest.NewRecorder()
+	handler(w, signer.request(t, "EV-2018022511223320873", "TRANSACTION.SUCCESS", transaction))
+	if w.Code != http.StatusOK {
+		t.Errorf("retry: unexpected status(%v)", w.Code)
+	}
+	if called != 2 {
+		t.Errorf("OnCallBack called(%v) times, expect 2", called)
+	}
+	if len(rejects) != 1 || rejects[0] != notifyguard.RejectNonceReused {
+		t.Errorf("unexpected rejects(%v)", rejects)
+	}
+}
@@ -6,11 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
-	"github.com/wechatpay-apiv3/wechatpay-go/core/auth/verifiers"
-	"github.com/wechatpay-apiv3/wechatpay-go/core/downloader"
 	"github.com/wechatpay-apiv3/wechatpay-go/core/notify"
-	"github.com/wechatpay-apiv3/wechatpay-go/utils"
 )
 
 //API字典详情请查阅https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_4_5.shtml
@@ -66,141 +64,98 @@ type NotifyRes struct {
 	Msg  string `json:"message"` // 返回信息 示例值：失败
 }
 
-const (
-	mchID                      string = "190000****"                               // 商户号
-	mchCertificateSerialNumber string = "3775B6A45ACD588826D15E583A95F5DD********" // 商户证书序列号
-	mchAPIv3Key                string = "2ab9****************************"         // 商户APIv3密钥
-)
+var ErrNotifyMismatch = errors.New("wechat pay notify mismatch with order")
 
 /*
 [NotifyHandle]
-处理微信回调通知,把body解密->传入的nativeReq
+处理微信支付成功通知,每次通知都解密到新的*NativeReq并传给options.OnCallBack
+handler不保存订单状态,可同时服务多个订单及并发通知,设置options.Store时按通知ID去重
 ctx: 上下文信息
 path: 示例 "/path/to/merchant/apiclient_key.pem"
-options: 提供钩子函数
-notifyReq: 支付通知请求,resource未解密
-nativeReq: resource解密后结构
+options: 提供钩子函数,可为nil
 */
-func NotifyHandle(ctx context.Context, path string, options *Option, myNotifyReq *NotifyReq, nativeReq *NativeReq) http.HandlerFunc {
+func NotifyHandle(ctx context.Context, path string, options *Option) http.HandlerFunc {
+	return notifierHandle(ctx, path, func(n *Notifier) http.HandlerFunc {
+		return n.NotifyHandle(ctx, options)
+	})
+}
+
+// [NotifyHandle]-> 使用已创建的Notifier处理支付成功通知,见NotifyHandle
+func (n *Notifier) NotifyHandle(ctx context.Context, options *Option) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		//检查业务数据
-		if nativeReq.IsHandle {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		//回调通知的验签和解密
-		mchPrivateKey, err := utils.LoadPrivateKeyWithPath(path) //从本地加载商户私钥
-		if err != nil {
-			fmt.Printf("load merchant private key error")
-			w.WriteHeader(http.StatusInternalServerError)
-			errRes := &NotifyRes{
-				Code: "FAIL",
-				Msg:  err.Error(),
-			}
-			errByte, err := json.Marshal(errRes)
-			if err != nil {
-				fmt.Printf("Marshal errRes(%v) error(%v)", errRes, err)
-				return
-			}
-			w.Write(errByte)
-			return
-		}
-		// 1. 使用 `RegisterDownloaderWithPrivateKey` 注册下载器
-		err = downloader.MgrInstance().RegisterDownloaderWithPrivateKey(ctx, mchPrivateKey, mchCertificateSerialNumber, mchID, mchAPIv3Key)
-		if err != nil {
-			fmt.Printf("RegisterDownloaderWithPrivateKey error(%v)", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			errRes := &NotifyRes{
-				Code: "FAIL",
-				Msg:  err.Error(),
-			}
-			errByte, err := json.Marshal(errRes)
-			if err != nil {
-				fmt.Printf("Marshal errRes(%v) error(%v)", errRes, err)
-				return
+		nativeReq := &NativeReq{}
+		n.serve(ctx, w, r, "NotifyHandle", eventTransaction, nativeReq, options, func(myNotifyReq *NotifyReq) error {
+			if options == nil {
+				return nil
 			}
-			w.Write(errByte)
-			return
-		}
-		// 2. 获取商户号对应的微信支付平台证书访问器
-		certificateVisitor := downloader.MgrInstance().GetCertificateVisitor(mchID)
-		// 3. 使用证书访问器初始化 `notify.Handler`
-		handler := notify.NewNotifyHandler(mchAPIv3Key, verifiers.NewSHA256WithRSAVerifier(certificateVisitor))
-
-		//获取notifyReq和解密Resourc -> nativeReq
-		var notifyReq *notify.Request
-		notifyReq, err = handler.ParseNotifyRequest(ctx, r, nativeReq)
-		if err != nil {
-			fmt.Printf("ParseNotifyRequest error(%v)", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			errRes := &NotifyRes{
-				Code: "FAIL",
-				Msg:  err.Error(),
+			if err := checkTransaction(ctx, options, nativeReq); err != nil {
+				return err
 			}
-			errByte, err := json.Marshal(errRes)
-			if err != nil {
-				fmt.Printf("Marshal errRes(%v) error(%v)", errRes, err)
-				return
+			if options.OnCallBack == nil {
+				return nil
 			}
-			w.Write(errByte)
-			return
-		}
+			return options.OnCallBack(ctx, myNotifyReq, nativeReq)
+		})
+	}
+}
 
-		myNotifyReq, err = getNativeReq2Mine(notifyReq)
-		if err != nil {
-			fmt.Printf("get naticeReq error(%v)", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			errRes := &NotifyRes{
-				Code: "FAIL",
-				Msg:  err.Error(),
-			}
-			errByte, err := json.Marshal(errRes)
-			if err != nil {
-				fmt.Printf("Marshal errRes(%v) error(%v)", errRes, err)
-				return
-			}
-			w.Write(errByte)
-			return
-		}
-		fmt.Printf("decode resource: %v\n", myNotifyReq.Resource.Plaintext)
-		if options.OnCallBack != nil {
-			if err = options.OnCallBack(ctx, myNotifyReq, nativeReq); err != nil {
-				fmt.Printf("options-> OnCallBack error(%v)", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				errRes := &NotifyRes{
-					Code: "FAIL",
-					Msg:  err.Error(),
-				}
-				errByte, err := json.Marshal(errRes)
-				if err != nil {
-					fmt.Printf("Marshal errRes(%v) error(%v)", errRes, err)
-					return
-				}
-				w.Write(errByte)
-				return
-			}
-		}
+/*
+[checkTransaction] 校验支付通知与下单时的订单一致:mchid、appid及订单总金额
+options.LookupOrder为nil时不校验,不一致时返回ErrNotifyMismatch
+*/
+func checkTransaction(ctx context.Context, options *Option, nativeReq *NativeReq) error {
+	if options.LookupOrder == nil {
+		return nil
+	}
+	order, err := options.LookupOrder(ctx, nativeReq.OutTradeNo)
+	if err != nil {
+		return err
+	}
+	if order == nil {
+		return fmt.Errorf("%w: order(%v) not found", ErrNotifyMismatch, nativeReq.OutTradeNo)
+	}
+	if nativeReq.MchId != order.MchId {
+		return fmt.Errorf("%w: mchid(%v) expect(%v)", ErrNotifyMismatch, nativeReq.MchId, order.MchId)
+	}
+	if nativeReq.AppId != order.AppId {
+		return fmt.Errorf("%w: appid(%v) expect(%v)", ErrNotifyMismatch, nativeReq.AppId, order.AppId)
+	}
+	if !nativeReq.Amount.Total.Equal(order.Amount.Total) {
+		return fmt.Errorf("%w: amount(%v) expect(%v)", ErrNotifyMismatch, nativeReq.Amount.Total, order.Amount.Total)
+	}
+	return nil
+}
 
-		//接收成功
-		nativeReq.IsHandle = true
-		w.WriteHeader(http.StatusOK)
+// notify.Request -> NotifyReq
+func toNotifyReq(notifyReq *notify.Request) *NotifyReq {
+	myNotifyReq := &NotifyReq{
+		ID:           notifyReq.ID,
+		EventType:    notifyReq.EventType,
+		ResourceType: notifyReq.ResourceType,
+		Summary:      notifyReq.Summary,
+	}
+	if notifyReq.CreateTime != nil {
+		myNotifyReq.CreateTime = notifyReq.CreateTime.Format(time.RFC3339)
+	}
+	if notifyReq.Resource != nil {
+		myNotifyReq.Resource.Algorithm = notifyReq.Resource.Algorithm
+		myNotifyReq.Resource.Ciphertext = notifyReq.Resource.Ciphertext
+		myNotifyReq.Resource.OriginalType = notifyReq.Resource.OriginalType
+		myNotifyReq.Resource.Nonce = notifyReq.Resource.Nonce
+		myNotifyReq.Resource.AssociatedData = notifyReq.Resource.AssociatedData
+		myNotifyReq.Resource.Plaintext = notifyReq.Resource.Plaintext
 	}
+	return myNotifyReq
 }
 
-func getNativeReq2Mine(notifyReq *notify.Request) (myNotifyReq *NotifyReq, err error) {
-	if notifyReq == nil {
-		return nil, errors.New("notifyReq can not be nil")
+// 应答微信支付通知,status非200时微信支付会按策略重新发送通知
+func writeNotifyRes(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	byteRes, err := json.Marshal(&NotifyRes{Code: code, Msg: msg})
+	if err != nil {
+		fmt.Printf("Marshal notifyRes error(%v)", err)
+		return
 	}
-	myNotifyReq.ID = notifyReq.ID
-	myNotifyReq.CreateTime = notifyReq.CreateTime.String()
-	myNotifyReq.EventType = notifyReq.EventType
-	myNotifyReq.ResourceType = notifyReq.ResourceType
-	myNotifyReq.Summary = notifyReq.Summary
-	myNotifyReq.Resource.Algorithm = notifyReq.Resource.Algorithm
-	myNotifyReq.Resource.Ciphertext = notifyReq.Resource.Ciphertext
-	myNotifyReq.Resource.OriginalType = notifyReq.Resource.OriginalType
-	myNotifyReq.Resource.Nonce = notifyReq.Resource.Nonce
-	myNotifyReq.Resource.AssociatedData = notifyReq.Resource.AssociatedData
-	myNotifyReq.Resource.Plaintext = notifyReq.Resource.Plaintext
-	return
+	w.Write(byteRes)
 }
@@ -0,0 +1,245 @@
+package wechatpay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+)
+
+//服务商模式API详情请查阅:https://pay.weixin.qq.com/wiki/doc/apiv3_partner/apis/chapter4_4_1.shtml
+//服务商(sp_mchid/sp_appid)代特约商户(sub_mchid/sub_appid)下单,client使用服务商的商户私钥及证书
+
+//示例
+/*
+{
+	"sp_appid": "wx8888888888888888",
+	"sp_mchid": "1230000109",
+	"sub_appid": "wxd678efh567hg6999",
+	"sub_mchid": "1900000109",
+	"description": "Image形象店-深圳腾大-QQ公仔",
+	"out_trade_no": "1217752501201407033233368018",
+	"notify_url": "https://www.weixin.qq.com/wxpay/pay.php",
+	"amount": {
+		"total": 100,
+		"currency": "CNY"
+	}
+}
+*/
+
+type PartnerPay interface {
+	GetPartnerCodeUrl(spAppId, spMchId, path string) (*NativeRes, error)
+	QueryPartner(path string) (*PartnerTransaction, error)
+	ClosePartner(path string) error
+}
+
+var _ PartnerPay = &PartnerNativeReq{}
+
+// 服务商Native下单req
+type PartnerNativeReq struct {
+	SpAppId     string       `json:"sp_appid"`              //服务商应用ID
+	SpMchId     string       `json:"sp_mchid"`              //服务商户号
+	SubAppId    string       `json:"sub_appid,omitempty"`   //[非必填]子商户应用ID
+	SubMchId    string       `json:"sub_mchid"`             //子商户号
+	Description string       `json:"description"`           //商品描述
+	OutTradeNo  string       `json:"out_trade_no"`          //商户订单号
+	TimeExpire  string       `json:"time_expire,omitempty"` //[非必填]交易结束时间
+	Attach      string       `json:"attach,omitempty"`      //[非必填]附加数据
+	NotifyUrl   string       `json:"notify_url"`            //通知地址
+	SettleInfo  *SettleInfo  `json:"settle_info,omitempty"` //[非必填]结算信息
+	Amount      NativeAmount `json:"amount"`                //订单金额
+	Debug       bool         `json:"-"`
+}
+
+// 服务商订单查询结果&支付通知resource解密后的结构
+type PartnerTransaction struct {
+	SpAppId        string        `json:"sp_appid"`                   //服务商应用ID
+	SpMchId        string        `json:"sp_mchid"`                   //服务商户号
+	SubAppId       string        `json:"sub_appid,omitempty"`        //子商户应用ID
+	SubMchId       string        `json:"sub_mchid"`                  //子商户号
+	OutTradeNo     string        `json:"out_trade_no"`               //商户订单号
+	TransactionId  string        `json:"transaction_id,omitempty"`   //微信支付订单号
+	TradeType      string        `json:"trade_type,omitempty"`       //交易类型 示例值：NATIVE
+	TradeState     string        `json:"trade_state"`                //交易状态 示例值：SUCCESS
+	TradeStateDesc string        `json:"trade_state_desc,omitempty"` //交易状态描述
+	BankType       string        `json:"bank_type,omitempty"`        //付款银行
+	Attach         string        `json:"attach,omitempty"`           //附加数据
+	SuccessTime    string        `json:"success_time,omitempty"`     //支付完成时间
+	Payer          *PartnerPayer `json:"payer,omitempty"`            //支付者
+	Amount         NativeAmount  `json:"amount"`                     //订单金额
+}
+
+// 服务商模式支付者
+type PartnerPayer struct {
+	SpOpenId  string `json:"sp_openid,omitempty"`  //用户在服务商appid下的唯一标识
+	SubOpenId string `json:"sub_openid,omitempty"` //用户在子商户appid下的唯一标识
+}
+
+// 服务商关单req
+type partnerCloseReq struct {
+	SpMchId  string `json:"sp_mchid"`
+	SubMchId string `json:"sub_mchid"`
+}
+
+func NewPartnerNativeReq(subMchId, description, outTradeNo, notifyUrl string, amount NativeAmount) *PartnerNativeReq {
+	return &PartnerNativeReq{
+		SubMchId:    subMchId,
+		Description: description,
+		OutTradeNo:  outTradeNo,
+		NotifyUrl:   notifyUrl,
+		Amount:      amount,
+	}
+}
+
+/*
+[GetPartnerCodeUrl]-> 服务商Native下单 POST https://api.mch.weixin.qq.com/v3/pay/partner/transactions/native
+spAppId:服务商应用ID
+spMchId:服务商户号
+path:本地文件中服务商商户私钥的位置
+*/
+func (p *PartnerNativeReq) GetPartnerCodeUrl(spAppId, spMchId, path string) (*NativeRes, error) {
+	Debug(p.Debug, "PartnerPrePay here")
+	if p.OutTradeNo == "" || p.SubMchId == "" {
+		return nil, errors.New("GetPartnerCodeUrl-> OutTradeNo and SubMchId can not be empty")
+	}
+	ctx := context.Background()
+	client, err := newClient(ctx, path)
+	if err != nil {
+		fmt.Printf("GetPartnerCodeUrl-> newClient error(%v)", err)
+		return nil, err
+	}
+	p.SpAppId = spAppId
+	p.SpMchId = spMchId
+	nativeRes := &NativeRes{}
+	url := DefaultConfig.domain() + "/v3/pay/partner/transactions/native"
+	if err = doRequest(ctx, client, http.MethodPost, url, nil, p, nativeRes); err != nil {
+		fmt.Printf("GetPartnerCodeUrl-> Post (%v) error(%v)", url, err)
+		return nil, err
+	}
+	Debug(p.Debug, "Partner pre pay success, nativeRes: %v", nativeRes)
+	return nativeRes, nil
+}
+
+/*
+[QueryPartner]-> 服务商商户订单号查询 GET https://api.mch.weixin.qq.com/v3/pay/partner/transactions/out-trade-no/{out_trade_no}?sp_mchid=&sub_mchid=
+path:本地文件中服务商商户私钥的位置
+*/
+func (p *PartnerNativeReq) QueryPartner(path string) (*PartnerTransaction, error) {
+	if p.OutTradeNo == "" || p.SpMchId == "" || p.SubMchId == "" {
+		return nil, errors.New("QueryPartner-> OutTradeNo, SpMchId and SubMchId can not be empty")
+	}
+	ctx := context.Background()
+	client, err := newClient(ctx, path)
+	if err != nil {
+		fmt.Printf("QueryPartner-> newClient error(%v)", err)
+		return nil, err
+	}
+	query := neturl.Values{}
+	query.Set("sp_mchid", p.SpMchId)
+	query.Set("sub_mchid", p.SubMchId)
+	url := DefaultConfig.domain() + "/v3/pay/partner/transactions/out-trade-no/" + neturl.PathEscape(p.OutTradeNo) + "?" + query.Encode()
+	partnerTransaction := &PartnerTransaction{}
+	if err = doRequest(ctx, client, http.MethodGet, url, nil, nil, partnerTransaction); err != nil {
+		return nil, err
+	}
+	Debug(p.Debug, "query partner transaction(%v)", partnerTransaction)
+	return partnerTransaction, nil
+}
+
+/*
+[ClosePartner]-> 服务商关闭订单 POST https://api.mch.weixin.qq.com/v3/pay/partner/transactions/out-trade-no/{out_trade_no}/close
+path:本地文件中服务商商户私钥的位置
+*/
+func (p *PartnerNativeReq) ClosePartner(path string) error {
+	if p.OutTradeNo == "" || p.SpMchId == "" || p.SubMchId == "" {
+		return errors.New("ClosePartner-> OutTradeNo, SpMchId and SubMchId can not be empty")
+	}
+	ctx := context.Background()
+	client, err := newClient(ctx, path)
+	if err != nil {
+		fmt.Printf("ClosePartner-> newClient error(%v)", err)
+		return err
+	}
+	url := DefaultConfig.domain() + "/v3/pay/partner/transactions/out-trade-no/" + neturl.PathEscape(p.OutTradeNo) + "/close"
+	closeReq := &partnerCloseReq{SpMchId: p.SpMchId, SubMchId: p.SubMchId}
+	if err = doRequest(ctx, client, http.MethodPost, url, nil, closeReq, nil); err != nil {
+		return err
+	}
+	Debug(p.Debug, "close partner transaction(%v) done", p.OutTradeNo)
+	return nil
+}
+
+/*
+[PartnerNativeCommit]->上层调用进行服务商Native下单
+spAppId:服务商应用ID
+spMchId:服务商户号
+path:本地文件中服务商商户私钥的位置
+*/
+func PartnerNativeCommit(spAppId, spMchId, path string, partnerReq PartnerPay) (*NativeRes, error) {
+	if partnerReq == nil {
+		fmt.Printf("PartnerNativeCommit-> partnerReq can not be nil")
+		return nil, errors.New("PartnerNativeCommit-> partnerReq can not be nil")
+	}
+	return partnerReq.GetPartnerCodeUrl(spAppId, spMchId, path)
+}
+
+/*
+[PartnerNotifyHandle]
+处理服务商模式支付通知,每次通知都解密到新的*PartnerTransaction并传给options.OnPartner
+设置options.LookupPartnerOrder时校验通知与下单时的订单一致,不一致时应答400且不调用OnPartner
+ctx: 上下文信息
+path: 示例 "/path/to/merchant/apiclient_key.pem"
+options: 提供钩子函数
+*/
+func PartnerNotifyHandle(ctx context.Context, path string, options *Option) http.HandlerFunc {
+	return notifierHandle(ctx, path, func(n *Notifier) http.HandlerFunc {
+		return n.PartnerNotifyHandle(ctx, options)
+	})
+}
+
+// [PartnerNotifyHandle]-> 使用已创建的Notifier处理服务商模式支付通知,见PartnerNotifyHandle
+func (n *Notifier) PartnerNotifyHandle(ctx context.Context, options *Option) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		partnerTransaction := &PartnerTransaction{}
+		n.serve(ctx, w, r, "PartnerNotifyHandle", eventTransaction, partnerTransaction, options, func(myNotifyReq *NotifyReq) error {
+			if options == nil {
+				return nil
+			}
+			if err := checkPartnerTransaction(ctx, options, partnerTransaction); err != nil {
+				return err
+			}
+			if options.OnPartner == nil {
+				return nil
+			}
+			return options.OnPartner(ctx, myNotifyReq, partnerTransaction)
+		})
+	}
+}
+
+/*
+[checkPartnerTransaction] 校验服务商模式支付通知与下单时的订单一致:sp_mchid、sub_mchid及订单总金额
+options.LookupPartnerOrder为nil时不校验,不一致时返回ErrNotifyMismatch
+*/
+func checkPartnerTransaction(ctx context.Context, options *Option, partnerTransaction *PartnerTransaction) error {
+	if options.LookupPartnerOrder == nil {
+		return nil
+	}
+	order, err := options.LookupPartnerOrder(ctx, partnerTransaction.OutTradeNo)
+	if err != nil {
+		return err
+	}
+	if order == nil {
+		return fmt.Errorf("%w: order(%v) not found", ErrNotifyMismatch, partnerTransaction.OutTradeNo)
+	}
+	if partnerTransaction.SpMchId != order.SpMchId {
+		return fmt.Errorf("%w: sp_mchid(%v) expect(%v)", ErrNotifyMismatch, partnerTransaction.SpMchId, order.SpMchId)
+	}
+	if partnerTransaction.SubMchId != order.SubMchId {
+		return fmt.Errorf("%w: sub_mchid(%v) expect(%v)", ErrNotifyMismatch, partnerTransaction.SubMchId, order.SubMchId)
+	}
+	if !partnerTransaction.Amount.Total.Equal(order.Amount.Total) {
+		return fmt.Errorf("%w: amount(%v) expect(%v)", ErrNotifyMismatch, partnerTransaction.Amount.Total, order.Amount.Total)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package wechatpay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+	"github.com/tanjl855/Sms_Pay_SDK/paytest"
+)
+
+func TestPartnerTransactionUnmarshal(t *testing.T) {
+	plaintext := `{"sp_appid":"wx8888888888888888","sp_mchid":"1230000109","sub_appid":"wxd678efh567hg6999","sub_mchid":"1900000109",
+	"out_trade_no":"1217752501201407033233368018","transaction_id":"1217752501201407033233368018","trade_type":"NATIVE",
+	"trade_state":"SUCCESS","trade_state_desc":"支付成功","bank_type":"CMC","success_time":"2018-06-08T10:34:56+08:00",
+	"payer":{"sp_openid":"oUpF8uMuAJO_M2pxb1Q9zNjWeS6o","sub_openid":"oUpF8uMuAJO_M2pxb1Q9zNjWeS6o"},
+	"amount":{"total":100,"payer_total":100,"currency":"CNY","payer_currency":"CNY"}}`
+	partnerTransaction := &PartnerTransaction{}
+	if err := json.Unmarshal([]byte(plaintext), partnerTransaction); err != nil {
+		t.Error(err)
+		return
+	}
+	if partnerTransaction.SubMchId != "1900000109" || partnerTransaction.Payer.SubOpenId == "" || partnerTransaction.Amount.PayerTotal.Minor() != 100 {
+		t.Errorf("unexpected partnerTransaction(%+v)", partnerTransaction)
+	}
+}
+
+func TestPartnerNativeCommit(t *testing.T) {
+	p := NewPartnerNativeReq("1900000109", "Image形象店-深圳腾大-QQ公仔", "1217752501201407033233368018",
+		"https://www.weixin.qq.com/wxpay/pay.php", NativeAmount{Total: money.Fen(100), Currency: "CNY"})
+	if _, err := PartnerNativeCommit("wx8888888888888888", "1230000109", ".././key.pem", p); err == nil {
+		t.Error("merchant private key not found but no return err")
+	}
+	byteReq, err := json.Marshal(p)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(byteReq) != `{"sp_appid":"","sp_mchid":"","sub_mchid":"1900000109","description":"Image形象店-深圳腾大-QQ公仔",`+
+		`"out_trade_no":"1217752501201407033233368018","notify_url":"https://www.weixin.qq.com/wxpay/pay.php","amount":{"total":100,"currency":"CNY"}}` {
+		t.Errorf("unexpected json(%v)", string(byteReq))
+	}
+	if _, err := (&PartnerNativeReq{OutTradeNo: "xxx"}).QueryPartner(".././key.pem"); err == nil {
+		t.Error("empty sub_mchid but no return err")
+	}
+	if _, err := (&PartnerNativeReq{OutTradeNo: "xxx"}).GetPartnerCodeUrl("wx8888888888888888", "1230000109", ".././key.pem"); err == nil ||
+		!strings.Contains(err.Error(), "can not be empty") {
+		t.Errorf("empty sub_mchid but got(%v)", err)
+	}
+}
+
+func TestPartnerNotifyHandleLookupOrder(t *testing.T) {
+	signer := newTestNotifySigner(t)
+	order := NewPartnerNativeReq("1900000109", "Image形象店-深圳腾大-QQ公仔", "1217752501201407033233368018",
+		"https://www.weixin.qq.com/wxpay/pay.php", NativeAmount{Total: money.Fen(100), Currency: "CNY"})
+	order.SpAppId, order.SpMchId = "wx8888888888888888", "1230000109"
+	called := 0
+	options := &Option{
+		LookupPartnerOrder: func(ctx context.Context, outTradeNo string) (*PartnerNativeReq, error) {
+			if outTradeNo != order.OutTradeNo {
+				return nil, errors.New("order not found")
+			}
+			return order, nil
+		},
+		OnPartner: func(ctx context.Context, notifyReq *NotifyReq, partnerTransaction *PartnerTransaction) error {
+			called++
+			return nil
+		},
+	}
+	handler := signer.notifier(t).PartnerNotifyHandle(context.Background(), options)
+	cases := []struct {
+		name   string
+		modify func(*PartnerTransaction)
+		status int
+	}{
+		{"match", func(p *PartnerTransaction) {}, http.StatusOK},
+		{"sp_mchid", func(p *PartnerTransaction) { p.SpMchId = "1230000110" }, http.StatusBadRequest},
+		{"sub_mchid", func(p *PartnerTransaction) { p.SubMchId = "1900000110" }, http.StatusBadRequest},
+		{"amount", func(p *PartnerTransaction) { p.Amount.Total = money.Fen(1) }, http.StatusBadRequest},
+		{"not found", func(p *PartnerTransaction) { p.OutTradeNo = "1217752501201407033233368019" }, http.StatusInternalServerError},
+	}
+	for i, c := range cases {
+		transaction := &PartnerTransaction{SpAppId: order.SpAppId, SpMchId: order.SpMchId, SubMchId: order.SubMchId, OutTradeNo: order.OutTradeNo,
+			TradeState: TradeStateSuccess, Amount: NativeAmount{Total: money.Fen(100)}}
+		c.modify(transaction)
+		w := httptest.NewRecorder()
+		handler(w, signer.request(t, fmt.Sprintf("notify-%d", i), "TRANSACTION.SUCCESS", transaction))
+		if w.Code != c.status {
+			t.Errorf("%v: unexpected status(%v) body(%v)", c.name, w.Code, w.Body.String())
+		}
+	}
+	if called != 1 {
+		t.Errorf("OnPartner called(%v) times, expect 1", called)
+	}
+}
+
+func TestPartnerRequest(t *testing.T) {
+	fake := paytest.NewWechat()
+	defer fake.Close()
+	path := usePaytestConfig(t, fake)
+	var prepayBody, closeBody string
+	fake.Handle(http.MethodPost, "/v3/pay/partner/transactions/native", func(r *http.Request, body []byte) (int, interface{}) {
+		prepayBody = strings.TrimSpace(string(body))
+		return http.StatusOK, map[string]string{"code_url": "weixin://wxpay/bizpayurl?pr=p4lpSuKzz"}
+	})
+	fake.Handle(http.MethodGet, "/v3/pay/partner/transactions/out-trade-no/", func(r *http.Request, body []byte) (int, interface{}) {
+		if r.URL.Path != "/v3/pay/partner/transactions/out-trade-no/1217752501201407033233368018" || r.URL.Query().Get("sp_mchid") != fake.MchId ||
+			r.URL.Query().Get("sub_mchid") != "1900000109" {
+			t.Errorf("unexpected query url(%v)", r.URL)
+		}
+		return http.StatusOK, map[string]interface{}{
+			"sp_appid": "wx8888888888888888", "sp_mchid": fake.MchId, "sub_mchid": "1900000109", "out_trade_no": "1217752501201407033233368018",
+			"trade_type": "NATIVE", "trade_state": "NOTPAY", "amount": map[string]interface{}{"total": 100, "currency": "CNY"},
+		}
+	})
+	fake.Handle(http.MethodPost, "/v3/pay/partner/transactions/out-trade-no/1217752501201407033233368018/close", func(r *http.Request, body []byte) (int, interface{}) {
+		closeBody = strings.TrimSpace(string(body))
+		return http.StatusNoContent, nil
+	})
+
+	p := NewPartnerNativeReq("1900000109", "Image形象店-深圳腾大-QQ公仔", "1217752501201407033233368018",
+		"https://www.weixin.qq.com/wxpay/pay.php", NativeAmount{Total: money.Fen(100), Currency: "CNY"})
+	nativeRes, err := PartnerNativeCommit("wx8888888888888888", fake.MchId, path, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nativeRes.CodeUrl != "weixin://wxpay/bizpayurl?pr=p4lpSuKzz" {
+		t.Errorf("unexpected native res(%+v)", nativeRes)
+	}
+	if prepayBody != `{"sp_appid":"wx8888888888888888","sp_mchid":"`+fake.MchId+`","sub_mchid":"1900000109","description":"Image形象店-深圳腾大-QQ公仔",`+
+		`"out_trade_no":"1217752501201407033233368018","notify_url":"https://www.weixin.qq.com/wxpay/pay.php","amount":{"total":100,"currency":"CNY"}}` {
+		t.Errorf("unexpected prepay request(%v)", prepayBody)
+	}
+
+	transaction, err := p.QueryPartner(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transaction.SubMchId != "1900000109" || transaction.TradeState != TradeStateNotPay || transaction.Amount.Total.Minor() != 100 {
+		t.Errorf("unexpected partner transaction(%+v)", transaction)
+	}
+	if err = p.ClosePartner(path); err != nil {
+		t.Error(err)
+	}
+	if closeBody != `{"sp_mchid":"`+fake.MchId+`","sub_mchid":"1900000109"}` {
+		t.Errorf("unexpected close request(%v)", closeBody)
+	}
+}
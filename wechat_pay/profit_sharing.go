@@ -0,0 +1,405 @@
+package wechatpay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+)
+
+//分账API详情请查阅:https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter8_1_1.shtml
+//下单时需设置settle_info.profit_sharing=true,支付成功后才能对该笔订单发起分账
+
+// 分账流程
+// 1.添加分账接收方(接收方姓名需使用平台证书加密,请求头带Wechatpay-Serial)
+// 2.请求分账 -> 查询分账结果 / 分账通知
+// 3.解冻剩余资金(不再分账时)
+// 4.分账回退(将已分账资金从接收方退回)
+
+// 分账接收方类型
+const (
+	ReceiverTypeMerchantId     = "MERCHANT_ID"         //商户号
+	ReceiverTypePersonalOpenId = "PERSONAL_OPENID"     //个人openid(由父商户APPID转换得到)
+	ReceiverTypeSubOpenId      = "PERSONAL_SUB_OPENID" //个人sub_openid(由子商户APPID转换得到)
+)
+
+// 分账单状态&分账结果
+const (
+	ProfitSharingStateProcessing = "PROCESSING" //处理中
+	ProfitSharingStateFinished   = "FINISHED"   //分账完成
+
+	ProfitSharingResultPending = "PENDING" //待分账
+	ProfitSharingResultSuccess = "SUCCESS" //分账成功
+	ProfitSharingResultClosed  = "CLOSED"  //已关闭
+
+	ReturnResultProcessing = "PROCESSING" //处理中
+	ReturnResultSuccess    = "SUCCESS"    //已成功
+	ReturnResultFailed     = "FAILED"     //已失败
+)
+
+// 分账通知类型
+const (
+	ProfitSharingEventSuccess = "PROFITSHARING.SUCCESS" //分账成功
+	ProfitSharingEventClosed  = "PROFITSHARING.CLOSED"  //分账失败
+)
+
+type ProfitSharing interface {
+	ProfitSharing(path string) (*ProfitSharingOrder, error)
+}
+
+var _ ProfitSharing = &ProfitSharingReq{}
+
+// 分账接收方 添加/删除 POST https://api.mch.weixin.qq.com/v3/profitsharing/receivers/add
+type ProfitSharingReceiver struct {
+	SubMchId       string `json:"sub_mchid,omitempty"`                  //[服务商模式必填]子商户号
+	AppId          string `json:"appid"`                                //应用ID
+	Type           string `json:"type"`                                 //分账接收方类型 MERCHANT_ID/PERSONAL_OPENID/PERSONAL_SUB_OPENID
+	Account        string `json:"account"`                              //分账接收方账号
+	Name           string `json:"name,omitempty" encryption:"EM_APIV3"` //[非必填]分账个人接收方姓名 明文传入,请求时使用平台证书加密 MERCHANT_ID时必填商户全称
+	RelationType   string `json:"relation_type,omitempty"`              //与分账方的关系类型 示例值：STORE/STAFF/PARTNER/CUSTOM...
+	CustomRelation string `json:"custom_relation,omitempty"`            //[非必填]自定义的分账关系 relation_type为CUSTOM时必填
+	Debug          bool   `json:"-"`
+}
+
+// 请求分账req POST https://api.mch.weixin.qq.com/v3/profitsharing/orders
+type ProfitSharingReq struct {
+	SubMchId        string                        `json:"sub_mchid,omitempty"` //[服务商模式必填]子商户号
+	AppId           string                        `json:"appid"`               //应用ID
+	TransactionId   string                        `json:"transaction_id"`      //微信订单号
+	OutOrderNo      string                        `json:"out_order_no"`        //商户分账单号
+	Receivers       []*ProfitSharingOrderReceiver `json:"receivers"`           //分账接收方列表
+	UnfreezeUnsplit bool                          `json:"unfreeze_unsplit"`    //是否解冻剩余未分资金
+	Debug           bool                          `json:"-"`
+}
+
+// 分账接收方
+type ProfitSharingOrderReceiver struct {
+	Type        string       `json:"type"`                                 //分账接收方类型
+	Account     string       `json:"account"`                              //分账接收方账号
+	Name        string       `json:"name,omitempty" encryption:"EM_APIV3"` //[非必填]分账个人接收方姓名 明文传入,请求时使用平台证书加密
+	Amount      money.Amount `json:"amount"`                               //分账金额 单位为分
+	Description string       `json:"description"`                          //分账描述
+}
+
+// 分账单&解冻结果
+type ProfitSharingOrder struct {
+	SubMchId      string                         `json:"sub_mchid,omitempty"` //子商户号
+	TransactionId string                         `json:"transaction_id"`      //微信订单号
+	OutOrderNo    string                         `json:"out_order_no"`        //商户分账单号
+	OrderId       string                         `json:"order_id"`            //微信分账单号
+	State         string                         `json:"state"`               //分账单状态 PROCESSING/FINISHED
+	Receivers     []*ProfitSharingReceiverResult `json:"receivers"`           //分账接收方列表
+}
+
+// 分账接收方分账结果
+type ProfitSharingReceiverResult struct {
+	Amount      money.Amount `json:"amount"`                //分账金额
+	Description string       `json:"description"`           //分账描述
+	Type        string       `json:"type"`                  //分账接收方类型
+	Account     string       `json:"account"`               //分账接收方账号
+	Result      string       `json:"result"`                //分账结果 PENDING/SUCCESS/CLOSED
+	FailReason  string       `json:"fail_reason,omitempty"` //分账失败原因
+	DetailId    string       `json:"detail_id"`             //分账明细单号
+	CreateTime  string       `json:"create_time"`           //分账创建时间
+	FinishTime  string       `json:"finish_time"`           //分账完成时间
+}
+
+// 解冻剩余资金req POST https://api.mch.weixin.qq.com/v3/profitsharing/orders/unfreeze
+type UnfreezeReq struct {
+	SubMchId      string `json:"sub_mchid,omitempty"` //[服务商模式必填]子商户号
+	TransactionId string `json:"transaction_id"`      //微信订单号
+	OutOrderNo    string `json:"out_order_no"`        //商户分账单号
+	Description   string `json:"description"`         //分账描述
+	Debug         bool   `json:"-"`
+}
+
+// 分账回退req POST https://api.mch.weixin.qq.com/v3/profitsharing/return-orders
+type ProfitSharingReturnReq struct {
+	SubMchId    string       `json:"sub_mchid,omitempty"`    //[服务商模式必填]子商户号
+	OrderId     string       `json:"order_id,omitempty"`     //微信分账单号 与OutOrderNo二选一
+	OutOrderNo  string       `json:"out_order_no,omitempty"` //商户分账单号 与OrderId二选一
+	OutReturnNo string       `json:"out_return_no"`          //商户回退单号
+	ReturnMchId string       `json:"return_mchid"`           //回退商户号 只能对原分账请求中成功分给商户接收方进行回退
+	Amount      money.Amount `json:"amount"`                 //回退金额 单位为分
+	Description string       `json:"description"`            //回退描述
+	Debug       bool         `json:"-"`
+}
+
+// 分账回退结果
+type ProfitSharingReturnOrder struct {
+	SubMchId    string       `json:"sub_mchid,omitempty"`   //子商户号
+	OrderId     string       `json:"order_id"`              //微信分账单号
+	OutOrderNo  string       `json:"out_order_no"`          //商户分账单号
+	OutReturnNo string       `json:"out_return_no"`         //商户回退单号
+	ReturnId    string       `json:"return_id"`             //微信回退单号
+	ReturnMchId string       `json:"return_mchid"`          //回退商户号
+	Amount      money.Amount `json:"amount"`                //回退金额
+	Description string       `json:"description"`           //回退描述
+	Result      string       `json:"result"`                //回退结果 PROCESSING/SUCCESS/FAILED
+	FailReason  string       `json:"fail_reason,omitempty"` //失败原因
+	CreateTime  string       `json:"create_time"`           //创建时间
+	FinishTime  string       `json:"finish_time"`           //完成时间
+}
+
+// 分账通知resource解密后的结构
+type ProfitSharingNotify struct {
+	MchId         string                       `json:"mchid,omitempty"`     //直连商户号
+	SpMchId       string                       `json:"sp_mchid,omitempty"`  //服务商户号(服务商模式)
+	SubMchId      string                       `json:"sub_mchid,omitempty"` //子商户号(服务商模式)
+	TransactionId string                       `json:"transaction_id"`      //微信订单号
+	OrderId       string                       `json:"order_id"`            //微信分账/回退单号
+	OutOrderNo    string                       `json:"out_order_no"`        //商户分账/回退单号
+	Receiver      *ProfitSharingNotifyReceiver `json:"receiver"`            //分账接收方
+	SuccessTime   string                       `json:"success_time"`        //成功时间
+}
+
+type ProfitSharingNotifyReceiver struct {
+	Type        string       `json:"type"`        //分账接收方类型
+	Account     string       `json:"account"`     //分账接收方账号
+	Amount      money.Amount `json:"amount"`      //分账动账金额
+	Description string       `json:"description"` //分账/回退描述
+}
+
+func NewProfitSharingReceiver(appId, receiverType, account, name, relationType string) *ProfitSharingReceiver {
+	return &ProfitSharingReceiver{
+		AppId:        appId,
+		Type:         receiverType,
+		Account:      account,
+		Name:         name,
+		RelationType: relationType,
+	}
+}
+
+func NewProfitSharingReq(appId, transactionId, outOrderNo string, receivers ...*ProfitSharingOrderReceiver) *ProfitSharingReq {
+	return &ProfitSharingReq{
+		AppId:         appId,
+		TransactionId: transactionId,
+		OutOrderNo:    outOrderNo,
+		Receivers:     receivers,
+	}
+}
+
+/*
+[AddReceiver]-> 添加分账接收方 POST https://api.mch.weixin.qq.com/v3/profitsharing/receivers/add
+Name使用平台证书加密后发送,r本身不会被修改
+path:本地文件中商户私钥的位置
+*/
+func (r *ProfitSharingReceiver) AddReceiver(path string) (*ProfitSharingReceiver, error) {
+	ctx := context.Background()
+	client, err := newClient(ctx, path)
+	if err != nil {
+		fmt.Printf("AddReceiver-> newClient error(%v)", err)
+		return nil, err
+	}
+	encryptReq := *r
+	serial, err := EncryptSensitive(ctx, &encryptReq)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	header.Set("Wechatpay-Serial", serial)
+	receiver := &ProfitSharingReceiver{}
+	url := DefaultConfig.domain() + "/v3/profitsharing/receivers/add"
+	if err = doRequest(ctx, client, http.MethodPost, url, header, &encryptReq, receiver); err != nil {
+		return nil, err
+	}
+	Debug(r.Debug, "add profit sharing receiver(%v) done", receiver)
+	return receiver, nil
+}
+
+/*
+[DeleteReceiver]-> 删除分账接收方 POST https://api.mch.weixin.qq.com/v3/profitsharing/receivers/delete
+path:本地文件中商户私钥的位置
+*/
+func (r *ProfitSharingReceiver) DeleteReceiver(path string) error {
+	ctx := context.Background()
+	client, err := newClient(ctx, path)
+	if err != nil {
+		fmt.Printf("DeleteReceiver-> newClient error(%v)", err)
+		return err
+	}
+	deleteReq := &ProfitSharingReceiver{SubMchId: r.SubMchId, AppId: r.AppId, Type: r.Type, Account: r.Account}
+	url := DefaultConfig.domain() + "/v3/profitsharing/receivers/delete"
+	if err = doRequest(ctx, client, http.MethodPost, url, nil, deleteReq, &ProfitSharingReceiver{}); err != nil {
+		return err
+	}
+	Debug(r.Debug, "delete profit sharing receiver(%v) done", r.Account)
+	return nil
+}
+
+/*
+[ProfitSharing]-> 请求分账 POST https://api.mch.weixin.qq.com/v3/profitsharing/orders
+接收方Name使用平台证书加密后发送,p本身不会被修改
+path:本地文件中商户私钥的位置
+*/
+func (p *ProfitSharingReq) ProfitSharing(path string) (*ProfitSharingOrder, error) {
+	if len(p.Receivers) == 0 {
+		return nil, errors.New("ProfitSharing-> Receivers can not be empty")
+	}
+	ctx := context.Background()
+	client, err := newClient(ctx, path)
+	if err != nil {
+		fmt.Printf("ProfitSharing-> newClient error(%v)", err)
+		return nil, err
+	}
+	encryptReq := *p
+	encryptReq.Receivers = make([]*ProfitSharingOrderReceiver, 0, len(p.Receivers))
+	for _, receiver := range p.Receivers {
+		encryptReceiver := *receiver
+		encryptReq.Receivers = append(encryptReq.Receivers, &encryptReceiver)
+	}
+	serial, err := EncryptSensitive(ctx, &encryptReq)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	header.Set("Wechatpay-Serial", serial)
+	order := &ProfitSharingOrder{}
+	url := DefaultConfig.domain() + "/v3/profitsharing/orders"
+	if err = doRequest(ctx, client, http.MethodPost, url, header, &encryptReq, order); err != nil {
+		return nil, err
+	}
+	Debug(p.Debug, "profit sharing order(%v)", order)
+	return order, nil
+}
+
+/*
+[QueryProfitSharingCommit]-> 查询分账结果 GET https://api.mch.weixin.qq.com/v3/profitsharing/orders/{out_order_no}?transaction_id=&sub_mchid=
+path:本地文件中商户私钥的位置
+subMchId:服务商模式下的子商户号,直连商户传空
+*/
+func QueryProfitSharingCommit(path, subMchId, transactionId, outOrderNo string) (*ProfitSharingOrder, error) {
+	if transactionId == "" || outOrderNo == "" {
+		return nil, errors.New("QueryProfitSharingCommit-> transactionId and outOrderNo can not be empty")
+	}
+	ctx := context.Background()
+	client, err := newClient(ctx, path)
+	if err != nil {
+		fmt.Printf("QueryProfitSharingCommit-> newClient error(%v)", err)
+		return nil, err
+	}
+	query := neturl.Values{}
+	query.Set("transaction_id", transactionId)
+	if subMchId != "" {
+		query.Set("sub_mchid", subMchId)
+	}
+	order := &ProfitSharingOrder{}
+	url := DefaultConfig.domain() + "/v3/profitsharing/orders/" + neturl.PathEscape(outOrderNo) + "?" + query.Encode()
+	if err = doRequest(ctx, client, http.MethodGet, url, nil, nil, order); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+/*
+[Unfreeze]-> 解冻剩余资金 POST https://api.mch.weixin.qq.com/v3/profitsharing/orders/unfreeze
+不再分账时将订单剩余待分金额解冻给本商户
+path:本地文件中商户私钥的位置
+*/
+func (u *UnfreezeReq) Unfreeze(path string) (*ProfitSharingOrder, error) {
+	ctx := context.Background()
+	client, err := newClient(ctx, path)
+	if err != nil {
+		fmt.Printf("Unfreeze-> newClient error(%v)", err)
+		return nil, err
+	}
+	order := &ProfitSharingOrder{}
+	url := DefaultConfig.domain() + "/v3/profitsharing/orders/unfreeze"
+	if err = doRequest(ctx, client, http.MethodPost, url, nil, u, order); err != nil {
+		return nil, err
+	}
+	Debug(u.Debug, "unfreeze order(%v)", order)
+	return order, nil
+}
+
+/*
+[Return]-> 请求分账回退 POST https://api.mch.weixin.qq.com/v3/profitsharing/return-orders
+path:本地文件中商户私钥的位置
+*/
+func (p *ProfitSharingReturnReq) Return(path string) (*ProfitSharingReturnOrder, error) {
+	if p.OrderId == "" && p.OutOrderNo == "" {
+		return nil, errors.New("Return-> OrderId and OutOrderNo can not be both empty")
+	}
+	ctx := context.Background()
+	client, err := newClient(ctx, path)
+	if err != nil {
+		fmt.Printf("Return-> newClient error(%v)", err)
+		return nil, err
+	}
+	returnOrder := &ProfitSharingReturnOrder{}
+	url := DefaultConfig.domain() + "/v3/profitsharing/return-orders"
+	if err = doRequest(ctx, client, http.MethodPost, url, nil, p, returnOrder); err != nil {
+		return nil, err
+	}
+	Debug(p.Debug, "profit sharing return order(%v)", returnOrder)
+	return returnOrder, nil
+}
+
+/*
+[QueryReturnCommit]-> 查询分账回退结果 GET https://api.mch.weixin.qq.com/v3/profitsharing/return-orders/{out_return_no}?out_order_no=&sub_mchid=
+path:本地文件中商户私钥的位置
+subMchId:服务商模式下的子商户号,直连商户传空
+*/
+func QueryReturnCommit(path, subMchId, outOrderNo, outReturnNo string) (*ProfitSharingReturnOrder, error) {
+	if outOrderNo == "" || outReturnNo == "" {
+		return nil, errors.New("QueryReturnCommit-> outOrderNo and outReturnNo can not be empty")
+	}
+	ctx := context.Background()
+	client, err := newClient(ctx, path)
+	if err != nil {
+		fmt.Printf("QueryReturnCommit-> newClient error(%v)", err)
+		return nil, err
+	}
+	query := neturl.Values{}
+	query.Set("out_order_no", outOrderNo)
+	if subMchId != "" {
+		query.Set("sub_mchid", subMchId)
+	}
+	returnOrder := &ProfitSharingReturnOrder{}
+	url := DefaultConfig.domain() + "/v3/profitsharing/return-orders/" + neturl.PathEscape(outReturnNo) + "?" + query.Encode()
+	if err = doRequest(ctx, client, http.MethodGet, url, nil, nil, returnOrder); err != nil {
+		return nil, err
+	}
+	return returnOrder, nil
+}
+
+/*
+[ProfitSharingCommit]->上层调用请求分账
+path:本地文件中商户私钥的位置
+*/
+func ProfitSharingCommit(path string, profitSharingReq ProfitSharing) (*ProfitSharingOrder, error) {
+	if profitSharingReq == nil {
+		fmt.Printf("ProfitSharingCommit-> profitSharingReq can not be nil")
+		return nil, errors.New("ProfitSharingCommit-> profitSharingReq can not be nil")
+	}
+	return profitSharingReq.ProfitSharing(path)
+}
+
+/*
+[ProfitSharingNotifyHandle]
+处理分账动账通知(PROFITSHARING.SUCCESS/PROFITSHARING.CLOSED),每次通知都解密到新的*ProfitSharingNotify并传给options.OnProfitSharing
+ctx: 上下文信息
+path: 示例 "/path/to/merchant/apiclient_key.pem"
+options: 提供钩子函数
+*/
+func ProfitSharingNotifyHandle(ctx context.Context, path string, options *Option) http.HandlerFunc {
+	return notifierHandle(ctx, path, func(n *Notifier) http.HandlerFunc {
+		return n.ProfitSharingNotifyHandle(ctx, options)
+	})
+}
+
+// [ProfitSharingNotifyHandle]-> 使用已创建的Notifier处理分账动账通知,见ProfitSharingNotifyHandle
+func (n *Notifier) ProfitSharingNotifyHandle(ctx context.Context, options *Option) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		profitSharingNotify := &ProfitSharingNotify{}
+		n.serve(ctx, w, r, "ProfitSharingNotifyHandle", eventProfitSharing, profitSharingNotify, options, func(myNotifyReq *NotifyReq) error {
+			if options == nil || options.OnProfitSharing == nil {
+				return nil
+			}
+			return options.OnProfitSharing(ctx, myNotifyReq, profitSharingNotify)
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package wechatpay
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+	"github.com/tanjl855/Sms_Pay_SDK/paytest"
+)
+
+func TestProfitSharingNotifyUnmarshal(t *testing.T) {
+	plaintext := `{"mchid":"1900000100","transaction_id":"4200000000000000000000000000","order_id":"1217752501201407033233368018",
+	"out_order_no":"P20150806125346","receiver":{"type":"MERCHANT_ID","account":"1900000109","amount":888,"description":"运费/交易分账"},
+	"success_time":"2018-06-08T10:34:56+08:00"}`
+	profitSharingNotify := &ProfitSharingNotify{}
+	if err := json.Unmarshal([]byte(plaintext), profitSharingNotify); err != nil {
+		t.Error(err)
+		return
+	}
+	if profitSharingNotify.Receiver == nil || profitSharingNotify.Receiver.Amount.Minor() != 888 {
+		t.Errorf("unexpected profitSharingNotify(%+v)", profitSharingNotify)
+	}
+}
+
+func TestProfitSharingCommit(t *testing.T) {
+	p := NewProfitSharingReq("wx8888888888888888", "4208450740201411110007820472", "P20150806125346")
+	if _, err := ProfitSharingCommit(".././key.pem", p); err == nil {
+		t.Error("empty receivers but no return err")
+	}
+	if _, err := QueryProfitSharingCommit(".././key.pem", "", "", "P20150806125346"); err == nil {
+		t.Error("empty transactionId but no return err")
+	}
+	returnReq := &ProfitSharingReturnReq{OutReturnNo: "R20190516001", ReturnMchId: "86693852", Amount: money.Fen(10)}
+	if _, err := returnReq.Return(".././key.pem"); err == nil {
+		t.Error("empty order_id and out_order_no but no return err")
+	}
+}
+
+func TestProfitSharingRequest(t *testing.T) {
+	fake := paytest.NewWechat()
+	defer fake.Close()
+	path := usePaytestConfig(t, fake)
+	// 敏感字段需使用平台证书加密 并在Wechatpay-Serial中传入平台证书序列号
+	decryptName := func(r *http.Request, name string) string {
+		if serial := r.Header.Get("Wechatpay-Serial"); serial != fake.PlatformSerialNumber() {
+			t.Errorf("unexpected Wechatpay-Serial(%v)", serial)
+		}
+		plaintext, err := fake.DecryptSensitive(name)
+		if err != nil {
+			t.Errorf("name(%v) is not encrypted with platform certificate: %v", name, err)
+		}
+		return plaintext
+	}
+	fake.Handle(http.MethodPost, "/v3/profitsharing/receivers/add", func(r *http.Request, body []byte) (int, interface{}) {
+		receiver := &ProfitSharingReceiver{}
+		if err := json.Unmarshal(body, receiver); err != nil {
+			t.Error(err)
+		}
+		if receiver.AppId != "wx8888888888888888" || receiver.Type != ReceiverTypeMerchantId || receiver.Account != "86693852" ||
+			receiver.RelationType != "STORE" || decryptName(r, receiver.Name) != "腾讯计算机系统有限公司" {
+			t.Errorf("unexpected add receiver request(%s)", body)
+		}
+		return http.StatusOK, map[string]string{"type": receiver.Type, "account": receiver.Account, "relation_type": receiver.RelationType}
+	})
+	fake.Handle(http.MethodPost, "/v3/profitsharing/receivers/delete", func(r *http.Request, body []byte) (int, interface{}) {
+		if m := map[string]interface{}{}; json.Unmarshal(body, &m) != nil || m["account"] != "86693852" || m["name"] != nil || m["relation_type"] != nil {
+			t.Errorf("unexpected delete receiver request(%s)", body)
+		}
+		return http.StatusOK, map[string]string{"type": ReceiverTypeMerchantId, "account": "86693852"}
+	})
+	order := map[string]interface{}{
+		"transaction_id": "4208450740201411110007820472", "out_order_no": "P20150806125346", "order_id": "3008450740201411110007820472", "state": ProfitSharingStateProcessing,
+		"receivers": []map[string]interface{}{{"amount": 888, "description": "分给商户A", "type": ReceiverTypeMerchantId, "account": "86693852", "result": ProfitSharingResultPending}},
+	}
+	fake.Handle(http.MethodPost, "/v3/profitsharing/orders", func(r *http.Request, body []byte) (int, interface{}) {
+		req := &ProfitSharingReq{}
+		if err := json.Unmarshal(body, req); err != nil {
+			t.Error(err)
+		}
+		if req.TransactionId != "4208450740201411110007820472" || req.OutOrderNo != "P20150806125346" || len(req.Receivers) != 1 ||
+			req.Receivers[0].Amount.Minor() != 888 || decryptName(r, req.Receivers[0].Name) != "腾讯计算机系统有限公司" {
+			t.Errorf("unexpected profit sharing request(%s)", body)
+		}
+		return http.StatusOK, order
+	})
+	fake.Handle(http.MethodGet, "/v3/profitsharing/orders/P20150806125346", func(r *http.Request, body []byte) (int, interface{}) {
+		if r.URL.Query().Get("transaction_id") != "4208450740201411110007820472" || r.URL.Query().Has("sub_mchid") {
+			t.Errorf("unexpected query url(%v)", r.URL)
+		}
+		return http.StatusOK, order
+	})
+	fake.Handle(http.MethodPost, "/v3/profitsharing/return-orders", func(r *http.Request, body []byte) (int, interface{}) {
+		req := &ProfitSharingReturnReq{}
+		if err := json.Unmarshal(body, req); err != nil {
+			t.Error(err)
+		}
+		if req.OutOrderNo != "P20150806125346" || req.ReturnMchId != "86693852" || req.Amount.Minor() != 10 || r.Header.Get("Wechatpay-Serial") != "" {
+			t.Errorf("unexpected return request(%s)", body)
+		}
+		return http.StatusOK, map[string]interface{}{"out_order_no": req.OutOrderNo, "out_return_no": req.OutReturnNo, "return_id": "3008450740201411110007820472",
+			"return_mchid": req.ReturnMchId, "amount": 10, "result": ReturnResultProcessing}
+	})
+
+	receiver := NewProfitSharingReceiver("wx8888888888888888", ReceiverTypeMerchantId, "86693852", "腾讯计算机系统有限公司", "STORE")
+	if _, err := receiver.AddReceiver(path); err != nil {
+		t.Fatal(err)
+	}
+	if receiver.Name != "腾讯计算机系统有限公司" {
+		t.Errorf("receiver should not be modified, got name(%v)", receiver.Name)
+	}
+	if err := receiver.DeleteReceiver(path); err != nil {
+		t.Error(err)
+	}
+
+	p := NewProfitSharingReq("wx8888888888888888", "4208450740201411110007820472", "P20150806125346",
+		&ProfitSharingOrderReceiver{Type: ReceiverTypeMerchantId, Account: "86693852", Name: "腾讯计算机系统有限公司", Amount: money.Fen(888), Description: "分给商户A"})
+	profitSharingOrder, err := ProfitSharingCommit(path, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profitSharingOrder.State != ProfitSharingStateProcessing || len(profitSharingOrder.Receivers) != 1 || profitSharingOrder.Receivers[0].Amount.Minor() != 888 {
+		t.Errorf("unexpected profit sharing order(%+v)", profitSharingOrder)
+	}
+	if p.Receivers[0].Name != "腾讯计算机系统有限公司" {
+		t.Errorf("profit sharing req should not be modified, got name(%v)", p.Receivers[0].Name)
+	}
+	if profitSharingOrder, err = QueryProfitSharingCommit(path, "", "4208450740201411110007820472", "P20150806125346"); err != nil || profitSharingOrder.OrderId != "3008450740201411110007820472" {
+		t.Errorf("unexpected query result(%+v) error(%v)", profitSharingOrder, err)
+	}
+
+	returnReq := &ProfitSharingReturnReq{OutOrderNo: "P20150806125346", OutReturnNo: "R20190516001", ReturnMchId: "86693852", Amount: money.Fen(10), Description: "用户退款"}
+	returnOrder, err := returnReq.Return(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if returnOrder.Result != ReturnResultProcessing || returnOrder.Amount.Minor() != 10 || returnOrder.OutReturnNo != "R20190516001" {
+		t.Errorf("unexpected return order(%+v)", returnOrder)
+	}
+}
@@ -0,0 +1,86 @@
+package wechatpay
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+)
+
+//退款结果通知API详情请查阅:https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_4_11.shtml
+//退款状态改变后，微信会把相关退款结果发送给商户, event_type为REFUND.SUCCESS/REFUND.ABNORMAL/REFUND.CLOSED
+
+/*
+resource 解密 -> RefundNotify 示例如下：
+{
+    "mchid": "1900000100",
+    "transaction_id": "1008450740201411110005820873",
+    "out_trade_no": "20150806125346",
+    "refund_id": "50200207182018070300011301001",
+    "out_refund_no": "7752501201407033233368018",
+    "refund_status": "SUCCESS",
+    "success_time": "2018-06-08T10:34:56+08:00",
+    "user_received_account": "招商银行信用卡0403",
+    "amount" : {
+        "total": 999,
+        "refund": 999,
+        "payer_total": 999,
+        "payer_refund": 999
+    }
+}
+*/
+
+// 退款通知类型
+const (
+	RefundEventSuccess  = "REFUND.SUCCESS"  //退款成功
+	RefundEventAbnormal = "REFUND.ABNORMAL" //退款异常
+	RefundEventClosed   = "REFUND.CLOSED"   //退款关闭
+)
+
+// 退款通知resource解密后的结构
+type RefundNotify struct {
+	MchId               string              `json:"mchid,omitempty"`       //直连商户号
+	SpMchId             string              `json:"sp_mchid,omitempty"`    //服务商户号(服务商模式)
+	SubMchId            string              `json:"sub_mchid,omitempty"`   //子商户号(服务商模式)
+	TransactionId       string              `json:"transaction_id"`        //微信支付订单号
+	OutTradeNo          string              `json:"out_trade_no"`          //商户订单号
+	RefundId            string              `json:"refund_id"`             //微信支付退款单号
+	OutRefundNo         string              `json:"out_refund_no"`         //商户退款单号
+	RefundStatus        string              `json:"refund_status"`         //退款状态 SUCCESS/CLOSED/ABNORMAL
+	SuccessTime         string              `json:"success_time"`          //[非必填]退款成功时间
+	UserReceivedAccount string              `json:"user_received_account"` //退款入账账户
+	Amount              *RefundNotifyAmount `json:"amount"`                //金额信息
+}
+
+type RefundNotifyAmount struct {
+	Total       money.Amount `json:"total"`        //订单金额
+	Refund      money.Amount `json:"refund"`       //退款金额
+	PayerTotal  money.Amount `json:"payer_total"`  //用户支付金额
+	PayerRefund money.Amount `json:"payer_refund"` //用户退款金额
+}
+
+/*
+[RefundNotifyHandle]
+处理微信退款结果通知,每次通知都解密到新的*RefundNotify并传给options.OnRefund
+ctx: 上下文信息
+path: 示例 "/path/to/merchant/apiclient_key.pem"
+options: 提供钩子函数
+*/
+func RefundNotifyHandle(ctx context.Context, path string, options *Option) http.HandlerFunc {
+	return notifierHandle(ctx, path, func(n *Notifier) http.HandlerFunc {
+		return n.RefundNotifyHandle(ctx, options)
+	})
+}
+
+// [RefundNotifyHandle]-> 使用已创建的Notifier处理退款结果通知,见RefundNotifyHandle
+func (n *Notifier) RefundNotifyHandle(ctx context.Context, options *Option) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		refundNotify := &RefundNotify{}
+		n.serve(ctx, w, r, "RefundNotifyHandle", eventRefund, refundNotify, options, func(myNotifyReq *NotifyReq) error {
+			if options == nil || options.OnRefund == nil {
+				return nil
+			}
+			return options.OnRefund(ctx, myNotifyReq, refundNotify)
+		})
+	}
+}
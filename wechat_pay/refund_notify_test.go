@@ -0,0 +1,44 @@
+package wechatpay
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRefundNotifyUnmarshal(t *testing.T) {
+	plaintext := `{"mchid":"1900000100","transaction_id":"1008450740201411110005820873","out_trade_no":"20150806125346",
+	"refund_id":"50200207182018070300011301001","out_refund_no":"7752501201407033233368018","refund_status":"SUCCESS",
+	"success_time":"2018-06-08T10:34:56+08:00","user_received_account":"招商银行信用卡0403",
+	"amount":{"total":999,"refund":999,"payer_total":999,"payer_refund":999}}`
+	refundNotify := &RefundNotify{}
+	if err := json.Unmarshal([]byte(plaintext), refundNotify); err != nil {
+		t.Error(err)
+		return
+	}
+	if refundNotify.RefundStatus != RefundStatusSuccess || refundNotify.Amount == nil || refundNotify.Amount.Refund.Minor() != 999 {
+		t.Errorf("unexpected refundNotify(%+v)", refundNotify)
+	}
+}
+
+func TestRefundNotifyHandle(t *testing.T) {
+	// 商户私钥不存在时应答FAIL,options为nil时不能panic
+	handler := RefundNotifyHandle(context.Background(), ".././key.pem", nil)
+	r := httptest.NewRequest(http.MethodPost, "/notify/refund", strings.NewReader(`{}`))
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("unexpected status(%v)", w.Code)
+	}
+	res := &NotifyRes{}
+	if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+		t.Error(err)
+		return
+	}
+	if res.Code != "FAIL" {
+		t.Errorf("unexpected code(%v)", res.Code)
+	}
+}
@@ -1,28 +1,161 @@
 package wechatpay
 
 import (
-	"fmt"
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/tanjl855/Sms_Pay_SDK/httprecord"
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+	"github.com/tanjl855/Sms_Pay_SDK/paytest"
+	"github.com/wechatpay-apiv3/wechatpay-go/core"
 )
 
 func TestCheckDate(t *testing.T) {
 	nativeReq := &NativeReq{}
 	nativeReq.SuccessTime = "2018-06-08T10:34:56+08:00"
-	CheckDate(nativeReq.SuccessTime)
+	if CheckDate(nativeReq.SuccessTime) {
+		t.Errorf("SuccessTime(%v) more than a year should not refund", nativeReq.SuccessTime)
+	}
+	if !CheckDate(time.Now().AddDate(0, -11, 0).Format(time.RFC3339)) {
+		t.Error("SuccessTime within a year should refund")
+	}
+	if CheckDate("") {
+		t.Error("empty SuccessTime should not refund")
+	}
+}
+
+func TestRefundDeadline(t *testing.T) {
+	successTime := time.Date(2018, 6, 8, 10, 34, 56, 0, time.FixedZone("CST", 8*3600))
+	if deadline := RefundDeadline(successTime); !deadline.Equal(time.Date(2019, 6, 8, 10, 34, 56, 0, time.FixedZone("CST", 8*3600))) {
+		t.Errorf("unexpected deadline(%v)", deadline)
+	}
 }
 
 func TestRefundCommit(t *testing.T) {
+	fake := paytest.NewWechat()
+	defer fake.Close()
+	path := usePaytestConfig(t, fake)
+	notifyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer notifyServer.Close()
+	fake.NotifyURL = notifyServer.URL
 	outTradeNo := "xxx"
+	n := NewNativeReq("lalla", outTradeNo, "https://xxx.com", NativeAmount{Total: money.Fen(10000)})
+	if _, err := NativeCommit("wxd678efh567hg6787", "", path, nil, n); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.Pay(outTradeNo); err != nil {
+		t.Fatal(err)
+	}
 	amount := &RefundAmount{}
-	amount.Refund = 10000
+	amount.Refund = money.Fen(10000)
 	amount.Currency = "CNY"
-	amount.Total = 10000
-	refundReq := NewRefundReq(outTradeNo, "", amount)
+	amount.Total = money.Fen(10000)
+	refundReq := NewRefundReq(outTradeNo, "refund-xxx", amount)
 	refundReq.SuccessTime = "2018-06-08T10:34:56+08:00"
-	resp, err := RefundCommit(".././key.pem", refundReq)
+	if _, err := RefundCommit(path, refundReq); err == nil {
+		t.Error("SuccessTime more than a year but no return err")
+	}
+	if _, err := DefaultConfig.withPrivateKeyPath(path).Refund(context.Background(), refundReq); err == nil {
+		t.Error("Config.Refund: SuccessTime more than a year but no return err")
+	}
+	if _, ok := fake.Refund("refund-xxx"); ok {
+		t.Error("expired refund should not be sent")
+	}
+	//未设置SuccessTime时不校验
+	refundReq.SuccessTime = ""
+	resp, err := RefundCommit(path, refundReq)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	fmt.Println(resp)
+	if resp.Status != RefundStatusProcessing || resp.OutRefundNo != "refund-xxx" || resp.Amount == nil || resp.Amount.Refund.Minor() != 10000 {
+		t.Errorf("unexpected resp(%+v)", resp)
+	}
+	if err = fake.CompleteRefund("refund-xxx"); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err = QueryRefundCommit(path, "refund-xxx"); err != nil || resp.Status != RefundStatusSuccess {
+		t.Errorf("unexpected query resp(%+v, %v)", resp, err)
+	}
+}
+
+func TestQueryRefundCommit(t *testing.T) {
+	if _, err := QueryRefundCommit(".././key.pem", ""); err == nil {
+		t.Error("empty outRefundNo but no return err")
+	}
+}
+
+// 使用模拟微信支付的平台证书私钥给回放的应答重新签名 录制的应答签名已脱敏
+type resignTransport struct {
+	transport http.RoundTripper
+	fake      *paytest.Wechat
+}
+
+func (r *resignTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range r.fake.SignHeader(body) {
+		res.Header[k] = v
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return res, nil
+}
+
+// 回放golden文件中的微信支付退款应答 应答重新签名后照常验签
+// golden文件为手工维护的合成数据,不能重新录制,修改请求格式时需同步更新
+func TestRefundReplay(t *testing.T) {
+	rec, err := httprecord.New("testdata/httprecord/refund.json", httprecord.ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := paytest.NewWechat()
+	fake.Close()
+	config := &Config{
+		MchId:                      "1900000109",
+		MchCertificateSerialNumber: "3775B6A45ACD588826D15E583A95F5DD00000000",
+		PrivateKey:                 fake.MchPrivateKey,
+		PlatformCertificates:       []*x509.Certificate{fake.PlatformCertificate},
+		HttpClient:                 &http.Client{Transport: &resignTransport{transport: rec, fake: fake}},
+	}
+	ctx := context.Background()
+	amount := &RefundAmount{Refund: money.Fen(100), Total: money.Fen(100), Currency: "CNY"}
+	res, err := config.Refund(ctx, NewRefundReq("1217752501201407033233368018", "1217752501201407033233368018", amount))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectAmount := RespAmount{Total: money.Fen(100), Refund: money.Fen(100), PayerTotal: money.Fen(90), PayerRefund: money.Fen(90),
+		SettlementRefund: money.Fen(100), SettlementTotal: money.Fen(100), DiscountRefund: money.Fen(10), Currency: "CNY"}
+	if res.RefundId != "50000000382019052709732678859" || res.OutRefundNo != "1217752501201407033233368018" || res.Status != RefundStatusProcessing ||
+		res.Channel != "ORIGINAL" || res.UserReceivedAccount != "招商银行信用卡0403" || res.CreateTime != "2023-06-01T16:00:00+08:00" ||
+		res.SuccessTime != "" || res.FundsAccount != "UNSETTLED" || res.Amount == nil || *res.Amount != expectAmount {
+		t.Errorf("unexpected refund res(%+v)", res)
+	}
+	if res, err = config.QueryRefund(ctx, "1217752501201407033233368018", ""); err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != RefundStatusSuccess || res.SuccessTime != "2023-06-01T16:00:12+08:00" {
+		t.Errorf("unexpected query refund res(%+v)", res)
+	}
+	//余额不足
+	_, err = config.Refund(ctx, NewRefundReq("1217752501201407033233368018", "1217752501201407033233368019", amount))
+	var apiErr *core.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden || apiErr.Code != "NOT_ENOUGH" {
+		t.Errorf("expect NOT_ENOUGH error, got(%v)", err)
+	}
 }
@@ -0,0 +1,155 @@
+package wechatpay
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/wechatpay-apiv3/wechatpay-go/utils"
+)
+
+//敏感信息加解密API详情请查阅:https://pay.weixin.qq.com/wiki/doc/apiv3/wechatpay/wechatpay4_3.shtml
+//请求中的敏感字段使用微信支付平台证书(RSA-OAEP)加密,请求头Wechatpay-Serial须与所用证书一致
+//应答中的敏感字段使用商户私钥(RSA-OAEP)解密
+//需要加解密的字段使用tag标记,与wechatpay-go保持一致,示例:
+/*
+type TransferDetail struct {
+	UserName string `json:"user_name,omitempty" encryption:"EM_APIV3"`
+}
+*/
+
+const (
+	sensitiveTag      = "encryption"
+	sensitiveTagValue = "EM_APIV3"
+)
+
+var ErrSensitiveTarget = errors.New("sensitive target must be a non-nil pointer to struct")
+
+/*
+[EncryptSensitive]-> 使用平台证书加密v中带有encryption:"EM_APIV3"标记的字段,原地替换为密文,返回所用证书序列号
+平台证书由newClient/NotifyHandle注册的证书下载器提供,须在其之后调用
+v: 结构体指针,嵌套的结构体、指针及切片会递归处理;如需保留明文请传入副本
+*/
+func EncryptSensitive(ctx context.Context, v interface{}) (serial string, err error) {
+	return DefaultConfig.EncryptSensitive(ctx, v)
+}
+
+/*
+[EncryptSensitive]-> 使用Config的平台证书加密v中的敏感字段,返回所用证书序列号
+未配置PlatformCertificates时,平台证书由NewClient/NewNotifier注册的证书下载器提供
+*/
+func (c *Config) EncryptSensitive(ctx context.Context, v interface{}) (serial string, err error) {
+	certificateGetter := c.certificateGetter()
+	serial = certificateGetter.GetNewestSerial(ctx)
+	certificate, ok := certificateGetter.Get(ctx, serial)
+	if !ok {
+		fmt.Printf("EncryptSensitive-> platform certificate(%v) not found", serial)
+		return "", fmt.Errorf("platform certificate(%v) not found", serial)
+	}
+	if err = EncryptSensitiveWithCertificate(v, certificate); err != nil {
+		return "", err
+	}
+	return serial, nil
+}
+
+/*
+[EncryptSensitiveWithCertificate]-> 使用指定的平台证书加密v中的敏感字段
+同一请求中的所有敏感字段必须使用同一证书加密
+*/
+func EncryptSensitiveWithCertificate(v interface{}, certificate *x509.Certificate) error {
+	return walkSensitive(v, func(field *string) error {
+		ciphertext, err := utils.EncryptOAEPWithCertificate(*field, certificate)
+		if err != nil {
+			fmt.Printf("EncryptSensitiveWithCertificate-> EncryptOAEPWithCertificate error(%v)", err)
+			return err
+		}
+		*field = ciphertext
+		return nil
+	})
+}
+
+/*
+[DecryptSensitive]-> 使用商户私钥解密v中带有encryption:"EM_APIV3"标记的字段,原地替换为明文
+path:本地文件中商户私钥的位置
+*/
+func DecryptSensitive(path string, v interface{}) error {
+	mchPrivateKey, err := utils.LoadPrivateKeyWithPath(path)
+	if err != nil {
+		fmt.Printf("DecryptSensitive-> LoadPrivateKeyWithPath error(%v)", err)
+		return err
+	}
+	return DecryptSensitiveWithPrivateKey(v, mchPrivateKey)
+}
+
+// [DecryptSensitiveWithPrivateKey]-> 使用指定的商户私钥解密v中的敏感字段
+func DecryptSensitiveWithPrivateKey(v interface{}, privateKey *rsa.PrivateKey) error {
+	return walkSensitive(v, func(field *string) error {
+		plaintext, err := utils.DecryptOAEP(*field, privateKey)
+		if err != nil {
+			fmt.Printf("DecryptSensitiveWithPrivateKey-> DecryptOAEP error(%v)", err)
+			return err
+		}
+		*field = plaintext
+		return nil
+	})
+}
+
+// 遍历v中所有非空的敏感字段并调用fn
+func walkSensitive(v interface{}, fn func(*string) error) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return ErrSensitiveTarget
+	}
+	return walkSensitiveValue(value.Elem(), fn)
+}
+
+func walkSensitiveValue(value reflect.Value, fn func(*string) error) error {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if value.IsNil() {
+			return nil
+		}
+		return walkSensitiveValue(value.Elem(), fn)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			if err := walkSensitiveValue(value.Index(i), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		valueType := value.Type()
+		for i := 0; i < value.NumField(); i++ {
+			structField := valueType.Field(i)
+			if structField.PkgPath != "" {
+				//未导出字段
+				continue
+			}
+			field := value.Field(i)
+			if structField.Tag.Get(sensitiveTag) != sensitiveTagValue {
+				if err := walkSensitiveValue(field, fn); err != nil {
+					return err
+				}
+				continue
+			}
+			if field.Kind() == reflect.Ptr {
+				if field.IsNil() {
+					continue
+				}
+				field = field.Elem()
+			}
+			if field.Kind() != reflect.String || !field.CanSet() {
+				return fmt.Errorf("sensitive field(%v) must be string or *string", structField.Name)
+			}
+			if field.String() == "" {
+				continue
+			}
+			if err := fn(field.Addr().Interface().(*string)); err != nil {
+				return fmt.Errorf("sensitive field(%v): %v", structField.Name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package wechatpay
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func newTestCertificate(t *testing.T) (*rsa.PrivateKey, *x509.Certificate) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Tenpay.com Root CA"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return privateKey, certificate
+}
+
+type sensitiveIdCard struct {
+	Number *string `json:"number,omitempty" encryption:"EM_APIV3"`
+}
+
+type sensitiveReq struct {
+	Name    string            `json:"name" encryption:"EM_APIV3"`
+	Remark  string            `json:"remark"`
+	IdCard  *sensitiveIdCard  `json:"id_card,omitempty"`
+	Empty   *string           `json:"empty,omitempty" encryption:"EM_APIV3"`
+	Details []*TransferDetail `json:"details"`
+	Other   []sensitiveIdCard `json:"other"`
+}
+
+func TestSensitiveEncryptDecrypt(t *testing.T) {
+	privateKey, certificate := newTestCertificate(t)
+	number := "440100199001011234"
+	req := &sensitiveReq{
+		Name:    "张三",
+		Remark:  "备注",
+		IdCard:  &sensitiveIdCard{Number: &number},
+		Details: []*TransferDetail{{OutDetailNo: "x23zy545Bd5436", UserName: "李四"}, {OutDetailNo: "x23zy545Bd5437"}},
+		Other:   []sensitiveIdCard{{}},
+	}
+	if err := EncryptSensitiveWithCertificate(req, certificate); err != nil {
+		t.Fatal(err)
+	}
+	if req.Name == "张三" || *req.IdCard.Number == "440100199001011234" || req.Details[0].UserName == "李四" {
+		t.Fatalf("sensitive fields not encrypted(%+v)", req)
+	}
+	if req.Remark != "备注" || req.Details[1].UserName != "" || req.Details[0].OutDetailNo != "x23zy545Bd5436" {
+		t.Fatalf("plain fields changed(%+v)", req)
+	}
+	if err := DecryptSensitiveWithPrivateKey(req, privateKey); err != nil {
+		t.Fatal(err)
+	}
+	if req.Name != "张三" || *req.IdCard.Number != "440100199001011234" || req.Details[0].UserName != "李四" {
+		t.Errorf("sensitive fields not decrypted(%+v)", req)
+	}
+}
+
+func TestSensitiveTarget(t *testing.T) {
+	_, certificate := newTestCertificate(t)
+	if err := EncryptSensitiveWithCertificate(sensitiveReq{}, certificate); err != ErrSensitiveTarget {
+		t.Errorf("struct value but return err(%v)", err)
+	}
+	var req *sensitiveReq
+	if err := EncryptSensitiveWithCertificate(req, certificate); err != ErrSensitiveTarget {
+		t.Errorf("nil pointer but return err(%v)", err)
+	}
+	invalid := &struct {
+		Age int `encryption:"EM_APIV3"`
+	}{Age: 18}
+	if err := EncryptSensitiveWithCertificate(invalid, certificate); err == nil {
+		t.Error("non-string sensitive field but no return err")
+	}
+}
@@ -0,0 +1,443 @@
+package wechatpay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+)
+
+//商家转账到零钱API详情请查阅:https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter4_3_1.shtml
+//收款用户姓名user_name需使用微信支付平台证书加密,请求头带Wechatpay-Serial;查询明细返回的user_name使用商户私钥解密
+
+//示例
+/*
+{
+	"appid": "wxf636efh567hg4356",
+	"out_batch_no": "plfk2020042013",
+	"batch_name": "2019年1月深圳分部报销单",
+	"batch_remark": "2019年1月深圳分部报销单",
+	"total_amount": 4000000,
+	"total_num": 200,
+	"transfer_detail_list": [{
+		"out_detail_no": "x23zy545Bd5436",
+		"transfer_amount": 200000,
+		"transfer_remark": "2020年4月报销",
+		"openid": "o-MYE42l80oelYMDE34nYD456Xoy",
+		"user_name": "757b340b45ebef5467rter35gf464344v3542sdf4t6re4tb4f54ty45t4yyry45"
+	}]
+}
+*/
+
+// 批次状态&明细状态
+const (
+	BatchStatusAccepted   = "ACCEPTED"   //已受理
+	BatchStatusProcessing = "PROCESSING" //转账中
+	BatchStatusFinished   = "FINISHED"   //已完成
+	BatchStatusClosed     = "CLOSED"     //已关闭
+
+	DetailStatusInit       = "INIT"       //初始态
+	DetailStatusWaitPay    = "WAIT_PAY"   //待确认
+	DetailStatusProcessing = "PROCESSING" //转账中
+	DetailStatusSuccess    = "SUCCESS"    //转账成功
+	DetailStatusFail       = "FAIL"       //转账失败
+)
+
+// 单笔转账金额达到2000元时必须填写收款用户姓名
+var userNameRequiredAmount = money.Fen(200000)
+
+type TransferBatch interface {
+	Transfer(path string) (*TransferBatchRes, error)
+}
+
+var _ TransferBatch = &TransferBatchReq{}
+
+// 发起商家转账req POST https://api.mch.weixin.qq.com/v3/transfer/batches
+type TransferBatchReq struct {
+	AppId              string            `json:"appid"`                       //商户appid
+	OutBatchNo         string            `json:"out_batch_no"`                //商家批次单号
+	BatchName          string            `json:"batch_name"`                  //批次名称
+	BatchRemark        string            `json:"batch_remark"`                //批次备注
+	TotalAmount        money.Amount      `json:"total_amount"`                //转账总金额 须与明细金额之和一致
+	TotalNum           int               `json:"total_num"`                   //转账总笔数 须与明细笔数一致
+	TransferDetailList []*TransferDetail `json:"transfer_detail_list"`        //转账明细列表 最多1000笔
+	TransferSceneId    string            `json:"transfer_scene_id,omitempty"` //[非必填]转账场景ID
+	Debug              bool              `json:"-"`
+}
+
+// 转账明细
+type TransferDetail struct {
+	OutDetailNo    string       `json:"out_detail_no"`                             //商家明细单号
+	TransferAmount money.Amount `json:"transfer_amount"`                           //转账金额 单位为分
+	TransferRemark string       `json:"transfer_remark"`                           //转账备注
+	OpenId         string       `json:"openid"`                                    //用户在appid下的唯一标识
+	UserName       string       `json:"user_name,omitempty" encryption:"EM_APIV3"` //[非必填]收款用户姓名 明文传入,请求时使用平台证书加密
+}
+
+// 发起商家转账res
+type TransferBatchRes struct {
+	OutBatchNo  string `json:"out_batch_no"`           //商家批次单号
+	BatchId     string `json:"batch_id"`               //微信批次单号
+	CreateTime  string `json:"create_time"`            //批次创建时间
+	BatchStatus string `json:"batch_status,omitempty"` //批次状态
+}
+
+// 查询批次req
+type TransferBatchQuery struct {
+	NeedQueryDetail bool   //是否查询转账明细单
+	Offset          int    //[非必填]请求资源起始位置 默认0
+	Limit           int    //[非必填]最大资源条数 默认20 最大100
+	DetailStatus    string //[非必填]明细状态 ALL/SUCCESS/FAIL NeedQueryDetail为true时必填
+}
+
+// 查询批次res
+type TransferBatchEntity struct {
+	TransferBatch      *TransferBatchInfo     `json:"transfer_batch"`                 //转账批次单
+	TransferDetailList []*TransferDetailBrief `json:"transfer_detail_list,omitempty"` //转账明细单列表
+}
+
+// 转账批次单
+type TransferBatchInfo struct {
+	MchId           string       `json:"mchid"`                       //商户号
+	OutBatchNo      string       `json:"out_batch_no"`                //商家批次单号
+	BatchId         string       `json:"batch_id"`                    //微信批次单号
+	AppId           string       `json:"appid"`                       //商户appid
+	BatchStatus     string       `json:"batch_status"`                //批次状态
+	BatchType       string       `json:"batch_type"`                  //批次类型 API/WEB
+	BatchName       string       `json:"batch_name"`                  //批次名称
+	BatchRemark     string       `json:"batch_remark"`                //批次备注
+	CloseReason     string       `json:"close_reason,omitempty"`      //批次关闭原因
+	TotalAmount     money.Amount `json:"total_amount"`                //转账总金额
+	TotalNum        int          `json:"total_num"`                   //转账总笔数
+	CreateTime      string       `json:"create_time,omitempty"`       //批次创建时间
+	UpdateTime      string       `json:"update_time,omitempty"`       //批次更新时间
+	SuccessAmount   money.Amount `json:"success_amount"`              //转账成功金额
+	SuccessNum      int          `json:"success_num,omitempty"`       //转账成功笔数
+	FailAmount      money.Amount `json:"fail_amount"`                 //转账失败金额
+	FailNum         int          `json:"fail_num,omitempty"`          //转账失败笔数
+	TransferSceneId string       `json:"transfer_scene_id,omitempty"` //转账场景ID
+}
+
+// 转账明细单(批次查询)
+type TransferDetailBrief struct {
+	DetailId     string `json:"detail_id"`     //微信明细单号
+	OutDetailNo  string `json:"out_detail_no"` //商家明细单号
+	DetailStatus string `json:"detail_status"` //明细状态
+}
+
+// 转账明细单(明细查询)
+type TransferDetailEntity struct {
+	MchId          string       `json:"mchid"`                                     //商户号
+	OutBatchNo     string       `json:"out_batch_no"`                              //商家批次单号
+	BatchId        string       `json:"batch_id"`                                  //微信批次单号
+	AppId          string       `json:"appid"`                                     //商户appid
+	OutDetailNo    string       `json:"out_detail_no"`                             //商家明细单号
+	DetailId       string       `json:"detail_id"`                                 //微信明细单号
+	DetailStatus   string       `json:"detail_status"`                             //明细状态
+	TransferAmount money.Amount `json:"transfer_amount"`                           //转账金额
+	TransferRemark string       `json:"transfer_remark"`                           //转账备注
+	FailReason     string       `json:"fail_reason,omitempty"`                     //明细失败原因
+	OpenId         string       `json:"openid"`                                    //用户在appid下的唯一标识
+	UserName       string       `json:"user_name,omitempty" encryption:"EM_APIV3"` //收款用户姓名 已使用商户私钥解密
+	InitiateTime   string       `json:"initiate_time"`                             //转账发起时间
+	UpdateTime     string       `json:"update_time"`                               //明细更新时间
+}
+
+// 电子回单
+type TransferReceipt struct {
+	AcceptType      string `json:"accept_type,omitempty"`   //受理类型 BATCH_TRANSFER(明细回单)
+	OutBatchNo      string `json:"out_batch_no"`            //商家批次单号
+	OutDetailNo     string `json:"out_detail_no,omitempty"` //商家明细单号(明细回单)
+	SignatureNo     string `json:"signature_no"`            //电子回单申请单号
+	SignatureStatus string `json:"signature_status"`        //电子回单状态 ACCEPTED/FINISHED
+	HashType        string `json:"hash_type,omitempty"`     //电子回单文件的hash方法 SHA256
+	HashValue       string `json:"hash_value,omitempty"`    //电子回单文件的hash值
+	DownloadUrl     string `json:"download_url,omitempty"`  //电子回单文件的下载地址 FINISHED时返回
+	CreateTime      string `json:"create_time,omitempty"`   //创建时间
+	UpdateTime      string `json:"update_time,omitempty"`   //更新时间
+}
+
+const (
+	ReceiptStatusAccepted = "ACCEPTED" //已受理
+	ReceiptStatusFinished = "FINISHED" //已完成
+
+	receiptAcceptType = "BATCH_TRANSFER"
+)
+
+func NewTransferBatchReq(appId, outBatchNo, batchName, batchRemark string, details ...*TransferDetail) *TransferBatchReq {
+	transferBatchReq := &TransferBatchReq{
+		AppId:              appId,
+		OutBatchNo:         outBatchNo,
+		BatchName:          batchName,
+		BatchRemark:        batchRemark,
+		TransferDetailList: details,
+		TotalNum:           len(details),
+	}
+	for _, detail := range details {
+		//金额溢出或币种不一致时保留已累计的金额,由check校验报错
+		if total, err := transferBatchReq.TotalAmount.Add(detail.TransferAmount); err == nil {
+			transferBatchReq.TotalAmount = total
+		}
+	}
+	return transferBatchReq
+}
+
+// 校验总金额、总笔数及姓名必填规则
+func (t *TransferBatchReq) check() error {
+	if len(t.TransferDetailList) == 0 {
+		return errors.New("TransferBatchReq-> TransferDetailList can not be empty")
+	}
+	var totalAmount money.Amount
+	for _, detail := range t.TransferDetailList {
+		var err error
+		if totalAmount, err = totalAmount.Add(detail.TransferAmount); err != nil {
+			return fmt.Errorf("TransferBatchReq-> detail(%v) amount error(%w)", detail.OutDetailNo, err)
+		}
+		if cmp, _ := detail.TransferAmount.Cmp(userNameRequiredAmount); cmp >= 0 && detail.UserName == "" {
+			return fmt.Errorf("TransferBatchReq-> detail(%v) UserName is required when amount >= %v", detail.OutDetailNo, userNameRequiredAmount.Yuan())
+		}
+	}
+	if !totalAmount.Equal(t.TotalAmount) || len(t.TransferDetailList) != t.TotalNum {
+		return fmt.Errorf("TransferBatchReq-> total_amount(%v)/total_num(%v) mismatch details(%v/%v)",
+			t.TotalAmount, t.TotalNum, totalAmount, len(t.TransferDetailList))
+	}
+	return nil
+}
+
+/*
+[Transfer]-> 发起商家转账 POST https://api.mch.weixin.qq.com/v3/transfer/batches
+UserName使用平台证书加密后发送,t本身不会被修改
+path:本地文件中商户私钥的位置
+*/
+func (t *TransferBatchReq) Transfer(path string) (*TransferBatchRes, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	client, err := newClient(ctx, path)
+	if err != nil {
+		fmt.Printf("Transfer-> newClient error(%v)", err)
+		return nil, err
+	}
+	encryptReq := *t
+	encryptReq.TransferDetailList = make([]*TransferDetail, 0, len(t.TransferDetailList))
+	for _, detail := range t.TransferDetailList {
+		encryptDetail := *detail
+		encryptReq.TransferDetailList = append(encryptReq.TransferDetailList, &encryptDetail)
+	}
+	serial, err := EncryptSensitive(ctx, &encryptReq)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	header.Set("Wechatpay-Serial", serial)
+	transferBatchRes := &TransferBatchRes{}
+	url := DefaultConfig.domain() + "/v3/transfer/batches"
+	if err = doRequest(ctx, client, http.MethodPost, url, header, &encryptReq, transferBatchRes); err != nil {
+		return nil, err
+	}
+	Debug(t.Debug, "transfer batch(%v) accepted", transferBatchRes)
+	return transferBatchRes, nil
+}
+
+/*
+[TransferBatchCommit]->上层调用发起商家转账
+path:本地文件中商户私钥的位置
+*/
+func TransferBatchCommit(path string, transferBatchReq TransferBatch) (*TransferBatchRes, error) {
+	if transferBatchReq == nil {
+		fmt.Printf("TransferBatchCommit-> transferBatchReq can not be nil")
+		return nil, errors.New("TransferBatchCommit-> transferBatchReq can not be nil")
+	}
+	return transferBatchReq.Transfer(path)
+}
+
+/*
+[QueryBatchById]-> 通过微信批次单号查询批次单 GET https://api.mch.weixin.qq.com/v3/transfer/batches/batch-id/{batch_id}
+path:本地文件中商户私钥的位置
+query:为nil时不查询明细
+*/
+func QueryBatchById(path, batchId string, query *TransferBatchQuery) (*TransferBatchEntity, error) {
+	if batchId == "" {
+		return nil, errors.New("QueryBatchById-> batchId can not be empty")
+	}
+	return queryBatch(path, DefaultConfig.domain()+"/v3/transfer/batches/batch-id/"+neturl.PathEscape(batchId), query)
+}
+
+/*
+[QueryBatchByOutBatchNo]-> 通过商家批次单号查询批次单 GET https://api.mch.weixin.qq.com/v3/transfer/batches/out-batch-no/{out_batch_no}
+path:本地文件中商户私钥的位置
+query:为nil时不查询明细
+*/
+func QueryBatchByOutBatchNo(path, outBatchNo string, query *TransferBatchQuery) (*TransferBatchEntity, error) {
+	if outBatchNo == "" {
+		return nil, errors.New("QueryBatchByOutBatchNo-> outBatchNo can not be empty")
+	}
+	return queryBatch(path, DefaultConfig.domain()+"/v3/transfer/batches/out-batch-no/"+neturl.PathEscape(outBatchNo), query)
+}
+
+func queryBatch(path, url string, query *TransferBatchQuery) (*TransferBatchEntity, error) {
+	if query == nil {
+		query = &TransferBatchQuery{}
+	}
+	values := neturl.Values{}
+	values.Set("need_query_detail", strconv.FormatBool(query.NeedQueryDetail))
+	if query.NeedQueryDetail {
+		values.Set("offset", strconv.Itoa(query.Offset))
+		if query.Limit > 0 {
+			values.Set("limit", strconv.Itoa(query.Limit))
+		}
+		detailStatus := query.DetailStatus
+		if detailStatus == "" {
+			detailStatus = "ALL"
+		}
+		values.Set("detail_status", detailStatus)
+	}
+	ctx := context.Background()
+	client, err := newClient(ctx, path)
+	if err != nil {
+		fmt.Printf("queryBatch-> newClient error(%v)", err)
+		return nil, err
+	}
+	transferBatchEntity := &TransferBatchEntity{}
+	if err = doRequest(ctx, client, http.MethodGet, url+"?"+values.Encode(), nil, nil, transferBatchEntity); err != nil {
+		return nil, err
+	}
+	return transferBatchEntity, nil
+}
+
+/*
+[QueryDetailById]-> 通过微信明细单号查询明细单 GET https://api.mch.weixin.qq.com/v3/transfer/batches/batch-id/{batch_id}/details/detail-id/{detail_id}
+返回的UserName已使用商户私钥解密
+path:本地文件中商户私钥的位置
+*/
+func QueryDetailById(path, batchId, detailId string) (*TransferDetailEntity, error) {
+	if batchId == "" || detailId == "" {
+		return nil, errors.New("QueryDetailById-> batchId and detailId can not be empty")
+	}
+	url := DefaultConfig.domain() + "/v3/transfer/batches/batch-id/" + neturl.PathEscape(batchId) + "/details/detail-id/" + neturl.PathEscape(detailId)
+	return queryDetail(path, url)
+}
+
+/*
+[QueryDetailByOutDetailNo]-> 通过商家明细单号查询明细单 GET https://api.mch.weixin.qq.com/v3/transfer/batches/out-batch-no/{out_batch_no}/details/out-detail-no/{out_detail_no}
+返回的UserName已使用商户私钥解密
+path:本地文件中商户私钥的位置
+*/
+func QueryDetailByOutDetailNo(path, outBatchNo, outDetailNo string) (*TransferDetailEntity, error) {
+	if outBatchNo == "" || outDetailNo == "" {
+		return nil, errors.New("QueryDetailByOutDetailNo-> outBatchNo and outDetailNo can not be empty")
+	}
+	url := DefaultConfig.domain() + "/v3/transfer/batches/out-batch-no/" + neturl.PathEscape(outBatchNo) + "/details/out-detail-no/" + neturl.PathEscape(outDetailNo)
+	return queryDetail(path, url)
+}
+
+func queryDetail(path, url string) (*TransferDetailEntity, error) {
+	ctx := context.Background()
+	client, err := newClient(ctx, path)
+	if err != nil {
+		fmt.Printf("queryDetail-> newClient error(%v)", err)
+		return nil, err
+	}
+	transferDetailEntity := &TransferDetailEntity{}
+	if err = doRequest(ctx, client, http.MethodGet, url, nil, nil, transferDetailEntity); err != nil {
+		return nil, err
+	}
+	if err = DecryptSensitive(path, transferDetailEntity); err != nil {
+		return nil, err
+	}
+	return transferDetailEntity, nil
+}
+
+/*
+[ApplyBatchReceipt]-> 转账批次电子回单申请受理 POST https://api.mch.weixin.qq.com/v3/transfer/bill-receipt
+path:本地文件中商户私钥的位置
+*/
+func ApplyBatchReceipt(path, outBatchNo string) (*TransferReceipt, error) {
+	if outBatchNo == "" {
+		return nil, errors.New("ApplyBatchReceipt-> outBatchNo can not be empty")
+	}
+	body := map[string]string{"out_batch_no": outBatchNo}
+	return receiptRequest(path, http.MethodPost, DefaultConfig.domain()+"/v3/transfer/bill-receipt", body)
+}
+
+/*
+[QueryBatchReceipt]-> 查询转账批次电子回单 GET https://api.mch.weixin.qq.com/v3/transfer/bill-receipt/{out_batch_no}
+path:本地文件中商户私钥的位置
+*/
+func QueryBatchReceipt(path, outBatchNo string) (*TransferReceipt, error) {
+	if outBatchNo == "" {
+		return nil, errors.New("QueryBatchReceipt-> outBatchNo can not be empty")
+	}
+	return receiptRequest(path, http.MethodGet, DefaultConfig.domain()+"/v3/transfer/bill-receipt/"+neturl.PathEscape(outBatchNo), nil)
+}
+
+/*
+[ApplyDetailReceipt]-> 转账明细电子回单受理 POST https://api.mch.weixin.qq.com/v3/transfer-detail/electronic-receipts
+path:本地文件中商户私钥的位置
+*/
+func ApplyDetailReceipt(path, outBatchNo, outDetailNo string) (*TransferReceipt, error) {
+	if outBatchNo == "" || outDetailNo == "" {
+		return nil, errors.New("ApplyDetailReceipt-> outBatchNo and outDetailNo can not be empty")
+	}
+	body := map[string]string{"accept_type": receiptAcceptType, "out_batch_no": outBatchNo, "out_detail_no": outDetailNo}
+	return receiptRequest(path, http.MethodPost, DefaultConfig.domain()+"/v3/transfer-detail/electronic-receipts", body)
+}
+
+/*
+[QueryDetailReceipt]-> 查询转账明细电子回单受理结果 GET https://api.mch.weixin.qq.com/v3/transfer-detail/electronic-receipts
+path:本地文件中商户私钥的位置
+*/
+func QueryDetailReceipt(path, outBatchNo, outDetailNo string) (*TransferReceipt, error) {
+	if outBatchNo == "" || outDetailNo == "" {
+		return nil, errors.New("QueryDetailReceipt-> outBatchNo and outDetailNo can not be empty")
+	}
+	values := neturl.Values{}
+	values.Set("accept_type", receiptAcceptType)
+	values.Set("out_batch_no", outBatchNo)
+	values.Set("out_detail_no", outDetailNo)
+	return receiptRequest(path, http.MethodGet, DefaultConfig.domain()+"/v3/transfer-detail/electronic-receipts?"+values.Encode(), nil)
+}
+
+func receiptRequest(path, method, url string, body interface{}) (*TransferReceipt, error) {
+	ctx := context.Background()
+	client, err := newClient(ctx, path)
+	if err != nil {
+		fmt.Printf("receiptRequest-> newClient error(%v)", err)
+		return nil, err
+	}
+	transferReceipt := &TransferReceipt{}
+	if err = doRequest(ctx, client, method, url, nil, body, transferReceipt); err != nil {
+		return nil, err
+	}
+	return transferReceipt, nil
+}
+
+/*
+[DownloadReceipt]-> 下载电子回单文件,并校验hash_value
+回单状态为FINISHED时才有download_url
+path:本地文件中商户私钥的位置
+*/
+func DownloadReceipt(path string, transferReceipt *TransferReceipt) ([]byte, error) {
+	if transferReceipt == nil || transferReceipt.SignatureStatus != ReceiptStatusFinished || transferReceipt.DownloadUrl == "" {
+		return nil, errors.New("DownloadReceipt-> receipt is not finished")
+	}
+	ctx := context.Background()
+	client, err := newClient(ctx, path)
+	if err != nil {
+		fmt.Printf("DownloadReceipt-> newClient error(%v)", err)
+		return nil, err
+	}
+	data, err := downloadFile(ctx, client, transferReceipt.DownloadUrl)
+	if err != nil {
+		return nil, err
+	}
+	if err = verifyHash(data, transferReceipt.HashType, transferReceipt.HashValue); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
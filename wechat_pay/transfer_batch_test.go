@@ -0,0 +1,136 @@
+package wechatpay
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+	"github.com/tanjl855/Sms_Pay_SDK/paytest"
+)
+
+func TestNewTransferBatchReq(t *testing.T) {
+	details := []*TransferDetail{
+		{OutDetailNo: "x23zy545Bd5436", TransferAmount: money.Fen(200000), TransferRemark: "2020年4月报销", OpenId: "o-MYE42l80oelYMDE34nYD456Xoy", UserName: "张三"},
+		{OutDetailNo: "x23zy545Bd5437", TransferAmount: money.Fen(100), TransferRemark: "2020年4月报销", OpenId: "o-MYE42l80oelYMDE34nYD456Xoz"},
+	}
+	transferBatchReq := NewTransferBatchReq("wxf636efh567hg4356", "plfk2020042013", "2019年1月深圳分部报销单", "2019年1月深圳分部报销单", details...)
+	if !transferBatchReq.TotalAmount.Equal(money.Fen(200100)) || transferBatchReq.TotalNum != 2 {
+		t.Errorf("unexpected total(%v/%v)", transferBatchReq.TotalAmount, transferBatchReq.TotalNum)
+	}
+	if err := transferBatchReq.check(); err != nil {
+		t.Error(err)
+	}
+	// 总金额不一致
+	transferBatchReq.TotalAmount = money.Fen(1)
+	if err := transferBatchReq.check(); err == nil {
+		t.Error("total_amount mismatch but no return err")
+	}
+	// 2000元以上未填姓名
+	details[0].UserName = ""
+	transferBatchReq = NewTransferBatchReq("wxf636efh567hg4356", "plfk2020042013", "报销", "报销", details...)
+	if _, err := TransferBatchCommit(".././key.pem", transferBatchReq); err == nil {
+		t.Error("user_name required but no return err")
+	}
+}
+
+func TestTransferRequest(t *testing.T) {
+	fake := paytest.NewWechat()
+	defer fake.Close()
+	path := usePaytestConfig(t, fake)
+	fake.Handle(http.MethodPost, "/v3/transfer/batches", func(r *http.Request, body []byte) (int, interface{}) {
+		// user_name需使用平台证书加密 并在Wechatpay-Serial中传入平台证书序列号
+		if serial := r.Header.Get("Wechatpay-Serial"); serial != fake.PlatformSerialNumber() {
+			t.Errorf("unexpected Wechatpay-Serial(%v)", serial)
+		}
+		req := &TransferBatchReq{}
+		if err := json.Unmarshal(body, req); err != nil {
+			t.Error(err)
+		}
+		if req.AppId != "wxf636efh567hg4356" || req.OutBatchNo != "plfk2020042013" || req.TotalAmount.Minor() != 200100 || req.TotalNum != 2 || len(req.TransferDetailList) != 2 {
+			t.Errorf("unexpected transfer request(%s)", body)
+			return http.StatusBadRequest, map[string]string{"code": "PARAM_ERROR", "message": "参数错误"}
+		}
+		if userName, err := fake.DecryptSensitive(req.TransferDetailList[0].UserName); err != nil || userName != "张三" {
+			t.Errorf("user_name(%v) is not encrypted with platform certificate: %v", req.TransferDetailList[0].UserName, err)
+		}
+		if req.TransferDetailList[1].UserName != "" || req.TransferDetailList[1].TransferAmount.Minor() != 100 {
+			t.Errorf("unexpected transfer detail(%+v)", req.TransferDetailList[1])
+		}
+		return http.StatusOK, map[string]string{"out_batch_no": req.OutBatchNo, "batch_id": "1030000071100999991182020050700019480001", "create_time": "2015-05-20T13:29:35.120+08:00"}
+	})
+	fake.Handle(http.MethodGet, "/v3/transfer/batches/out-batch-no/plfk2020042013", func(r *http.Request, body []byte) (int, interface{}) {
+		if query := r.URL.Query(); query.Get("need_query_detail") != "true" || query.Get("offset") != "0" || query.Get("detail_status") != "ALL" {
+			t.Errorf("unexpected query url(%v)", r.URL)
+		}
+		return http.StatusOK, map[string]interface{}{
+			"transfer_batch": map[string]interface{}{"mchid": fake.MchId, "out_batch_no": "plfk2020042013", "batch_id": "1030000071100999991182020050700019480001",
+				"batch_status": "ACCEPTED", "total_amount": 200100, "total_num": 2, "success_amount": 0, "fail_amount": 0},
+			"transfer_detail_list": []map[string]string{{"detail_id": "1040000071100999991182020050700019500100", "out_detail_no": "x23zy545Bd5436", "detail_status": "PROCESSING"}},
+		}
+	})
+	// 查询明细返回的user_name使用商户证书公钥加密
+	fake.Handle(http.MethodGet, "/v3/transfer/batches/out-batch-no/plfk2020042013/details/out-detail-no/x23zy545Bd5436", func(r *http.Request, body []byte) (int, interface{}) {
+		ciphertext, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, &fake.MchPrivateKey.PublicKey, []byte("张三"), nil)
+		if err != nil {
+			t.Error(err)
+		}
+		return http.StatusOK, map[string]interface{}{"mchid": fake.MchId, "out_batch_no": "plfk2020042013", "out_detail_no": "x23zy545Bd5436",
+			"detail_status": "SUCCESS", "transfer_amount": 200000, "openid": "o-MYE42l80oelYMDE34nYD456Xoy", "user_name": base64.StdEncoding.EncodeToString(ciphertext)}
+	})
+
+	details := []*TransferDetail{
+		{OutDetailNo: "x23zy545Bd5436", TransferAmount: money.Fen(200000), TransferRemark: "2020年4月报销", OpenId: "o-MYE42l80oelYMDE34nYD456Xoy", UserName: "张三"},
+		{OutDetailNo: "x23zy545Bd5437", TransferAmount: money.Fen(100), TransferRemark: "2020年4月报销", OpenId: "o-MYE42l80oelYMDE34nYD456Xoz"},
+	}
+	transferBatchReq := NewTransferBatchReq("wxf636efh567hg4356", "plfk2020042013", "2019年1月深圳分部报销单", "2019年1月深圳分部报销单", details...)
+	transferBatchRes, err := TransferBatchCommit(path, transferBatchReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transferBatchRes.BatchId != "1030000071100999991182020050700019480001" || transferBatchRes.OutBatchNo != "plfk2020042013" {
+		t.Errorf("unexpected transfer res(%+v)", transferBatchRes)
+	}
+	if details[0].UserName != "张三" {
+		t.Errorf("transfer req should not be modified, got user_name(%v)", details[0].UserName)
+	}
+
+	transferBatchEntity, err := QueryBatchByOutBatchNo(path, "plfk2020042013", &TransferBatchQuery{NeedQueryDetail: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transferBatchEntity.TransferBatch.TotalAmount.Minor() != 200100 || len(transferBatchEntity.TransferDetailList) != 1 {
+		t.Errorf("unexpected transfer batch(%+v)", transferBatchEntity)
+	}
+	transferDetailEntity, err := QueryDetailByOutDetailNo(path, "plfk2020042013", "x23zy545Bd5436")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transferDetailEntity.UserName != "张三" || transferDetailEntity.TransferAmount.Minor() != 200000 {
+		t.Errorf("unexpected transfer detail(%+v)", transferDetailEntity)
+	}
+}
+
+func TestDownloadReceipt(t *testing.T) {
+	transferReceipt := &TransferReceipt{OutBatchNo: "plfk2020042013", SignatureStatus: ReceiptStatusAccepted}
+	if _, err := DownloadReceipt(".././key.pem", transferReceipt); err == nil {
+		t.Error("receipt is not finished but no return err")
+	}
+}
+
+func TestVerifyHash(t *testing.T) {
+	data := []byte("hello")
+	if err := verifyHash(data, "SHA256", "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"); err != nil {
+		t.Error(err)
+	}
+	if err := verifyHash(data, "SHA1", "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"); err != nil {
+		t.Error(err)
+	}
+	if err := verifyHash(data, "MD5", "5d41402abc4b2a76b9719d911017c592"); err == nil {
+		t.Error("unsupported hash_type but no return err")
+	}
+}
@@ -0,0 +1,327 @@
+package wechatpay
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+//微信支付API v2(XML)详情请查阅:https://pay.weixin.qq.com/wiki/doc/api/micropay.php?chapter=4_3
+//v3没有提供的接口(如直连商户付款码支付)走v2,请求与应答均为XML,使用API v2密钥签名
+
+// 签名类型
+const (
+	SignTypeMD5        = "MD5"
+	SignTypeHMACSHA256 = "HMAC-SHA256"
+)
+
+// v2接口返回状态码
+const (
+	v2Success = "SUCCESS"
+	v2Fail    = "FAIL"
+)
+
+var ErrV2Sign = errors.New("wechat pay v2 response sign verify failed")
+
+// v2请求及应答参数
+type V2Params map[string]string
+
+// v2接口错误 return_code或result_code不为SUCCESS
+type V2Error struct {
+	ReturnCode string //通信标识
+	ReturnMsg  string //返回信息
+	ResultCode string //业务结果
+	ErrCode    string //错误代码 如USERPAYING/SYSTEMERROR
+	ErrCodeDes string //错误代码描述
+}
+
+func (e *V2Error) Error() string {
+	if e.ReturnCode != v2Success {
+		return fmt.Sprintf("wechat pay v2 return_code(%v) return_msg(%v)", e.ReturnCode, e.ReturnMsg)
+	}
+	return fmt.Sprintf("wechat pay v2 result_code(%v) err_code(%v) err_code_des(%v)", e.ResultCode, e.ErrCode, e.ErrCodeDes)
+}
+
+// v2 client
+type V2Client struct {
+	AppId    string       //应用ID
+	MchId    string       //商户号
+	ApiKey   string       //API v2密钥
+	SignType string       //签名类型 默认MD5
+	Domain   string       //[非必填]默认https://api.mch.weixin.qq.com,测试时可替换
+	Client   *http.Client //[非必填]默认超时10s的http.Client
+
+	TLSClient *http.Client //[需要证书的接口必填]携带商户API证书的http.Client,见WithCertificate
+	Debug     bool
+}
+
+// 不同v2接口的调用方式
+type v2CallOption struct {
+	cert     bool //是否需要商户API证书(双向TLS)
+	md5Only  bool //只支持MD5签名且不传sign_type,如红包、企业付款
+	noVerify bool //应答不带签名,如红包、企业付款
+}
+
+func NewV2Client(appId, mchId, apiKey string) *V2Client {
+	return &V2Client{
+		AppId:    appId,
+		MchId:    mchId,
+		ApiKey:   apiKey,
+		SignType: SignTypeMD5,
+		Domain:   apiDomain,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+/*
+[Post]-> 发起v2请求 自动填充nonce_str、sign_type及sign,并校验应答签名
+path: 接口路径 示例 "/pay/micropay"
+params: 业务参数,不会被修改
+return_code或result_code不为SUCCESS时返回*V2Error,同时返回已解析的应答
+*/
+func (c *V2Client) Post(ctx context.Context, path string, params V2Params) (V2Params, error) {
+	return c.post(ctx, path, params, v2CallOption{})
+}
+
+/*
+[PostWithCert]-> 发起需要商户API证书的v2请求,如退款、撤销订单
+须先调用WithCertificate或设置TLSClient
+*/
+func (c *V2Client) PostWithCert(ctx context.Context, path string, params V2Params) (V2Params, error) {
+	return c.post(ctx, path, params, v2CallOption{cert: true})
+}
+
+// 营销类接口(红包、企业付款)只支持MD5签名,应答不带签名,且都需要商户API证书
+func (c *V2Client) postMarketing(ctx context.Context, path string, params V2Params) (V2Params, error) {
+	return c.post(ctx, path, params, v2CallOption{cert: true, md5Only: true, noVerify: true})
+}
+
+/*
+[WithCertificate]-> 加载商户API证书(apiclient_cert.pem/apiclient_key.pem),用于双向TLS
+certPath:本地文件中商户API证书的位置
+keyPath:本地文件中商户私钥的位置
+*/
+func (c *V2Client) WithCertificate(certPath, keyPath string) error {
+	certificate, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		fmt.Printf("WithCertificate-> LoadX509KeyPair error(%v)", err)
+		return err
+	}
+	c.TLSClient = &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{Certificates: []tls.Certificate{certificate}},
+		},
+	}
+	return nil
+}
+
+func (c *V2Client) post(ctx context.Context, path string, params V2Params, opt v2CallOption) (V2Params, error) {
+	reqParams := make(V2Params, len(params)+3)
+	for k, v := range params {
+		reqParams[k] = v
+	}
+	reqParams["nonce_str"] = nonceStr()
+	signType := c.signType()
+	if opt.md5Only {
+		signType = SignTypeMD5
+	} else if signType != SignTypeMD5 {
+		reqParams["sign_type"] = signType
+	}
+	reqParams["sign"] = c.Sign(reqParams, signType)
+
+	client := c.Client
+	if opt.cert {
+		if c.TLSClient == nil {
+			return nil, fmt.Errorf("V2Client.Post-> %v need merchant certificate, call WithCertificate first", path)
+		}
+		client = c.TLSClient
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	domain := c.Domain
+	if domain == "" {
+		domain = apiDomain
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, domain+path, bytes.NewReader(reqParams.ToXML()))
+	if err != nil {
+		fmt.Printf("V2Client.Post-> NewRequest(%v) error(%v)", path, err)
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	Debug(c.Debug, "v2 request(%v) params(%v)", path, reqParams)
+	httpRes, err := client.Do(httpReq)
+	if err != nil {
+		fmt.Printf("V2Client.Post-> Do(%v) error(%v)", path, err)
+		return nil, err
+	}
+	defer httpRes.Body.Close()
+	body, err := ioutil.ReadAll(httpRes.Body)
+	if err != nil {
+		fmt.Printf("V2Client.Post-> read response(%v) body error(%v)", path, err)
+		return nil, err
+	}
+	if httpRes.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("V2Client.Post-> %v http status(%v) body(%v)", path, httpRes.StatusCode, string(body))
+	}
+	resParams, err := ParseV2XML(body)
+	if err != nil {
+		fmt.Printf("V2Client.Post-> ParseV2XML body(%v) error(%v)", string(body), err)
+		return nil, err
+	}
+	Debug(c.Debug, "v2 response(%v) params(%v)", path, resParams)
+	if resParams["return_code"] != v2Success {
+		return resParams, resParams.v2Error()
+	}
+	if !opt.noVerify && !c.Verify(resParams, signType) {
+		return nil, ErrV2Sign
+	}
+	if resParams["result_code"] != "" && resParams["result_code"] != v2Success {
+		return resParams, resParams.v2Error()
+	}
+	return resParams, nil
+}
+
+func (c *V2Client) signType() string {
+	if c.SignType == "" {
+		return SignTypeMD5
+	}
+	return c.SignType
+}
+
+/*
+[Sign]-> v2签名 参数名ASCII码从小到大排序,空值及sign不参与签名,拼接&key=API密钥后MD5或HMAC-SHA256,结果转大写
+*/
+func (c *V2Client) Sign(params V2Params, signType string) string {
+	keys := make([]string, 0, len(params))
+	for k, v := range params {
+		if k == "sign" || v == "" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf strings.Builder
+	for _, k := range keys {
+		buf.WriteString(k)
+		buf.WriteByte('=')
+		buf.WriteString(params[k])
+		buf.WriteByte('&')
+	}
+	buf.WriteString("key=")
+	buf.WriteString(c.ApiKey)
+
+	var h hash.Hash
+	if signType == SignTypeHMACSHA256 {
+		h = hmac.New(sha256.New, []byte(c.ApiKey))
+	} else {
+		h = md5.New()
+	}
+	h.Write([]byte(buf.String()))
+	return strings.ToUpper(hex.EncodeToString(h.Sum(nil)))
+}
+
+// [Verify]-> 校验v2应答或通知的签名
+func (c *V2Client) Verify(params V2Params, signType string) bool {
+	sign := params["sign"]
+	if sign == "" {
+		return false
+	}
+	if params["sign_type"] != "" {
+		signType = params["sign_type"]
+	}
+	return hmac.Equal([]byte(sign), []byte(c.Sign(params, signType)))
+}
+
+func (p V2Params) v2Error() *V2Error {
+	return &V2Error{
+		ReturnCode: p["return_code"],
+		ReturnMsg:  p["return_msg"],
+		ResultCode: p["result_code"],
+		ErrCode:    p["err_code"],
+		ErrCodeDes: p["err_code_des"],
+	}
+}
+
+// [ToXML]-> 编码为v2请求XML <xml><k>v</k>...</xml>
+func (p V2Params) ToXML() []byte {
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	buf.WriteString("<xml>")
+	for _, k := range keys {
+		buf.WriteString("<" + k + ">")
+		xml.EscapeText(&buf, []byte(p[k]))
+		buf.WriteString("</" + k + ">")
+	}
+	buf.WriteString("</xml>")
+	return buf.Bytes()
+}
+
+// [ParseV2XML]-> 解析v2应答或通知XML,只处理根节点下的一级元素
+func ParseV2XML(data []byte) (V2Params, error) {
+	params := V2Params{}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	depth := 0
+	var key string
+	var value strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 2 {
+				key = t.Name.Local
+				value.Reset()
+			}
+		case xml.CharData:
+			if depth == 2 {
+				value.Write(t)
+			}
+		case xml.EndElement:
+			if depth == 2 {
+				params[key] = value.String()
+			}
+			depth--
+		}
+	}
+	if depth != 0 || len(params) == 0 {
+		return nil, errors.New("ParseV2XML-> invalid xml")
+	}
+	return params, nil
+}
+
+// 32位随机字符串
+func nonceStr() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return hex.EncodeToString([]byte(time.Now().Format("20060102150405.000000")))[:32]
+	}
+	return hex.EncodeToString(b)
+}
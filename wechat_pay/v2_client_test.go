@@ -0,0 +1,176 @@
+package wechatpay
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+)
+
+// 模拟需要双向证书的v2接口 未携带客户端证书时TLS握手失败
+func newFakeV2TLSServer(t *testing.T, client *V2Client, handle func(path string, req V2Params) V2Params) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		req, err := ParseV2XML(body)
+		if err != nil || !client.Verify(req, SignTypeMD5) {
+			w.Write(V2Params{"return_code": v2Fail, "return_msg": "签名错误"}.ToXML())
+			return
+		}
+		w.Write(handle(r.URL.Path, req).ToXML())
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	return server
+}
+
+// 生成商户API证书文件,并让client信任测试服务端证书
+func withTestCertificate(t *testing.T, client *V2Client, server *httptest.Server) {
+	privateKey, certificate := newTestCertificate(t)
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "apiclient_cert.pem")
+	keyPath := filepath.Join(dir, "apiclient_key.pem")
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	if err := os.WriteFile(certPath, certPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WithCertificate(certPath, keyPath); err != nil {
+		t.Fatal(err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(server.Certificate())
+	client.TLSClient.Transport.(*http.Transport).TLSClientConfig.RootCAs = rootCAs
+	client.Domain = server.URL
+}
+
+func TestV2ClientCertificate(t *testing.T) {
+	client := NewV2Client("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+	server := newFakeV2TLSServer(t, client, func(path string, req V2Params) V2Params {
+		res := V2Params{"return_code": v2Success, "result_code": v2Success}
+		switch path {
+		case "/secapi/pay/refund":
+			res["out_trade_no"] = req["out_trade_no"]
+			res["out_refund_no"] = req["out_refund_no"]
+			res["refund_id"] = "2008450740201411110000174436"
+			res["refund_fee"] = req["refund_fee"]
+			res["total_fee"] = req["total_fee"]
+			res["sign"] = client.Sign(res, SignTypeMD5)
+		case "/mmpaymkttransfers/sendredpack":
+			if req["sign_type"] != "" || req["wxappid"] == "" || req["total_num"] != "1" {
+				return V2Params{"return_code": v2Fail, "return_msg": "参数错误"}
+			}
+			res["mch_billno"] = req["mch_billno"]
+			res["re_openid"] = req["re_openid"]
+			res["total_amount"] = req["total_amount"]
+			res["send_listid"] = "100000000020150520314766074200"
+		case "/mmpaymkttransfers/promotion/transfers":
+			if req["mch_appid"] == "" || req["mchid"] == "" || req["check_name"] != CheckNameNone {
+				return V2Params{"return_code": v2Fail, "return_msg": "参数错误"}
+			}
+			res["partner_trade_no"] = req["partner_trade_no"]
+			res["payment_no"] = "1000018301201505190181489473"
+			res["payment_time"] = "2015-05-19 15:26:59"
+		case "/mmpaymkttransfers/gettransferinfo":
+			res["result_code"] = v2Fail
+			res["err_code"] = "NOT_FOUND"
+			res["err_code_des"] = "指定单号数据不存在"
+		}
+		return res
+	})
+	defer server.Close()
+
+	ctx := context.Background()
+	//未加载证书
+	client.Domain = server.URL
+	if _, err := client.Refund(ctx, NewV2RefundReq("1217752501201407033233368018", "1217752501201407033233368019", money.Fen(100), money.Fen(50))); err == nil {
+		t.Fatal("no certificate but no return err")
+	}
+	withTestCertificate(t, client, server)
+
+	refundResult, err := client.Refund(ctx, NewV2RefundReq("1217752501201407033233368018", "1217752501201407033233368019", money.Fen(100), money.Fen(50)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refundResult.RefundId == "" || refundResult.RefundFee.Minor() != 50 || refundResult.TotalFee.Minor() != 100 {
+		t.Errorf("unexpected refund result(%+v)", refundResult)
+	}
+	if _, err = client.Refund(ctx, NewV2RefundReq("1217752501201407033233368018", "1217752501201407033233368019", money.Fen(100), money.Fen(101))); err == nil {
+		t.Error("refund fee exceed total fee but no return err")
+	}
+
+	redpackReq := NewRedpackReq("10000098201411111234567890", "天虹百货", "oxTWIuGaIt6gTKsQRLau2M0yL16E", "感谢您参加猜灯谜活动", "192.168.0.1", "猜灯谜抢红包活动", "猜越多得越多", money.Fen(1000))
+	redpackResult, err := client.SendRedpack(ctx, redpackReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redpackResult.SendListId == "" || redpackResult.TotalAmount.Minor() != 1000 {
+		t.Errorf("unexpected redpack result(%+v)", redpackResult)
+	}
+
+	transferResult, err := client.EnterpriseTransfer(ctx, NewEnterpriseTransferReq("10000098201411111234567890", "oxTWIuGaIt6gTKsQRLau2M0yL16E", "理赔", money.Fen(100)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transferResult.PaymentNo == "" || transferResult.Status != TransferStatusSuccess {
+		t.Errorf("unexpected transfer result(%+v)", transferResult)
+	}
+	_, err = client.QueryEnterpriseTransfer(ctx, "10000098201411111234567891")
+	var v2Err *V2Error
+	if !errors.As(err, &v2Err) || v2Err.ErrCode != "NOT_FOUND" {
+		t.Errorf("expect NOT_FOUND, got(%v)", err)
+	}
+}
+
+func TestV2ResponseSign(t *testing.T) {
+	client := NewV2Client("wx2421b1c4370ec43b", "10000100", "192006250b4c09247ec02edce69f6a2d")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := V2Params{"return_code": v2Success, "result_code": v2Success, "trade_state": TradeStateSuccess}
+		res["sign"] = client.Sign(res, SignTypeMD5)
+		res["trade_state"] = TradeStateRefund //签名后篡改
+		w.Write(res.ToXML())
+	}))
+	defer server.Close()
+	client.Domain = server.URL
+	if _, err := client.OrderQuery(context.Background(), "1415757673"); err != ErrV2Sign {
+		t.Errorf("tampered response but return err(%v)", err)
+	}
+}
+
+func TestV2Sign(t *testing.T) {
+	//微信支付签名示例 https://pay.weixin.qq.com/wiki/doc/api/jsapi.php?chapter=4_3
+	client := NewV2Client("wxd930ea5d5a258f4f", "10000100", "192006250b4c09247ec02edce69f6a2d")
+	params := V2Params{
+		"appid":       "wxd930ea5d5a258f4f",
+		"mch_id":      "10000100",
+		"device_info": "1000",
+		"body":        "test",
+		"nonce_str":   "ibuaiVcKdpRxkhJA",
+	}
+	if sign := client.Sign(params, SignTypeMD5); sign != "9A0A8659F005D6984697E2CA0A9CF3B7" {
+		t.Errorf("unexpected md5 sign(%v)", sign)
+	}
+	if sign := client.Sign(params, SignTypeHMACSHA256); sign != "6A9AE1657590FD6257D693A078E1C3E4BB6BA4DC30B23E0EE2496E54170DACD6" {
+		t.Errorf("unexpected hmac-sha256 sign(%v)", sign)
+	}
+	params["sign"] = client.Sign(params, SignTypeMD5)
+	parsed, err := ParseV2XML(params.ToXML())
+	if err != nil || !client.Verify(parsed, SignTypeMD5) {
+		t.Errorf("xml round trip verify failed(%v)", err)
+	}
+}
@@ -0,0 +1,139 @@
+package wechatpay
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+)
+
+//现金红包API详情请查阅:https://pay.weixin.qq.com/wiki/doc/api/tools/cash_coupon.php?chapter=13_4&index=3
+//红包接口只支持MD5签名,应答不带签名,需要商户API证书
+
+// 红包状态
+const (
+	RedpackStatusSending   = "SENDING"   //发放中
+	RedpackStatusSent      = "SENT"      //已发放待领取
+	RedpackStatusFailed    = "FAILED"    //发放失败
+	RedpackStatusReceived  = "RECEIVED"  //已领取
+	RedpackStatusRefunding = "RFUND_ING" //退款中
+	RedpackStatusRefund    = "REFUND"    //已退款
+)
+
+// 发放普通红包req POST https://api.mch.weixin.qq.com/mmpaymkttransfers/sendredpack
+type RedpackReq struct {
+	MchBillNo   string       //商户订单号
+	SendName    string       //商户名称
+	ReOpenId    string       //用户在wxappid下的openid
+	TotalAmount money.Amount //付款金额 单位为分
+	Wishing     string       //红包祝福语
+	ClientIp    string       //调用接口的机器Ip地址
+	ActName     string       //活动名称
+	Remark      string       //备注
+	SceneId     string       //[非必填]场景id 金额小于1元或大于200元时必填 如PRODUCT_1
+}
+
+// 发放红包结果
+type RedpackResult struct {
+	MchBillNo   string       //商户订单号
+	ReOpenId    string       //用户openid
+	TotalAmount money.Amount //付款金额
+	SendListId  string       //微信单号
+}
+
+// 红包查询结果
+type RedpackInfo struct {
+	MchBillNo    string       //商户订单号
+	DetailId     string       //红包单号
+	Status       string       //红包状态
+	SendType     string       //发放类型 API/UPLOAD/ACTIVITY
+	HbType       string       //红包类型 GROUP/NORMAL
+	TotalAmount  money.Amount //红包总金额
+	Reason       string       //发送失败原因
+	SendTime     string       //红包发送时间
+	RefundTime   string       //红包退款时间
+	RefundAmount money.Amount //红包退款金额
+}
+
+func NewRedpackReq(mchBillNo, sendName, reOpenId, wishing, clientIp, actName, remark string, totalAmount money.Amount) *RedpackReq {
+	return &RedpackReq{
+		MchBillNo:   mchBillNo,
+		SendName:    sendName,
+		ReOpenId:    reOpenId,
+		TotalAmount: totalAmount,
+		Wishing:     wishing,
+		ClientIp:    clientIp,
+		ActName:     actName,
+		Remark:      remark,
+	}
+}
+
+/*
+[SendRedpack]-> 发放普通红包 POST https://api.mch.weixin.qq.com/mmpaymkttransfers/sendredpack
+需要商户API证书,见WithCertificate
+*/
+func (c *V2Client) SendRedpack(ctx context.Context, redpackReq *RedpackReq) (*RedpackResult, error) {
+	if redpackReq == nil || redpackReq.MchBillNo == "" || redpackReq.ReOpenId == "" || !redpackReq.TotalAmount.IsPositive() {
+		return nil, errors.New("V2Client.SendRedpack-> MchBillNo, ReOpenId and TotalAmount can not be empty")
+	}
+	params := V2Params{
+		"wxappid":      c.AppId,
+		"mch_id":       c.MchId,
+		"mch_billno":   redpackReq.MchBillNo,
+		"send_name":    redpackReq.SendName,
+		"re_openid":    redpackReq.ReOpenId,
+		"total_amount": strconv.FormatInt(redpackReq.TotalAmount.Minor(), 10),
+		"total_num":    "1",
+		"wishing":      redpackReq.Wishing,
+		"client_ip":    redpackReq.ClientIp,
+		"act_name":     redpackReq.ActName,
+		"remark":       redpackReq.Remark,
+		"scene_id":     redpackReq.SceneId,
+	}
+	res, err := c.postMarketing(ctx, "/mmpaymkttransfers/sendredpack", params)
+	if err != nil {
+		return nil, err
+	}
+	totalAmount, _ := strconv.ParseInt(res["total_amount"], 10, 64)
+	return &RedpackResult{
+		MchBillNo:   res["mch_billno"],
+		ReOpenId:    res["re_openid"],
+		TotalAmount: money.Fen(totalAmount),
+		SendListId:  res["send_listid"],
+	}, nil
+}
+
+/*
+[QueryRedpack]-> 查询红包记录 POST https://api.mch.weixin.qq.com/mmpaymkttransfers/gethbinfo
+需要商户API证书,见WithCertificate
+*/
+func (c *V2Client) QueryRedpack(ctx context.Context, mchBillNo string) (*RedpackInfo, error) {
+	if mchBillNo == "" {
+		return nil, errors.New("V2Client.QueryRedpack-> mchBillNo can not be empty")
+	}
+	params := V2Params{
+		"appid":      c.AppId,
+		"mch_id":     c.MchId,
+		"mch_billno": mchBillNo,
+		"bill_type":  "MCHT",
+	}
+	res, err := c.postMarketing(ctx, "/mmpaymkttransfers/gethbinfo", params)
+	if err != nil {
+		return nil, err
+	}
+	totalAmount, _ := strconv.ParseInt(res["total_amount"], 10, 64)
+	refundAmount, _ := strconv.ParseInt(res["refund_amount"], 10, 64)
+	return &RedpackInfo{
+		MchBillNo:    res["mch_billno"],
+		DetailId:     res["detail_id"],
+		Status:       res["status"],
+		SendType:     res["send_type"],
+		HbType:       res["hb_type"],
+		TotalAmount:  money.Fen(totalAmount),
+		Reason:       res["reason"],
+		SendTime:     res["send_time"],
+		RefundTime:   res["refund_time"],
+		RefundAmount: money.Fen(refundAmount),
+	}, nil
+}
@@ -0,0 +1,83 @@
+package wechatpay
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+)
+
+//v2申请退款API详情请查阅:https://pay.weixin.qq.com/wiki/doc/api/micropay.php?chapter=9_4
+//用于v2下单(如付款码支付)的订单,需要商户API证书
+
+// v2申请退款req POST https://api.mch.weixin.qq.com/secapi/pay/refund
+type V2RefundReq struct {
+	TransactionId string       //微信支付订单号 与OutTradeNo二选一
+	OutTradeNo    string       //商户订单号 与TransactionId二选一
+	OutRefundNo   string       //商户退款单号
+	TotalFee      money.Amount //订单金额
+	RefundFee     money.Amount //退款金额
+	RefundDesc    string       //[非必填]退款原因
+	NotifyUrl     string       //[非必填]退款结果通知地址
+}
+
+// v2申请退款结果
+type V2RefundResult struct {
+	TransactionId string       //微信支付订单号
+	OutTradeNo    string       //商户订单号
+	OutRefundNo   string       //商户退款单号
+	RefundId      string       //微信退款单号
+	RefundFee     money.Amount //退款金额
+	TotalFee      money.Amount //订单金额
+	CashFee       money.Amount //现金支付金额
+}
+
+func NewV2RefundReq(outTradeNo, outRefundNo string, totalFee, refundFee money.Amount) *V2RefundReq {
+	return &V2RefundReq{
+		OutTradeNo:  outTradeNo,
+		OutRefundNo: outRefundNo,
+		TotalFee:    totalFee,
+		RefundFee:   refundFee,
+	}
+}
+
+/*
+[Refund]-> v2申请退款 POST https://api.mch.weixin.qq.com/secapi/pay/refund
+需要商户API证书,见WithCertificate
+*/
+func (c *V2Client) Refund(ctx context.Context, refundReq *V2RefundReq) (*V2RefundResult, error) {
+	if refundReq == nil || refundReq.OutRefundNo == "" || (refundReq.OutTradeNo == "" && refundReq.TransactionId == "") {
+		return nil, errors.New("V2Client.Refund-> OutRefundNo and OutTradeNo/TransactionId can not be empty")
+	}
+	if cmp, err := refundReq.RefundFee.Cmp(refundReq.TotalFee); !refundReq.RefundFee.IsPositive() || err != nil || cmp > 0 {
+		return nil, errors.New("V2Client.Refund-> RefundFee must be in (0, TotalFee]")
+	}
+	params := V2Params{
+		"appid":          c.AppId,
+		"mch_id":         c.MchId,
+		"transaction_id": refundReq.TransactionId,
+		"out_trade_no":   refundReq.OutTradeNo,
+		"out_refund_no":  refundReq.OutRefundNo,
+		"total_fee":      strconv.FormatInt(refundReq.TotalFee.Minor(), 10),
+		"refund_fee":     strconv.FormatInt(refundReq.RefundFee.Minor(), 10),
+		"refund_desc":    refundReq.RefundDesc,
+		"notify_url":     refundReq.NotifyUrl,
+	}
+	res, err := c.PostWithCert(ctx, "/secapi/pay/refund", params)
+	if err != nil {
+		return nil, err
+	}
+	refundFee, _ := strconv.ParseInt(res["refund_fee"], 10, 64)
+	totalFee, _ := strconv.ParseInt(res["total_fee"], 10, 64)
+	cashFee, _ := strconv.ParseInt(res["cash_fee"], 10, 64)
+	return &V2RefundResult{
+		TransactionId: res["transaction_id"],
+		OutTradeNo:    res["out_trade_no"],
+		OutRefundNo:   res["out_refund_no"],
+		RefundId:      res["refund_id"],
+		RefundFee:     money.Fen(refundFee),
+		TotalFee:      money.Fen(totalFee),
+		CashFee:       money.Fen(cashFee),
+	}, nil
+}
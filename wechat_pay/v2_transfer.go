@@ -0,0 +1,123 @@
+package wechatpay
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/tanjl855/Sms_Pay_SDK/money"
+)
+
+//企业付款到零钱API详情请查阅:https://pay.weixin.qq.com/wiki/doc/api/tools/mch_pay.php?chapter=14_2
+//新商户请使用v3商家转账到零钱(TransferBatchCommit),企业付款只支持MD5签名,需要商户API证书
+
+// 校验用户姓名选项
+const (
+	CheckNameNone  = "NO_CHECK"    //不校验真实姓名
+	CheckNameForce = "FORCE_CHECK" //强校验真实姓名
+)
+
+// 企业付款状态
+const (
+	TransferStatusSuccess    = "SUCCESS"    //转账成功
+	TransferStatusFailed     = "FAILED"     //转账失败
+	TransferStatusProcessing = "PROCESSING" //处理中
+)
+
+// 企业付款req POST https://api.mch.weixin.qq.com/mmpaymkttransfers/promotion/transfers
+type EnterpriseTransferReq struct {
+	PartnerTradeNo string       //商户订单号
+	OpenId         string       //用户在mch_appid下的openid
+	CheckName      string       //校验用户姓名选项 NO_CHECK/FORCE_CHECK
+	ReUserName     string       //[FORCE_CHECK时必填]收款用户姓名
+	Amount         money.Amount //付款金额 单位为分
+	Desc           string       //付款备注
+	SpbillCreateIp string       //[非必填]Ip地址
+}
+
+// 企业付款结果
+type EnterpriseTransferResult struct {
+	PartnerTradeNo string       //商户订单号
+	PaymentNo      string       //微信付款单号
+	PaymentTime    string       //付款成功时间
+	Status         string       //转账状态(查询)
+	Reason         string       //失败原因(查询)
+	Amount         money.Amount //付款金额(查询)
+}
+
+func NewEnterpriseTransferReq(partnerTradeNo, openId, desc string, amount money.Amount) *EnterpriseTransferReq {
+	return &EnterpriseTransferReq{
+		PartnerTradeNo: partnerTradeNo,
+		OpenId:         openId,
+		CheckName:      CheckNameNone,
+		Amount:         amount,
+		Desc:           desc,
+	}
+}
+
+/*
+[EnterpriseTransfer]-> 企业付款到零钱 POST https://api.mch.weixin.qq.com/mmpaymkttransfers/promotion/transfers
+需要商户API证书,见WithCertificate;结果为SYSTEMERROR时须使用原商户订单号重试或查询
+*/
+func (c *V2Client) EnterpriseTransfer(ctx context.Context, transferReq *EnterpriseTransferReq) (*EnterpriseTransferResult, error) {
+	if transferReq == nil || transferReq.PartnerTradeNo == "" || transferReq.OpenId == "" || !transferReq.Amount.IsPositive() {
+		return nil, errors.New("V2Client.EnterpriseTransfer-> PartnerTradeNo, OpenId and Amount can not be empty")
+	}
+	checkName := transferReq.CheckName
+	if checkName == "" {
+		checkName = CheckNameNone
+	}
+	if checkName == CheckNameForce && transferReq.ReUserName == "" {
+		return nil, errors.New("V2Client.EnterpriseTransfer-> ReUserName is required when FORCE_CHECK")
+	}
+	params := V2Params{
+		"mch_appid":        c.AppId,
+		"mchid":            c.MchId,
+		"partner_trade_no": transferReq.PartnerTradeNo,
+		"openid":           transferReq.OpenId,
+		"check_name":       checkName,
+		"re_user_name":     transferReq.ReUserName,
+		"amount":           strconv.FormatInt(transferReq.Amount.Minor(), 10),
+		"desc":             transferReq.Desc,
+		"spbill_create_ip": transferReq.SpbillCreateIp,
+	}
+	res, err := c.postMarketing(ctx, "/mmpaymkttransfers/promotion/transfers", params)
+	if err != nil {
+		return nil, err
+	}
+	return &EnterpriseTransferResult{
+		PartnerTradeNo: res["partner_trade_no"],
+		PaymentNo:      res["payment_no"],
+		PaymentTime:    res["payment_time"],
+		Status:         TransferStatusSuccess,
+		Amount:         transferReq.Amount,
+	}, nil
+}
+
+/*
+[QueryEnterpriseTransfer]-> 查询企业付款 POST https://api.mch.weixin.qq.com/mmpaymkttransfers/gettransferinfo
+需要商户API证书,见WithCertificate
+*/
+func (c *V2Client) QueryEnterpriseTransfer(ctx context.Context, partnerTradeNo string) (*EnterpriseTransferResult, error) {
+	if partnerTradeNo == "" {
+		return nil, errors.New("V2Client.QueryEnterpriseTransfer-> partnerTradeNo can not be empty")
+	}
+	params := V2Params{
+		"appid":            c.AppId,
+		"mch_id":           c.MchId,
+		"partner_trade_no": partnerTradeNo,
+	}
+	res, err := c.postMarketing(ctx, "/mmpaymkttransfers/gettransferinfo", params)
+	if err != nil {
+		return nil, err
+	}
+	amount, _ := strconv.ParseInt(res["payment_amount"], 10, 64)
+	return &EnterpriseTransferResult{
+		PartnerTradeNo: res["partner_trade_no"],
+		PaymentNo:      res["detail_id"],
+		PaymentTime:    res["payment_time"],
+		Status:         res["status"],
+		Reason:         res["reason"],
+		Amount:         money.Fen(amount),
+	}, nil
+}